@@ -0,0 +1,167 @@
+package persistencesql
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec names the algorithm a payload has been compressed with. It is recorded along with every journal entry and
+// snapshot so that payloads written with different settings remain readable
+type Codec string
+
+const (
+	// NoCompression states that the payload is stored as is
+	NoCompression Codec = ""
+	// GzipCodec compresses the payloads using gzip
+	GzipCodec Codec = "gzip"
+	// ZstdCodec compresses the payloads using zstandard
+	ZstdCodec Codec = "zstd"
+	// SnappyCodec compresses the payloads using snappy
+	SnappyCodec Codec = "snappy"
+)
+
+// the zstd encoder and decoder are safe for concurrent use and costly to create, hence they are shared
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+// zstdCodec returns the shared zstd encoder and decoder
+func zstdCodec() (*zstd.Encoder, *zstd.Decoder, error) {
+	zstdOnce.Do(func() {
+		if zstdEncoder, zstdErr = zstd.NewWriter(nil); zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil)
+	})
+	return zstdEncoder, zstdDecoder, zstdErr
+}
+
+// compress compresses the given data
+func (c Codec) compress(data []byte) ([]byte, error) {
+	switch c {
+	case NoCompression:
+		return data, nil
+	case GzipCodec:
+		var buffer bytes.Buffer
+		writer := gzip.NewWriter(&buffer)
+		if _, err := writer.Write(data); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		return buffer.Bytes(), nil
+	case ZstdCodec:
+		encoder, _, err := zstdCodec()
+		if err != nil {
+			return nil, err
+		}
+		return encoder.EncodeAll(data, nil), nil
+	case SnappyCodec:
+		return snappy.Encode(nil, data), nil
+	default:
+		return nil, fmt.Errorf("unknown codec: %s", c)
+	}
+}
+
+// decompress decompresses the given data
+func (c Codec) decompress(data []byte) ([]byte, error) {
+	switch c {
+	case NoCompression:
+		return data, nil
+	case GzipCodec:
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = reader.Close()
+		}()
+		return ioutil.ReadAll(reader)
+	case ZstdCodec:
+		_, decoder, err := zstdCodec()
+		if err != nil {
+			return nil, err
+		}
+		return decoder.DecodeAll(data, nil)
+	case SnappyCodec:
+		return snappy.Decode(nil, data)
+	default:
+		return nil, fmt.Errorf("unknown codec: %s", c)
+	}
+}
+
+// compressJournal compresses the payload of a journal entry when it is at least threshold bytes long
+func compressJournal(journal *Journal, codec Codec, threshold int) error {
+	if codec == NoCompression || len(journal.Payload) < threshold {
+		return nil
+	}
+
+	payload, err := codec.compress(journal.Payload)
+	if err != nil {
+		return err
+	}
+
+	journal.Payload = payload
+	journal.Codec = codec
+	return nil
+}
+
+// decompressJournal returns a copy of the journal entry carrying the decompressed payload
+func decompressJournal(journal *Journal) (*Journal, error) {
+	if journal.Codec == NoCompression {
+		return journal, nil
+	}
+
+	payload, err := journal.Codec.decompress(journal.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	decompressed := *journal
+	decompressed.Payload = payload
+	decompressed.Codec = NoCompression
+	return &decompressed, nil
+}
+
+// compressSnapshot compresses a snapshot when it is at least threshold bytes long
+func compressSnapshot(snapshot *Snapshot, codec Codec, threshold int) error {
+	if codec == NoCompression || len(snapshot.Snapshot) < threshold {
+		return nil
+	}
+
+	payload, err := codec.compress(snapshot.Snapshot)
+	if err != nil {
+		return err
+	}
+
+	snapshot.Snapshot = payload
+	snapshot.Codec = codec
+	return nil
+}
+
+// decompressSnapshot returns a copy of the snapshot carrying the decompressed payload
+func decompressSnapshot(snapshot *Snapshot) (*Snapshot, error) {
+	if snapshot.Codec == NoCompression {
+		return snapshot, nil
+	}
+
+	payload, err := snapshot.Codec.decompress(snapshot.Snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	decompressed := *snapshot
+	decompressed.Snapshot = payload
+	decompressed.Codec = NoCompression
+	return &decompressed, nil
+}
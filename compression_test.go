@@ -0,0 +1,123 @@
+package persistencesql
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/AsynkronIT/protoactor-go/actor"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	pb "github.com/tochemey/protoactor-persistence-sql/gen"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestCodec(t *testing.T) {
+	data := bytes.Repeat([]byte("some-compressible-payload"), 100)
+
+	testCases := map[string]struct {
+		codec Codec
+	}{
+		"no compression": {codec: NoCompression},
+		"gzip":           {codec: GzipCodec},
+		"zstd":           {codec: ZstdCodec},
+		"snappy":         {codec: SnappyCodec},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(
+			name, func(t *testing.T) {
+				// get instance of assert
+				assertions := assert.New(t)
+
+				compressed, err := testCase.codec.compress(data)
+				assertions.NoError(err)
+				if testCase.codec != NoCompression {
+					assertions.Less(len(compressed), len(data))
+				}
+
+				decompressed, err := testCase.codec.decompress(compressed)
+				assertions.NoError(err)
+				assertions.Equal(data, decompressed)
+			},
+		)
+	}
+
+	// unknown codecs are rejected
+	_, err := Codec("lz4").compress(data)
+	assert.Error(t, err)
+	_, err = Codec("lz4").decompress(data)
+	assert.Error(t, err)
+}
+
+func TestCompressionThreshold(t *testing.T) {
+	// get instance of assert
+	assertions := assert.New(t)
+
+	small, err := NewJournal("some-persistence-id", &pb.AccountDebited{AccountNumber: "1"}, 1, "some-writer-id")
+	assertions.NoError(err)
+	assertions.NoError(compressJournal(small, GzipCodec, 64))
+	assertions.Equal(NoCompression, small.Codec)
+
+	large, err := NewSnapshot("some-persistence-id", &pb.Account{
+		AccountNumber: string(bytes.Repeat([]byte("1"), 128)),
+	}, 1, "some-writer-id")
+	assertions.NoError(err)
+	assertions.NoError(compressSnapshot(large, GzipCodec, 64))
+	assertions.Equal(GzipCodec, large.Codec)
+
+	decompressed, err := decompressSnapshot(large)
+	assertions.NoError(err)
+	assertions.Equal(NoCompression, decompressed.Codec)
+	message, err := decompressed.message(defaultSerializer)
+	assertions.NoError(err)
+	assertions.Equal(string(bytes.Repeat([]byte("1"), 128)), message.(*pb.Account).GetAccountNumber())
+}
+
+func TestProviderCompression(t *testing.T) {
+	persistenceID := uuid.New().String()
+	accountNumber := string(bytes.Repeat([]byte("1"), 128))
+
+	// get instance of assert
+	assertions := assert.New(t)
+	memoryDialect := NewInMemoryDialect()
+
+	// the history is written with several compression settings
+	settings := [][]OptFunc{
+		nil,
+		{WithCompression(ZstdCodec, 64)},
+		{WithCompression(SnappyCodec, 0)},
+	}
+	for i, opts := range settings {
+		provider := NewSQLProvider(context.TODO(), actor.NewActorSystem(), memoryDialect, opts...)
+		provider.GetState().PersistEvent(persistenceID, i+1, &pb.AccountDebited{
+			AccountNumber: accountNumber,
+			Balance:       float32(i),
+		})
+	}
+
+	provider := NewSQLProvider(context.TODO(), actor.NewActorSystem(), memoryDialect, WithCompression(GzipCodec, 64))
+	state := provider.GetState()
+	state.PersistSnapshot(persistenceID, 3, &pb.Account{AccountNumber: accountNumber})
+
+	codecs := make([]Codec, 0)
+	for _, journal := range memoryDialect.Journals(persistenceID) {
+		codecs = append(codecs, journal.Codec)
+	}
+	assertions.Equal([]Codec{NoCompression, ZstdCodec, SnappyCodec}, codecs)
+	snapshots := memoryDialect.Snapshots(persistenceID)
+	if assertions.Len(snapshots, 1) {
+		assertions.Equal(GzipCodec, snapshots[0].Codec)
+	}
+
+	// every row remains readable whatever the current setting
+	snapshot, _, ok := state.GetSnapshot(persistenceID)
+	assertions.True(ok)
+	assertions.True(proto.Equal(&pb.Account{AccountNumber: accountNumber}, snapshot.(proto.Message)))
+
+	replayed := make([]float32, 0)
+	state.GetEvents(persistenceID, 1, 0, func(e interface{}) {
+		replayed = append(replayed, e.(*pb.AccountDebited).GetBalance())
+	})
+	assertions.Equal([]float32{0, 1, 2}, replayed)
+}
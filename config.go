@@ -11,8 +11,16 @@ type DBConfig struct {
 	dbConnectionMaxLife  int    // to ensure connections are closed by the driver safely
 	dbMaxOpenConnections int
 	dbMaxIdleConnections int
+	dbNotifications      bool // states whether the live queries are notified of new journal rows
+	dbTables             TableNames
+	dbJournalColumns     JournalColumns
+	dbSnapshotColumns    SnapshotColumns
+	dbMigrationMode      MigrationMode // states how the schema migrations are carried out
 }
 
+// sqliteInMemory is the SQLite database name of an in-memory database
+const sqliteInMemory = ":memory:"
+
 // PoolOpt defines the connection pool options
 type PoolOpt = func(*DBConfig)
 
@@ -38,6 +46,32 @@ func NewDBConfig(dbUser, dbPassword, dbName, dbSchema, dbHost string, dbPort int
 	return dbConfig
 }
 
+// NewSQLiteConfig creates an instance of DBConfig for a SQLite database.
+// dbPath is either the database file path or :memory: for an in-memory database.
+// An in-memory database only lives as long as its connection, hence the connection pool is restricted
+// to a single connection that is never recycled.
+func NewSQLiteConfig(dbPath string, opts ...PoolOpt) *DBConfig {
+	dbConfig := &DBConfig{
+		dbName:               dbPath,
+		dbMaxIdleConnections: 10,
+		dbMaxOpenConnections: 10,
+		dbConnectionMaxLife:  3,
+	}
+
+	if dbPath == sqliteInMemory {
+		dbConfig.dbMaxIdleConnections = 1
+		dbConfig.dbMaxOpenConnections = 1
+		dbConfig.dbConnectionMaxLife = 0
+	}
+
+	// set pool settings if defined
+	for _, opt := range opts {
+		opt(dbConfig)
+	}
+
+	return dbConfig
+}
+
 // WithConnectionMaxLife sets the database connection max life
 func WithConnectionMaxLife(connectionMaxLife int) PoolOpt {
 	return func(config *DBConfig) {
@@ -58,3 +92,43 @@ func WithMaxIdleConnections(maxIdleConnections int) PoolOpt {
 		config.dbMaxIdleConnections = maxIdleConnections
 	}
 }
+
+// WithNotifications notifies the live queries of new journal rows as soon as they are written, using LISTEN/NOTIFY.
+// Live queries fall back to polling while the listening connection is down. It must be set on the writing and on the
+// reading processes. It is only supported by Postgres and ignored by the other drivers
+func WithNotifications() PoolOpt {
+	return func(config *DBConfig) {
+		config.dbNotifications = true
+	}
+}
+
+// WithTableNames sets the names of the tables. The names left empty keep their default.
+// Names must be made of letters, digits and underscores, must not start with a digit and must not be SQL reserved
+// words. They are lowercased, the way Postgres folds the unquoted names
+func WithTableNames(tables TableNames) PoolOpt {
+	return func(config *DBConfig) {
+		config.dbTables = tables
+	}
+}
+
+// WithJournalColumns sets the names of the journal columns. The names left empty keep their default
+func WithJournalColumns(columns JournalColumns) PoolOpt {
+	return func(config *DBConfig) {
+		config.dbJournalColumns = columns
+	}
+}
+
+// WithSnapshotColumns sets the names of the snapshot columns. The names left empty keep their default
+func WithSnapshotColumns(columns SnapshotColumns) PoolOpt {
+	return func(config *DBConfig) {
+		config.dbSnapshotColumns = columns
+	}
+}
+
+// WithMigrationMode sets how the schema migrations are carried out when the provider starts. They are applied by
+// default
+func WithMigrationMode(mode MigrationMode) PoolOpt {
+	return func(config *DBConfig) {
+		config.dbMigrationMode = mode
+	}
+}
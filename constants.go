@@ -1,124 +1,1065 @@
 package persistencesql
 
+// The statements are templates referencing the tables and columns by their default names, e.g. {{table "journal"}} or
+// {{journal "persistence_id"}}, which are resolved from the DBConfig when connecting
 const (
 	postgresSQL = `
 		-- name: create-journal-table
-		CREATE TABLE IF NOT EXISTS journal
+		CREATE TABLE IF NOT EXISTS {{table "journal"}}
 		(
-		    ordering        BIGSERIAL UNIQUE,
-		    persistence_id  VARCHAR(255)          NOT NULL,
-		    sequence_number BIGINT                NOT NULL,
-		    timestamp       BIGINT                NOT NULL,
-		    payload         BYTEA                 NOT NULL,
-		    manifest        VARCHAR(255)          NOT NULL,
-		    writer_id       VARCHAR(255)          NOT NULL,
-		    deleted         BOOLEAN DEFAULT FALSE NOT NULL,
-		    PRIMARY KEY (persistence_id, sequence_number)
+		    {{journal "ordering"}}        BIGSERIAL UNIQUE,
+		    {{journal "persistence_id"}}  VARCHAR(255)          NOT NULL,
+		    {{journal "sequence_number"}} BIGINT                NOT NULL,
+		    {{journal "timestamp"}}       BIGINT                NOT NULL,
+		    {{journal "payload"}}         BYTEA                 NOT NULL,
+		    {{journal "manifest"}}        VARCHAR(255)          NOT NULL,
+		    {{journal "writer_id"}}       VARCHAR(255)          NOT NULL,
+		    {{journal "deleted"}}         BOOLEAN DEFAULT FALSE NOT NULL,
+		    PRIMARY KEY ({{journal "persistence_id"}}, {{journal "sequence_number"}})
 		);
 		
 		-- name: create-snapshot-table
-		CREATE TABLE IF NOT EXISTS snapshot
+		CREATE TABLE IF NOT EXISTS {{table "snapshot"}}
 		(
-		    persistence_id  VARCHAR(255) NOT NULL,
-		    sequence_number BIGINT       NOT NULL,
-		    timestamp       BIGINT       NOT NULL,
-		    snapshot        BYTEA        NOT NULL,
-		    manifest        VARCHAR(255) NOT NULL,
-		    writer_id       VARCHAR(255) NOT NULL,
-		    PRIMARY KEY (persistence_id, sequence_number)
+		    {{snapshot "persistence_id"}}  VARCHAR(255) NOT NULL,
+		    {{snapshot "sequence_number"}} BIGINT       NOT NULL,
+		    {{snapshot "timestamp"}}       BIGINT       NOT NULL,
+		    {{snapshot "snapshot"}}        BYTEA        NOT NULL,
+		    {{snapshot "manifest"}}        VARCHAR(255) NOT NULL,
+		    {{snapshot "writer_id"}}       VARCHAR(255) NOT NULL,
+		    PRIMARY KEY ({{snapshot "persistence_id"}}, {{snapshot "sequence_number"}})
 		);
 		
+		-- name: create-tag-table
+		CREATE TABLE IF NOT EXISTS {{table "event_tag"}}
+		(
+		    ordering BIGINT       NOT NULL REFERENCES {{table "journal"}} ({{journal "ordering"}}) ON DELETE CASCADE,
+		    tag      VARCHAR(255) NOT NULL,
+		    PRIMARY KEY (tag, ordering)
+		);
+		
+		-- name: create-writer-table
+		CREATE TABLE IF NOT EXISTS {{table "journal_writer"}}
+		(
+		    persistence_id VARCHAR(255) NOT NULL,
+		    writer_id      VARCHAR(255) NOT NULL,
+		    claimed_at     BIGINT       NOT NULL,
+		    PRIMARY KEY (persistence_id)
+		);
+		
+		-- name: create-projection-offset-table
+		CREATE TABLE IF NOT EXISTS {{table "projection_offset"}}
+		(
+		    projection_name VARCHAR(255) NOT NULL,
+		    current_offset  BIGINT       NOT NULL,
+		    updated_at      BIGINT       NOT NULL,
+		    PRIMARY KEY (projection_name)
+		);
+		
+		-- name: create-outbox-table
+		CREATE TABLE IF NOT EXISTS {{table "outbox"}}
+		(
+		    id              BIGSERIAL      PRIMARY KEY,
+		    ordering        BIGINT         NOT NULL,
+		    persistence_id  VARCHAR(255)   NOT NULL,
+		    sequence_number BIGINT         NOT NULL,
+		    timestamp       BIGINT         NOT NULL,
+		    payload         BYTEA          NOT NULL,
+		    manifest        VARCHAR(255)   NOT NULL,
+		    writer_id       VARCHAR(255)   NOT NULL,
+		    codec           VARCHAR(32)    DEFAULT '' NOT NULL,
+		    key_id          VARCHAR(255)   DEFAULT '' NOT NULL,
+		    status          VARCHAR(16)    DEFAULT 'pending' NOT NULL,
+		    attempts        INTEGER        DEFAULT 0 NOT NULL,
+		    next_attempt_at BIGINT         DEFAULT 0 NOT NULL,
+		    last_error      VARCHAR(1024)  DEFAULT '' NOT NULL
+		);
+		
+		-- name: create-outbox-index
+		CREATE INDEX IF NOT EXISTS {{table "outbox"}}_status_idx ON {{table "outbox"}} (status, next_attempt_at);
+		
+		-- name: create-schema-version-table
+		CREATE TABLE IF NOT EXISTS {{table "schema_version"}}
+		(
+		    version     INTEGER      NOT NULL,
+		    description VARCHAR(255) NOT NULL,
+		    applied_at  BIGINT       NOT NULL,
+		    PRIMARY KEY (version)
+		);
+		
+		-- name: add-journal-codec
+		ALTER TABLE {{table "journal"}} ADD COLUMN {{journal "codec"}} VARCHAR(32) DEFAULT '' NOT NULL;
+
+		-- name: add-snapshot-codec
+		ALTER TABLE {{table "snapshot"}} ADD COLUMN {{snapshot "codec"}} VARCHAR(32) DEFAULT '' NOT NULL;
+
+		-- name: add-journal-key-id
+		ALTER TABLE {{table "journal"}} ADD COLUMN {{journal "key_id"}} VARCHAR(255) DEFAULT '' NOT NULL;
+
+		-- name: add-snapshot-key-id
+		ALTER TABLE {{table "snapshot"}} ADD COLUMN {{snapshot "key_id"}} VARCHAR(255) DEFAULT '' NOT NULL;
+
+		-- name: add-journal-tenant
+		ALTER TABLE {{table "journal"}}
+		    ADD COLUMN {{journal "tenant_id"}} VARCHAR(255) DEFAULT '' NOT NULL,
+		    DROP CONSTRAINT {{table "journal"}}_pkey,
+		    ADD PRIMARY KEY ({{journal "tenant_id"}}, {{journal "persistence_id"}}, {{journal "sequence_number"}});
+
+		-- name: create-journal-tenant-index
+		CREATE INDEX IF NOT EXISTS {{table "journal"}}_tenant_idx
+		    ON {{table "journal"}} ({{journal "tenant_id"}}, {{journal "ordering"}});
+
+		-- name: add-snapshot-tenant
+		ALTER TABLE {{table "snapshot"}}
+		    ADD COLUMN {{snapshot "tenant_id"}} VARCHAR(255) DEFAULT '' NOT NULL,
+		    DROP CONSTRAINT {{table "snapshot"}}_pkey,
+		    ADD PRIMARY KEY ({{snapshot "tenant_id"}}, {{snapshot "persistence_id"}}, {{snapshot "sequence_number"}});
+
+		-- name: add-writer-tenant
+		ALTER TABLE {{table "journal_writer"}}
+		    ADD COLUMN tenant_id VARCHAR(255) DEFAULT '' NOT NULL,
+		    DROP CONSTRAINT {{table "journal_writer"}}_pkey,
+		    ADD PRIMARY KEY (tenant_id, persistence_id);
+
+		-- name: add-projection-offset-tenant
+		ALTER TABLE {{table "projection_offset"}}
+		    ADD COLUMN tenant_id VARCHAR(255) DEFAULT '' NOT NULL,
+		    DROP CONSTRAINT {{table "projection_offset"}}_pkey,
+		    ADD PRIMARY KEY (tenant_id, projection_name);
+
+		-- name: add-outbox-tenant
+		ALTER TABLE {{table "outbox"}} ADD COLUMN tenant_id VARCHAR(255) DEFAULT '' NOT NULL;
+
+		-- name: drop-outbox-index
+		DROP INDEX IF EXISTS {{table "outbox"}}_status_idx;
+
+		-- name: create-outbox-tenant-index
+		CREATE INDEX IF NOT EXISTS {{table "outbox"}}_tenant_status_idx
+		    ON {{table "outbox"}} (tenant_id, status, next_attempt_at);
+
+		-- name: add-journal-serializer
+		ALTER TABLE {{table "journal"}} ADD COLUMN {{journal "serializer"}} VARCHAR(32) DEFAULT '' NOT NULL;
+
+		-- name: add-snapshot-serializer
+		ALTER TABLE {{table "snapshot"}} ADD COLUMN {{snapshot "serializer"}} VARCHAR(32) DEFAULT '' NOT NULL;
+
+		-- name: add-outbox-serializer
+		ALTER TABLE {{table "outbox"}} ADD COLUMN serializer VARCHAR(32) DEFAULT '' NOT NULL;
+
 		-- name: create-journal
-		INSERT INTO journal (persistence_id, sequence_number, timestamp, payload, manifest, writer_id)
-		VALUES ($1, $2, $3, $4, $5, $6);
+		INSERT INTO {{table "journal"}} ({{journal "tenant_id"}}, {{journal "persistence_id"}},
+		    {{journal "sequence_number"}}, {{journal "timestamp"}}, {{journal "payload"}}, {{journal "manifest"}},
+		    {{journal "writer_id"}}, {{journal "codec"}}, {{journal "key_id"}}, {{journal "serializer"}})
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);
+		
+		-- name: create-journals
+		INSERT INTO {{table "journal"}} ({{journal "tenant_id"}}, {{journal "persistence_id"}},
+		    {{journal "sequence_number"}}, {{journal "timestamp"}}, {{journal "payload"}}, {{journal "manifest"}},
+		    {{journal "writer_id"}}, {{journal "codec"}}, {{journal "key_id"}}, {{journal "serializer"}})
+		VALUES
+
+		-- name: lock-writer
+		SELECT writer_id FROM {{table "journal_writer"}} WHERE tenant_id = $1 AND persistence_id = $2 FOR SHARE
 		
 		-- name: create-snapshot
-		INSERT INTO snapshot (persistence_id, sequence_number, timestamp, snapshot, manifest, writer_id)
-		VALUES ($1, $2, $3, $4, $5, $6);
+		INSERT INTO {{table "snapshot"}} ({{snapshot "tenant_id"}}, {{snapshot "persistence_id"}},
+		    {{snapshot "sequence_number"}}, {{snapshot "timestamp"}}, {{snapshot "snapshot"}}, {{snapshot "manifest"}},
+		    {{snapshot "writer_id"}}, {{snapshot "codec"}}, {{snapshot "key_id"}},
+		    {{snapshot "serializer"}})
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);
+		
+		-- name: claim-writer
+		INSERT INTO {{table "journal_writer"}} (tenant_id, persistence_id, writer_id, claimed_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (tenant_id, persistence_id) DO UPDATE SET writer_id = EXCLUDED.writer_id,
+		    claimed_at = EXCLUDED.claimed_at;
 		
 		-- name: latest-snapshot
 		SELECT *
-		FROM snapshot
-		WHERE persistence_id = $1
-		ORDER BY sequence_number DESC
+		FROM {{table "snapshot"}}
+		WHERE {{snapshot "tenant_id"}} = $1 AND {{snapshot "persistence_id"}} = $2
+		ORDER BY {{snapshot "sequence_number"}} DESC
 		LIMIT 1
 
 		-- name: read-journals
-		SELECT * FROM journal 
-		WHERE persistence_id = $1 AND sequence_number >= $2 AND sequence_number <= $3 AND NOT deleted
-		ORDER BY sequence_number ASC
+		SELECT * FROM {{table "journal"}} 
+		WHERE {{journal "tenant_id"}} = $1 AND {{journal "persistence_id"}} = $2
+		    AND {{journal "sequence_number"}} >= $3 AND {{journal "sequence_number"}} <= $4
+		    AND NOT {{journal "deleted"}}
+		ORDER BY {{journal "sequence_number"}} ASC
+
+		-- name: read-journals-page
+		SELECT * FROM {{table "journal"}} 
+		WHERE {{journal "tenant_id"}} = $1 AND {{journal "persistence_id"}} = $2
+		    AND {{journal "sequence_number"}} >= $3 AND {{journal "sequence_number"}} <= $4
+		    AND NOT {{journal "deleted"}}
+		ORDER BY {{journal "sequence_number"}} ASC
+		LIMIT $5
+
+		-- name: read-journals-by-ordering
+		SELECT * FROM {{table "journal"}}
+		WHERE {{journal "tenant_id"}} = $1 AND {{journal "ordering"}} > $2 AND {{journal "ordering"}} <= $3
+		ORDER BY {{journal "ordering"}} ASC
+		LIMIT $4
+
+		-- name: create-tag
+		INSERT INTO {{table "event_tag"}} (ordering, tag)
+		SELECT {{journal "ordering"}}, $1 FROM {{table "journal"}}
+		WHERE {{journal "tenant_id"}} = $2 AND {{journal "persistence_id"}} = $3 AND {{journal "sequence_number"}} = $4
+
+		-- name: read-journals-by-tag
+		SELECT {{table "journal"}}.* FROM {{table "event_tag"}}
+		INNER JOIN {{table "journal"}} ON {{table "journal"}}.{{journal "ordering"}} = {{table "event_tag"}}.ordering
+		WHERE {{table "journal"}}.{{journal "tenant_id"}} = $1 AND {{table "event_tag"}}.tag = $2
+		    AND {{table "event_tag"}}.ordering > $3 AND {{table "event_tag"}}.ordering <= $4
+		    AND NOT {{table "journal"}}.{{journal "deleted"}}
+		ORDER BY {{table "event_tag"}}.ordering ASC
+		LIMIT $5
+
+		-- name: read-journal-orderings
+		SELECT {{journal "ordering"}}, {{journal "timestamp"}} FROM {{table "journal"}}
+		WHERE {{journal "ordering"}} > $1
+		ORDER BY {{journal "ordering"}} ASC
+		LIMIT $2
+
+		-- name: list-persistence-ids
+		SELECT DISTINCT {{journal "persistence_id"}} FROM {{table "journal"}}
+		WHERE {{journal "tenant_id"}} = $1 AND {{journal "persistence_id"}} > $2
+		    AND LEFT({{journal "persistence_id"}}, LENGTH($3)) = $4
+		ORDER BY {{journal "persistence_id"}} ASC
+		LIMIT $5
+
+		-- name: list-new-persistence-ids
+		SELECT first_row.{{journal "ordering"}}, first_row.{{journal "persistence_id"}},
+		    first_row.{{journal "sequence_number"}}, first_row.{{journal "timestamp"}}
+		FROM {{table "journal"}} first_row
+		WHERE first_row.{{journal "tenant_id"}} = $1 AND first_row.{{journal "ordering"}} > $2
+		    AND LEFT(first_row.{{journal "persistence_id"}}, LENGTH($3)) = $4
+		    AND NOT EXISTS (
+		        SELECT 1 FROM {{table "journal"}} earlier_row
+		        WHERE earlier_row.{{journal "tenant_id"}} = first_row.{{journal "tenant_id"}}
+		            AND earlier_row.{{journal "persistence_id"}} = first_row.{{journal "persistence_id"}}
+		            AND earlier_row.{{journal "sequence_number"}} < first_row.{{journal "sequence_number"}}
+		    )
+		ORDER BY first_row.{{journal "ordering"}} ASC
+		LIMIT $5
+
+		-- name: projection-offset
+		SELECT current_offset FROM {{table "projection_offset"}} WHERE tenant_id = $1 AND projection_name = $2
+
+		-- name: save-projection-offset
+		INSERT INTO {{table "projection_offset"}} (tenant_id, projection_name, current_offset, updated_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (tenant_id, projection_name) DO UPDATE SET current_offset = EXCLUDED.current_offset,
+		    updated_at = EXCLUDED.updated_at;
+
+		-- name: create-outbox-entry
+		INSERT INTO {{table "outbox"}} (tenant_id, ordering, persistence_id, sequence_number, timestamp, payload,
+		    manifest, writer_id, codec, key_id, serializer)
+		SELECT {{journal "tenant_id"}}, {{journal "ordering"}}, {{journal "persistence_id"}},
+		    {{journal "sequence_number"}}, {{journal "timestamp"}}, {{journal "payload"}}, {{journal "manifest"}},
+		    {{journal "writer_id"}}, {{journal "codec"}}, {{journal "key_id"}}, {{journal "serializer"}}
+		FROM {{table "journal"}} WHERE {{journal "tenant_id"}} = $1 AND {{journal "persistence_id"}} = $2
+		    AND {{journal "sequence_number"}} = $3
+
+		-- name: claim-outbox-entries
+		SELECT id, ordering, persistence_id, sequence_number, timestamp, payload, manifest, writer_id, codec, key_id, status,
+		       attempts, next_attempt_at, last_error, tenant_id, serializer
+		FROM {{table "outbox"}}
+		WHERE tenant_id = $1 AND status = 'pending' AND next_attempt_at <= $2
+		ORDER BY id ASC
+		LIMIT $3
+		FOR UPDATE SKIP LOCKED
+
+		-- name: outbox-entries
+		SELECT id, ordering, persistence_id, sequence_number, timestamp, payload, manifest, writer_id, codec, key_id, status,
+		       attempts, next_attempt_at, last_error, tenant_id, serializer
+		FROM {{table "outbox"}}
+		WHERE tenant_id = $1 AND status = $2
+		ORDER BY id ASC
+		LIMIT $3
+
+		-- name: list-outbox-tenants
+		SELECT DISTINCT tenant_id FROM {{table "outbox"}}
+		WHERE status = 'pending'
+		ORDER BY tenant_id ASC
+
+		-- name: lease-outbox-entry
+		UPDATE {{table "outbox"}} SET next_attempt_at = $1
+		WHERE id = $2
+
+		-- name: update-outbox-entry
+		UPDATE {{table "outbox"}} SET status = $1, attempts = $2, next_attempt_at = $3, last_error = $4
+		WHERE id = $5
+
+		-- name: notify-journal
+		SELECT pg_notify($1, '')
+
+		-- name: schema-version-table-exists
+		SELECT COUNT(*) FROM information_schema.tables
+		WHERE table_schema = CURRENT_SCHEMA() AND table_name = LOWER($1)
+
+		-- name: schema-versions
+		SELECT version FROM {{table "schema_version"}} ORDER BY version ASC
+
+		-- name: create-schema-version
+		INSERT INTO {{table "schema_version"}} (version, description, applied_at)
+		VALUES ($1, $2, $3)
+
+		-- name: lock-migrations
+		SELECT pg_advisory_lock(hashtext($1))
+
+		-- name: unlock-migrations
+		SELECT pg_advisory_unlock(hashtext($1))
 
 		-- name: delete-journals
-		DELETE FROM journal 
-		WHERE persistence_id = $1 AND sequence_number <= $2
+		DELETE FROM {{table "journal"}} 
+		WHERE {{journal "tenant_id"}} = $1 AND {{journal "persistence_id"}} = $2 AND {{journal "sequence_number"}} <= $3
 
 		-- name: logical-delete-journals
-		UPDATE journal
-		SET deleted = TRUE
-		WHERE persistence_id = $1 AND sequence_number <= $2
+		UPDATE {{table "journal"}}
+		SET {{journal "deleted"}} = TRUE
+		WHERE {{journal "tenant_id"}} = $1 AND {{journal "persistence_id"}} = $2 AND {{journal "sequence_number"}} <= $3
 
 		-- name: delete-snapshots
-		DELETE FROM snapshot 
-		WHERE persistence_id = $1 AND sequence_number <= $2
+		DELETE FROM {{table "snapshot"}} 
+		WHERE {{snapshot "tenant_id"}} = $1 AND {{snapshot "persistence_id"}} = $2
+		    AND {{snapshot "sequence_number"}} <= $3
+
+		-- name: list-snapshots
+		SELECT {{snapshot "persistence_id"}}, {{snapshot "sequence_number"}}, {{snapshot "timestamp"}}
+		FROM {{table "snapshot"}}
+		WHERE {{snapshot "tenant_id"}} = $1 AND {{snapshot "persistence_id"}} = $2
+		ORDER BY {{snapshot "sequence_number"}} ASC
+
+		-- name: list-snapshot-persistence-ids
+		SELECT DISTINCT {{snapshot "persistence_id"}}
+		FROM {{table "snapshot"}}
+		WHERE {{snapshot "tenant_id"}} = $1
+		ORDER BY {{snapshot "persistence_id"}} ASC
+
+		-- name: list-snapshot-tenants
+		SELECT DISTINCT {{snapshot "tenant_id"}}
+		FROM {{table "snapshot"}}
+		ORDER BY {{snapshot "tenant_id"}} ASC
+
+		-- name: delete-snapshot
+		DELETE FROM {{table "snapshot"}}
+		WHERE {{snapshot "tenant_id"}} = $1 AND {{snapshot "persistence_id"}} = $2
+		    AND {{snapshot "sequence_number"}} = $3
 	`
 	mysqlSQL = `
 		-- name: create-journal-table
-		CREATE TABLE IF NOT EXISTS journal
+		CREATE TABLE IF NOT EXISTS {{table "journal"}}
 		(
-		    ordering        SERIAL,
-		    persistence_id  VARCHAR(255)          NOT NULL,
-		    sequence_number BIGINT UNSIGNED       NOT NULL,
-		    timestamp       BIGINT                NOT NULL,
-		    payload         BLOB                  NOT NULL,
-		    manifest        VARCHAR(255)          NOT NULL,
-		    writer_id       VARCHAR(255)          NOT NULL,
-		    deleted         BOOLEAN DEFAULT FALSE NOT NULL,
-		    PRIMARY KEY (persistence_id, sequence_number)
+		    {{journal "ordering"}}        SERIAL,
+		    {{journal "persistence_id"}}  VARCHAR(255)          NOT NULL,
+		    {{journal "sequence_number"}} BIGINT UNSIGNED       NOT NULL,
+		    {{journal "timestamp"}}       BIGINT                NOT NULL,
+		    {{journal "payload"}}         BLOB                  NOT NULL,
+		    {{journal "manifest"}}        VARCHAR(255)          NOT NULL,
+		    {{journal "writer_id"}}       VARCHAR(255)          NOT NULL,
+		    {{journal "deleted"}}         BOOLEAN DEFAULT FALSE NOT NULL,
+		    PRIMARY KEY ({{journal "persistence_id"}}, {{journal "sequence_number"}})
 		);
 		
 		-- name: create-snapshot-table
-		CREATE TABLE IF NOT EXISTS snapshot
+		CREATE TABLE IF NOT EXISTS {{table "snapshot"}}
+		(
+		    {{snapshot "persistence_id"}}  VARCHAR(255)    NOT NULL,
+		    {{snapshot "sequence_number"}} BIGINT UNSIGNED NOT NULL,
+		    {{snapshot "timestamp"}}       BIGINT UNSIGNED NOT NULL,
+		    {{snapshot "snapshot"}}        BLOB            NOT NULL,
+		    {{snapshot "manifest"}}        VARCHAR(255)    NOT NULL,
+		    {{snapshot "writer_id"}}       VARCHAR(255)    NOT NULL,
+		    PRIMARY KEY ({{snapshot "persistence_id"}}, {{snapshot "sequence_number"}})
+		);
+		
+		-- name: create-tag-table
+		CREATE TABLE IF NOT EXISTS {{table "event_tag"}}
 		(
-		    persistence_id  VARCHAR(255)    NOT NULL,
-		    sequence_number BIGINT UNSIGNED NOT NULL,
-		    timestamp       BIGINT UNSIGNED NOT NULL,
-		    snapshot        BLOB            NOT NULL,
-		    manifest        VARCHAR(255)    NOT NULL,
-		    writer_id       VARCHAR(255)    NOT NULL,
-		    PRIMARY KEY (persistence_id, sequence_number)
+		    ordering BIGINT UNSIGNED NOT NULL,
+		    tag      VARCHAR(255)    NOT NULL,
+		    PRIMARY KEY (tag, ordering),
+		    FOREIGN KEY (ordering) REFERENCES {{table "journal"}} ({{journal "ordering"}}) ON DELETE CASCADE
 		);
 		
+		-- name: create-writer-table
+		CREATE TABLE IF NOT EXISTS {{table "journal_writer"}}
+		(
+		    persistence_id VARCHAR(255) NOT NULL,
+		    writer_id      VARCHAR(255) NOT NULL,
+		    claimed_at     BIGINT       NOT NULL,
+		    PRIMARY KEY (persistence_id)
+		);
+		
+		-- name: create-projection-offset-table
+		CREATE TABLE IF NOT EXISTS {{table "projection_offset"}}
+		(
+		    projection_name VARCHAR(255) NOT NULL,
+		    current_offset  BIGINT       NOT NULL,
+		    updated_at      BIGINT       NOT NULL,
+		    PRIMARY KEY (projection_name)
+		);
+		
+		-- name: create-outbox-table
+		CREATE TABLE IF NOT EXISTS {{table "outbox"}}
+		(
+		    id              SERIAL         PRIMARY KEY,
+		    ordering        BIGINT         NOT NULL,
+		    persistence_id  VARCHAR(255)   NOT NULL,
+		    sequence_number BIGINT         NOT NULL,
+		    timestamp       BIGINT         NOT NULL,
+		    payload         BLOB           NOT NULL,
+		    manifest        VARCHAR(255)   NOT NULL,
+		    writer_id       VARCHAR(255)   NOT NULL,
+		    codec           VARCHAR(32)    DEFAULT '' NOT NULL,
+		    key_id          VARCHAR(255)   DEFAULT '' NOT NULL,
+		    status          VARCHAR(16)    DEFAULT 'pending' NOT NULL,
+		    attempts        INTEGER        DEFAULT 0 NOT NULL,
+		    next_attempt_at BIGINT         DEFAULT 0 NOT NULL,
+		    last_error      VARCHAR(1024)  DEFAULT '' NOT NULL,
+		    INDEX (status, next_attempt_at)
+		);
+		
+		-- name: create-schema-version-table
+		CREATE TABLE IF NOT EXISTS {{table "schema_version"}}
+		(
+		    version     INTEGER      NOT NULL,
+		    description VARCHAR(255) NOT NULL,
+		    applied_at  BIGINT       NOT NULL,
+		    PRIMARY KEY (version)
+		);
+		
+		-- name: add-journal-codec
+		ALTER TABLE {{table "journal"}} ADD COLUMN {{journal "codec"}} VARCHAR(32) DEFAULT '' NOT NULL;
+
+		-- name: add-snapshot-codec
+		ALTER TABLE {{table "snapshot"}} ADD COLUMN {{snapshot "codec"}} VARCHAR(32) DEFAULT '' NOT NULL;
+
+		-- name: add-journal-key-id
+		ALTER TABLE {{table "journal"}} ADD COLUMN {{journal "key_id"}} VARCHAR(255) DEFAULT '' NOT NULL;
+
+		-- name: add-snapshot-key-id
+		ALTER TABLE {{table "snapshot"}} ADD COLUMN {{snapshot "key_id"}} VARCHAR(255) DEFAULT '' NOT NULL;
+
+		-- name: add-journal-tenant
+		ALTER TABLE {{table "journal"}}
+		    ADD COLUMN {{journal "tenant_id"}} VARCHAR(255) DEFAULT '' NOT NULL,
+		    DROP PRIMARY KEY,
+		    ADD PRIMARY KEY ({{journal "tenant_id"}}, {{journal "persistence_id"}}, {{journal "sequence_number"}}),
+		    ADD INDEX tenant_ordering ({{journal "tenant_id"}}, {{journal "ordering"}});
+
+		-- name: add-snapshot-tenant
+		ALTER TABLE {{table "snapshot"}}
+		    ADD COLUMN {{snapshot "tenant_id"}} VARCHAR(255) DEFAULT '' NOT NULL,
+		    DROP PRIMARY KEY,
+		    ADD PRIMARY KEY ({{snapshot "tenant_id"}}, {{snapshot "persistence_id"}}, {{snapshot "sequence_number"}});
+
+		-- name: add-writer-tenant
+		ALTER TABLE {{table "journal_writer"}}
+		    ADD COLUMN tenant_id VARCHAR(255) DEFAULT '' NOT NULL,
+		    DROP PRIMARY KEY,
+		    ADD PRIMARY KEY (tenant_id, persistence_id);
+
+		-- name: add-projection-offset-tenant
+		ALTER TABLE {{table "projection_offset"}}
+		    ADD COLUMN tenant_id VARCHAR(255) DEFAULT '' NOT NULL,
+		    DROP PRIMARY KEY,
+		    ADD PRIMARY KEY (tenant_id, projection_name);
+
+		-- name: add-outbox-tenant
+		ALTER TABLE {{table "outbox"}}
+		    ADD COLUMN tenant_id VARCHAR(255) DEFAULT '' NOT NULL,
+		    DROP INDEX status,
+		    ADD INDEX tenant_status (tenant_id, status, next_attempt_at);
+
+		-- name: add-journal-serializer
+		ALTER TABLE {{table "journal"}} ADD COLUMN {{journal "serializer"}} VARCHAR(32) DEFAULT '' NOT NULL;
+
+		-- name: add-snapshot-serializer
+		ALTER TABLE {{table "snapshot"}} ADD COLUMN {{snapshot "serializer"}} VARCHAR(32) DEFAULT '' NOT NULL;
+
+		-- name: add-outbox-serializer
+		ALTER TABLE {{table "outbox"}} ADD COLUMN serializer VARCHAR(32) DEFAULT '' NOT NULL;
+
 		-- name: create-journal
-		INSERT INTO journal (persistence_id, sequence_number, timestamp, payload, manifest, writer_id)
-		VALUES (?, ?, ?, ?, ?, ?);
+		INSERT INTO {{table "journal"}} ({{journal "tenant_id"}}, {{journal "persistence_id"}},
+		    {{journal "sequence_number"}}, {{journal "timestamp"}}, {{journal "payload"}}, {{journal "manifest"}},
+		    {{journal "writer_id"}}, {{journal "codec"}}, {{journal "key_id"}}, {{journal "serializer"}})
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+		
+		-- name: create-journals
+		INSERT INTO {{table "journal"}} ({{journal "tenant_id"}}, {{journal "persistence_id"}},
+		    {{journal "sequence_number"}}, {{journal "timestamp"}}, {{journal "payload"}}, {{journal "manifest"}},
+		    {{journal "writer_id"}}, {{journal "codec"}}, {{journal "key_id"}}, {{journal "serializer"}})
+		VALUES
+
+		-- name: lock-writer
+		SELECT writer_id FROM {{table "journal_writer"}} WHERE tenant_id = ? AND persistence_id = ? LOCK IN SHARE MODE
 		
 		-- name: create-snapshot
-		INSERT INTO snapshot (persistence_id, sequence_number, timestamp, snapshot, manifest, writer_id)
-		VALUES (?, ?, ?, ?, ?, ?);
+		INSERT INTO {{table "snapshot"}} ({{snapshot "tenant_id"}}, {{snapshot "persistence_id"}},
+		    {{snapshot "sequence_number"}}, {{snapshot "timestamp"}}, {{snapshot "snapshot"}}, {{snapshot "manifest"}},
+		    {{snapshot "writer_id"}}, {{snapshot "codec"}}, {{snapshot "key_id"}},
+		    {{snapshot "serializer"}})
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+		
+		-- name: claim-writer
+		INSERT INTO {{table "journal_writer"}} (tenant_id, persistence_id, writer_id, claimed_at)
+		VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE writer_id = VALUES(writer_id), claimed_at = VALUES(claimed_at);
 		
 		-- name: latest-snapshot
 		SELECT *
-		FROM snapshot
-		WHERE persistence_id = ?
-		ORDER BY sequence_number DESC
+		FROM {{table "snapshot"}}
+		WHERE {{snapshot "tenant_id"}} = ? AND {{snapshot "persistence_id"}} = ?
+		ORDER BY {{snapshot "sequence_number"}} DESC
 		LIMIT 1
 
 		-- name: read-journals
-		SELECT * FROM journal 
-		WHERE persistence_id = ? AND sequence_number >= ? AND sequence_number <= ? AND deleted IS NOT TRUE
-		ORDER BY sequence_number ASC
+		SELECT * FROM {{table "journal"}} 
+		WHERE {{journal "tenant_id"}} = ? AND {{journal "persistence_id"}} = ?
+		    AND {{journal "sequence_number"}} >= ? AND {{journal "sequence_number"}} <= ?
+		    AND {{journal "deleted"}} IS NOT TRUE
+		ORDER BY {{journal "sequence_number"}} ASC
+
+		-- name: read-journals-page
+		SELECT * FROM {{table "journal"}} 
+		WHERE {{journal "tenant_id"}} = ? AND {{journal "persistence_id"}} = ?
+		    AND {{journal "sequence_number"}} >= ? AND {{journal "sequence_number"}} <= ?
+		    AND {{journal "deleted"}} IS NOT TRUE
+		ORDER BY {{journal "sequence_number"}} ASC
+		LIMIT ?
+
+		-- name: read-journals-by-ordering
+		SELECT * FROM {{table "journal"}}
+		WHERE {{journal "tenant_id"}} = ? AND {{journal "ordering"}} > ? AND {{journal "ordering"}} <= ?
+		ORDER BY {{journal "ordering"}} ASC
+		LIMIT ?
+
+		-- name: create-tag
+		INSERT INTO {{table "event_tag"}} (ordering, tag)
+		SELECT {{journal "ordering"}}, ? FROM {{table "journal"}}
+		WHERE {{journal "tenant_id"}} = ? AND {{journal "persistence_id"}} = ? AND {{journal "sequence_number"}} = ?
+
+		-- name: read-journals-by-tag
+		SELECT {{table "journal"}}.* FROM {{table "event_tag"}}
+		INNER JOIN {{table "journal"}} ON {{table "journal"}}.{{journal "ordering"}} = {{table "event_tag"}}.ordering
+		WHERE {{table "journal"}}.{{journal "tenant_id"}} = ? AND {{table "event_tag"}}.tag = ?
+		    AND {{table "event_tag"}}.ordering > ? AND {{table "event_tag"}}.ordering <= ?
+		    AND {{table "journal"}}.{{journal "deleted"}} IS NOT TRUE
+		ORDER BY {{table "event_tag"}}.ordering ASC
+		LIMIT ?
+
+		-- name: read-journal-orderings
+		SELECT {{journal "ordering"}}, {{journal "timestamp"}} FROM {{table "journal"}}
+		WHERE {{journal "ordering"}} > ?
+		ORDER BY {{journal "ordering"}} ASC
+		LIMIT ?
+
+		-- name: list-persistence-ids
+		SELECT DISTINCT {{journal "persistence_id"}} FROM {{table "journal"}}
+		WHERE {{journal "tenant_id"}} = ? AND {{journal "persistence_id"}} > ?
+		    AND LEFT({{journal "persistence_id"}}, CHAR_LENGTH(?)) = ?
+		ORDER BY {{journal "persistence_id"}} ASC
+		LIMIT ?
+
+		-- name: list-new-persistence-ids
+		SELECT first_row.{{journal "ordering"}}, first_row.{{journal "persistence_id"}},
+		    first_row.{{journal "sequence_number"}}, first_row.{{journal "timestamp"}}
+		FROM {{table "journal"}} first_row
+		WHERE first_row.{{journal "tenant_id"}} = ? AND first_row.{{journal "ordering"}} > ?
+		    AND LEFT(first_row.{{journal "persistence_id"}}, CHAR_LENGTH(?)) = ?
+		    AND NOT EXISTS (
+		        SELECT 1 FROM {{table "journal"}} earlier_row
+		        WHERE earlier_row.{{journal "tenant_id"}} = first_row.{{journal "tenant_id"}}
+		            AND earlier_row.{{journal "persistence_id"}} = first_row.{{journal "persistence_id"}}
+		            AND earlier_row.{{journal "sequence_number"}} < first_row.{{journal "sequence_number"}}
+		    )
+		ORDER BY first_row.{{journal "ordering"}} ASC
+		LIMIT ?
+
+		-- name: projection-offset
+		SELECT current_offset FROM {{table "projection_offset"}} WHERE tenant_id = ? AND projection_name = ?
+
+		-- name: save-projection-offset
+		INSERT INTO {{table "projection_offset"}} (tenant_id, projection_name, current_offset, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE current_offset = VALUES(current_offset), updated_at = VALUES(updated_at);
+
+		-- name: create-outbox-entry
+		INSERT INTO {{table "outbox"}} (tenant_id, ordering, persistence_id, sequence_number, timestamp, payload,
+		    manifest, writer_id, codec, key_id, serializer)
+		SELECT {{journal "tenant_id"}}, {{journal "ordering"}}, {{journal "persistence_id"}},
+		    {{journal "sequence_number"}}, {{journal "timestamp"}}, {{journal "payload"}}, {{journal "manifest"}},
+		    {{journal "writer_id"}}, {{journal "codec"}}, {{journal "key_id"}}, {{journal "serializer"}}
+		FROM {{table "journal"}} WHERE {{journal "tenant_id"}} = ? AND {{journal "persistence_id"}} = ?
+		    AND {{journal "sequence_number"}} = ?
+
+		-- name: claim-outbox-entries
+		SELECT id, ordering, persistence_id, sequence_number, timestamp, payload, manifest, writer_id, codec, key_id, status,
+		       attempts, next_attempt_at, last_error, tenant_id, serializer
+		FROM {{table "outbox"}}
+		WHERE tenant_id = ? AND status = 'pending' AND next_attempt_at <= ?
+		ORDER BY id ASC
+		LIMIT ?
+		FOR UPDATE SKIP LOCKED
+
+		-- name: outbox-entries
+		SELECT id, ordering, persistence_id, sequence_number, timestamp, payload, manifest, writer_id, codec, key_id, status,
+		       attempts, next_attempt_at, last_error, tenant_id, serializer
+		FROM {{table "outbox"}}
+		WHERE tenant_id = ? AND status = ?
+		ORDER BY id ASC
+		LIMIT ?
+
+		-- name: list-outbox-tenants
+		SELECT DISTINCT tenant_id FROM {{table "outbox"}}
+		WHERE status = 'pending'
+		ORDER BY tenant_id ASC
+
+		-- name: lease-outbox-entry
+		UPDATE {{table "outbox"}} SET next_attempt_at = ?
+		WHERE id = ?
+
+		-- name: update-outbox-entry
+		UPDATE {{table "outbox"}} SET status = ?, attempts = ?, next_attempt_at = ?, last_error = ?
+		WHERE id = ?
+
+		-- name: schema-version-table-exists
+		SELECT COUNT(*) FROM information_schema.tables
+		WHERE table_schema = DATABASE() AND table_name = ?
+
+		-- name: schema-versions
+		SELECT version FROM {{table "schema_version"}} ORDER BY version ASC
+
+		-- name: create-schema-version
+		INSERT INTO {{table "schema_version"}} (version, description, applied_at)
+		VALUES (?, ?, ?)
+
+		-- name: lock-migrations
+		DO GET_LOCK(?, -1)
+
+		-- name: unlock-migrations
+		DO RELEASE_LOCK(?)
+
+		-- name: delete-journals
+		DELETE FROM {{table "journal"}} 
+		WHERE {{journal "tenant_id"}} = ? AND {{journal "persistence_id"}} = ? AND {{journal "sequence_number"}} <= ?
+
+		-- name: logical-delete-journals
+		UPDATE {{table "journal"}}
+		SET {{journal "deleted"}} = TRUE
+		WHERE {{journal "tenant_id"}} = ? AND {{journal "persistence_id"}} = ? AND {{journal "sequence_number"}} <= ?
+
+		-- name: delete-snapshots
+		DELETE FROM {{table "snapshot"}} 
+		WHERE {{snapshot "tenant_id"}} = ? AND {{snapshot "persistence_id"}} = ? AND {{snapshot "sequence_number"}} <= ?
+
+		-- name: list-snapshots
+		SELECT {{snapshot "persistence_id"}}, {{snapshot "sequence_number"}}, {{snapshot "timestamp"}}
+		FROM {{table "snapshot"}}
+		WHERE {{snapshot "tenant_id"}} = ? AND {{snapshot "persistence_id"}} = ?
+		ORDER BY {{snapshot "sequence_number"}} ASC
+
+		-- name: list-snapshot-persistence-ids
+		SELECT DISTINCT {{snapshot "persistence_id"}}
+		FROM {{table "snapshot"}}
+		WHERE {{snapshot "tenant_id"}} = ?
+		ORDER BY {{snapshot "persistence_id"}} ASC
+
+		-- name: list-snapshot-tenants
+		SELECT DISTINCT {{snapshot "tenant_id"}}
+		FROM {{table "snapshot"}}
+		ORDER BY {{snapshot "tenant_id"}} ASC
+
+		-- name: delete-snapshot
+		DELETE FROM {{table "snapshot"}}
+		WHERE {{snapshot "tenant_id"}} = ? AND {{snapshot "persistence_id"}} = ? AND {{snapshot "sequence_number"}} = ?
+	`
+	sqliteSQL = `
+		-- name: create-journal-table
+		CREATE TABLE IF NOT EXISTS {{table "journal"}}
+		(
+		    {{journal "ordering"}}        INTEGER PRIMARY KEY AUTOINCREMENT,
+		    {{journal "persistence_id"}}  VARCHAR(255)          NOT NULL,
+		    {{journal "sequence_number"}} BIGINT                NOT NULL,
+		    {{journal "timestamp"}}       BIGINT                NOT NULL,
+		    {{journal "payload"}}         BLOB                  NOT NULL,
+		    {{journal "manifest"}}        VARCHAR(255)          NOT NULL,
+		    {{journal "writer_id"}}       VARCHAR(255)          NOT NULL,
+		    {{journal "deleted"}}         BOOLEAN DEFAULT FALSE NOT NULL,
+		    UNIQUE ({{journal "persistence_id"}}, {{journal "sequence_number"}})
+		);
+		
+		-- name: create-snapshot-table
+		CREATE TABLE IF NOT EXISTS {{table "snapshot"}}
+		(
+		    {{snapshot "persistence_id"}}  VARCHAR(255) NOT NULL,
+		    {{snapshot "sequence_number"}} BIGINT       NOT NULL,
+		    {{snapshot "timestamp"}}       BIGINT       NOT NULL,
+		    {{snapshot "snapshot"}}        BLOB         NOT NULL,
+		    {{snapshot "manifest"}}        VARCHAR(255) NOT NULL,
+		    {{snapshot "writer_id"}}       VARCHAR(255) NOT NULL,
+		    PRIMARY KEY ({{snapshot "persistence_id"}}, {{snapshot "sequence_number"}})
+		);
+		
+		-- name: create-tag-table
+		CREATE TABLE IF NOT EXISTS {{table "event_tag"}}
+		(
+		    ordering INTEGER      NOT NULL REFERENCES {{table "journal"}} ({{journal "ordering"}}) ON DELETE CASCADE,
+		    tag      VARCHAR(255) NOT NULL,
+		    PRIMARY KEY (tag, ordering)
+		);
+		
+		-- name: create-writer-table
+		CREATE TABLE IF NOT EXISTS {{table "journal_writer"}}
+		(
+		    persistence_id VARCHAR(255) NOT NULL,
+		    writer_id      VARCHAR(255) NOT NULL,
+		    claimed_at     BIGINT       NOT NULL,
+		    PRIMARY KEY (persistence_id)
+		);
+		
+		-- name: create-projection-offset-table
+		CREATE TABLE IF NOT EXISTS {{table "projection_offset"}}
+		(
+		    projection_name VARCHAR(255) NOT NULL,
+		    current_offset  BIGINT       NOT NULL,
+		    updated_at      BIGINT       NOT NULL,
+		    PRIMARY KEY (projection_name)
+		);
+		
+		-- name: create-outbox-table
+		CREATE TABLE IF NOT EXISTS {{table "outbox"}}
+		(
+		    id              INTEGER        PRIMARY KEY AUTOINCREMENT,
+		    ordering        BIGINT         NOT NULL,
+		    persistence_id  VARCHAR(255)   NOT NULL,
+		    sequence_number BIGINT         NOT NULL,
+		    timestamp       BIGINT         NOT NULL,
+		    payload         BLOB           NOT NULL,
+		    manifest        VARCHAR(255)   NOT NULL,
+		    writer_id       VARCHAR(255)   NOT NULL,
+		    codec           VARCHAR(32)    DEFAULT '' NOT NULL,
+		    key_id          VARCHAR(255)   DEFAULT '' NOT NULL,
+		    status          VARCHAR(16)    DEFAULT 'pending' NOT NULL,
+		    attempts        INTEGER        DEFAULT 0 NOT NULL,
+		    next_attempt_at BIGINT         DEFAULT 0 NOT NULL,
+		    last_error      VARCHAR(1024)  DEFAULT '' NOT NULL
+		);
+		
+		-- name: create-outbox-index
+		CREATE INDEX IF NOT EXISTS {{table "outbox"}}_status_idx ON {{table "outbox"}} (status, next_attempt_at);
+		
+		-- name: create-schema-version-table
+		CREATE TABLE IF NOT EXISTS {{table "schema_version"}}
+		(
+		    version     INTEGER      NOT NULL,
+		    description VARCHAR(255) NOT NULL,
+		    applied_at  BIGINT       NOT NULL,
+		    PRIMARY KEY (version)
+		);
+		
+		-- name: add-journal-codec
+		ALTER TABLE {{table "journal"}} ADD COLUMN {{journal "codec"}} VARCHAR(32) DEFAULT '' NOT NULL;
+
+		-- name: add-snapshot-codec
+		ALTER TABLE {{table "snapshot"}} ADD COLUMN {{snapshot "codec"}} VARCHAR(32) DEFAULT '' NOT NULL;
+
+		-- name: add-journal-key-id
+		ALTER TABLE {{table "journal"}} ADD COLUMN {{journal "key_id"}} VARCHAR(255) DEFAULT '' NOT NULL;
+
+		-- name: add-snapshot-key-id
+		ALTER TABLE {{table "snapshot"}} ADD COLUMN {{snapshot "key_id"}} VARCHAR(255) DEFAULT '' NOT NULL;
+
+		-- name: add-journal-tenant
+		CREATE TABLE {{table "journal"}}_tenant
+		(
+		    {{journal "ordering"}}        INTEGER PRIMARY KEY AUTOINCREMENT,
+		    {{journal "persistence_id"}}  VARCHAR(255)          NOT NULL,
+		    {{journal "sequence_number"}} BIGINT                NOT NULL,
+		    {{journal "timestamp"}}       BIGINT                NOT NULL,
+		    {{journal "payload"}}         BLOB                  NOT NULL,
+		    {{journal "manifest"}}        VARCHAR(255)          NOT NULL,
+		    {{journal "writer_id"}}       VARCHAR(255)          NOT NULL,
+		    {{journal "deleted"}}         BOOLEAN DEFAULT FALSE NOT NULL,
+		    {{journal "codec"}}           VARCHAR(32) DEFAULT '' NOT NULL,
+		    {{journal "key_id"}}          VARCHAR(255) DEFAULT '' NOT NULL,
+		    {{journal "tenant_id"}}       VARCHAR(255) DEFAULT '' NOT NULL,
+		    UNIQUE ({{journal "tenant_id"}}, {{journal "persistence_id"}}, {{journal "sequence_number"}})
+		);
+		INSERT INTO {{table "journal"}}_tenant
+		SELECT {{journal "ordering"}}, {{journal "persistence_id"}}, {{journal "sequence_number"}},
+		    {{journal "timestamp"}}, {{journal "payload"}}, {{journal "manifest"}}, {{journal "writer_id"}},
+		    {{journal "deleted"}}, {{journal "codec"}}, {{journal "key_id"}}, ''
+		FROM {{table "journal"}};
+		DELETE FROM sqlite_sequence WHERE name = '{{table "journal"}}_tenant';
+		INSERT INTO sqlite_sequence (name, seq)
+		SELECT '{{table "journal"}}_tenant', seq FROM sqlite_sequence WHERE name = '{{table "journal"}}';
+		DROP TABLE {{table "journal"}};
+		ALTER TABLE {{table "journal"}}_tenant RENAME TO {{table "journal"}};
+
+		-- name: create-journal-tenant-index
+		CREATE INDEX IF NOT EXISTS {{table "journal"}}_tenant_idx
+		    ON {{table "journal"}} ({{journal "tenant_id"}}, {{journal "ordering"}});
+
+		-- name: add-snapshot-tenant
+		CREATE TABLE {{table "snapshot"}}_tenant
+		(
+		    {{snapshot "persistence_id"}}  VARCHAR(255) NOT NULL,
+		    {{snapshot "sequence_number"}} BIGINT       NOT NULL,
+		    {{snapshot "timestamp"}}       BIGINT       NOT NULL,
+		    {{snapshot "snapshot"}}        BLOB         NOT NULL,
+		    {{snapshot "manifest"}}        VARCHAR(255) NOT NULL,
+		    {{snapshot "writer_id"}}       VARCHAR(255) NOT NULL,
+		    {{snapshot "codec"}}           VARCHAR(32)  DEFAULT '' NOT NULL,
+		    {{snapshot "key_id"}}          VARCHAR(255) DEFAULT '' NOT NULL,
+		    {{snapshot "tenant_id"}}       VARCHAR(255) DEFAULT '' NOT NULL,
+		    PRIMARY KEY ({{snapshot "tenant_id"}}, {{snapshot "persistence_id"}}, {{snapshot "sequence_number"}})
+		);
+		INSERT INTO {{table "snapshot"}}_tenant
+		SELECT {{snapshot "persistence_id"}}, {{snapshot "sequence_number"}}, {{snapshot "timestamp"}},
+		    {{snapshot "snapshot"}}, {{snapshot "manifest"}}, {{snapshot "writer_id"}}, {{snapshot "codec"}},
+		    {{snapshot "key_id"}}, ''
+		FROM {{table "snapshot"}};
+		DROP TABLE {{table "snapshot"}};
+		ALTER TABLE {{table "snapshot"}}_tenant RENAME TO {{table "snapshot"}};
+
+		-- name: add-writer-tenant
+		CREATE TABLE {{table "journal_writer"}}_tenant
+		(
+		    persistence_id VARCHAR(255) NOT NULL,
+		    writer_id      VARCHAR(255) NOT NULL,
+		    claimed_at     BIGINT       NOT NULL,
+		    tenant_id      VARCHAR(255) DEFAULT '' NOT NULL,
+		    PRIMARY KEY (tenant_id, persistence_id)
+		);
+		INSERT INTO {{table "journal_writer"}}_tenant
+		SELECT persistence_id, writer_id, claimed_at, '' FROM {{table "journal_writer"}};
+		DROP TABLE {{table "journal_writer"}};
+		ALTER TABLE {{table "journal_writer"}}_tenant RENAME TO {{table "journal_writer"}};
+
+		-- name: add-projection-offset-tenant
+		CREATE TABLE {{table "projection_offset"}}_tenant
+		(
+		    projection_name VARCHAR(255) NOT NULL,
+		    current_offset  BIGINT       NOT NULL,
+		    updated_at      BIGINT       NOT NULL,
+		    tenant_id       VARCHAR(255) DEFAULT '' NOT NULL,
+		    PRIMARY KEY (tenant_id, projection_name)
+		);
+		INSERT INTO {{table "projection_offset"}}_tenant
+		SELECT projection_name, current_offset, updated_at, '' FROM {{table "projection_offset"}};
+		DROP TABLE {{table "projection_offset"}};
+		ALTER TABLE {{table "projection_offset"}}_tenant RENAME TO {{table "projection_offset"}};
+
+		-- name: add-outbox-tenant
+		ALTER TABLE {{table "outbox"}} ADD COLUMN tenant_id VARCHAR(255) DEFAULT '' NOT NULL;
+
+		-- name: drop-outbox-index
+		DROP INDEX IF EXISTS {{table "outbox"}}_status_idx;
+
+		-- name: create-outbox-tenant-index
+		CREATE INDEX IF NOT EXISTS {{table "outbox"}}_tenant_status_idx
+		    ON {{table "outbox"}} (tenant_id, status, next_attempt_at);
+
+		-- name: add-journal-serializer
+		ALTER TABLE {{table "journal"}} ADD COLUMN {{journal "serializer"}} VARCHAR(32) DEFAULT '' NOT NULL;
+
+		-- name: add-snapshot-serializer
+		ALTER TABLE {{table "snapshot"}} ADD COLUMN {{snapshot "serializer"}} VARCHAR(32) DEFAULT '' NOT NULL;
+
+		-- name: add-outbox-serializer
+		ALTER TABLE {{table "outbox"}} ADD COLUMN serializer VARCHAR(32) DEFAULT '' NOT NULL;
+
+		-- name: create-journal
+		INSERT INTO {{table "journal"}} ({{journal "tenant_id"}}, {{journal "persistence_id"}},
+		    {{journal "sequence_number"}}, {{journal "timestamp"}}, {{journal "payload"}}, {{journal "manifest"}},
+		    {{journal "writer_id"}}, {{journal "codec"}}, {{journal "key_id"}}, {{journal "serializer"}})
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+		
+		-- name: create-journals
+		INSERT INTO {{table "journal"}} ({{journal "tenant_id"}}, {{journal "persistence_id"}},
+		    {{journal "sequence_number"}}, {{journal "timestamp"}}, {{journal "payload"}}, {{journal "manifest"}},
+		    {{journal "writer_id"}}, {{journal "codec"}}, {{journal "key_id"}}, {{journal "serializer"}})
+		VALUES
+
+		-- name: lock-writer
+		SELECT writer_id FROM {{table "journal_writer"}} WHERE tenant_id = ? AND persistence_id = ?
+		
+		-- name: create-snapshot
+		INSERT INTO {{table "snapshot"}} ({{snapshot "tenant_id"}}, {{snapshot "persistence_id"}},
+		    {{snapshot "sequence_number"}}, {{snapshot "timestamp"}}, {{snapshot "snapshot"}}, {{snapshot "manifest"}},
+		    {{snapshot "writer_id"}}, {{snapshot "codec"}}, {{snapshot "key_id"}},
+		    {{snapshot "serializer"}})
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+		
+		-- name: claim-writer
+		INSERT INTO {{table "journal_writer"}} (tenant_id, persistence_id, writer_id, claimed_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (tenant_id, persistence_id) DO UPDATE SET writer_id = excluded.writer_id,
+		    claimed_at = excluded.claimed_at;
+		
+		-- name: latest-snapshot
+		SELECT *
+		FROM {{table "snapshot"}}
+		WHERE {{snapshot "tenant_id"}} = ? AND {{snapshot "persistence_id"}} = ?
+		ORDER BY {{snapshot "sequence_number"}} DESC
+		LIMIT 1
+
+		-- name: read-journals
+		SELECT * FROM {{table "journal"}} 
+		WHERE {{journal "tenant_id"}} = ? AND {{journal "persistence_id"}} = ?
+		    AND {{journal "sequence_number"}} >= ? AND {{journal "sequence_number"}} <= ? AND NOT {{journal "deleted"}}
+		ORDER BY {{journal "sequence_number"}} ASC
+
+		-- name: read-journals-page
+		SELECT * FROM {{table "journal"}} 
+		WHERE {{journal "tenant_id"}} = ? AND {{journal "persistence_id"}} = ?
+		    AND {{journal "sequence_number"}} >= ? AND {{journal "sequence_number"}} <= ? AND NOT {{journal "deleted"}}
+		ORDER BY {{journal "sequence_number"}} ASC
+		LIMIT ?
+
+		-- name: read-journals-by-ordering
+		SELECT * FROM {{table "journal"}}
+		WHERE {{journal "tenant_id"}} = ? AND {{journal "ordering"}} > ? AND {{journal "ordering"}} <= ?
+		ORDER BY {{journal "ordering"}} ASC
+		LIMIT ?
+
+		-- name: create-tag
+		INSERT INTO {{table "event_tag"}} (ordering, tag)
+		SELECT {{journal "ordering"}}, ? FROM {{table "journal"}}
+		WHERE {{journal "tenant_id"}} = ? AND {{journal "persistence_id"}} = ? AND {{journal "sequence_number"}} = ?
+
+		-- name: read-journals-by-tag
+		SELECT {{table "journal"}}.* FROM {{table "event_tag"}}
+		INNER JOIN {{table "journal"}} ON {{table "journal"}}.{{journal "ordering"}} = {{table "event_tag"}}.ordering
+		WHERE {{table "journal"}}.{{journal "tenant_id"}} = ? AND {{table "event_tag"}}.tag = ?
+		    AND {{table "event_tag"}}.ordering > ? AND {{table "event_tag"}}.ordering <= ?
+		    AND NOT {{table "journal"}}.{{journal "deleted"}}
+		ORDER BY {{table "event_tag"}}.ordering ASC
+		LIMIT ?
+
+		-- name: read-journal-orderings
+		SELECT {{journal "ordering"}}, {{journal "timestamp"}} FROM {{table "journal"}}
+		WHERE {{journal "ordering"}} > ?
+		ORDER BY {{journal "ordering"}} ASC
+		LIMIT ?
+
+		-- name: list-persistence-ids
+		SELECT DISTINCT {{journal "persistence_id"}} FROM {{table "journal"}}
+		WHERE {{journal "tenant_id"}} = ? AND {{journal "persistence_id"}} > ?
+		    AND SUBSTR({{journal "persistence_id"}}, 1, LENGTH(?)) = ?
+		ORDER BY {{journal "persistence_id"}} ASC
+		LIMIT ?
+
+		-- name: list-new-persistence-ids
+		SELECT first_row.{{journal "ordering"}}, first_row.{{journal "persistence_id"}},
+		    first_row.{{journal "sequence_number"}}, first_row.{{journal "timestamp"}}
+		FROM {{table "journal"}} first_row
+		WHERE first_row.{{journal "tenant_id"}} = ? AND first_row.{{journal "ordering"}} > ?
+		    AND SUBSTR(first_row.{{journal "persistence_id"}}, 1, LENGTH(?)) = ?
+		    AND NOT EXISTS (
+		        SELECT 1 FROM {{table "journal"}} earlier_row
+		        WHERE earlier_row.{{journal "tenant_id"}} = first_row.{{journal "tenant_id"}}
+		            AND earlier_row.{{journal "persistence_id"}} = first_row.{{journal "persistence_id"}}
+		            AND earlier_row.{{journal "sequence_number"}} < first_row.{{journal "sequence_number"}}
+		    )
+		ORDER BY first_row.{{journal "ordering"}} ASC
+		LIMIT ?
+
+		-- name: projection-offset
+		SELECT current_offset FROM {{table "projection_offset"}} WHERE tenant_id = ? AND projection_name = ?
+
+		-- name: save-projection-offset
+		INSERT INTO {{table "projection_offset"}} (tenant_id, projection_name, current_offset, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (tenant_id, projection_name) DO UPDATE SET current_offset = excluded.current_offset,
+		    updated_at = excluded.updated_at;
+
+		-- name: create-outbox-entry
+		INSERT INTO {{table "outbox"}} (tenant_id, ordering, persistence_id, sequence_number, timestamp, payload,
+		    manifest, writer_id, codec, key_id, serializer)
+		SELECT {{journal "tenant_id"}}, {{journal "ordering"}}, {{journal "persistence_id"}},
+		    {{journal "sequence_number"}}, {{journal "timestamp"}}, {{journal "payload"}}, {{journal "manifest"}},
+		    {{journal "writer_id"}}, {{journal "codec"}}, {{journal "key_id"}}, {{journal "serializer"}}
+		FROM {{table "journal"}} WHERE {{journal "tenant_id"}} = ? AND {{journal "persistence_id"}} = ?
+		    AND {{journal "sequence_number"}} = ?
+
+		-- name: claim-outbox-entries
+		SELECT id, ordering, persistence_id, sequence_number, timestamp, payload, manifest, writer_id, codec, key_id, status,
+		       attempts, next_attempt_at, last_error, tenant_id, serializer
+		FROM {{table "outbox"}}
+		WHERE tenant_id = ? AND status = 'pending' AND next_attempt_at <= ?
+		ORDER BY id ASC
+		LIMIT ?
+
+		-- name: outbox-entries
+		SELECT id, ordering, persistence_id, sequence_number, timestamp, payload, manifest, writer_id, codec, key_id, status,
+		       attempts, next_attempt_at, last_error, tenant_id, serializer
+		FROM {{table "outbox"}}
+		WHERE tenant_id = ? AND status = ?
+		ORDER BY id ASC
+		LIMIT ?
+
+		-- name: list-outbox-tenants
+		SELECT DISTINCT tenant_id FROM {{table "outbox"}}
+		WHERE status = 'pending'
+		ORDER BY tenant_id ASC
+
+		-- name: lease-outbox-entry
+		UPDATE {{table "outbox"}} SET next_attempt_at = ?
+		WHERE id = ?
+
+		-- name: update-outbox-entry
+		UPDATE {{table "outbox"}} SET status = ?, attempts = ?, next_attempt_at = ?, last_error = ?
+		WHERE id = ?
+
+		-- name: schema-version-table-exists
+		SELECT COUNT(*) FROM sqlite_master
+		WHERE type = 'table' AND name = ? COLLATE NOCASE
+
+		-- name: schema-versions
+		SELECT version FROM {{table "schema_version"}} ORDER BY version ASC
+
+		-- name: create-schema-version
+		INSERT INTO {{table "schema_version"}} (version, description, applied_at)
+		VALUES (?, ?, ?)
 
 		-- name: delete-journals
-		DELETE FROM journal 
-		WHERE persistence_id = ? AND sequence_number <= ?
+		DELETE FROM {{table "journal"}} 
+		WHERE {{journal "tenant_id"}} = ? AND {{journal "persistence_id"}} = ? AND {{journal "sequence_number"}} <= ?
 
 		-- name: logical-delete-journals
-		UPDATE journal
-		SET deleted = TRUE
-		WHERE persistence_id = ? AND sequence_number <= ?
+		UPDATE {{table "journal"}}
+		SET {{journal "deleted"}} = TRUE
+		WHERE {{journal "tenant_id"}} = ? AND {{journal "persistence_id"}} = ? AND {{journal "sequence_number"}} <= ?
 
 		-- name: delete-snapshots
-		DELETE FROM snapshot 
-		WHERE persistence_id = ? AND sequence_number <= ?
+		DELETE FROM {{table "snapshot"}} 
+		WHERE {{snapshot "tenant_id"}} = ? AND {{snapshot "persistence_id"}} = ? AND {{snapshot "sequence_number"}} <= ?
+
+		-- name: list-snapshots
+		SELECT {{snapshot "persistence_id"}}, {{snapshot "sequence_number"}}, {{snapshot "timestamp"}}
+		FROM {{table "snapshot"}}
+		WHERE {{snapshot "tenant_id"}} = ? AND {{snapshot "persistence_id"}} = ?
+		ORDER BY {{snapshot "sequence_number"}} ASC
+
+		-- name: list-snapshot-persistence-ids
+		SELECT DISTINCT {{snapshot "persistence_id"}}
+		FROM {{table "snapshot"}}
+		WHERE {{snapshot "tenant_id"}} = ?
+		ORDER BY {{snapshot "persistence_id"}} ASC
+
+		-- name: list-snapshot-tenants
+		SELECT DISTINCT {{snapshot "tenant_id"}}
+		FROM {{table "snapshot"}}
+		ORDER BY {{snapshot "tenant_id"}} ASC
+
+		-- name: delete-snapshot
+		DELETE FROM {{table "snapshot"}}
+		WHERE {{snapshot "tenant_id"}} = ? AND {{snapshot "persistence_id"}} = ? AND {{snapshot "sequence_number"}} = ?
 	`
 )
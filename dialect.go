@@ -3,28 +3,76 @@ package persistencesql
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/gchaincl/dotsql"
 	_ "github.com/go-sql-driver/mysql" // load the mysql driver
-	"github.com/hashicorp/go-multierror"
-	_ "github.com/lib/pq" // loads the Postgres driver
+	"github.com/lib/pq"                // loads the Postgres driver
+	_ "github.com/mattn/go-sqlite3"    // loads the SQLite driver
 )
 
 const (
 	createJournalTableStmt     = "create-journal-table"
 	createSnapshotTableStmt    = "create-snapshot-table"
+	createWriterTableStmt      = "create-writer-table"
 	createJournalQueryStmt     = "create-journal"
+	createJournalsQueryStmt    = "create-journals"
+	lockWriterQueryStmt        = "lock-writer"
 	createSnapshotQueryStmt    = "create-snapshot"
 	latestSnapshotQueryStmt    = "latest-snapshot"
 	readJournalQueryStmt       = "read-journals"
+	readJournalPageQueryStmt   = "read-journals-page"
 	logicalJournalDeletionStmt = "logical-delete-journals"
 	journalDeletionStmt        = "delete-journals"
 	snapshotDeletionStmt       = "delete-snapshots"
+	claimWriterStmt            = "claim-writer"
+	listSnapshotsQueryStmt     = "list-snapshots"
+	listSnapshotIDsQueryStmt   = "list-snapshot-persistence-ids"
+	listSnapshotTenantsStmt    = "list-snapshot-tenants"
+	snapshotPruningStmt        = "delete-snapshot"
+	readJournalsByOrderingStmt = "read-journals-by-ordering"
+	createTagTableStmt         = "create-tag-table"
+	createTagQueryStmt         = "create-tag"
+	readJournalsByTagStmt      = "read-journals-by-tag"
+	readJournalOrderingsStmt   = "read-journal-orderings"
+	listPersistenceIDsStmt     = "list-persistence-ids"
+	listNewPersistenceIDsStmt  = "list-new-persistence-ids"
+	createProjectionTableStmt  = "create-projection-offset-table"
+	projectionOffsetQueryStmt  = "projection-offset"
+	saveProjectionOffsetStmt   = "save-projection-offset"
+	createOutboxTableStmt      = "create-outbox-table"
+	createOutboxIndexStmt      = "create-outbox-index"
+	createOutboxEntryStmt      = "create-outbox-entry"
+	claimOutboxEntriesStmt     = "claim-outbox-entries"
+	outboxEntriesQueryStmt     = "outbox-entries"
+	listOutboxTenantsStmt      = "list-outbox-tenants"
+	leaseOutboxEntryStmt       = "lease-outbox-entry"
+	updateOutboxEntryStmt      = "update-outbox-entry"
+	notifyJournalStmt          = "notify-journal"
 )
 
-// SQLDialect will be implemented any database dialect
+const (
+	// journalTable is the default name of the journal table
+	journalTable = "journal"
+	// maxRowsPerInsert is the maximum number of rows written by a single multi-row insert
+	maxRowsPerInsert = 500
+	// copyThreshold is the number of rows from which Postgres batches are written using COPY
+	copyThreshold = 1000
+)
+
+// journalColumns are the journal columns written when persisting a journal entry
+var journalColumns = []string{
+	"tenant_id", "persistence_id", "sequence_number", "timestamp", "payload", "manifest", "writer_id", "codec",
+	"key_id", "serializer",
+}
+
+// SQLDialect will be implemented any database dialect.
+// Every operation only reads and writes the rows of the tenant its context is scoped to, see WithTenant, but
+// ListSnapshotTenants and ListOutboxTenants, which list the tenants themselves, and GetJournalOrderings, which only
+// reads the orderings and timestamps of the rows of every tenant
 type SQLDialect interface {
 	CreateSchemasIfNotExist(ctx context.Context) error
 
@@ -32,15 +80,43 @@ type SQLDialect interface {
 	Close() error
 
 	PersistJournal(ctx context.Context, journal *Journal) error
+	PersistJournals(ctx context.Context, journals []*Journal) error
 	PersistSnapshot(ctx context.Context, snapshot *Snapshot) error
+	PersistSnapshotAndTruncate(ctx context.Context, snapshot *Snapshot, logical bool) error
 
 	GetLatestSnapshot(ctx context.Context, persistenceID string) (*Snapshot, error)
 	GetJournals(ctx context.Context, persistenceID string, fromSequenceNumber int, toSequenceNumber int) (
 		[]*Journal, error,
 	)
+	StreamJournals(
+		ctx context.Context, persistenceID string, fromSequenceNumber int, toSequenceNumber int, pageSize int,
+		fn func(journal *Journal) error,
+	) error
+	GetJournalsByOrdering(ctx context.Context, fromOrdering int64, toOrdering int64, limit int) ([]*Journal, error)
+	GetJournalsByTag(ctx context.Context, tag string, fromOrdering int64, toOrdering int64, limit int) (
+		[]*Journal, error,
+	)
+	GetJournalOrderings(ctx context.Context, fromOrdering int64, limit int) ([]*JournalOrdering, error)
+	ListPersistenceIDs(ctx context.Context, prefix string, afterID string, limit int) ([]string, error)
+	ListNewPersistenceIDs(ctx context.Context, prefix string, fromOrdering int64, limit int) ([]*JournalMetadata, error)
+
+	ListSnapshots(ctx context.Context, persistenceID string) ([]*SnapshotMetadata, error)
+	ListSnapshotPersistenceIDs(ctx context.Context) ([]string, error)
+	ListSnapshotTenants(ctx context.Context) ([]string, error)
 
 	DeleteSnapshots(ctx context.Context, persistenceID string, toSequenceNumber int) error
+	PruneSnapshots(ctx context.Context, persistenceID string, sequenceNumbers []int) (int64, error)
 	DeleteJournals(ctx context.Context, persistenceID string, toSequenceNumber int, logical bool) error
+
+	ClaimWriter(ctx context.Context, persistenceID string, writerID string) error
+
+	GetProjectionOffset(ctx context.Context, projectionName string) (int64, error)
+	SaveProjectionOffset(ctx context.Context, projectionName string, offset int64, fn func(tx *sql.Tx) error) error
+
+	ClaimOutboxEntries(ctx context.Context, now int64, leaseUntil int64, limit int) ([]*OutboxEntry, error)
+	UpdateOutboxEntries(ctx context.Context, entries []*OutboxEntry) error
+	GetOutboxEntries(ctx context.Context, status OutboxStatus, limit int) ([]*OutboxEntry, error)
+	ListOutboxTenants(ctx context.Context) ([]string, error)
 }
 
 type dialect struct {
@@ -49,8 +125,16 @@ type dialect struct {
 
 	driver Driver
 	dotSQL *dotsql.DotSql
+	// the configured table and column names
+	names *names
+
+	// listens to the new journal rows when notifications are enabled
+	listener *journalListener
 }
 
+// enforces that dialect implements the Notifier interface
+var _ Notifier = (*dialect)(nil)
+
 // NewDialect creates a new instance of SQLDialect
 func NewDialect(config *DBConfig, driver Driver) (SQLDialect, error) {
 	// validates driver
@@ -58,26 +142,23 @@ func NewDialect(config *DBConfig, driver Driver) (SQLDialect, error) {
 		return nil, err
 	}
 
+	// validates the table and column names
+	names, err := newNames(config)
+	if err != nil {
+		return nil, err
+	}
+
 	return &dialect{
 		config: config,
 		driver: driver,
+		names:  names,
 	}, nil
 }
 
-// CreateSchemasIfNotExist creates the database tables required
+// CreateSchemasIfNotExist creates the database tables required by applying the pending schema migrations, unless
+// the migration mode states otherwise
 func (d *dialect) CreateSchemasIfNotExist(ctx context.Context) error {
-	var result error
-	// create the journal table
-	if _, err := d.dotSQL.ExecContext(ctx, d.db, createJournalTableStmt); err != nil {
-		result = multierror.Append(result, err)
-	}
-
-	// create the snapshot table
-	if _, err := d.dotSQL.ExecContext(ctx, d.db, createSnapshotTableStmt); err != nil {
-		result = multierror.Append(result, err)
-	}
-
-	return result
+	return d.migrate(ctx)
 }
 
 // Connect connects to the database
@@ -104,46 +185,378 @@ func (d *dialect) Connect(ctx context.Context) error {
 	}
 
 	// Loads sql statements
-	dot, err := dotsql.LoadFromString(d.driver.SQLFile())
+	statements, err := d.names.render(d.driver.SQLFile())
+	if err != nil {
+		return err
+	}
+
+	dot, err := dotsql.LoadFromString(statements)
 	if err != nil {
 		return err
 	}
 
 	d.db = db
 	d.dotSQL = dot
+
+	if d.config.dbMigrationMode == VerifySchema {
+		if err = d.verifySchema(ctx); err != nil {
+			_ = db.Close()
+			return err
+		}
+	}
+
+	if d.notifications() {
+		d.listener = newJournalListener(connStr, d.names.table(journalTable))
+	}
 	return nil
 }
 
 // Close closes the underlying database connection
 func (d *dialect) Close() error {
+	if d.listener != nil {
+		_ = d.listener.Close()
+	}
 	return d.db.Close()
 }
 
-// PersistJournal persists a journal entry into the datastore
+// Subscribe registers for the notifications of new journal rows. No notification is ever delivered when
+// notifications are not enabled
+func (d *dialect) Subscribe() (<-chan struct{}, func()) {
+	if d.listener == nil {
+		return nil, func() {}
+	}
+	return d.listener.Subscribe()
+}
+
+// Listening tells whether the notifications of new journal rows are currently delivered
+func (d *dialect) Listening() bool {
+	return d.listener != nil && d.listener.Listening()
+}
+
+// notifications tells whether the new journal rows are notified
+func (d *dialect) notifications() bool {
+	return d.driver == POSTGRES && d.config.dbNotifications
+}
+
+// notify notifies the listeners of new journal rows. Failing to notify is not fatal since the live queries
+// eventually poll the journal
+func (d *dialect) notify(ctx context.Context) {
+	if !d.notifications() {
+		return
+	}
+
+	// the channel is passed as is rather than as an identifier, which would be folded to lowercase, so that it
+	// matches the channel listened to
+	if _, err := d.dotSQL.ExecContext(ctx, d.db, notifyJournalStmt, d.names.table(journalTable)); err != nil {
+		log.Printf("failed to notify the new journal rows: %v", err)
+	}
+}
+
+// PersistJournal persists a journal entry into the datastore.
+// It returns ErrConcurrentModification when the sequence number has already been written for the persistenceID
+// and ErrStaleWriter when another writer has claimed the persistenceID. The claims are not checked for the providers
+// that do not fence the writers, which never claim the persistenceIDs
 func (d *dialect) PersistJournal(ctx context.Context, journal *Journal) error {
+	// the tags and the outbox entry must be written in the same transaction as the journal entry, and so must the
+	// lock of the claim of the persistenceID
+	if len(journal.Tags) > 0 || journal.Outbox || writerFenced(ctx) {
+		return d.PersistJournals(ctx, []*Journal{journal})
+	}
+
+	tenant := tenantOf(ctx)
 	_, err := d.dotSQL.ExecContext(
 		ctx,
-		d.db, createJournalQueryStmt, journal.PersistenceID, journal.SequenceNumber, journal.Timestamp, journal.Payload,
-		journal.EventManifest, journal.WriterID,
+		d.db, createJournalQueryStmt, tenant, journal.PersistenceID, journal.SequenceNumber, journal.Timestamp,
+		journal.Payload, journal.EventManifest, journal.WriterID, journal.Codec, journal.KeyID, journal.Serializer,
 	)
-	return err
+	if err != nil {
+		if d.driver.isUniqueViolation(err) {
+			return concurrentModificationError(journal.PersistenceID, journal.SequenceNumber, err)
+		}
+		return err
+	}
+
+	d.notify(ctx)
+	return nil
+}
+
+// PersistJournals persists several journal entries into the datastore in a single transaction.
+// Either all the entries are written or none of them. Entries are written using multi-row inserts and, on
+// Postgres, large batches are written using COPY.
+// It returns ErrConcurrentModification when any sequence number has already been written for its persistenceID
+// and ErrStaleWriter when another writer has claimed any of the persistenceIDs. The claims are not checked for the
+// providers that do not fence the writers, which never claim the persistenceIDs
+func (d *dialect) PersistJournals(ctx context.Context, journals []*Journal) (err error) {
+	if len(journals) == 0 {
+		return nil
+	}
+
+	// the entries conflicting with each other are told apart before hitting the datastore
+	if journal := duplicateJournal(journals); journal != nil {
+		return concurrentModificationError(
+			journal.PersistenceID, journal.SequenceNumber,
+			duplicateKeyError(journal.PersistenceID, journal.SequenceNumber),
+		)
+	}
+
+	tenant := tenantOf(ctx)
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	// let us make sure every writer still owns its persistenceID, unless the writers are not fenced
+	if writerFenced(ctx) {
+		if err = d.checkWriters(ctx, tx, tenant, journals); err != nil {
+			return err
+		}
+	}
+
+	if d.driver == POSTGRES && len(journals) >= copyThreshold {
+		err = d.copyJournals(ctx, tx, tenant, journals)
+	} else {
+		err = d.insertJournals(ctx, tx, tenant, journals)
+	}
+
+	if err != nil {
+		if !d.driver.isUniqueViolation(err) {
+			return err
+		}
+
+		// the datastore does not tell which entry conflicts
+		if len(journals) > 1 {
+			return concurrentBatchModificationError(len(journals), err)
+		}
+		return concurrentModificationError(journals[0].PersistenceID, journals[0].SequenceNumber, err)
+	}
+
+	if err = d.tagJournals(ctx, tx, tenant, journals); err != nil {
+		return err
+	}
+
+	if err = d.createOutboxEntries(ctx, tx, tenant, journals); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	d.notify(ctx)
+	return nil
+}
+
+// checkWriters checks that the writers of the journal entries own their persistenceID. The claims are locked until
+// the transaction ends so that no other writer can take over in between the check and the insert
+func (d *dialect) checkWriters(ctx context.Context, tx *sql.Tx, tenant string, journals []*Journal) error {
+	owners := make(map[string]*string)
+	for _, journal := range journals {
+		owner, checked := owners[journal.PersistenceID]
+		if !checked {
+			var err error
+			if owner, err = d.lockWriter(ctx, tx, tenant, journal.PersistenceID); err != nil {
+				return err
+			}
+			owners[journal.PersistenceID] = owner
+		}
+
+		if owner != nil && *owner != journal.WriterID {
+			return staleWriterError(journal.PersistenceID, journal.WriterID)
+		}
+	}
+	return nil
+}
+
+// lockWriter locks the claim of a persistenceID until the end of the transaction and returns the writer owning the
+// persistenceID, nil when it has not been claimed
+func (d *dialect) lockWriter(ctx context.Context, tx *sql.Tx, tenant string, persistenceID string) (*string, error) {
+	row, err := d.dotSQL.QueryRowContext(ctx, tx, lockWriterQueryStmt, tenant, persistenceID)
+	if err != nil {
+		return nil, err
+	}
+
+	var writerID string
+	if err = row.Scan(&writerID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &writerID, nil
+}
+
+// duplicateJournal returns the first journal entry of a batch whose persistenceID and sequence number are
+// already taken by a previous entry of the batch, nil when there is none
+func duplicateJournal(journals []*Journal) *Journal {
+	type key struct {
+		persistenceID  string
+		sequenceNumber int
+	}
+
+	seen := make(map[key]bool, len(journals))
+	for _, journal := range journals {
+		k := key{journal.PersistenceID, journal.SequenceNumber}
+		if seen[k] {
+			return journal
+		}
+		seen[k] = true
+	}
+	return nil
+}
+
+// tagJournals writes the tags of the journal entries once the entries have been written
+func (d *dialect) tagJournals(ctx context.Context, tx *sql.Tx, tenant string, journals []*Journal) error {
+	for _, journal := range journals {
+		for _, tag := range journal.Tags {
+			if _, err := d.dotSQL.ExecContext(
+				ctx, tx, createTagQueryStmt, tag, tenant, journal.PersistenceID, journal.SequenceNumber,
+			); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// insertJournals writes the journal entries using multi-row inserts
+func (d *dialect) insertJournals(ctx context.Context, tx *sql.Tx, tenant string, journals []*Journal) error {
+	prefix, err := d.dotSQL.Raw(createJournalsQueryStmt)
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(journals); start += maxRowsPerInsert {
+		end := start + maxRowsPerInsert
+		if end > len(journals) {
+			end = len(journals)
+		}
+
+		var query strings.Builder
+		query.WriteString(prefix)
+		args := make([]interface{}, 0, (end-start)*len(journalColumns))
+		for i, journal := range journals[start:end] {
+			if i > 0 {
+				query.WriteString(",")
+			}
+
+			query.WriteString(" (")
+			for j := range journalColumns {
+				if j > 0 {
+					query.WriteString(", ")
+				}
+				query.WriteString(d.driver.placeholder(len(args) + j + 1))
+			}
+			query.WriteString(")")
+
+			args = append(
+				args, tenant, journal.PersistenceID, journal.SequenceNumber, journal.Timestamp, journal.Payload,
+				journal.EventManifest, journal.WriterID, journal.Codec, journal.KeyID, journal.Serializer,
+			)
+		}
+
+		if _, err = tx.ExecContext(ctx, query.String(), args...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copyJournals writes the journal entries using the Postgres COPY protocol
+func (d *dialect) copyJournals(ctx context.Context, tx *sql.Tx, tenant string, journals []*Journal) error {
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn(d.names.table(journalTable), d.names.journalColumns(journalColumns)...))
+	if err != nil {
+		return err
+	}
+
+	for _, journal := range journals {
+		if _, err = stmt.ExecContext(
+			ctx, tenant, journal.PersistenceID, journal.SequenceNumber, journal.Timestamp, journal.Payload,
+			string(journal.EventManifest), journal.WriterID, string(journal.Codec), journal.KeyID, journal.Serializer,
+		); err != nil {
+			_ = stmt.Close()
+			return err
+		}
+	}
+
+	// flush the buffered rows
+	if _, err = stmt.ExecContext(ctx); err != nil {
+		_ = stmt.Close()
+		return err
+	}
+
+	return stmt.Close()
 }
 
 // PersistSnapshot persists a snapshot entry into the snapshot data store
 func (d *dialect) PersistSnapshot(ctx context.Context, snapshot *Snapshot) error {
 	_, err := d.dotSQL.ExecContext(
 		ctx,
-		d.db, createSnapshotQueryStmt, snapshot.PersistenceID, snapshot.SequenceNumber, snapshot.Timestamp,
-		snapshot.Snapshot,
-		snapshot.SnapshotManifest, snapshot.WriterID,
+		d.db, createSnapshotQueryStmt, tenantOf(ctx), snapshot.PersistenceID, snapshot.SequenceNumber,
+		snapshot.Timestamp, snapshot.Snapshot, snapshot.SnapshotManifest, snapshot.WriterID, snapshot.Codec,
+		snapshot.KeyID, snapshot.Serializer,
 	)
 	return err
 }
 
-// GetLatestSnapshot fetch the latest snapshot for a given persistenceID
+// PersistSnapshotAndTruncate persists a snapshot entry and, in the same transaction, deletes the journal entries
+// before the snapshot sequence number as well as the older snapshots. The journal entries are either soft deleted
+// or hard-deleted. Either everything is done or nothing is.
+func (d *dialect) PersistSnapshotAndTruncate(ctx context.Context, snapshot *Snapshot, logical bool) (err error) {
+	tenant := tenantOf(ctx)
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if _, err = d.dotSQL.ExecContext(
+		ctx,
+		tx, createSnapshotQueryStmt, tenant, snapshot.PersistenceID, snapshot.SequenceNumber, snapshot.Timestamp,
+		snapshot.Snapshot, snapshot.SnapshotManifest, snapshot.WriterID, snapshot.Codec, snapshot.KeyID,
+		snapshot.Serializer,
+	); err != nil {
+		return err
+	}
+
+	stmt := journalDeletionStmt
+	if logical {
+		stmt = logicalJournalDeletionStmt
+	}
+
+	// the recovery replays the events from the sequence number of the snapshot onwards
+	if _, err = d.dotSQL.ExecContext(
+		ctx, tx, stmt, tenant, snapshot.PersistenceID, snapshot.SequenceNumber-1,
+	); err != nil {
+		return err
+	}
+
+	// the snapshot just written is the only one worth keeping
+	if _, err = d.dotSQL.ExecContext(
+		ctx, tx, snapshotDeletionStmt, tenant, snapshot.PersistenceID, snapshot.SequenceNumber-1,
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetLatestSnapshot fetch the latest snapshot for a given persistenceID.
+// It returns a nil snapshot when the persistenceID has no snapshot
 func (d *dialect) GetLatestSnapshot(ctx context.Context, persistenceID string) (*Snapshot, error) {
 	// execute the query against the database
-	row, err := d.dotSQL.QueryRowContext(ctx, d.db, latestSnapshotQueryStmt, persistenceID)
+	row, err := d.dotSQL.QueryRowContext(ctx, d.db, latestSnapshotQueryStmt, tenantOf(ctx), persistenceID)
 	if err != nil {
 		return nil, err
 	}
@@ -152,10 +565,14 @@ func (d *dialect) GetLatestSnapshot(ctx context.Context, persistenceID string) (
 	var snapshot Snapshot
 	err = row.Scan(
 		&snapshot.PersistenceID, &snapshot.SequenceNumber, &snapshot.Timestamp,
-		&snapshot.Snapshot, &snapshot.SnapshotManifest, &snapshot.WriterID,
+		&snapshot.Snapshot, &snapshot.SnapshotManifest, &snapshot.WriterID, &snapshot.Codec,
+		&snapshot.KeyID, &snapshot.TenantID, &snapshot.Serializer,
 	)
 
-	if err != nil {
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+	case err != nil:
 		return nil, err
 	}
 
@@ -169,7 +586,7 @@ func (d *dialect) GetJournals(
 	events := make([]*Journal, 0)
 	// execute the query against the database
 	rows, err := d.dotSQL.QueryContext(
-		ctx, d.db, readJournalQueryStmt, persistenceID, fromSequenceNumber, toSequenceNumber,
+		ctx, d.db, readJournalQueryStmt, tenantOf(ctx), persistenceID, fromSequenceNumber, toSequenceNumber,
 	)
 
 	if err != nil {
@@ -177,18 +594,15 @@ func (d *dialect) GetJournals(
 	}
 
 	defer rows.Close()
-	var journal Journal
 	for rows.Next() {
 		// read the row data
-		if err = rows.Scan(
-			&journal.Ordering, &journal.PersistenceID, &journal.SequenceNumber, &journal.Timestamp,
-			&journal.Payload, &journal.EventManifest, &journal.WriterID, &journal.Deleted,
-		); err != nil {
+		journal, err := scanJournal(rows)
+		if err != nil {
 			return nil, err
 		}
 
 		// append the read row into the event slice
-		events = append(events, &journal)
+		events = append(events, journal)
 	}
 	// get any error encountered during iteration
 	if err = rows.Err(); err != nil {
@@ -198,14 +612,342 @@ func (d *dialect) GetJournals(
 	return events, nil
 }
 
+// StreamJournals reads some events from the journal store page by page and hands them over to fn in
+// sequence number order. Pages are fetched using keyset pagination on the sequence number so that memory usage
+// is bounded by the page size. The streaming stops at the first error returned by fn or when the context is done.
+func (d *dialect) StreamJournals(
+	ctx context.Context, persistenceID string, fromSequenceNumber int, toSequenceNumber int, pageSize int,
+	fn func(journal *Journal) error,
+) error {
+	if pageSize <= 0 {
+		return errors.New("invalid page size")
+	}
+
+	for fromSequenceNumber <= toSequenceNumber {
+		// let us make sure the caller is still interested before fetching the next page
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		count, last, err := d.streamJournalPage(ctx, persistenceID, fromSequenceNumber, toSequenceNumber, pageSize, fn)
+		if err != nil {
+			return err
+		}
+
+		// a partial page means we have reached the end of the range
+		if count < pageSize || last >= toSequenceNumber {
+			return nil
+		}
+		fromSequenceNumber = last + 1
+	}
+
+	return nil
+}
+
+// streamJournalPage reads a single page of events and hands them over to fn.
+// It returns the number of events read and the last sequence number read
+func (d *dialect) streamJournalPage(
+	ctx context.Context, persistenceID string, fromSequenceNumber int, toSequenceNumber int, pageSize int,
+	fn func(journal *Journal) error,
+) (int, int, error) {
+	// execute the query against the database
+	rows, err := d.dotSQL.QueryContext(
+		ctx, d.db, readJournalPageQueryStmt, tenantOf(ctx), persistenceID, fromSequenceNumber, toSequenceNumber,
+		pageSize,
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer rows.Close()
+	count, last := 0, fromSequenceNumber
+	for rows.Next() {
+		// read the row data
+		journal, err := scanJournal(rows)
+		if err != nil {
+			return count, last, err
+		}
+
+		count++
+		last = journal.SequenceNumber
+		if err = fn(journal); err != nil {
+			return count, last, err
+		}
+	}
+
+	// get any error encountered during iteration
+	return count, last, rows.Err()
+}
+
+// GetJournalsByOrdering fetches up to limit journal rows of the tenant across all the persistenceIDs which ordering
+// is greater than fromOrdering and lower than or equal to toOrdering, in ordering order. The logically deleted rows
+// are returned as well
+func (d *dialect) GetJournalsByOrdering(
+	ctx context.Context, fromOrdering int64, toOrdering int64, limit int,
+) ([]*Journal, error) {
+	rows, err := d.dotSQL.QueryContext(
+		ctx, d.db, readJournalsByOrderingStmt, tenantOf(ctx), fromOrdering, toOrdering, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	journals := make([]*Journal, 0)
+	for rows.Next() {
+		journal, err := scanJournal(rows)
+		if err != nil {
+			return nil, err
+		}
+		journals = append(journals, journal)
+	}
+
+	return journals, rows.Err()
+}
+
+// createOutboxEntries copies the journal entries to publish through the outbox once they have been written
+func (d *dialect) createOutboxEntries(ctx context.Context, tx *sql.Tx, tenant string, journals []*Journal) error {
+	for _, journal := range journals {
+		if !journal.Outbox {
+			continue
+		}
+
+		if _, err := d.dotSQL.ExecContext(
+			ctx, tx, createOutboxEntryStmt, tenant, journal.PersistenceID, journal.SequenceNumber,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetJournalsByTag fetches up to limit journal rows tagged with the given tag which ordering is greater than
+// fromOrdering and less than or equal to toOrdering, in ordering order
+func (d *dialect) GetJournalsByTag(
+	ctx context.Context, tag string, fromOrdering int64, toOrdering int64, limit int,
+) ([]*Journal, error) {
+	rows, err := d.dotSQL.QueryContext(
+		ctx, d.db, readJournalsByTagStmt, tenantOf(ctx), tag, fromOrdering, toOrdering, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	journals := make([]*Journal, 0)
+	for rows.Next() {
+		journal, err := scanJournal(rows)
+		if err != nil {
+			return nil, err
+		}
+		journals = append(journals, journal)
+	}
+
+	return journals, rows.Err()
+}
+
+// GetJournalOrderings fetches the orderings and timestamps of up to limit journal rows which ordering is greater than
+// fromOrdering, in ordering order. The orderings are shared by the tenants, hence the rows of every tenant are read so
+// that the gaps left by in-flight transactions can be told apart from the rows of the other tenants
+func (d *dialect) GetJournalOrderings(ctx context.Context, fromOrdering int64, limit int) ([]*JournalOrdering, error) {
+	rows, err := d.dotSQL.QueryContext(ctx, d.db, readJournalOrderingsStmt, fromOrdering, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	orderings := make([]*JournalOrdering, 0)
+	for rows.Next() {
+		var ordering JournalOrdering
+		if err = rows.Scan(&ordering.Ordering, &ordering.Timestamp); err != nil {
+			return nil, err
+		}
+		orderings = append(orderings, &ordering)
+	}
+
+	return orderings, rows.Err()
+}
+
+// ListPersistenceIDs lists up to limit persistenceIDs that have journal rows, in ascending order. Only the
+// persistenceIDs starting with the given prefix and sorting after afterID are listed. An empty prefix matches
+// every persistenceID and an empty afterID starts from the first one.
+// The comparisons follow the collation of the persistence_id column
+func (d *dialect) ListPersistenceIDs(ctx context.Context, prefix string, afterID string, limit int) ([]string, error) {
+	rows, err := d.dotSQL.QueryContext(ctx, d.db, listPersistenceIDsStmt, tenantOf(ctx), afterID, prefix, prefix, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	persistenceIDs := make([]string, 0)
+	for rows.Next() {
+		var persistenceID string
+		if err = rows.Scan(&persistenceID); err != nil {
+			return nil, err
+		}
+		persistenceIDs = append(persistenceIDs, persistenceID)
+	}
+
+	return persistenceIDs, rows.Err()
+}
+
+// ListNewPersistenceIDs fetches the metadata of up to limit first journal rows of the persistenceIDs, i.e. the rows of
+// their lowest sequence number, which ordering is greater than fromOrdering, in ordering order. Only the
+// persistenceIDs starting with the given prefix are listed. An empty prefix matches every persistenceID
+func (d *dialect) ListNewPersistenceIDs(
+	ctx context.Context, prefix string, fromOrdering int64, limit int,
+) ([]*JournalMetadata, error) {
+	rows, err := d.dotSQL.QueryContext(
+		ctx, d.db, listNewPersistenceIDsStmt, tenantOf(ctx), fromOrdering, prefix, prefix, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	journals := make([]*JournalMetadata, 0)
+	for rows.Next() {
+		var journal JournalMetadata
+		if err = rows.Scan(
+			&journal.Ordering, &journal.PersistenceID, &journal.SequenceNumber, &journal.Timestamp,
+		); err != nil {
+			return nil, err
+		}
+		journals = append(journals, &journal)
+	}
+
+	return journals, rows.Err()
+}
+
+// ListSnapshotTenants lists the tenants that have at least one snapshot, the default tenant being the empty one.
+// It is not scoped to the tenant of its context
+func (d *dialect) ListSnapshotTenants(ctx context.Context) ([]string, error) {
+	rows, err := d.dotSQL.QueryContext(ctx, d.db, listSnapshotTenantsStmt)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	tenants := make([]string, 0)
+	for rows.Next() {
+		var tenant string
+		if err = rows.Scan(&tenant); err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, tenant)
+	}
+
+	return tenants, rows.Err()
+}
+
 // DeleteSnapshots removes some events from the journal. All snapshots which sequence numbers are less than
 // the given sequence number will be either soft deleted or hard-deleted
 func (d *dialect) DeleteSnapshots(ctx context.Context, persistenceID string, toSequenceNumber int) error {
 	// execute the query against the database
-	_, err := d.dotSQL.ExecContext(ctx, d.db, snapshotDeletionStmt, persistenceID, toSequenceNumber)
+	_, err := d.dotSQL.ExecContext(ctx, d.db, snapshotDeletionStmt, tenantOf(ctx), persistenceID, toSequenceNumber)
 	return err
 }
 
+// ListSnapshots lists the snapshots of a given persistenceID ordered by sequence number
+func (d *dialect) ListSnapshots(ctx context.Context, persistenceID string) ([]*SnapshotMetadata, error) {
+	rows, err := d.dotSQL.QueryContext(ctx, d.db, listSnapshotsQueryStmt, tenantOf(ctx), persistenceID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	snapshots := make([]*SnapshotMetadata, 0)
+	for rows.Next() {
+		var snapshot SnapshotMetadata
+		if err = rows.Scan(&snapshot.PersistenceID, &snapshot.SequenceNumber, &snapshot.Timestamp); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, &snapshot)
+	}
+
+	return snapshots, rows.Err()
+}
+
+// ListSnapshotPersistenceIDs lists the persistenceIDs that have at least one snapshot
+func (d *dialect) ListSnapshotPersistenceIDs(ctx context.Context) ([]string, error) {
+	rows, err := d.dotSQL.QueryContext(ctx, d.db, listSnapshotIDsQueryStmt, tenantOf(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	persistenceIDs := make([]string, 0)
+	for rows.Next() {
+		var persistenceID string
+		if err = rows.Scan(&persistenceID); err != nil {
+			return nil, err
+		}
+		persistenceIDs = append(persistenceIDs, persistenceID)
+	}
+
+	return persistenceIDs, rows.Err()
+}
+
+// PruneSnapshots removes the snapshots of a given persistenceID at the given sequence numbers in a single
+// transaction. It returns the number of snapshots removed
+func (d *dialect) PruneSnapshots(
+	ctx context.Context, persistenceID string, sequenceNumbers []int,
+) (pruned int64, err error) {
+	if len(sequenceNumbers) == 0 {
+		return 0, nil
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	for _, sequenceNumber := range sequenceNumbers {
+		result, err := d.dotSQL.ExecContext(ctx, tx, snapshotPruningStmt, tenantOf(ctx), persistenceID, sequenceNumber)
+		if err != nil {
+			return 0, err
+		}
+
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		pruned += affected
+	}
+
+	return pruned, tx.Commit()
+}
+
 // DeleteJournals removes some events from the journal. All events which sequence numbers are less than
 // the given sequence number will be either soft deleted or hard-deleted
 func (d *dialect) DeleteJournals(ctx context.Context, persistenceID string, toSequenceNumber int, logical bool) error {
@@ -215,6 +957,197 @@ func (d *dialect) DeleteJournals(ctx context.Context, persistenceID string, toSe
 	}
 
 	// execute the query against the database
-	_, err := d.dotSQL.ExecContext(ctx, d.db, stmt, persistenceID, toSequenceNumber)
+	_, err := d.dotSQL.ExecContext(ctx, d.db, stmt, tenantOf(ctx), persistenceID, toSequenceNumber)
 	return err
 }
+
+// ClaimWriter makes the given writer the owner of the persistenceID.
+// From then on, journal entries of the persistenceID written by any other writer are rejected
+func (d *dialect) ClaimWriter(ctx context.Context, persistenceID string, writerID string) error {
+	_, err := d.dotSQL.ExecContext(
+		ctx, d.db, claimWriterStmt, tenantOf(ctx), persistenceID, writerID, time.Now().UTC().Unix(),
+	)
+	return err
+}
+
+// GetProjectionOffset fetches the offset a given projection has reached. It returns 0 when the projection has not
+// saved any offset yet
+func (d *dialect) GetProjectionOffset(ctx context.Context, projectionName string) (int64, error) {
+	row, err := d.dotSQL.QueryRowContext(ctx, d.db, projectionOffsetQueryStmt, tenantOf(ctx), projectionName)
+	if err != nil {
+		return 0, err
+	}
+
+	var offset int64
+	switch err = row.Scan(&offset); {
+	case err == sql.ErrNoRows:
+		return 0, nil
+	case err != nil:
+		return 0, err
+	}
+	return offset, nil
+}
+
+// SaveProjectionOffset saves the offset a given projection has reached. When fn is set, it is run in the transaction
+// the offset is saved in: either the changes made by fn and the offset are committed or none of them is
+func (d *dialect) SaveProjectionOffset(
+	ctx context.Context, projectionName string, offset int64, fn func(tx *sql.Tx) error,
+) (err error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if fn != nil {
+		if err = fn(tx); err != nil {
+			return err
+		}
+	}
+
+	if _, err = d.dotSQL.ExecContext(
+		ctx, tx, saveProjectionOffsetStmt, tenantOf(ctx), projectionName, offset, time.Now().UTC().Unix(),
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ClaimOutboxEntries claims up to limit pending outbox entries due at the given time, in Unix milliseconds, by
+// leasing them until leaseUntil: they are not claimed again before the lease expires, unless they are updated using
+// UpdateOutboxEntries. The entries are returned as they were before being leased, hence updating them unchanged
+// releases them.
+// On Postgres and MySQL the entries being claimed by another transaction are skipped, hence several relays can
+// process the outbox concurrently
+func (d *dialect) ClaimOutboxEntries(
+	ctx context.Context, now int64, leaseUntil int64, limit int,
+) (entries []*OutboxEntry, err error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	rows, err := d.dotSQL.QueryContext(ctx, tx, claimOutboxEntriesStmt, tenantOf(ctx), now, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err = scanOutboxEntries(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if _, err = d.dotSQL.ExecContext(ctx, tx, leaseOutboxEntryStmt, leaseUntil, entry.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return entries, tx.Commit()
+}
+
+// UpdateOutboxEntries saves the delivery states of the given outbox entries in a single transaction
+func (d *dialect) UpdateOutboxEntries(ctx context.Context, entries []*OutboxEntry) (err error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	for _, entry := range entries {
+		if _, err = d.dotSQL.ExecContext(
+			ctx, tx, updateOutboxEntryStmt, string(entry.Status), entry.Attempts, entry.NextAttemptAt, entry.LastError,
+			entry.ID,
+		); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetOutboxEntries fetches up to limit outbox entries in a given delivery state, oldest first
+func (d *dialect) GetOutboxEntries(ctx context.Context, status OutboxStatus, limit int) ([]*OutboxEntry, error) {
+	rows, err := d.dotSQL.QueryContext(ctx, d.db, outboxEntriesQueryStmt, tenantOf(ctx), string(status), limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanOutboxEntries(rows)
+}
+
+// ListOutboxTenants lists the tenants that have at least one outbox entry pending, the default tenant being the empty
+// one. It is not scoped to the tenant of its context
+func (d *dialect) ListOutboxTenants(ctx context.Context) ([]string, error) {
+	rows, err := d.dotSQL.QueryContext(ctx, d.db, listOutboxTenantsStmt)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	tenants := make([]string, 0)
+	for rows.Next() {
+		var tenant string
+		if err = rows.Scan(&tenant); err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, tenant)
+	}
+
+	return tenants, rows.Err()
+}
+
+// scanOutboxEntries reads the outbox rows and closes them
+func scanOutboxEntries(rows *sql.Rows) ([]*OutboxEntry, error) {
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	entries := make([]*OutboxEntry, 0)
+	for rows.Next() {
+		entry := OutboxEntry{Journal: &Journal{}}
+		if err := rows.Scan(
+			&entry.ID, &entry.Journal.Ordering, &entry.Journal.PersistenceID, &entry.Journal.SequenceNumber,
+			&entry.Journal.Timestamp, &entry.Journal.Payload, &entry.Journal.EventManifest, &entry.Journal.WriterID,
+			&entry.Journal.Codec, &entry.Journal.KeyID, &entry.Status, &entry.Attempts, &entry.NextAttemptAt,
+			&entry.LastError, &entry.Journal.TenantID, &entry.Journal.Serializer,
+		); err != nil {
+			return nil, err
+		}
+		entries = append(entries, &entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// scanJournal reads a journal row
+func scanJournal(rows *sql.Rows) (*Journal, error) {
+	var journal Journal
+	if err := rows.Scan(
+		&journal.Ordering, &journal.PersistenceID, &journal.SequenceNumber, &journal.Timestamp,
+		&journal.Payload, &journal.EventManifest, &journal.WriterID, &journal.Deleted, &journal.Codec,
+		&journal.KeyID, &journal.TenantID, &journal.Serializer,
+	); err != nil {
+		return nil, err
+	}
+	return &journal, nil
+}
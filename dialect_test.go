@@ -66,6 +66,12 @@ func TestNewDialect(t *testing.T) {
 			driver: MYSQL,
 			err:    nil,
 		},
+		// asserting the creation of SQLite SQLDialect
+		"sqlite": {
+			config: &DBConfig{},
+			driver: SQLITE,
+			err:    nil,
+		},
 		// asserting the creation of Oracle SQLDialect
 		"oracle": {
 			config: &DBConfig{},
@@ -223,26 +229,6 @@ func freeResources() {
 	}
 }
 
-func countJournal(db *sql.DB) int {
-	var count int
-	row := db.QueryRow("SELECT COUNT(*) FROM journal")
-	err := row.Scan(&count)
-	if err != nil {
-		return -1
-	}
-	return count
-}
-
-func countSnapshot(db *sql.DB) int {
-	var count int
-	row := db.QueryRow("SELECT COUNT(*) FROM snapshot")
-	err := row.Scan(&count)
-	if err != nil {
-		return -1
-	}
-	return count
-}
-
 func tableExist(db *sql.DB, driver Driver, schema, tableName string) error {
 	var query string
 	var result string
@@ -254,6 +240,8 @@ func tableExist(db *sql.DB, driver Driver, schema, tableName string) error {
 			"SELECT table_name FROM information_schema.tables WHERE table_schema = '%s' AND table_name = '%s' LIMIT 1; ",
 			schema, tableName,
 		)
+	case SQLITE:
+		query = fmt.Sprintf("SELECT name FROM sqlite_master WHERE type = 'table' AND name = '%s';", tableName)
 	}
 
 	err := db.QueryRow(query).Scan(&result)
@@ -0,0 +1,1367 @@
+// Package dialecttest provides a compliance test kit for SQLDialect implementations.
+// Every built-in dialect runs it and any third-party dialect can use it to prove that it behaves
+// like the built-in ones.
+package dialecttest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/AsynkronIT/protoactor-go/actor"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	persistencesql "github.com/tochemey/protoactor-persistence-sql"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// Factory creates a new instance of the SQLDialect under test.
+// The returned dialect must not be connected yet. The suite takes care of connecting it, creating the schemas
+// and closing it. Dialects created by the same factory may share the same datastore.
+type Factory func(t *testing.T) persistencesql.SQLDialect
+
+// RunSQLDialectSuite runs the compliance test kit against the SQLDialect created by the given factory
+func RunSQLDialectSuite(t *testing.T, factory Factory) {
+	t.Run("PersistJournal", func(t *testing.T) { testPersistJournal(t, factory) })
+	t.Run("DuplicateJournal", func(t *testing.T) { testDuplicateJournal(t, factory) })
+	t.Run("PersistJournals", func(t *testing.T) { testPersistJournals(t, factory) })
+	t.Run("PersistJournalsAtomicity", func(t *testing.T) { testPersistJournalsAtomicity(t, factory) })
+	t.Run("PersistLargeJournalBatch", func(t *testing.T) { testPersistLargeJournalBatch(t, factory) })
+	t.Run("GetJournalsRange", func(t *testing.T) { testGetJournalsRange(t, factory) })
+	t.Run("GetJournalsEmptyRange", func(t *testing.T) { testGetJournalsEmptyRange(t, factory) })
+	t.Run("StreamJournals", func(t *testing.T) { testStreamJournals(t, factory) })
+	t.Run("StreamJournalsCancellation", func(t *testing.T) { testStreamJournalsCancellation(t, factory) })
+	t.Run("GetJournalsByOrdering", func(t *testing.T) { testGetJournalsByOrdering(t, factory) })
+	t.Run("GetJournalsByTag", func(t *testing.T) { testGetJournalsByTag(t, factory) })
+	t.Run("GetJournalOrderings", func(t *testing.T) { testGetJournalOrderings(t, factory) })
+	t.Run("ListPersistenceIDs", func(t *testing.T) { testListPersistenceIDs(t, factory) })
+	t.Run("ListNewPersistenceIDs", func(t *testing.T) { testListNewPersistenceIDs(t, factory) })
+	t.Run("LogicalDeletion", func(t *testing.T) { testDeleteJournals(t, factory, true) })
+	t.Run("PhysicalDeletion", func(t *testing.T) { testDeleteJournals(t, factory, false) })
+	t.Run("LatestSnapshot", func(t *testing.T) { testLatestSnapshot(t, factory) })
+	t.Run("MissingSnapshot", func(t *testing.T) { testMissingSnapshot(t, factory) })
+	t.Run("DuplicateSnapshot", func(t *testing.T) { testDuplicateSnapshot(t, factory) })
+	t.Run("DeleteSnapshots", func(t *testing.T) { testDeleteSnapshots(t, factory) })
+	t.Run("PayloadMetadata", func(t *testing.T) { testPayloadMetadata(t, factory) })
+	t.Run("ListSnapshots", func(t *testing.T) { testListSnapshots(t, factory) })
+	t.Run("PruneSnapshots", func(t *testing.T) { testPruneSnapshots(t, factory) })
+	t.Run("ListSnapshotTenants", func(t *testing.T) { testListSnapshotTenants(t, factory) })
+	t.Run("LogicalSnapshotTruncation", func(t *testing.T) { testSnapshotTruncation(t, factory, true) })
+	t.Run("PhysicalSnapshotTruncation", func(t *testing.T) { testSnapshotTruncation(t, factory, false) })
+	t.Run("SnapshotTruncationAtomicity", func(t *testing.T) { testSnapshotTruncationAtomicity(t, factory) })
+	t.Run("ConcurrentWriters", func(t *testing.T) { testConcurrentWriters(t, factory) })
+	t.Run("WriterFencing", func(t *testing.T) { testWriterFencing(t, factory) })
+	t.Run("ProjectionOffset", func(t *testing.T) { testProjectionOffset(t, factory) })
+	t.Run("Outbox", func(t *testing.T) { testOutbox(t, factory) })
+	t.Run("ListOutboxTenants", func(t *testing.T) { testListOutboxTenants(t, factory) })
+	t.Run("TenantIsolation", func(t *testing.T) { testTenantIsolation(t, factory) })
+	t.Run("ProviderState", func(t *testing.T) { testProviderState(t, factory) })
+	t.Run("ProviderWriterFencing", func(t *testing.T) { testProviderWriterFencing(t, factory) })
+}
+
+// connect creates a connected dialect with its schemas created
+func connect(t *testing.T, factory Factory) persistencesql.SQLDialect {
+	ctx := context.TODO()
+	dialect := factory(t)
+	if err := dialect.Connect(ctx); err != nil {
+		t.Fatalf("error connecting: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = dialect.Close()
+	})
+
+	if err := dialect.CreateSchemasIfNotExist(ctx); err != nil {
+		t.Fatalf("error creating schemas: %v", err)
+	}
+	return dialect
+}
+
+// event creates the event persisted at a given sequence number
+func event(sequenceNumber int) *wrapperspb.Int64Value {
+	return wrapperspb.Int64(int64(sequenceNumber))
+}
+
+// persistJournals persists the events of a given persistenceID within a range of sequence numbers
+func persistJournals(t *testing.T, dialect persistencesql.SQLDialect, persistenceID string, from, to int) {
+	for sequenceNumber := from; sequenceNumber <= to; sequenceNumber++ {
+		journal, err := persistencesql.NewJournal(persistenceID, event(sequenceNumber), sequenceNumber, "writer")
+		if err != nil {
+			t.Fatalf("error creating journal: %v", err)
+		}
+
+		if err = dialect.PersistJournal(context.TODO(), journal); err != nil {
+			t.Fatalf("error persisting journal: %v", err)
+		}
+	}
+}
+
+// persistSnapshot persists the snapshot of a given persistenceID at a given sequence number
+func persistSnapshot(t *testing.T, dialect persistencesql.SQLDialect, persistenceID string, sequenceNumber int) {
+	snapshot, err := persistencesql.NewSnapshot(
+		persistenceID, wrapperspb.String(persistenceID), sequenceNumber, "writer",
+	)
+	if err != nil {
+		t.Fatalf("error creating snapshot: %v", err)
+	}
+
+	if err = dialect.PersistSnapshot(context.TODO(), snapshot); err != nil {
+		t.Fatalf("error persisting snapshot: %v", err)
+	}
+}
+
+// sequenceNumbers returns the sequence numbers of the given journals
+func sequenceNumbers(journals []*persistencesql.Journal) []int {
+	result := make([]int, 0, len(journals))
+	for _, journal := range journals {
+		result = append(result, journal.SequenceNumber)
+	}
+	return result
+}
+
+func testPersistJournal(t *testing.T, factory Factory) {
+	ctx := context.TODO()
+	assertions := assert.New(t)
+	dialect := connect(t, factory)
+	persistenceID := uuid.New().String()
+
+	journal, err := persistencesql.NewJournal(persistenceID, event(1), 1, "writer")
+	assertions.NoError(err)
+	assertions.NoError(dialect.PersistJournal(ctx, journal))
+
+	journals, err := dialect.GetJournals(ctx, persistenceID, 1, 1)
+	assertions.NoError(err)
+	if !assertions.Len(journals, 1) {
+		return
+	}
+
+	// every column is read back as written
+	actual := journals[0]
+	assertions.Equal(persistenceID, actual.PersistenceID)
+	assertions.Equal(1, actual.SequenceNumber)
+	assertions.Equal(journal.Timestamp, actual.Timestamp)
+	assertions.Equal(journal.EventManifest, actual.EventManifest)
+	assertions.Equal("writer", actual.WriterID)
+	assertions.False(actual.Deleted)
+	assertions.NotZero(actual.Ordering)
+
+	decoded := new(wrapperspb.Int64Value)
+	assertions.NoError(proto.Unmarshal(actual.Payload, decoded))
+	assertions.True(proto.Equal(event(1), decoded))
+}
+
+func testDuplicateJournal(t *testing.T, factory Factory) {
+	ctx := context.TODO()
+	assertions := assert.New(t)
+	dialect := connect(t, factory)
+	persistenceID := uuid.New().String()
+
+	persistJournals(t, dialect, persistenceID, 1, 3)
+
+	// the same sequence number cannot be written twice for a given persistenceID
+	journal, err := persistencesql.NewJournal(persistenceID, event(2), 2, "another-writer")
+	assertions.NoError(err)
+	err = dialect.PersistJournal(ctx, journal)
+	assertions.True(errors.Is(err, persistencesql.ErrConcurrentModification), "unexpected error: %v", err)
+
+	// the original row is left untouched
+	journals, err := dialect.GetJournals(ctx, persistenceID, 2, 2)
+	assertions.NoError(err)
+	if assertions.Len(journals, 1) {
+		assertions.Equal("writer", journals[0].WriterID)
+	}
+
+	// the same sequence number can be written for another persistenceID
+	journal, err = persistencesql.NewJournal(uuid.New().String(), event(2), 2, "writer")
+	assertions.NoError(err)
+	assertions.NoError(dialect.PersistJournal(ctx, journal))
+}
+
+// newJournals creates the journal entries of a given persistenceID within a range of sequence numbers
+func newJournals(t *testing.T, persistenceID string, from, to int, writerID string) []*persistencesql.Journal {
+	journals := make([]*persistencesql.Journal, 0)
+	for sequenceNumber := from; sequenceNumber <= to; sequenceNumber++ {
+		journal, err := persistencesql.NewJournal(persistenceID, event(sequenceNumber), sequenceNumber, writerID)
+		if err != nil {
+			t.Fatalf("error creating journal: %v", err)
+		}
+		journals = append(journals, journal)
+	}
+	return journals
+}
+
+func testPersistJournals(t *testing.T, factory Factory) {
+	ctx := context.TODO()
+	assertions := assert.New(t)
+	dialect := connect(t, factory)
+	persistenceID := uuid.New().String()
+	otherPersistenceID := uuid.New().String()
+
+	// an empty batch is a no-op
+	assertions.NoError(dialect.PersistJournals(ctx, nil))
+
+	// a batch can span several persistenceIDs
+	batch := append(newJournals(t, persistenceID, 1, 5, "writer"), newJournals(t, otherPersistenceID, 1, 2, "writer")...)
+	assertions.NoError(dialect.PersistJournals(ctx, batch))
+
+	journals, err := dialect.GetJournals(ctx, persistenceID, 1, math.MaxInt32)
+	assertions.NoError(err)
+	assertions.Equal([]int{1, 2, 3, 4, 5}, sequenceNumbers(journals))
+	for i, journal := range journals {
+		assertions.Equal(batch[i].Timestamp, journal.Timestamp)
+		assertions.Equal(batch[i].EventManifest, journal.EventManifest)
+		assertions.Equal(batch[i].Payload, journal.Payload)
+		assertions.Equal(batch[i].Serializer, journal.Serializer)
+		assertions.Equal("writer", journal.WriterID)
+		assertions.False(journal.Deleted)
+	}
+
+	journals, err = dialect.GetJournals(ctx, otherPersistenceID, 1, math.MaxInt32)
+	assertions.NoError(err)
+	assertions.Equal([]int{1, 2}, sequenceNumbers(journals))
+
+	// the large batches are written as well, which Postgres does with COPY
+	largePersistenceID := uuid.New().String()
+	assertions.NoError(dialect.PersistJournals(ctx, newJournals(t, largePersistenceID, 1, 1500, "writer")))
+	journals, err = dialect.GetJournals(ctx, largePersistenceID, 1, math.MaxInt32)
+	assertions.NoError(err)
+	assertions.Len(journals, 1500)
+}
+
+func testPersistJournalsAtomicity(t *testing.T, factory Factory) {
+	ctx := context.TODO()
+	assertions := assert.New(t)
+	dialect := connect(t, factory)
+	persistenceID := uuid.New().String()
+
+	persistJournals(t, dialect, persistenceID, 1, 3)
+
+	// the batch conflicts with an existing entry, which is not blamed on another entry of the batch
+	batch := append(newJournals(t, persistenceID, 4, 5, "writer"), newJournals(t, persistenceID, 3, 3, "writer")...)
+	err := dialect.PersistJournals(ctx, batch)
+	assertions.True(errors.Is(err, persistencesql.ErrConcurrentModification), "unexpected error: %v", err)
+	assertions.NotContains(err.Error(), "sequenceNumber: 4")
+
+	// the batch conflicts with itself
+	batch = append(newJournals(t, persistenceID, 4, 6, "writer"), newJournals(t, persistenceID, 6, 6, "writer")...)
+	err = dialect.PersistJournals(ctx, batch)
+	assertions.True(errors.Is(err, persistencesql.ErrConcurrentModification), "unexpected error: %v", err)
+	assertions.Contains(err.Error(), "sequenceNumber: 6")
+
+	// the batch is written by a stale writer
+	assertions.NoError(dialect.ClaimWriter(ctx, persistenceID, "writer"))
+	err = dialect.PersistJournals(ctx, newJournals(t, persistenceID, 4, 6, "stale-writer"))
+	assertions.True(errors.Is(err, persistencesql.ErrStaleWriter), "unexpected error: %v", err)
+
+	// none of the failed batches wrote anything
+	journals, err := dialect.GetJournals(ctx, persistenceID, 1, math.MaxInt32)
+	assertions.NoError(err)
+	assertions.Equal([]int{1, 2, 3}, sequenceNumbers(journals))
+
+	// the owner can still write
+	assertions.NoError(dialect.PersistJournals(ctx, newJournals(t, persistenceID, 4, 6, "writer")))
+	journals, err = dialect.GetJournals(ctx, persistenceID, 1, math.MaxInt32)
+	assertions.NoError(err)
+	assertions.Equal([]int{1, 2, 3, 4, 5, 6}, sequenceNumbers(journals))
+}
+
+func testPersistLargeJournalBatch(t *testing.T, factory Factory) {
+	ctx := context.TODO()
+	assertions := assert.New(t)
+	dialect := connect(t, factory)
+	persistenceID := uuid.New().String()
+	numEvents := 2500
+
+	assertions.NoError(dialect.PersistJournals(ctx, newJournals(t, persistenceID, 1, numEvents, "writer")))
+
+	count := 0
+	err := dialect.StreamJournals(ctx, persistenceID, 1, math.MaxInt32, 1000, func(journal *persistencesql.Journal) error {
+		count++
+		assertions.Equal(count, journal.SequenceNumber)
+		return nil
+	})
+	assertions.NoError(err)
+	assertions.Equal(numEvents, count)
+
+	// a large conflicting batch is rolled back as a whole
+	err = dialect.PersistJournals(ctx, newJournals(t, persistenceID, numEvents-1, 2*numEvents, "writer"))
+	assertions.True(errors.Is(err, persistencesql.ErrConcurrentModification), "unexpected error: %v", err)
+	journals, err := dialect.GetJournals(ctx, persistenceID, numEvents+1, math.MaxInt32)
+	assertions.NoError(err)
+	assertions.Empty(journals)
+}
+
+func testGetJournalsRange(t *testing.T, factory Factory) {
+	ctx := context.TODO()
+	assertions := assert.New(t)
+	dialect := connect(t, factory)
+	persistenceID := uuid.New().String()
+
+	persistJournals(t, dialect, persistenceID, 1, 10)
+	// events of another persistenceID are never returned
+	persistJournals(t, dialect, uuid.New().String(), 1, 10)
+
+	journals, err := dialect.GetJournals(ctx, persistenceID, 2, 6)
+	assertions.NoError(err)
+	assertions.Equal([]int{2, 3, 4, 5, 6}, sequenceNumbers(journals))
+
+	journals, err = dialect.GetJournals(ctx, persistenceID, 8, math.MaxInt32)
+	assertions.NoError(err)
+	assertions.Equal([]int{8, 9, 10}, sequenceNumbers(journals))
+
+	// the ordering grows with the sequence number
+	journals, err = dialect.GetJournals(ctx, persistenceID, 1, 10)
+	assertions.NoError(err)
+	for i := 1; i < len(journals); i++ {
+		assertions.Greater(journals[i].Ordering, journals[i-1].Ordering)
+	}
+}
+
+func testGetJournalsEmptyRange(t *testing.T, factory Factory) {
+	ctx := context.TODO()
+	assertions := assert.New(t)
+	dialect := connect(t, factory)
+	persistenceID := uuid.New().String()
+
+	persistJournals(t, dialect, persistenceID, 1, 5)
+
+	// the lower bound is greater than the upper bound
+	journals, err := dialect.GetJournals(ctx, persistenceID, 4, 2)
+	assertions.NoError(err)
+	assertions.Empty(journals)
+
+	// the range is beyond the latest event
+	journals, err = dialect.GetJournals(ctx, persistenceID, 6, 10)
+	assertions.NoError(err)
+	assertions.Empty(journals)
+
+	// the persistenceID does not exist
+	journals, err = dialect.GetJournals(ctx, uuid.New().String(), 1, math.MaxInt32)
+	assertions.NoError(err)
+	assertions.Empty(journals)
+
+	calls := 0
+	err = dialect.StreamJournals(ctx, uuid.New().String(), 1, math.MaxInt32, 10, func(*persistencesql.Journal) error {
+		calls++
+		return nil
+	})
+	assertions.NoError(err)
+	assertions.Zero(calls)
+}
+
+func testStreamJournals(t *testing.T, factory Factory) {
+	ctx := context.TODO()
+	assertions := assert.New(t)
+	dialect := connect(t, factory)
+	persistenceID := uuid.New().String()
+
+	persistJournals(t, dialect, persistenceID, 1, 10)
+	assertions.NoError(dialect.DeleteJournals(ctx, persistenceID, 2, true))
+
+	for _, pageSize := range []int{1, 3, 4, 100} {
+		// deleted events are skipped and the range is honoured whatever the page size
+		streamed := make([]int, 0)
+		err := dialect.StreamJournals(ctx, persistenceID, 1, 9, pageSize, func(journal *persistencesql.Journal) error {
+			streamed = append(streamed, journal.SequenceNumber)
+			return nil
+		})
+		assertions.NoError(err)
+		assertions.Equal([]int{3, 4, 5, 6, 7, 8, 9}, streamed, "page size %d", pageSize)
+	}
+
+	// the streaming stops at the first error returned by the callback
+	stop := errors.New("stop")
+	calls := 0
+	err := dialect.StreamJournals(ctx, persistenceID, 1, 10, 3, func(*persistencesql.Journal) error {
+		calls++
+		if calls == 2 {
+			return stop
+		}
+		return nil
+	})
+	assertions.True(errors.Is(err, stop))
+	assertions.Equal(2, calls)
+
+	// an invalid page size is rejected
+	err = dialect.StreamJournals(ctx, persistenceID, 1, 10, 0, func(*persistencesql.Journal) error {
+		return nil
+	})
+	assertions.Error(err)
+}
+
+func testStreamJournalsCancellation(t *testing.T, factory Factory) {
+	assertions := assert.New(t)
+	dialect := connect(t, factory)
+	persistenceID := uuid.New().String()
+
+	persistJournals(t, dialect, persistenceID, 1, 10)
+
+	// the context is canceled while consuming the first page
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	calls := 0
+	err := dialect.StreamJournals(ctx, persistenceID, 1, math.MaxInt32, 2, func(*persistencesql.Journal) error {
+		calls++
+		cancel()
+		return nil
+	})
+	assertions.True(errors.Is(err, context.Canceled))
+	assertions.LessOrEqual(calls, 2)
+}
+
+func testGetJournalsByOrdering(t *testing.T, factory Factory) {
+	ctx := context.TODO()
+	assertions := assert.New(t)
+	dialect := connect(t, factory)
+	persistenceID := uuid.New().String()
+	otherPersistenceID := uuid.New().String()
+
+	// the events of both persistenceIDs are interleaved
+	for sequenceNumber := 1; sequenceNumber <= 2; sequenceNumber++ {
+		persistJournals(t, dialect, persistenceID, sequenceNumber, sequenceNumber)
+		persistJournals(t, dialect, otherPersistenceID, sequenceNumber, sequenceNumber)
+	}
+
+	journals, err := dialect.GetJournals(ctx, persistenceID, 1, 1)
+	assertions.NoError(err)
+	if !assertions.Len(journals, 1) {
+		return
+	}
+	first := journals[0].Ordering
+	from := first - 1
+
+	journals, err = dialect.GetJournalsByOrdering(ctx, from, math.MaxInt64, 10)
+	assertions.NoError(err)
+	if !assertions.Len(journals, 4) {
+		return
+	}
+	for i, journal := range journals {
+		expected := persistenceID
+		if i%2 == 1 {
+			expected = otherPersistenceID
+		}
+		assertions.Equal(expected, journal.PersistenceID)
+		assertions.Equal(i/2+1, journal.SequenceNumber)
+		assertions.Greater(journal.Ordering, from)
+		from = journal.Ordering
+	}
+
+	// the range of orderings and the page size are honoured
+	journals, err = dialect.GetJournalsByOrdering(ctx, first, math.MaxInt64, 2)
+	assertions.NoError(err)
+	assertions.Equal([]int{1, 2}, sequenceNumbers(journals))
+	journals, err = dialect.GetJournalsByOrdering(ctx, first-1, from-1, 10)
+	assertions.NoError(err)
+	assertions.Equal([]int{1, 1, 2}, sequenceNumbers(journals))
+
+	// the logically deleted rows are returned flagged
+	assertions.NoError(dialect.DeleteJournals(ctx, persistenceID, 1, true))
+	journals, err = dialect.GetJournalsByOrdering(ctx, first-1, math.MaxInt64, 1)
+	assertions.NoError(err)
+	if assertions.Len(journals, 1) {
+		assertions.Equal(persistenceID, journals[0].PersistenceID)
+		assertions.True(journals[0].Deleted)
+	}
+}
+
+func testGetJournalsByTag(t *testing.T, factory Factory) {
+	ctx := context.TODO()
+	assertions := assert.New(t)
+	dialect := connect(t, factory)
+	persistenceID := uuid.New().String()
+	otherPersistenceID := uuid.New().String()
+	tag := uuid.New().String()
+	otherTag := uuid.New().String()
+
+	// the tags are written whether the journal entries are persisted one by one or in a batch
+	journals := newJournals(t, persistenceID, 1, 4, "writer")
+	journals[0].Tags = []string{tag}
+	journals[1].Tags = []string{tag, otherTag}
+	journals[3].Tags = []string{otherTag}
+	assertions.NoError(dialect.PersistJournal(ctx, journals[0]))
+	assertions.NoError(dialect.PersistJournals(ctx, journals[1:]))
+
+	journals = newJournals(t, otherPersistenceID, 1, 1, "writer")
+	journals[0].Tags = []string{tag}
+	assertions.NoError(dialect.PersistJournals(ctx, journals))
+
+	tagged, err := dialect.GetJournalsByTag(ctx, tag, 0, math.MaxInt64, 10)
+	assertions.NoError(err)
+	if !assertions.Len(tagged, 3) {
+		return
+	}
+	assertions.Equal([]int{1, 2, 1}, sequenceNumbers(tagged))
+	assertions.Equal(otherPersistenceID, tagged[2].PersistenceID)
+
+	// the range of orderings and the page size are honoured
+	journals, err = dialect.GetJournalsByTag(ctx, tag, tagged[0].Ordering, tagged[2].Ordering-1, 10)
+	assertions.NoError(err)
+	assertions.Equal([]int{2}, sequenceNumbers(journals))
+	journals, err = dialect.GetJournalsByTag(ctx, tag, 0, math.MaxInt64, 1)
+	assertions.NoError(err)
+	assertions.Equal([]int{1}, sequenceNumbers(journals))
+
+	journals, err = dialect.GetJournalsByTag(ctx, otherTag, 0, math.MaxInt64, 10)
+	assertions.NoError(err)
+	assertions.Equal([]int{2, 4}, sequenceNumbers(journals))
+
+	// the deleted events are left out
+	assertions.NoError(dialect.DeleteJournals(ctx, persistenceID, 2, true))
+	journals, err = dialect.GetJournalsByTag(ctx, tag, 0, math.MaxInt64, 10)
+	assertions.NoError(err)
+	if assertions.Len(journals, 1) {
+		assertions.Equal(otherPersistenceID, journals[0].PersistenceID)
+	}
+}
+
+func testGetJournalOrderings(t *testing.T, factory Factory) {
+	ctx := context.TODO()
+	assertions := assert.New(t)
+	dialect := connect(t, factory)
+	persistenceID := uuid.New().String()
+
+	persistJournals(t, dialect, persistenceID, 1, 3)
+	journals, err := dialect.GetJournals(ctx, persistenceID, 1, 3)
+	assertions.NoError(err)
+	if !assertions.Len(journals, 3) {
+		return
+	}
+
+	orderings, err := dialect.GetJournalOrderings(ctx, journals[0].Ordering-1, 2)
+	assertions.NoError(err)
+	if assertions.Len(orderings, 2) {
+		for i, ordering := range orderings {
+			assertions.Equal(journals[i].Ordering, ordering.Ordering)
+			assertions.Equal(journals[i].Timestamp, ordering.Timestamp)
+		}
+	}
+}
+
+func testListPersistenceIDs(t *testing.T, factory Factory) {
+	ctx := context.TODO()
+	assertions := assert.New(t)
+	dialect := connect(t, factory)
+	prefix := uuid.New().String() + "|"
+
+	// the persistenceIDs are persisted out of order and some have several events
+	for _, persistenceID := range []string{"c", "a", "d", "b"} {
+		persistJournals(t, dialect, prefix+persistenceID, 1, 2)
+	}
+	persistJournals(t, dialect, "other|"+prefix, 1, 1)
+
+	persistenceIDs, err := dialect.ListPersistenceIDs(ctx, prefix, "", 10)
+	assertions.NoError(err)
+	assertions.Equal([]string{prefix + "a", prefix + "b", prefix + "c", prefix + "d"}, persistenceIDs)
+
+	// the persistenceIDs are paginated
+	persistenceIDs, err = dialect.ListPersistenceIDs(ctx, prefix, "", 2)
+	assertions.NoError(err)
+	assertions.Equal([]string{prefix + "a", prefix + "b"}, persistenceIDs)
+	persistenceIDs, err = dialect.ListPersistenceIDs(ctx, prefix, prefix+"b", 2)
+	assertions.NoError(err)
+	assertions.Equal([]string{prefix + "c", prefix + "d"}, persistenceIDs)
+	persistenceIDs, err = dialect.ListPersistenceIDs(ctx, prefix, prefix+"d", 2)
+	assertions.NoError(err)
+	assertions.Empty(persistenceIDs)
+
+	// an empty prefix lists every persistenceID
+	persistenceIDs, err = dialect.ListPersistenceIDs(ctx, "", prefix+"c", 10000)
+	assertions.NoError(err)
+	assertions.Contains(persistenceIDs, prefix+"d")
+	assertions.Contains(persistenceIDs, "other|"+prefix)
+	assertions.NotContains(persistenceIDs, prefix+"c")
+}
+
+func testListNewPersistenceIDs(t *testing.T, factory Factory) {
+	ctx := context.TODO()
+	assertions := assert.New(t)
+	dialect := connect(t, factory)
+	prefix := uuid.New().String() + "|"
+
+	// the first rows are listed in the order they have been stored
+	for _, persistenceID := range []string{"c", "a", "b"} {
+		persistJournals(t, dialect, prefix+persistenceID, 1, 2)
+	}
+	persistJournals(t, dialect, "other|"+prefix, 1, 1)
+
+	journals, err := dialect.GetJournals(ctx, prefix+"c", 1, 1)
+	assertions.NoError(err)
+	if !assertions.Len(journals, 1) {
+		return
+	}
+	first := journals[0]
+
+	metadata, err := dialect.ListNewPersistenceIDs(ctx, prefix, first.Ordering-1, 10)
+	assertions.NoError(err)
+	if assertions.Len(metadata, 3) {
+		assertions.Equal(prefix+"c", metadata[0].PersistenceID)
+		assertions.Equal(first.Ordering, metadata[0].Ordering)
+		assertions.Equal(first.Timestamp, metadata[0].Timestamp)
+		assertions.Equal(prefix+"a", metadata[1].PersistenceID)
+		assertions.Equal(prefix+"b", metadata[2].PersistenceID)
+		for _, journal := range metadata {
+			assertions.Equal(1, journal.SequenceNumber)
+		}
+	}
+
+	// the rows are paginated by ordering
+	metadata, err = dialect.ListNewPersistenceIDs(ctx, prefix, first.Ordering, 1)
+	assertions.NoError(err)
+	if assertions.Len(metadata, 1) {
+		assertions.Equal(prefix+"a", metadata[0].PersistenceID)
+	}
+
+	// the first rows are the rows of the lowest sequence number left, whatever it is
+	persistJournals(t, dialect, prefix+"d", 0, 1)
+	persistJournals(t, dialect, prefix+"e", 1, 3)
+	assertions.NoError(dialect.DeleteJournals(ctx, prefix+"e", 1, false))
+	metadata, err = dialect.ListNewPersistenceIDs(ctx, prefix, first.Ordering, 10)
+	assertions.NoError(err)
+	if assertions.Len(metadata, 4) {
+		assertions.Equal(prefix+"d", metadata[2].PersistenceID)
+		assertions.Equal(0, metadata[2].SequenceNumber)
+		assertions.Equal(prefix+"e", metadata[3].PersistenceID)
+		assertions.Equal(2, metadata[3].SequenceNumber)
+	}
+
+	// the rows of the other tenants are not listed
+	metadata, err = dialect.ListNewPersistenceIDs(persistencesql.WithTenant(ctx, uuid.New().String()), "", 0, 10)
+	assertions.NoError(err)
+	assertions.Empty(metadata)
+}
+
+func testDeleteJournals(t *testing.T, factory Factory, logical bool) {
+	ctx := context.TODO()
+	assertions := assert.New(t)
+	dialect := connect(t, factory)
+	persistenceID := uuid.New().String()
+	otherPersistenceID := uuid.New().String()
+
+	persistJournals(t, dialect, persistenceID, 1, 10)
+	persistJournals(t, dialect, otherPersistenceID, 1, 10)
+
+	assertions.NoError(dialect.DeleteJournals(ctx, persistenceID, 4, logical))
+
+	journals, err := dialect.GetJournals(ctx, persistenceID, 1, math.MaxInt32)
+	assertions.NoError(err)
+	assertions.Equal([]int{5, 6, 7, 8, 9, 10}, sequenceNumbers(journals))
+
+	// the events of other persistenceIDs are left untouched
+	journals, err = dialect.GetJournals(ctx, otherPersistenceID, 1, math.MaxInt32)
+	assertions.NoError(err)
+	assertions.Len(journals, 10)
+
+	// deleting an already deleted range is not an error
+	assertions.NoError(dialect.DeleteJournals(ctx, persistenceID, 4, logical))
+}
+
+func testLatestSnapshot(t *testing.T, factory Factory) {
+	ctx := context.TODO()
+	assertions := assert.New(t)
+	dialect := connect(t, factory)
+	persistenceID := uuid.New().String()
+
+	// snapshots are written in an arbitrary order
+	for _, sequenceNumber := range []int{20, 30, 10} {
+		persistSnapshot(t, dialect, persistenceID, sequenceNumber)
+	}
+	persistSnapshot(t, dialect, uuid.New().String(), 40)
+
+	latest, err := dialect.GetLatestSnapshot(ctx, persistenceID)
+	assertions.NoError(err)
+	if !assertions.NotNil(latest) {
+		return
+	}
+
+	assertions.Equal(persistenceID, latest.PersistenceID)
+	assertions.Equal(30, latest.SequenceNumber)
+	assertions.Equal("writer", latest.WriterID)
+	assertions.Equal(
+		persistencesql.Manifest(proto.MessageName(&wrapperspb.StringValue{})), latest.SnapshotManifest,
+	)
+
+	decoded := new(wrapperspb.StringValue)
+	assertions.NoError(proto.Unmarshal(latest.Snapshot, decoded))
+	assertions.Equal(persistenceID, decoded.GetValue())
+}
+
+func testMissingSnapshot(t *testing.T, factory Factory) {
+	assertions := assert.New(t)
+	dialect := connect(t, factory)
+
+	latest, err := dialect.GetLatestSnapshot(context.TODO(), uuid.New().String())
+	assertions.NoError(err)
+	assertions.Nil(latest)
+}
+
+func testDuplicateSnapshot(t *testing.T, factory Factory) {
+	assertions := assert.New(t)
+	dialect := connect(t, factory)
+	persistenceID := uuid.New().String()
+
+	persistSnapshot(t, dialect, persistenceID, 1)
+
+	snapshot, err := persistencesql.NewSnapshot(persistenceID, wrapperspb.String("duplicate"), 1, "writer")
+	assertions.NoError(err)
+	assertions.Error(dialect.PersistSnapshot(context.TODO(), snapshot))
+}
+
+func testDeleteSnapshots(t *testing.T, factory Factory) {
+	ctx := context.TODO()
+	assertions := assert.New(t)
+	dialect := connect(t, factory)
+	persistenceID := uuid.New().String()
+
+	for _, sequenceNumber := range []int{10, 20, 30} {
+		persistSnapshot(t, dialect, persistenceID, sequenceNumber)
+	}
+
+	// the latest snapshot survives a partial deletion
+	assertions.NoError(dialect.DeleteSnapshots(ctx, persistenceID, 20))
+	latest, err := dialect.GetLatestSnapshot(ctx, persistenceID)
+	assertions.NoError(err)
+	if assertions.NotNil(latest) {
+		assertions.Equal(30, latest.SequenceNumber)
+	}
+
+	// no snapshot is left after a full deletion
+	assertions.NoError(dialect.DeleteSnapshots(ctx, persistenceID, 30))
+	latest, err = dialect.GetLatestSnapshot(ctx, persistenceID)
+	assertions.NoError(err)
+	assertions.Nil(latest)
+}
+
+func testPayloadMetadata(t *testing.T, factory Factory) {
+	ctx := context.TODO()
+	assertions := assert.New(t)
+	dialect := connect(t, factory)
+	persistenceID := uuid.New().String()
+
+	// the codec, the key ID and the serializer are stored as is along with the rows
+	journals := newJournals(t, persistenceID, 1, 2, "writer")
+	journals[1].Codec = persistencesql.GzipCodec
+	journals[1].KeyID = "journal-key"
+	journals[1].Serializer = persistencesql.ProtoJSONSerializerID
+	assertions.NoError(dialect.PersistJournal(ctx, journals[0]))
+	assertions.NoError(dialect.PersistJournal(ctx, journals[1]))
+
+	snapshot, err := persistencesql.NewSnapshot(persistenceID, wrapperspb.String(persistenceID), 1, "writer")
+	assertions.NoError(err)
+	snapshot.Codec = persistencesql.SnappyCodec
+	snapshot.KeyID = "snapshot-key"
+	snapshot.Serializer = persistencesql.ProtoJSONSerializerID
+	assertions.NoError(dialect.PersistSnapshot(ctx, snapshot))
+
+	journals, err = dialect.GetJournals(ctx, persistenceID, 1, math.MaxInt32)
+	assertions.NoError(err)
+	if assertions.Len(journals, 2) {
+		assertions.Equal(persistencesql.NoCompression, journals[0].Codec)
+		assertions.Empty(journals[0].KeyID)
+		assertions.Equal(persistencesql.GzipCodec, journals[1].Codec)
+		assertions.Equal("journal-key", journals[1].KeyID)
+		assertions.Equal(persistencesql.ProtoSerializerID, journals[0].Serializer)
+		assertions.Equal(persistencesql.ProtoJSONSerializerID, journals[1].Serializer)
+	}
+
+	latest, err := dialect.GetLatestSnapshot(ctx, persistenceID)
+	assertions.NoError(err)
+	if assertions.NotNil(latest) {
+		assertions.Equal(persistencesql.SnappyCodec, latest.Codec)
+		assertions.Equal("snapshot-key", latest.KeyID)
+		assertions.Equal(persistencesql.ProtoJSONSerializerID, latest.Serializer)
+	}
+}
+
+func testListSnapshots(t *testing.T, factory Factory) {
+	ctx := context.TODO()
+	assertions := assert.New(t)
+	dialect := connect(t, factory)
+	persistenceID := uuid.New().String()
+	otherPersistenceID := uuid.New().String()
+
+	for _, sequenceNumber := range []int{20, 30, 10} {
+		persistSnapshot(t, dialect, persistenceID, sequenceNumber)
+	}
+	persistSnapshot(t, dialect, otherPersistenceID, 40)
+
+	snapshots, err := dialect.ListSnapshots(ctx, persistenceID)
+	assertions.NoError(err)
+	if assertions.Len(snapshots, 3) {
+		for i, snapshot := range snapshots {
+			assertions.Equal(persistenceID, snapshot.PersistenceID)
+			assertions.Equal((i+1)*10, snapshot.SequenceNumber)
+			assertions.NotZero(snapshot.Timestamp)
+		}
+	}
+
+	snapshots, err = dialect.ListSnapshots(ctx, uuid.New().String())
+	assertions.NoError(err)
+	assertions.Empty(snapshots)
+
+	persistenceIDs, err := dialect.ListSnapshotPersistenceIDs(ctx)
+	assertions.NoError(err)
+	assertions.Contains(persistenceIDs, persistenceID)
+	assertions.Contains(persistenceIDs, otherPersistenceID)
+}
+
+func testPruneSnapshots(t *testing.T, factory Factory) {
+	ctx := context.TODO()
+	assertions := assert.New(t)
+	dialect := connect(t, factory)
+	persistenceID := uuid.New().String()
+
+	for _, sequenceNumber := range []int{10, 20, 30, 40} {
+		persistSnapshot(t, dialect, persistenceID, sequenceNumber)
+	}
+
+	// only the existing snapshots are counted
+	pruned, err := dialect.PruneSnapshots(ctx, persistenceID, []int{10, 30, 50})
+	assertions.NoError(err)
+	assertions.EqualValues(2, pruned)
+
+	snapshots, err := dialect.ListSnapshots(ctx, persistenceID)
+	assertions.NoError(err)
+	if assertions.Len(snapshots, 2) {
+		assertions.Equal(20, snapshots[0].SequenceNumber)
+		assertions.Equal(40, snapshots[1].SequenceNumber)
+	}
+
+	// pruning nothing is not an error
+	pruned, err = dialect.PruneSnapshots(ctx, persistenceID, nil)
+	assertions.NoError(err)
+	assertions.Zero(pruned)
+}
+
+func testListSnapshotTenants(t *testing.T, factory Factory) {
+	ctx := context.TODO()
+	assertions := assert.New(t)
+	dialect := connect(t, factory)
+	tenant := uuid.New().String()
+	otherTenant := uuid.New().String()
+
+	persistSnapshot(t, dialect, uuid.New().String(), 1)
+	for _, tenantID := range []string{tenant, otherTenant} {
+		snapshot, err := persistencesql.NewSnapshot("account", wrapperspb.String(tenantID), 1, "writer")
+		assertions.NoError(err)
+		assertions.NoError(dialect.PersistSnapshot(persistencesql.WithTenant(ctx, tenantID), snapshot))
+	}
+
+	// the tenants are listed whatever the tenant of the context
+	tenants, err := dialect.ListSnapshotTenants(persistencesql.WithTenant(ctx, tenant))
+	assertions.NoError(err)
+	assertions.Contains(tenants, "")
+	assertions.Contains(tenants, tenant)
+	assertions.Contains(tenants, otherTenant)
+}
+
+func testSnapshotTruncation(t *testing.T, factory Factory, logical bool) {
+	ctx := context.TODO()
+	assertions := assert.New(t)
+	dialect := connect(t, factory)
+	persistenceID := uuid.New().String()
+	otherPersistenceID := uuid.New().String()
+
+	persistJournals(t, dialect, persistenceID, 1, 10)
+	persistJournals(t, dialect, otherPersistenceID, 1, 10)
+	persistSnapshot(t, dialect, persistenceID, 2)
+
+	snapshot, err := persistencesql.NewSnapshot(persistenceID, wrapperspb.String(persistenceID), 6, "writer")
+	assertions.NoError(err)
+	assertions.NoError(dialect.PersistSnapshotAndTruncate(ctx, snapshot, logical))
+
+	// the snapshot is written along with the journal truncation
+	latest, err := dialect.GetLatestSnapshot(ctx, persistenceID)
+	assertions.NoError(err)
+	if assertions.NotNil(latest) {
+		assertions.Equal(6, latest.SequenceNumber)
+	}
+
+	journals, err := dialect.GetJournals(ctx, persistenceID, 1, math.MaxInt32)
+	assertions.NoError(err)
+	assertions.Equal([]int{6, 7, 8, 9, 10}, sequenceNumbers(journals))
+
+	// the events of other persistenceIDs are left untouched
+	journals, err = dialect.GetJournals(ctx, otherPersistenceID, 1, math.MaxInt32)
+	assertions.NoError(err)
+	assertions.Len(journals, 10)
+}
+
+func testSnapshotTruncationAtomicity(t *testing.T, factory Factory) {
+	ctx := context.TODO()
+	assertions := assert.New(t)
+	dialect := connect(t, factory)
+	persistenceID := uuid.New().String()
+
+	persistJournals(t, dialect, persistenceID, 1, 10)
+	persistSnapshot(t, dialect, persistenceID, 6)
+
+	// the snapshot already exists, hence nothing is truncated
+	snapshot, err := persistencesql.NewSnapshot(persistenceID, wrapperspb.String("duplicate"), 6, "writer")
+	assertions.NoError(err)
+	assertions.Error(dialect.PersistSnapshotAndTruncate(ctx, snapshot, false))
+
+	journals, err := dialect.GetJournals(ctx, persistenceID, 1, math.MaxInt32)
+	assertions.NoError(err)
+	assertions.Len(journals, 10)
+
+	latest, err := dialect.GetLatestSnapshot(ctx, persistenceID)
+	assertions.NoError(err)
+	if assertions.NotNil(latest) {
+		decoded := new(wrapperspb.StringValue)
+		assertions.NoError(proto.Unmarshal(latest.Snapshot, decoded))
+		assertions.Equal(persistenceID, decoded.GetValue())
+	}
+}
+
+func testConcurrentWriters(t *testing.T, factory Factory) {
+	ctx := context.TODO()
+	assertions := assert.New(t)
+	dialect := connect(t, factory)
+	persistenceID := uuid.New().String()
+	numWriters := 5
+	numEvents := 20
+
+	// every writer attempts to write the same sequence numbers
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	written := 0
+	for i := 0; i < numWriters; i++ {
+		wg.Add(1)
+		go func(writerID string) {
+			defer wg.Done()
+			for sequenceNumber := 1; sequenceNumber <= numEvents; sequenceNumber++ {
+				journal, err := persistencesql.NewJournal(persistenceID, event(sequenceNumber), sequenceNumber, writerID)
+				if err != nil {
+					continue
+				}
+
+				err = dialect.PersistJournal(ctx, journal)
+				if err != nil {
+					// the losers are told about the conflict
+					assertions.True(errors.Is(err, persistencesql.ErrConcurrentModification), "unexpected error: %v", err)
+					continue
+				}
+
+				mu.Lock()
+				written++
+				mu.Unlock()
+			}
+		}(uuid.New().String())
+	}
+	wg.Wait()
+
+	// exactly one write per sequence number succeeds
+	assertions.Equal(numEvents, written)
+	journals, err := dialect.GetJournals(ctx, persistenceID, 1, math.MaxInt32)
+	assertions.NoError(err)
+	assertions.Len(journals, numEvents)
+}
+
+func testWriterFencing(t *testing.T, factory Factory) {
+	ctx := context.TODO()
+	assertions := assert.New(t)
+	dialect := connect(t, factory)
+	persistenceID := uuid.New().String()
+
+	persist := func(sequenceNumber int, writerID string) error {
+		journal, err := persistencesql.NewJournal(persistenceID, event(sequenceNumber), sequenceNumber, writerID)
+		assertions.NoError(err)
+		return dialect.PersistJournal(ctx, journal)
+	}
+
+	// anybody can write as long as the persistenceID has not been claimed
+	assertions.NoError(persist(1, "writer-1"))
+
+	// only the owner can write once the persistenceID has been claimed
+	assertions.NoError(dialect.ClaimWriter(ctx, persistenceID, "writer-1"))
+	assertions.NoError(persist(2, "writer-1"))
+	err := persist(3, "writer-2")
+	assertions.True(errors.Is(err, persistencesql.ErrStaleWriter), "unexpected error: %v", err)
+
+	// a new writer takes over the ownership
+	assertions.NoError(dialect.ClaimWriter(ctx, persistenceID, "writer-2"))
+	err = persist(3, "writer-1")
+	assertions.True(errors.Is(err, persistencesql.ErrStaleWriter), "unexpected error: %v", err)
+	assertions.NoError(persist(3, "writer-2"))
+
+	// the claim of a persistenceID does not affect the others
+	journal, err := persistencesql.NewJournal(uuid.New().String(), event(1), 1, "writer-1")
+	assertions.NoError(err)
+	assertions.NoError(dialect.PersistJournal(ctx, journal))
+
+	journals, err := dialect.GetJournals(ctx, persistenceID, 1, math.MaxInt32)
+	assertions.NoError(err)
+	assertions.Equal([]int{1, 2, 3}, sequenceNumbers(journals))
+}
+
+func testProjectionOffset(t *testing.T, factory Factory) {
+	ctx := context.TODO()
+	assertions := assert.New(t)
+	dialect := connect(t, factory)
+	projectionName := uuid.New().String()
+
+	// a projection starts from the beginning
+	offset, err := dialect.GetProjectionOffset(ctx, projectionName)
+	assertions.NoError(err)
+	assertions.Zero(offset)
+
+	assertions.NoError(dialect.SaveProjectionOffset(ctx, projectionName, 10, nil))
+	err = dialect.SaveProjectionOffset(ctx, projectionName, 20, func(tx *sql.Tx) error {
+		assertions.NotNil(tx)
+		return nil
+	})
+	if errors.Is(err, persistencesql.ErrTransactionsUnsupported) {
+		// the dialects without database transaction reject the functions to run in one
+		offset, err = dialect.GetProjectionOffset(ctx, projectionName)
+		assertions.NoError(err)
+		assertions.EqualValues(10, offset)
+	} else {
+		assertions.NoError(err)
+		offset, err = dialect.GetProjectionOffset(ctx, projectionName)
+		assertions.NoError(err)
+		assertions.EqualValues(20, offset)
+
+		// the offset is not saved when the function run in the same transaction fails
+		failure := errors.New("failure")
+		err = dialect.SaveProjectionOffset(ctx, projectionName, 30, func(*sql.Tx) error { return failure })
+		assertions.ErrorIs(err, failure)
+		offset, err = dialect.GetProjectionOffset(ctx, projectionName)
+		assertions.NoError(err)
+		assertions.EqualValues(20, offset)
+	}
+
+	// the offsets of the projections are kept apart
+	offset, err = dialect.GetProjectionOffset(ctx, uuid.New().String())
+	assertions.NoError(err)
+	assertions.Zero(offset)
+}
+
+func testOutbox(t *testing.T, factory Factory) {
+	ctx := context.TODO()
+	assertions := assert.New(t)
+	dialect := connect(t, factory)
+	persistenceID := uuid.New().String()
+
+	// the outbox entries are written whether the journal entries are persisted one by one or in a batch
+	journals := newJournals(t, persistenceID, 1, 4, "writer")
+	for _, journal := range journals[:3] {
+		journal.Outbox = true
+	}
+	assertions.NoError(dialect.PersistJournal(ctx, journals[0]))
+	assertions.NoError(dialect.PersistJournals(ctx, journals[1:]))
+
+	// claim claims the due outbox entries, leasing them for 10 milliseconds
+	claim := func(now int64) []*persistencesql.OutboxEntry {
+		entries, err := dialect.ClaimOutboxEntries(ctx, now, now+10, 1000)
+		assertions.NoError(err)
+		return entries
+	}
+	ours := func(entries []*persistencesql.OutboxEntry) []*persistencesql.OutboxEntry {
+		result := make([]*persistencesql.OutboxEntry, 0)
+		for _, entry := range entries {
+			if entry.Journal.PersistenceID == persistenceID {
+				result = append(result, entry)
+			}
+		}
+		return result
+	}
+	claimed := func(entries []*persistencesql.OutboxEntry) []int {
+		sequenceNumbers := make([]int, 0)
+		for _, entry := range ours(entries) {
+			sequenceNumbers = append(sequenceNumbers, entry.Journal.SequenceNumber)
+		}
+		return sequenceNumbers
+	}
+
+	// the entries are claimed as they were before being leased
+	entries := claim(0)
+	assertions.Equal([]int{1, 2, 3}, claimed(entries))
+	for _, entry := range ours(entries) {
+		assertions.Zero(entry.NextAttemptAt)
+	}
+
+	// the claimed entries are not claimed again before their lease expires
+	assertions.Empty(claimed(claim(9)))
+	entries = claim(10)
+	assertions.Equal([]int{1, 2, 3}, claimed(entries))
+
+	// the delivery states are saved
+	for _, entry := range ours(entries) {
+		entry.Attempts++
+		switch entry.Journal.SequenceNumber {
+		case 1:
+			entry.Status = persistencesql.OutboxDelivered
+		case 2:
+			entry.NextAttemptAt = 100
+			entry.LastError = "failure"
+		case 3:
+			entry.Status = persistencesql.OutboxDeadLetter
+		}
+	}
+	assertions.NoError(dialect.UpdateOutboxEntries(ctx, entries))
+
+	// the entries are only claimed again when pending and due
+	assertions.Empty(claimed(claim(99)))
+	pending, err := dialect.GetOutboxEntries(ctx, persistencesql.OutboxPending, 1000)
+	assertions.NoError(err)
+	pending = ours(pending)
+	if assertions.Len(pending, 1) {
+		assertions.Equal(2, pending[0].Journal.SequenceNumber)
+		assertions.Equal("failure", pending[0].LastError)
+		assertions.EqualValues(100, pending[0].NextAttemptAt)
+	}
+	assertions.Equal([]int{2}, claimed(claim(100)))
+
+	entries, err = dialect.GetOutboxEntries(ctx, persistencesql.OutboxDeadLetter, 1000)
+	assertions.NoError(err)
+	entries = ours(entries)
+	if assertions.Len(entries, 1) {
+		entry := entries[0]
+		assertions.Equal(3, entry.Journal.SequenceNumber)
+		assertions.Equal(1, entry.Attempts)
+		assertions.Equal(journals[2].Payload, entry.Journal.Payload)
+		assertions.Equal(journals[2].EventManifest, entry.Journal.EventManifest)
+		assertions.Equal(journals[2].Serializer, entry.Journal.Serializer)
+		assertions.NotZero(entry.Journal.Ordering)
+	}
+}
+
+func testListOutboxTenants(t *testing.T, factory Factory) {
+	ctx := context.TODO()
+	assertions := assert.New(t)
+	dialect := connect(t, factory)
+	tenant := uuid.New().String()
+	otherTenant := uuid.New().String()
+
+	for _, tenantID := range []string{tenant, otherTenant} {
+		journals := newJournals(t, "account", 1, 1, "writer")
+		journals[0].Outbox = true
+		assertions.NoError(dialect.PersistJournals(persistencesql.WithTenant(ctx, tenantID), journals))
+	}
+
+	// the tenants are listed whatever the tenant of the context
+	tenants, err := dialect.ListOutboxTenants(persistencesql.WithTenant(ctx, tenant))
+	assertions.NoError(err)
+	assertions.Contains(tenants, tenant)
+	assertions.Contains(tenants, otherTenant)
+
+	// the tenants which outbox has been drained are left out
+	otherCtx := persistencesql.WithTenant(ctx, otherTenant)
+	entries, err := dialect.ClaimOutboxEntries(otherCtx, 0, 0, 1000)
+	assertions.NoError(err)
+	for _, entry := range entries {
+		entry.Status = persistencesql.OutboxDelivered
+	}
+	assertions.NoError(dialect.UpdateOutboxEntries(otherCtx, entries))
+	tenants, err = dialect.ListOutboxTenants(ctx)
+	assertions.NoError(err)
+	assertions.Contains(tenants, tenant)
+	assertions.NotContains(tenants, otherTenant)
+}
+
+func testTenantIsolation(t *testing.T, factory Factory) {
+	assertions := assert.New(t)
+	dialect := connect(t, factory)
+	persistenceID := uuid.New().String()
+	tag := uuid.New().String()
+	tenantID := uuid.New().String()
+	tenant := persistencesql.WithTenant(context.TODO(), tenantID)
+	otherTenant := persistencesql.WithTenant(context.TODO(), uuid.New().String())
+
+	// the same persistenceID is written to both tenants without conflicting
+	for _, ctx := range []context.Context{tenant, otherTenant} {
+		journals := newJournals(t, persistenceID, 1, 2, "writer")
+		journals[0].Tags = []string{tag}
+		assertions.NoError(dialect.PersistJournal(ctx, journals[0]))
+		assertions.NoError(dialect.PersistJournals(ctx, journals[1:]))
+	}
+
+	journals, err := dialect.GetJournals(tenant, persistenceID, 1, 2)
+	assertions.NoError(err)
+	if !assertions.Len(journals, 2) {
+		return
+	}
+	assertions.Equal(tenantID, journals[0].TenantID)
+	first := journals[0].Ordering
+
+	// the default tenant sees none of them
+	journals, err = dialect.GetJournals(context.TODO(), persistenceID, 1, 2)
+	assertions.NoError(err)
+	assertions.Empty(journals)
+	persistenceIDs, err := dialect.ListPersistenceIDs(context.TODO(), persistenceID, "", 10)
+	assertions.NoError(err)
+	assertions.Empty(persistenceIDs)
+	persistenceIDs, err = dialect.ListPersistenceIDs(tenant, persistenceID, "", 10)
+	assertions.NoError(err)
+	assertions.Equal([]string{persistenceID}, persistenceIDs)
+
+	tagged, err := dialect.GetJournalsByTag(tenant, tag, 0, math.MaxInt64, 10)
+	assertions.NoError(err)
+	if assertions.Len(tagged, 1) {
+		assertions.Equal(tenantID, tagged[0].TenantID)
+	}
+
+	// the rows of the other tenants are not read by ordering, but their orderings are
+	journals, err = dialect.GetJournalsByOrdering(tenant, first-1, math.MaxInt64, 4)
+	assertions.NoError(err)
+	assertions.Equal([]int{1, 2}, sequenceNumbers(journals))
+	orderings, err := dialect.GetJournalOrderings(tenant, first-1, 4)
+	assertions.NoError(err)
+	if assertions.Len(orderings, 4) {
+		assertions.Equal(journals[1].Ordering, orderings[1].Ordering)
+		assertions.Greater(orderings[2].Ordering, orderings[1].Ordering)
+		assertions.Greater(orderings[3].Ordering, orderings[2].Ordering)
+	}
+
+	// the snapshots, the deletions, the writers and the projection offsets are kept apart
+	snapshot, err := persistencesql.NewSnapshot(persistenceID, wrapperspb.String(persistenceID), 2, "writer")
+	assertions.NoError(err)
+	assertions.NoError(dialect.PersistSnapshot(tenant, snapshot))
+	latest, err := dialect.GetLatestSnapshot(otherTenant, persistenceID)
+	assertions.NoError(err)
+	assertions.Nil(latest)
+
+	assertions.NoError(dialect.DeleteJournals(tenant, persistenceID, 2, false))
+	journals, err = dialect.GetJournals(otherTenant, persistenceID, 1, 2)
+	assertions.NoError(err)
+	assertions.Equal([]int{1, 2}, sequenceNumbers(journals))
+
+	assertions.NoError(dialect.ClaimWriter(tenant, persistenceID, "owner"))
+	journal, err := persistencesql.NewJournal(persistenceID, event(3), 3, "writer")
+	assertions.NoError(err)
+	assertions.NoError(dialect.PersistJournal(otherTenant, journal))
+
+	assertions.NoError(dialect.SaveProjectionOffset(tenant, tag, 10, nil))
+	offset, err := dialect.GetProjectionOffset(otherTenant, tag)
+	assertions.NoError(err)
+	assertions.Zero(offset)
+}
+
+func testProviderState(t *testing.T, factory Factory) {
+	assertions := assert.New(t)
+	persistenceID := uuid.New().String()
+
+	dialect := factory(t)
+	provider := persistencesql.NewSQLProvider(
+		context.TODO(), actor.NewActorSystem(), dialect,
+		persistencesql.WithSnapshotInterval(5), persistencesql.WithReplayPageSize(2),
+	)
+	t.Cleanup(func() {
+		_ = dialect.Close()
+	})
+
+	state := provider.GetState()
+	assertions.Equal(5, state.GetSnapshotInterval())
+
+	// there is nothing to recover yet
+	_, _, ok := state.GetSnapshot(persistenceID)
+	assertions.False(ok)
+
+	// persist some events and a snapshot
+	for sequenceNumber := 1; sequenceNumber <= 7; sequenceNumber++ {
+		state.PersistEvent(persistenceID, sequenceNumber, event(sequenceNumber))
+	}
+	state.PersistSnapshot(persistenceID, 5, wrapperspb.String(persistenceID))
+
+	// persist a batch of events atomically
+	if batcher, ok := state.(*persistencesql.SQLProviderState); assertions.True(ok) {
+		batcher.PersistEvents(persistenceID, 8, event(8), event(9))
+	}
+
+	// recover the snapshot
+	snapshot, eventIndex, ok := state.GetSnapshot(persistenceID)
+	assertions.True(ok)
+	assertions.Equal(5, eventIndex)
+	if message, isMessage := snapshot.(proto.Message); assertions.True(isMessage) {
+		assertions.True(proto.Equal(wrapperspb.String(persistenceID), message))
+	}
+
+	// replay the events following the snapshot. 0 means up to the latest event
+	replayed := make([]int64, 0)
+	state.GetEvents(persistenceID, eventIndex+1, 0, func(e interface{}) {
+		if message, isEvent := e.(*wrapperspb.Int64Value); assertions.True(isEvent) {
+			replayed = append(replayed, message.GetValue())
+		}
+	})
+	assertions.Equal([]int64{6, 7, 8, 9}, replayed)
+
+	// replay a bounded range of events
+	replayed = replayed[:0]
+	state.GetEvents(persistenceID, 2, 4, func(e interface{}) {
+		replayed = append(replayed, e.(*wrapperspb.Int64Value).GetValue())
+	})
+	assertions.Equal([]int64{2, 3, 4}, replayed)
+
+	// deleted events are not replayed
+	state.DeleteEvents(persistenceID, 6)
+	replayed = replayed[:0]
+	state.GetEvents(persistenceID, 1, 0, func(e interface{}) {
+		replayed = append(replayed, e.(*wrapperspb.Int64Value).GetValue())
+	})
+	assertions.Equal([]int64{7, 8, 9}, replayed)
+
+	// deleted snapshots are not recovered
+	state.DeleteSnapshots(persistenceID, 5)
+	_, _, ok = state.GetSnapshot(persistenceID)
+	assertions.False(ok)
+}
+
+func testProviderWriterFencing(t *testing.T, factory Factory) {
+	assertions := assert.New(t)
+	persistenceID := uuid.New().String()
+
+	// collect the failures instead of escalating them
+	var mu sync.Mutex
+	failures := make([]*persistencesql.PersistenceError, 0)
+	handler := func(err *persistencesql.PersistenceError) persistencesql.Directive {
+		mu.Lock()
+		defer mu.Unlock()
+		failures = append(failures, err)
+		return persistencesql.ResumeDirective
+	}
+
+	dialect := factory(t)
+	provider := persistencesql.NewSQLProvider(
+		context.TODO(), actor.NewActorSystem(), dialect,
+		persistencesql.WithWriterFencing(), persistencesql.WithErrorHandler(handler),
+	)
+	t.Cleanup(func() {
+		_ = dialect.Close()
+	})
+
+	// the first incarnation recovers and writes
+	stale := provider.GetState()
+	stale.GetSnapshot(persistenceID)
+	stale.PersistEvent(persistenceID, 1, event(1))
+	assertions.Empty(failures)
+
+	// a second incarnation recovers somewhere else and takes over
+	current := provider.GetState()
+	current.GetSnapshot(persistenceID)
+	current.PersistEvent(persistenceID, 2, event(2))
+	assertions.Empty(failures)
+
+	// the first incarnation can no longer write
+	stale.PersistEvent(persistenceID, 3, event(3))
+	if assertions.Len(failures, 1) {
+		assertions.Equal(persistencesql.PersistEventOperation, failures[0].Operation)
+		assertions.Equal(3, failures[0].SequenceNumber)
+		assertions.True(errors.Is(failures[0], persistencesql.ErrStaleWriter))
+	}
+
+	// a conflicting sequence number is reported as a concurrent modification
+	current.PersistEvent(persistenceID, 2, event(2))
+	if assertions.Len(failures, 2) {
+		assertions.True(errors.Is(failures[1], persistencesql.ErrConcurrentModification))
+	}
+
+	replayed := make([]int64, 0)
+	current.GetEvents(persistenceID, 1, 0, func(e interface{}) {
+		replayed = append(replayed, e.(*wrapperspb.Int64Value).GetValue())
+	})
+	assertions.Equal([]int64{1, 2}, replayed)
+}
@@ -3,6 +3,9 @@ package persistencesql
 import (
 	"errors"
 	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
 )
 
 // Driver defines a type of SQL driver accepted.
@@ -14,12 +17,14 @@ const (
 	POSTGRES Driver = "postgres"
 	// MYSQL driver type
 	MYSQL Driver = "mysql"
+	// SQLITE driver type
+	SQLITE Driver = "sqlite3"
 )
 
 // IsValid checks whether the given driver is valid or not
 func (d Driver) IsValid() error {
 	switch d {
-	case POSTGRES, MYSQL:
+	case POSTGRES, MYSQL, SQLITE:
 		return nil
 	}
 	return errors.New("invalid driver type")
@@ -51,6 +56,10 @@ func (d Driver) ConnStr(dbHost string, dbPort int, dbName, dbUser, dbPassword, d
 		connectionInfo = fmt.Sprintf(
 			"%s:%s@tcp(%s:%v)/%s", dbUser, dbPassword, dbHost, dbPort, dbName,
 		)
+	case SQLITE:
+		// the database name is either the database file path or :memory:. The transactions take the write lock
+		// right away so that the rows read before writing cannot be changed in between
+		connectionInfo = fmt.Sprintf("file:%s?_busy_timeout=5000&_txlock=immediate", dbName)
 	}
 
 	return connectionInfo
@@ -63,7 +72,32 @@ func (d Driver) SQLFile() string {
 		return postgresSQL
 	case MYSQL:
 		return mysqlSQL
+	case SQLITE:
+		return sqliteSQL
 	}
 
 	return ""
 }
+
+// placeholder returns the query parameter placeholder at the given position, starting at 1
+func (d Driver) placeholder(position int) string {
+	if d == POSTGRES {
+		return fmt.Sprintf("$%d", position)
+	}
+	return "?"
+}
+
+// isUniqueViolation checks whether the given error is raised by the driver on a unique constraint violation
+func (d Driver) isUniqueViolation(err error) bool {
+	switch d {
+	case POSTGRES:
+		var pqErr *pq.Error
+		return errors.As(err, &pqErr) && pqErr.Code == "23505"
+	case MYSQL:
+		var mysqlErr *mysql.MySQLError
+		return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062
+	case SQLITE:
+		return isSQLiteUniqueViolation(err)
+	}
+	return false
+}
@@ -0,0 +1,18 @@
+//go:build cgo
+// +build cgo
+
+package persistencesql
+
+import (
+	"errors"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// isSQLiteUniqueViolation checks whether the given error is raised by the SQLite driver on a unique constraint
+// violation
+func isSQLiteUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) &&
+		(sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey)
+}
@@ -0,0 +1,9 @@
+//go:build !cgo
+// +build !cgo
+
+package persistencesql
+
+// isSQLiteUniqueViolation always returns false since the SQLite driver cannot run without cgo
+func isSQLiteUniqueViolation(error) bool {
+	return false
+}
@@ -43,6 +43,22 @@ func TestConnectionString(t *testing.T) {
 			},
 			expected: "root:test@tcp(localhost:3306)/pg",
 		},
+		// asserting sqlite connection string
+		"sqlite connection string": {
+			driver: SQLITE,
+			config: dbConfig{
+				dbName: "/tmp/journal.db",
+			},
+			expected: "file:/tmp/journal.db?_busy_timeout=5000&_txlock=immediate",
+		},
+		// asserting sqlite in-memory connection string
+		"sqlite in-memory connection string": {
+			driver: SQLITE,
+			config: dbConfig{
+				dbName: ":memory:",
+			},
+			expected: "file::memory:?_busy_timeout=5000&_txlock=immediate",
+		},
 		// asserting that unknown driver type will return empty string
 		"not yet supported driver": {
 			driver:   "ORACLE",
@@ -81,6 +97,11 @@ func TestIsValid(t *testing.T) {
 			driver:    MYSQL,
 			expectErr: false,
 		},
+		// asserting that sqlite is driver type
+		"sqlite": {
+			driver:    SQLITE,
+			expectErr: false,
+		},
 		// asserting that oracle is driver type
 		"oracle": {
 			driver:    "ORACLE",
@@ -117,6 +138,11 @@ func TestSchemaFile(t *testing.T) {
 			driver:   MYSQL,
 			expected: mysqlSQL,
 		},
+		// asserting that the sqlite driver will return the correct schema file
+		"sqlite": {
+			driver:   SQLITE,
+			expected: sqliteSQL,
+		},
 		// asserting that an unknown driver type will return an empty string as schema file
 		"unknown": {
 			driver:   "DB2",
@@ -148,6 +174,10 @@ func TestDriverString(t *testing.T) {
 			driver:   MYSQL,
 			expected: "mysql",
 		},
+		"sqlite": {
+			driver:   SQLITE,
+			expected: "sqlite3",
+		},
 		"oracle": {
 			driver:   "ORACLE",
 			expected: "",
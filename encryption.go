@@ -0,0 +1,317 @@
+package persistencesql
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// ErrKeyNotFound is returned by a KeyProvider when a key does not exist or has been destroyed
+var ErrKeyNotFound = errors.New("key not found")
+
+// KeyProvider hands over the keys protecting the payloads of every persistence ID.
+// Every payload is encrypted with its own data key, which is in turn encrypted with the current key of the
+// persistence ID. The ID of that key is recorded along with the row.
+// The persistence IDs are scoped to the tenant of the context, see TenantFromContext: the same persistence ID of two
+// tenants must be given distinct keys
+type KeyProvider interface {
+	// CurrentKey returns the key new payloads of a persistence ID are encrypted with, along with its ID.
+	// Keys must be 16, 24 or 32 bytes long to select AES-128, AES-192 or AES-256
+	CurrentKey(ctx context.Context, persistenceID string) (keyID string, key []byte, err error)
+	// Key returns a key of a persistence ID given its ID. Rotated keys must remain available so that the payloads
+	// they protect can still be decrypted. It returns ErrKeyNotFound when the key has been destroyed
+	Key(ctx context.Context, persistenceID string, keyID string) ([]byte, error)
+	// DestroyKeys destroys all the keys of a persistence ID. The payloads of the persistence ID can no longer be
+	// decrypted afterwards: they are crypto-shredded
+	DestroyKeys(ctx context.Context, persistenceID string) error
+}
+
+// InMemoryKeyProvider is a KeyProvider generating and keeping the keys in memory.
+// It is meant to be used in unit tests, production deployments should back the KeyProvider with a KMS
+type InMemoryKeyProvider struct {
+	mu      sync.RWMutex
+	keys    map[tenantKey]map[string][]byte // persistenceID within its tenant -> keyID -> key
+	current map[tenantKey]string            // persistenceID within its tenant -> current keyID
+}
+
+// enforces that InMemoryKeyProvider implements the KeyProvider interface
+var _ KeyProvider = (*InMemoryKeyProvider)(nil)
+
+// NewInMemoryKeyProvider creates a new instance of InMemoryKeyProvider
+func NewInMemoryKeyProvider() *InMemoryKeyProvider {
+	return &InMemoryKeyProvider{
+		keys:    make(map[tenantKey]map[string][]byte),
+		current: make(map[tenantKey]string),
+	}
+}
+
+// CurrentKey returns the current key of a persistence ID. A key is generated on first use
+func (p *InMemoryKeyProvider) CurrentKey(ctx context.Context, persistenceID string) (string, []byte, error) {
+	p.mu.RLock()
+	keyID, ok := p.current[tenantKey{tenantOf(ctx), persistenceID}]
+	if ok {
+		key := p.keys[tenantKey{tenantOf(ctx), persistenceID}][keyID]
+		p.mu.RUnlock()
+		return keyID, key, nil
+	}
+	p.mu.RUnlock()
+
+	return p.Rotate(ctx, persistenceID)
+}
+
+// Key returns a key of a persistence ID given its ID
+func (p *InMemoryKeyProvider) Key(ctx context.Context, persistenceID string, keyID string) ([]byte, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	key, ok := p.keys[tenantKey{tenantOf(ctx), persistenceID}][keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: persistenceID: %s keyID: %s", ErrKeyNotFound, persistenceID, keyID)
+	}
+	return key, nil
+}
+
+// DestroyKeys destroys all the keys of a persistence ID
+func (p *InMemoryKeyProvider) DestroyKeys(ctx context.Context, persistenceID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.keys, tenantKey{tenantOf(ctx), persistenceID})
+	delete(p.current, tenantKey{tenantOf(ctx), persistenceID})
+	return nil
+}
+
+// Rotate generates a new current key for a persistence ID. The previous keys remain available for decryption
+func (p *InMemoryKeyProvider) Rotate(ctx context.Context, persistenceID string) (string, []byte, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return "", nil, err
+	}
+	keyID := uuid.New().String()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	id := tenantKey{tenantOf(ctx), persistenceID}
+	if _, ok := p.keys[id]; !ok {
+		p.keys[id] = make(map[string][]byte)
+	}
+	p.keys[id][keyID] = key
+	p.current[id] = keyID
+	return keyID, key, nil
+}
+
+// dataKeySize is the size of the data key generated for every payload, which selects AES-256
+const dataKeySize = 32
+
+// the kinds of rows whose payloads are encrypted
+const (
+	eventRow    = "event"
+	snapshotRow = "snapshot"
+)
+
+// rowData returns the data authenticated along with the payload of a row, so that a payload cannot be moved to
+// another row: the kind of row, the persistence ID, the sequence number, the manifest and the tenant. The tenant is
+// only added for the rows of a tenant, hence the rows of the default tenant written before the tenants were
+// introduced remain readable. Every field is prefixed with its length to keep the encoding unambiguous
+func rowData(kind string, tenantID string, persistenceID string, sequenceNumber int, manifest Manifest) []byte {
+	fields := []string{kind, persistenceID, strconv.Itoa(sequenceNumber), string(manifest)}
+	if tenantID != "" {
+		fields = append(fields, tenantID)
+	}
+
+	var data []byte
+	size := make([]byte, 4)
+	for _, field := range fields {
+		binary.BigEndian.PutUint32(size, uint32(len(field)))
+		data = append(append(data, size...), field...)
+	}
+	return data
+}
+
+// encrypt encrypts a payload using envelope encryption. A data key is generated and used to encrypt the payload,
+// then the data key is encrypted with the key of the persistence ID. The result is laid out as:
+// length of the encrypted data key (2 bytes) | encrypted data key | encrypted payload.
+// The additional data, which describes the row of the payload, is authenticated along with the payload
+func encrypt(key []byte, additionalData []byte, payload []byte) ([]byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+
+	encryptedKey, err := seal(key, dataKey, additionalData)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedPayload, err := seal(dataKey, payload, additionalData)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 2, 2+len(encryptedKey)+len(encryptedPayload))
+	binary.BigEndian.PutUint16(out, uint16(len(encryptedKey)))
+	out = append(out, encryptedKey...)
+	return append(out, encryptedPayload...), nil
+}
+
+// decrypt decrypts a payload encrypted by encrypt with the same additional data
+func decrypt(key []byte, additionalData []byte, data []byte) ([]byte, error) {
+	if len(data) < 2 {
+		return nil, errors.New("malformed encrypted payload")
+	}
+
+	size := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+size {
+		return nil, errors.New("malformed encrypted payload")
+	}
+
+	dataKey, err := open(key, data[2:2+size], additionalData)
+	if err != nil {
+		return nil, err
+	}
+	return open(dataKey, data[2+size:], additionalData)
+}
+
+// seal encrypts the plaintext with AES-GCM. The random nonce is prepended to the ciphertext
+func seal(key []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// open decrypts a ciphertext sealed by seal
+func open(key []byte, ciphertext []byte, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("malformed ciphertext")
+	}
+	return aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], additionalData)
+}
+
+// newGCM creates an AES-GCM cipher given a key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// journalData returns the data authenticated along with the payload of a journal entry
+func journalData(journal *Journal) []byte {
+	return rowData(eventRow, journal.TenantID, journal.PersistenceID, journal.SequenceNumber, journal.EventManifest)
+}
+
+// snapshotData returns the data authenticated along with the payload of a snapshot
+func snapshotData(snapshot *Snapshot) []byte {
+	return rowData(
+		snapshotRow, snapshot.TenantID, snapshot.PersistenceID, snapshot.SequenceNumber, snapshot.SnapshotManifest,
+	)
+}
+
+// encryptJournal encrypts the payload of a journal entry with the current key of its persistence ID within its tenant
+func encryptJournal(ctx context.Context, keyProvider KeyProvider, journal *Journal) error {
+	keyID, key, err := keyProvider.CurrentKey(WithTenant(ctx, journal.TenantID), journal.PersistenceID)
+	if err != nil {
+		return err
+	}
+
+	payload, err := encrypt(key, journalData(journal), journal.Payload)
+	if err != nil {
+		return err
+	}
+
+	journal.Payload = payload
+	journal.KeyID = keyID
+	return nil
+}
+
+// decryptJournal returns a copy of the journal entry carrying the decrypted payload
+func decryptJournal(ctx context.Context, keyProvider KeyProvider, journal *Journal) (*Journal, error) {
+	if journal.KeyID == "" {
+		return journal, nil
+	}
+
+	if keyProvider == nil {
+		return nil, fmt.Errorf("no key provider set to decrypt persistenceID: %s", journal.PersistenceID)
+	}
+
+	key, err := keyProvider.Key(WithTenant(ctx, journal.TenantID), journal.PersistenceID, journal.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := decrypt(key, journalData(journal), journal.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted := *journal
+	decrypted.Payload = payload
+	decrypted.KeyID = ""
+	return &decrypted, nil
+}
+
+// encryptSnapshot encrypts a snapshot with the current key of its persistence ID within its tenant
+func encryptSnapshot(ctx context.Context, keyProvider KeyProvider, snapshot *Snapshot) error {
+	keyID, key, err := keyProvider.CurrentKey(WithTenant(ctx, snapshot.TenantID), snapshot.PersistenceID)
+	if err != nil {
+		return err
+	}
+
+	payload, err := encrypt(key, snapshotData(snapshot), snapshot.Snapshot)
+	if err != nil {
+		return err
+	}
+
+	snapshot.Snapshot = payload
+	snapshot.KeyID = keyID
+	return nil
+}
+
+// decryptSnapshot returns a copy of the snapshot carrying the decrypted payload
+func decryptSnapshot(ctx context.Context, keyProvider KeyProvider, snapshot *Snapshot) (*Snapshot, error) {
+	if snapshot.KeyID == "" {
+		return snapshot, nil
+	}
+
+	if keyProvider == nil {
+		return nil, fmt.Errorf("no key provider set to decrypt persistenceID: %s", snapshot.PersistenceID)
+	}
+
+	key, err := keyProvider.Key(WithTenant(ctx, snapshot.TenantID), snapshot.PersistenceID, snapshot.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := decrypt(key, snapshotData(snapshot), snapshot.Snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted := *snapshot
+	decrypted.Snapshot = payload
+	decrypted.KeyID = ""
+	return &decrypted, nil
+}
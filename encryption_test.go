@@ -0,0 +1,250 @@
+package persistencesql
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/AsynkronIT/protoactor-go/actor"
+	"github.com/AsynkronIT/protoactor-go/persistence"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	pb "github.com/tochemey/protoactor-persistence-sql/gen"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestEncryption(t *testing.T) {
+	ctx := context.TODO()
+	persistenceID := uuid.New().String()
+	payload := []byte("some-sensitive-payload")
+
+	// get instance of assert
+	assertions := assert.New(t)
+	keyProvider := NewInMemoryKeyProvider()
+	_, key, err := keyProvider.CurrentKey(ctx, persistenceID)
+	assertions.NoError(err)
+
+	additionalData := rowData(eventRow, "", persistenceID, 1, "persistence.Event")
+	encrypted, err := encrypt(key, additionalData, payload)
+	assertions.NoError(err)
+	assertions.NotContains(string(encrypted), string(payload))
+
+	// every payload gets its own data key
+	other, err := encrypt(key, additionalData, payload)
+	assertions.NoError(err)
+	assertions.NotEqual(encrypted, other)
+
+	decrypted, err := decrypt(key, additionalData, encrypted)
+	assertions.NoError(err)
+	assertions.Equal(payload, decrypted)
+
+	// the payload is bound to its additional data
+	_, err = decrypt(key, rowData(eventRow, "", uuid.New().String(), 1, "persistence.Event"), encrypted)
+	assertions.Error(err)
+	_, err = decrypt(key, rowData(eventRow, "acme", persistenceID, 1, "persistence.Event"), encrypted)
+	assertions.Error(err)
+
+	// a tampered payload is rejected
+	encrypted[len(encrypted)-1] ^= 0xff
+	_, err = decrypt(key, additionalData, encrypted)
+	assertions.Error(err)
+
+	// a truncated payload is rejected
+	_, err = decrypt(key, additionalData, encrypted[:1])
+	assertions.Error(err)
+}
+
+func TestEncryptionBinding(t *testing.T) {
+	ctx := context.TODO()
+	persistenceID := uuid.New().String()
+	keyProvider := NewInMemoryKeyProvider()
+	event := &pb.AccountDebited{AccountNumber: persistenceID, Balance: 1}
+
+	// the payload of an encrypted journal entry cannot be moved to another row
+	testCases := map[string]struct {
+		move func(journal *Journal) (*Journal, *Snapshot)
+	}{
+		"sequence number": {
+			move: func(journal *Journal) (*Journal, *Snapshot) {
+				journal.SequenceNumber++
+				return journal, nil
+			},
+		},
+		"manifest": {
+			move: func(journal *Journal) (*Journal, *Snapshot) {
+				journal.EventManifest = Manifest(proto.MessageName(&pb.Account{}))
+				return journal, nil
+			},
+		},
+		"snapshot": {
+			move: func(journal *Journal) (*Journal, *Snapshot) {
+				return nil, &Snapshot{
+					PersistenceID:    journal.PersistenceID,
+					SequenceNumber:   journal.SequenceNumber,
+					Snapshot:         journal.Payload,
+					SnapshotManifest: journal.EventManifest,
+					KeyID:            journal.KeyID,
+				}
+			},
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(
+			name, func(t *testing.T) {
+				// get instance of assert
+				assertions := assert.New(t)
+
+				journal, err := NewJournal(persistenceID, event, 1, "writer")
+				assertions.NoError(err)
+				assertions.NoError(encryptJournal(ctx, keyProvider, journal))
+
+				// the row decrypts where it has been written
+				decrypted, err := decryptJournal(ctx, keyProvider, journal)
+				assertions.NoError(err)
+				message, err := decrypted.message(defaultSerializer)
+				assertions.NoError(err)
+				assertions.True(proto.Equal(event, message))
+
+				moved, snapshot := testCase.move(journal)
+				if moved != nil {
+					_, err = decryptJournal(ctx, keyProvider, moved)
+				} else {
+					_, err = decryptSnapshot(ctx, keyProvider, snapshot)
+				}
+				assertions.Error(err)
+			},
+		)
+	}
+}
+
+func TestProviderEncryption(t *testing.T) {
+	ctx := context.TODO()
+	persistenceID := uuid.New().String()
+	otherPersistenceID := uuid.New().String()
+
+	// get instance of assert
+	assertions := assert.New(t)
+	memoryDialect := NewInMemoryDialect()
+	keyProvider := NewInMemoryKeyProvider()
+
+	// keep track of the events that could not be decrypted
+	var shredded []int
+	provider := NewSQLProvider(
+		ctx, actor.NewActorSystem(), memoryDialect,
+		WithEncryption(keyProvider),
+		WithCompression(GzipCodec, 0),
+		WithErrorHandler(func(err *PersistenceError) Directive {
+			if errors.Is(err, ErrKeyNotFound) {
+				shredded = append(shredded, err.SequenceNumber)
+			}
+			return ResumeDirective
+		}),
+	)
+	state := provider.GetState()
+
+	// the keys are rotated halfway
+	state.PersistEvent(persistenceID, 1, &pb.AccountDebited{AccountNumber: persistenceID, Balance: 1})
+	_, _, err := keyProvider.Rotate(ctx, persistenceID)
+	assertions.NoError(err)
+	state.PersistEvent(persistenceID, 2, &pb.AccountDebited{AccountNumber: persistenceID, Balance: 2})
+	state.PersistSnapshot(persistenceID, 2, &pb.Account{AccountNumber: persistenceID, ActualBalance: 2})
+	state.PersistEvent(otherPersistenceID, 1, &pb.AccountDebited{AccountNumber: otherPersistenceID, Balance: 1})
+
+	journals := memoryDialect.Journals(persistenceID)
+	if assertions.Len(journals, 2) {
+		assertions.NotEmpty(journals[0].KeyID)
+		assertions.NotEmpty(journals[1].KeyID)
+		assertions.NotEqual(journals[0].KeyID, journals[1].KeyID)
+		assertions.Equal(GzipCodec, journals[0].Codec)
+		assertions.NotContains(string(journals[0].Payload), persistenceID)
+	}
+
+	// the old keys still decrypt
+	snapshot, _, ok := state.GetSnapshot(persistenceID)
+	assertions.True(ok)
+	assertions.True(proto.Equal(&pb.Account{AccountNumber: persistenceID, ActualBalance: 2}, snapshot.(proto.Message)))
+
+	replayed := make([]float32, 0)
+	state.GetEvents(persistenceID, 1, 0, func(e interface{}) {
+		replayed = append(replayed, e.(*pb.AccountDebited).GetBalance())
+	})
+	assertions.Equal([]float32{1, 2}, replayed)
+
+	// once shredded, the payloads of the persistence ID can no longer be read
+	assertions.NoError(provider.CryptoShred(ctx, persistenceID))
+	_, _, ok = state.GetSnapshot(persistenceID)
+	assertions.False(ok)
+
+	replayed = replayed[:0]
+	state.GetEvents(persistenceID, 1, 0, func(e interface{}) {
+		replayed = append(replayed, e.(*pb.AccountDebited).GetBalance())
+	})
+	assertions.Empty(replayed)
+	assertions.Equal([]int{2, 1, 2}, shredded)
+
+	// the other persistence IDs are not affected
+	state.GetEvents(otherPersistenceID, 1, 0, func(e interface{}) {
+		replayed = append(replayed, e.(*pb.AccountDebited).GetBalance())
+	})
+	assertions.Equal([]float32{1}, replayed)
+
+	// shredding requires encryption to be enabled
+	provider = NewSQLProvider(ctx, actor.NewActorSystem(), memoryDialect)
+	assertions.Error(provider.CryptoShred(ctx, persistenceID))
+}
+
+func TestProviderEncryptionTenants(t *testing.T) {
+	ctx := context.TODO()
+	acme := WithTenant(ctx, "acme")
+	globex := WithTenant(ctx, "globex")
+
+	// get instance of assert
+	assertions := assert.New(t)
+	memoryDialect := NewInMemoryDialect()
+	keyProvider := NewInMemoryKeyProvider()
+	actorSystem := actor.NewActorSystem()
+
+	// the same persistence ID is written to both tenants
+	states := map[string]persistence.ProviderState{}
+	for _, tenant := range []context.Context{acme, globex} {
+		state := NewSQLProvider(
+			tenant, actorSystem, memoryDialect, WithEncryption(keyProvider),
+			WithErrorHandler(func(err *PersistenceError) Directive { return ResumeDirective }),
+		).GetState()
+		state.PersistEvent("account", 1, &pb.AccountDebited{Balance: 1})
+		states[tenantOf(tenant)] = state
+	}
+
+	// every tenant gets its own keys
+	acmeJournals, err := memoryDialect.GetJournals(acme, "account", 1, 1)
+	assertions.NoError(err)
+	globexJournals, err := memoryDialect.GetJournals(globex, "account", 1, 1)
+	assertions.NoError(err)
+	if !assertions.Len(acmeJournals, 1) || !assertions.Len(globexJournals, 1) {
+		return
+	}
+	assertions.NotEqual(acmeJournals[0].KeyID, globexJournals[0].KeyID)
+
+	// the payload of a tenant cannot be moved to another tenant
+	moved := *acmeJournals[0]
+	moved.TenantID = "globex"
+	_, err = decryptJournal(globex, keyProvider, &moved)
+	assertions.Error(err)
+
+	// shredding a tenant leaves the same persistence ID of the other tenants readable
+	resolved := NewSQLProvider(
+		ctx, actorSystem, memoryDialect, WithEncryption(keyProvider), WithTenantResolver(func(string) string {
+			return "acme"
+		}),
+	)
+	assertions.NoError(resolved.CryptoShred(ctx, "account"))
+
+	replayed := make(map[string][]float32)
+	for tenant, state := range states {
+		state.GetEvents("account", 1, 0, func(e interface{}) {
+			replayed[tenant] = append(replayed[tenant], e.(*pb.AccountDebited).GetBalance())
+		})
+	}
+	assertions.Equal(map[string][]float32{"globex": {1}}, replayed)
+}
@@ -0,0 +1,52 @@
+package persistencesql
+
+import (
+	"google.golang.org/protobuf/proto"
+)
+
+// EventDecoder turns a journal row into the event delivered to the persistent actor during recovery
+type EventDecoder = func(journal *Journal) (proto.Message, error)
+
+// EventEnvelope wraps a replayed event with its journal metadata.
+// It is delivered to the persistent actor during recovery when the provider is set WithEventEnvelope
+type EventEnvelope struct {
+	// the decoded event
+	Event proto.Message
+	// Persistent ID that journals the event.
+	PersistenceID string
+	// The event sequence number
+	SequenceNumber int
+	// The time the event was stored
+	Timestamp int64
+	// Unique identifier of the writing persistent actor.
+	WriterID string
+	// the unique id of the journal row
+	Ordering int64
+	// The tenant the event belongs to. Empty for the default tenant
+	TenantID string
+}
+
+// serializerDecoder creates the default EventDecoder. It decodes every event using the serializer it has been
+// encoded with
+func serializerDecoder(serializers serializers) EventDecoder {
+	return func(journal *Journal) (proto.Message, error) {
+		serializer, err := serializers.of(journal.Serializer)
+		if err != nil {
+			return nil, err
+		}
+		return journal.message(serializer)
+	}
+}
+
+// newEventEnvelope creates an instance of EventEnvelope
+func newEventEnvelope(journal *Journal, event proto.Message) *EventEnvelope {
+	return &EventEnvelope{
+		Event:          event,
+		PersistenceID:  journal.PersistenceID,
+		SequenceNumber: journal.SequenceNumber,
+		Timestamp:      journal.Timestamp,
+		WriterID:       journal.WriterID,
+		Ordering:       journal.Ordering,
+		TenantID:       journal.TenantID,
+	}
+}
@@ -0,0 +1,134 @@
+package persistencesql
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+var (
+	// ErrConcurrentModification is returned when a journal entry already exists for a given persistenceID and
+	// sequence number. It usually means that the persistent actor is running somewhere else as well
+	ErrConcurrentModification = errors.New("concurrent modification")
+	// ErrStaleWriter is returned when a journal entry is written by a writer that no longer owns the persistenceID
+	ErrStaleWriter = errors.New("stale writer")
+	// ErrOutdatedSchema is returned when connecting to a database whose schema misses some migrations while the
+	// schema is only to be verified
+	ErrOutdatedSchema = errors.New("outdated schema")
+	// ErrTransactionsUnsupported is returned when a dialect without database transaction, e.g. the InMemoryDialect,
+	// is asked to run a function in a transaction. Exactly-once projections cannot be run against such a dialect
+	ErrTransactionsUnsupported = errors.New("transactions unsupported")
+)
+
+// Operation names a persistence operation carried out by the SQLProviderState
+type Operation string
+
+const (
+	// GetSnapshotOperation is the operation fetching the latest snapshot of a persistence ID
+	GetSnapshotOperation Operation = "GetSnapshot"
+	// PersistSnapshotOperation is the operation saving a snapshot
+	PersistSnapshotOperation Operation = "PersistSnapshot"
+	// DeleteSnapshotsOperation is the operation removing snapshots
+	DeleteSnapshotsOperation Operation = "DeleteSnapshots"
+	// GetEventsOperation is the operation replaying events from the journal
+	GetEventsOperation Operation = "GetEvents"
+	// PersistEventOperation is the operation appending an event to the journal
+	PersistEventOperation Operation = "PersistEvent"
+	// DeleteEventsOperation is the operation removing events from the journal
+	DeleteEventsOperation Operation = "DeleteEvents"
+	// PruneSnapshotsOperation is the operation enforcing the snapshot retention policy
+	PruneSnapshotsOperation Operation = "PruneSnapshots"
+	// ClaimWriterOperation is the operation claiming the ownership of a persistence ID
+	ClaimWriterOperation Operation = "ClaimWriter"
+)
+
+// PersistenceError is the error handed over to the ErrorHandler when a persistence operation fails
+type PersistenceError struct {
+	// the operation that failed
+	Operation Operation
+	// the persistence ID the operation was carried out for
+	PersistenceID string
+	// the sequence number involved in the operation
+	SequenceNumber int
+	// the number of times the operation has been attempted, starting at 1
+	Attempt int
+	// the underlying error
+	Cause error
+}
+
+// Error implements the error interface
+func (e *PersistenceError) Error() string {
+	return fmt.Sprintf(
+		"%s failed for persistenceID: %s at sequenceNumber: %d (attempt %d): %v",
+		e.Operation, e.PersistenceID, e.SequenceNumber, e.Attempt, e.Cause,
+	)
+}
+
+// Unwrap returns the underlying error
+func (e *PersistenceError) Unwrap() error {
+	return e.Cause
+}
+
+// concurrentModificationError wraps ErrConcurrentModification with the conflicting key
+func concurrentModificationError(persistenceID string, sequenceNumber int, cause error) error {
+	return fmt.Errorf(
+		"%w: persistenceID: %s sequenceNumber: %d: %v", ErrConcurrentModification, persistenceID, sequenceNumber, cause,
+	)
+}
+
+// concurrentBatchModificationError wraps ErrConcurrentModification for a batch in which some entry has already
+// been written, when the datastore does not tell which one
+func concurrentBatchModificationError(size int, cause error) error {
+	return fmt.Errorf("%w: one of the %d journal entries of the batch: %v", ErrConcurrentModification, size, cause)
+}
+
+// duplicateKeyError is returned when a row already exists for a given persistenceID and sequence number
+func duplicateKeyError(persistenceID string, sequenceNumber int) error {
+	return fmt.Errorf(
+		"duplicate key (persistence_id, sequence_number) = (%s, %d)", persistenceID, sequenceNumber,
+	)
+}
+
+// staleWriterError wraps ErrStaleWriter with the rejected writer
+func staleWriterError(persistenceID string, writerID string) error {
+	return fmt.Errorf("%w: persistenceID: %s writerID: %s", ErrStaleWriter, persistenceID, writerID)
+}
+
+// Directive tells the SQLProviderState how to proceed after a failure
+type Directive int
+
+const (
+	// EscalateDirective panics with the PersistenceError so that the owning actor supervisor handles the failure
+	EscalateDirective Directive = iota
+	// RetryDirective runs the failed operation again
+	RetryDirective
+	// ResumeDirective gives up on the failed operation and carries on
+	ResumeDirective
+)
+
+// ErrorHandler decides how to proceed when a persistence operation fails
+type ErrorHandler = func(err *PersistenceError) Directive
+
+// EscalateOnError is the default ErrorHandler. It escalates every failure to the owning actor
+func EscalateOnError(*PersistenceError) Directive {
+	return EscalateDirective
+}
+
+// LogAndContinue logs every failure and carries on
+func LogAndContinue(err *PersistenceError) Directive {
+	log.Printf("error: %v", err)
+	return ResumeDirective
+}
+
+// RetryOnError retries a failed operation up to maxAttempts times, waiting for the given backoff between
+// attempts. Once the attempts are exhausted the decision is handed over to the fallback handler.
+func RetryOnError(maxAttempts int, backoff time.Duration, fallback ErrorHandler) ErrorHandler {
+	return func(err *PersistenceError) Directive {
+		if err.Attempt < maxAttempts {
+			time.Sleep(backoff)
+			return RetryDirective
+		}
+		return fallback(err)
+	}
+}
@@ -0,0 +1,105 @@
+package persistencesql
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorHandler(t *testing.T) {
+	cause := errors.New("connection reset")
+	testCases := map[string]struct {
+		handler        ErrorHandler
+		expectedResult bool
+		expectedCalls  int
+		expectPanic    bool
+	}{
+		// asserting that the default handler escalates the failure
+		"escalate": {
+			handler:       EscalateOnError,
+			expectedCalls: 1,
+			expectPanic:   true,
+		},
+		// asserting that the operation is abandoned when resuming
+		"log and continue": {
+			handler:        LogAndContinue,
+			expectedResult: false,
+			expectedCalls:  1,
+		},
+		// asserting that the operation is retried until it succeeds
+		"retry": {
+			handler:        RetryOnError(5, 0, EscalateOnError),
+			expectedResult: true,
+			expectedCalls:  3,
+		},
+		// asserting that the fallback handler is used once the attempts are exhausted
+		"retry exhausted": {
+			handler:        RetryOnError(2, 0, LogAndContinue),
+			expectedResult: false,
+			expectedCalls:  2,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(
+			name, func(t *testing.T) {
+				// get instance of assert
+				assertions := assert.New(t)
+
+				state := &SQLProviderState{
+					SQLProvider: &SQLProvider{errorHandler: testCase.handler},
+				}
+
+				// the operation fails twice before succeeding
+				calls := 0
+				operation := func() error {
+					calls++
+					if calls < 3 {
+						return cause
+					}
+					return nil
+				}
+
+				if testCase.expectPanic {
+					assertions.PanicsWithError(
+						(&PersistenceError{
+							Operation:      PersistEventOperation,
+							PersistenceID:  "some-persistence-id",
+							SequenceNumber: 1,
+							Attempt:        1,
+							Cause:          cause,
+						}).Error(), func() {
+							state.handle(PersistEventOperation, "some-persistence-id", 1, operation)
+						},
+					)
+				} else {
+					result := state.handle(PersistEventOperation, "some-persistence-id", 1, operation)
+					assertions.Equal(testCase.expectedResult, result)
+				}
+
+				assertions.Equal(testCase.expectedCalls, calls)
+			},
+		)
+	}
+}
+
+func TestPersistenceError(t *testing.T) {
+	// get instance of assert
+	assertions := assert.New(t)
+
+	cause := errors.New("connection reset")
+	err := &PersistenceError{
+		Operation:      GetEventsOperation,
+		PersistenceID:  "some-persistence-id",
+		SequenceNumber: 10,
+		Attempt:        2,
+		Cause:          cause,
+	}
+
+	assertions.True(errors.Is(err, cause))
+	assertions.Equal(
+		"GetEvents failed for persistenceID: some-persistence-id at sequenceNumber: 10 (attempt 2): connection reset",
+		err.Error(),
+	)
+}
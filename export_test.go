@@ -0,0 +1,17 @@
+package persistencesql
+
+// PostgresTestConfig returns the database config of the postgres test container
+func PostgresTestConfig() *DBConfig {
+	return NewDBConfig(
+		"test", "test", database, "public", "localhost", postgresContainerPort,
+		WithConnectionMaxLife(maxConnectionLifetime),
+	)
+}
+
+// MySQLTestConfig returns the database config of the mysql test container
+func MySQLTestConfig() *DBConfig {
+	return NewDBConfig(
+		"test", "test", database, "public", "localhost", mysqlContainerPort,
+		WithConnectionMaxLife(maxConnectionLifetime),
+	)
+}
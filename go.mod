@@ -8,9 +8,10 @@ require (
 	github.com/go-sql-driver/mysql v1.6.0
 	github.com/golang/protobuf v1.5.2
 	github.com/google/uuid v1.3.0
-	github.com/hashicorp/go-multierror v1.1.1
-	github.com/ory/dockertest/v3 v3.8.1
+	github.com/klauspost/compress v1.13.6
 	github.com/lib/pq v1.10.4
+	github.com/mattn/go-sqlite3 v1.14.10
+	github.com/ory/dockertest/v3 v3.8.1
 	github.com/stretchr/testify v1.7.0
 	google.golang.org/protobuf v1.26.0
 )
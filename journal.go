@@ -1,12 +1,9 @@
 package persistencesql
 
 import (
-	"log"
 	"time"
 
 	"google.golang.org/protobuf/proto"
-	"google.golang.org/protobuf/reflect/protoreflect"
-	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
 // Journal defines the journal row
@@ -27,14 +24,54 @@ type Journal struct {
 	WriterID string
 	// Flag to indicate the event has been deleted when logical deletion is set.
 	Deleted bool
+	// The codec the payload has been compressed with. Empty when the payload is not compressed
+	Codec Codec
+	// The ID of the key the payload has been encrypted with. Empty when the payload is not encrypted
+	KeyID string
+	// The tenant the event belongs to. Empty for the default tenant
+	TenantID string
+	// The ID of the serializer the event has been encoded with. Empty for the rows written before the serializer was
+	// recorded, which use the protobuf binary wire format
+	Serializer string
+	// The tags of the event. They are written along with the journal row but not read back
+	Tags []string
+	// States whether the event is published through the outbox. It is written along with the journal row but not
+	// read back
+	Outbox bool
 }
 
-// NewJournal creates a new instance of Snapshot
-func NewJournal(persistenceID string, message proto.Message, sequenceNumber int, writerID string) *Journal {
-	manifest := proto.MessageName(message)
-	bytes, err := proto.Marshal(message)
+// JournalMetadata describes a journal row without its payload
+type JournalMetadata struct {
+	// the unique id of the journal row
+	Ordering int64
+	// Persistent ID that journals a persistent message.
+	PersistenceID string
+	// This persistent message's sequence number
+	SequenceNumber int
+	// The `timestamp` is the time the event was stored, in seconds since midnight, January 1, 1970 UTC.
+	Timestamp int64
+}
+
+// JournalOrdering locates a journal row in the ordering, which is shared by the tenants
+type JournalOrdering struct {
+	// the unique id of the journal row
+	Ordering int64
+	// The `timestamp` is the time the event was stored, in seconds since midnight, January 1, 1970 UTC.
+	Timestamp int64
+}
+
+// NewJournal creates a new instance of Journal. The event is encoded using the protobuf binary wire format
+func NewJournal(persistenceID string, message proto.Message, sequenceNumber int, writerID string) (*Journal, error) {
+	return newJournal(defaultSerializer, persistenceID, message, sequenceNumber, writerID)
+}
+
+// newJournal creates a new instance of Journal encoding the event with the given serializer
+func newJournal(
+	serializer Serializer, persistenceID string, message proto.Message, sequenceNumber int, writerID string,
+) (*Journal, error) {
+	bytes, manifest, err := serializer.Serialize(message)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	return &Journal{
@@ -42,21 +79,13 @@ func NewJournal(persistenceID string, message proto.Message, sequenceNumber int,
 		SequenceNumber: sequenceNumber,
 		Timestamp:      time.Now().UTC().Unix(),
 		Payload:        bytes,
-		EventManifest:  Manifest(manifest),
+		EventManifest:  manifest,
 		WriterID:       writerID,
-	}
+		Serializer:     serializer.ID(),
+	}, nil
 }
 
-func (journal *Journal) message() proto.Message {
-	mt, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(journal.EventManifest))
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	pm := mt.New().Interface()
-	err = proto.Unmarshal(journal.Payload, pm)
-	if err != nil {
-		log.Fatal(err)
-	}
-	return pm
+// message decodes the event using the given serializer
+func (journal *Journal) message(serializer Serializer) (proto.Message, error) {
+	return serializer.Deserialize(journal.Payload, journal.EventManifest)
 }
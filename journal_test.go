@@ -18,8 +18,26 @@ func TestJournal(t *testing.T) {
 		Balance:       2000,
 	}
 
-	journal := NewJournal("some-persistence-id", event, 1, "some-writer-id")
+	journal, err := NewJournal("some-persistence-id", event, 1, "some-writer-id")
+	assertions.NoError(err)
 
 	assertions.Equal(journal.EventManifest, Manifest(proto.MessageName(event)))
-	assertions.True(proto.Equal(journal.message(), event))
+	message, err := journal.message(defaultSerializer)
+	assertions.NoError(err)
+	assertions.True(proto.Equal(message, event))
+}
+
+func TestJournalUnknownManifest(t *testing.T) {
+	// get instance of assert
+	assertions := assert.New(t)
+
+	journal := &Journal{
+		PersistenceID:  "some-persistence-id",
+		SequenceNumber: 1,
+		EventManifest:  "persistence.Unknown",
+	}
+
+	message, err := journal.message(defaultSerializer)
+	assertions.Error(err)
+	assertions.Nil(message)
 }
@@ -0,0 +1,793 @@
+package persistencesql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// InMemoryDialect is a SQLDialect keeping the journal and the snapshots in memory.
+// It behaves like the SQL dialects and is meant to be used in unit tests.
+type InMemoryDialect struct {
+	mu        sync.RWMutex
+	journals  map[tenantKey][]*Journal  // persistenceID -> journals ordered by sequence number
+	snapshots map[tenantKey][]*Snapshot // persistenceID -> snapshots ordered by sequence number
+	writers   map[tenantKey]string      // persistenceID -> writerID owning the persistenceID
+	offsets   map[tenantKey]int64       // projection name -> offset
+	outbox    []*OutboxEntry            // outbox entries ordered by ID
+	ordering  int64
+	// notifies the live queries of new journal rows
+	notifications *broadcaster
+}
+
+// tenantKey keys the rows of a persistenceID or the offset of a projection within a tenant
+type tenantKey struct {
+	tenantID string
+	name     string
+}
+
+// enforces that InMemoryDialect implements the SQLDialect and Notifier interfaces
+var (
+	_ SQLDialect = (*InMemoryDialect)(nil)
+	_ Notifier   = (*InMemoryDialect)(nil)
+)
+
+// NewInMemoryDialect creates a new instance of InMemoryDialect
+func NewInMemoryDialect() *InMemoryDialect {
+	return &InMemoryDialect{
+		journals:  make(map[tenantKey][]*Journal),
+		snapshots: make(map[tenantKey][]*Snapshot),
+		writers:   make(map[tenantKey]string),
+		offsets:   make(map[tenantKey]int64),
+
+		notifications: newBroadcaster(true),
+	}
+}
+
+// CreateSchemasIfNotExist does nothing since there is no schema to create
+func (d *InMemoryDialect) CreateSchemasIfNotExist(context.Context) error {
+	return nil
+}
+
+// Connect does nothing since there is no database to connect to
+func (d *InMemoryDialect) Connect(context.Context) error {
+	return nil
+}
+
+// Close does nothing since there is no database connection to close
+func (d *InMemoryDialect) Close() error {
+	return nil
+}
+
+// PersistJournal persists a journal entry into the datastore.
+// It returns ErrConcurrentModification when the sequence number has already been written for the persistenceID
+// and ErrStaleWriter when another writer has claimed the persistenceID
+func (d *InMemoryDialect) PersistJournal(ctx context.Context, journal *Journal) error {
+	return d.PersistJournals(ctx, []*Journal{journal})
+}
+
+// PersistJournals persists several journal entries into the datastore. Either all the entries are written or
+// none of them.
+// It returns ErrConcurrentModification when any sequence number has already been written for its persistenceID
+// and ErrStaleWriter when another writer has claimed any of the persistenceIDs
+func (d *InMemoryDialect) PersistJournals(ctx context.Context, journals []*Journal) error {
+	if err := d.persistJournals(tenantOf(ctx), journals); err != nil {
+		return err
+	}
+
+	d.notifications.broadcast()
+	return nil
+}
+
+// persistJournals persists several journal entries of a tenant into the datastore
+func (d *InMemoryDialect) persistJournals(tenant string, journals []*Journal) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// let us validate the whole batch before writing anything
+	type key struct {
+		persistenceID  string
+		sequenceNumber int
+	}
+	batch := make(map[key]bool, len(journals))
+	for _, journal := range journals {
+		persistenceID := tenantKey{tenant, journal.PersistenceID}
+		if owner, ok := d.writers[persistenceID]; ok && owner != journal.WriterID {
+			return staleWriterError(journal.PersistenceID, journal.WriterID)
+		}
+
+		k := key{journal.PersistenceID, journal.SequenceNumber}
+		if _, found := d.findJournal(persistenceID, journal.SequenceNumber); found || batch[k] {
+			return concurrentModificationError(
+				journal.PersistenceID, journal.SequenceNumber,
+				duplicateKeyError(journal.PersistenceID, journal.SequenceNumber),
+			)
+		}
+		batch[k] = true
+	}
+
+	for _, journal := range journals {
+		persistenceID := tenantKey{tenant, journal.PersistenceID}
+		index, _ := d.findJournal(persistenceID, journal.SequenceNumber)
+
+		d.ordering++
+		row := *journal
+		row.Ordering = d.ordering
+		row.Deleted = false
+		row.TenantID = tenant
+		row.Tags = append([]string(nil), journal.Tags...)
+
+		rows := append(d.journals[persistenceID], nil)
+		copy(rows[index+1:], rows[index:])
+		rows[index] = &row
+		d.journals[persistenceID] = rows
+
+		if journal.Outbox {
+			published := row
+			d.outbox = append(d.outbox, &OutboxEntry{
+				ID:      int64(len(d.outbox) + 1),
+				Journal: &published,
+				Status:  OutboxPending,
+			})
+		}
+	}
+	return nil
+}
+
+// PersistSnapshot persists a snapshot entry into the snapshot data store
+func (d *InMemoryDialect) PersistSnapshot(ctx context.Context, snapshot *Snapshot) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.persistSnapshot(tenantOf(ctx), snapshot)
+}
+
+// PersistSnapshotAndTruncate persists a snapshot entry and deletes the journal entries before the snapshot
+// sequence number as well as the older snapshots. The journal entries are either soft deleted or hard-deleted.
+// Either everything is done or nothing is.
+func (d *InMemoryDialect) PersistSnapshotAndTruncate(ctx context.Context, snapshot *Snapshot, logical bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tenant := tenantOf(ctx)
+	if err := d.persistSnapshot(tenant, snapshot); err != nil {
+		return err
+	}
+
+	persistenceID := tenantKey{tenant, snapshot.PersistenceID}
+	// the recovery replays the events from the sequence number of the snapshot onwards
+	d.deleteJournals(persistenceID, snapshot.SequenceNumber-1, logical)
+	d.deleteSnapshots(persistenceID, snapshot.SequenceNumber-1)
+	return nil
+}
+
+// persistSnapshot inserts a snapshot entry of a tenant. It must be called with the lock held
+func (d *InMemoryDialect) persistSnapshot(tenant string, snapshot *Snapshot) error {
+	persistenceID := tenantKey{tenant, snapshot.PersistenceID}
+	snapshots := d.snapshots[persistenceID]
+	index := sort.Search(len(snapshots), func(i int) bool {
+		return snapshots[i].SequenceNumber >= snapshot.SequenceNumber
+	})
+	if index < len(snapshots) && snapshots[index].SequenceNumber == snapshot.SequenceNumber {
+		return duplicateKeyError(snapshot.PersistenceID, snapshot.SequenceNumber)
+	}
+
+	row := *snapshot
+	row.TenantID = tenant
+	snapshots = append(snapshots, nil)
+	copy(snapshots[index+1:], snapshots[index:])
+	snapshots[index] = &row
+	d.snapshots[persistenceID] = snapshots
+	return nil
+}
+
+// GetLatestSnapshot fetch the latest snapshot for a given persistenceID.
+// It returns a nil snapshot when the persistenceID has no snapshot
+func (d *InMemoryDialect) GetLatestSnapshot(ctx context.Context, persistenceID string) (*Snapshot, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	snapshots := d.snapshots[tenantKey{tenantOf(ctx), persistenceID}]
+	if len(snapshots) == 0 {
+		return nil, nil
+	}
+
+	latest := *snapshots[len(snapshots)-1]
+	return &latest, nil
+}
+
+// GetJournals fetch some events from the journal store
+func (d *InMemoryDialect) GetJournals(
+	ctx context.Context, persistenceID string, fromSequenceNumber int, toSequenceNumber int,
+) ([]*Journal, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.readJournals(tenantKey{tenantOf(ctx), persistenceID}, fromSequenceNumber, toSequenceNumber, -1), nil
+}
+
+// StreamJournals reads some events from the journal store page by page and hands them over to fn in
+// sequence number order. The streaming stops at the first error returned by fn or when the context is done.
+func (d *InMemoryDialect) StreamJournals(
+	ctx context.Context, persistenceID string, fromSequenceNumber int, toSequenceNumber int, pageSize int,
+	fn func(journal *Journal) error,
+) error {
+	if pageSize <= 0 {
+		return errors.New("invalid page size")
+	}
+
+	for fromSequenceNumber <= toSequenceNumber {
+		// let us make sure the caller is still interested before fetching the next page
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		d.mu.RLock()
+		page := d.readJournals(tenantKey{tenantOf(ctx), persistenceID}, fromSequenceNumber, toSequenceNumber, pageSize)
+		d.mu.RUnlock()
+
+		for _, journal := range page {
+			if err := fn(journal); err != nil {
+				return err
+			}
+		}
+
+		// a partial page means we have reached the end of the range
+		if len(page) < pageSize || page[len(page)-1].SequenceNumber >= toSequenceNumber {
+			return nil
+		}
+		fromSequenceNumber = page[len(page)-1].SequenceNumber + 1
+	}
+
+	return nil
+}
+
+// GetJournalsByOrdering fetches up to limit journal rows of the tenant across all the persistenceIDs which ordering
+// is greater than fromOrdering and lower than or equal to toOrdering, in ordering order. The logically deleted rows
+// are returned as well
+func (d *InMemoryDialect) GetJournalsByOrdering(
+	ctx context.Context, fromOrdering int64, toOrdering int64, limit int,
+) ([]*Journal, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	tenant := tenantOf(ctx)
+	journals := make([]*Journal, 0)
+	for persistenceID, rows := range d.journals {
+		if persistenceID.tenantID != tenant {
+			continue
+		}
+
+		for _, journal := range rows {
+			if journal.Ordering > fromOrdering && journal.Ordering <= toOrdering {
+				row := *journal
+				journals = append(journals, &row)
+			}
+		}
+	}
+
+	sort.Slice(journals, func(i, j int) bool {
+		return journals[i].Ordering < journals[j].Ordering
+	})
+	if limit >= 0 && len(journals) > limit {
+		journals = journals[:limit]
+	}
+	return journals, nil
+}
+
+// GetJournalsByTag fetches up to limit journal rows tagged with the given tag which ordering is greater than
+// fromOrdering and less than or equal to toOrdering, in ordering order
+func (d *InMemoryDialect) GetJournalsByTag(
+	ctx context.Context, tag string, fromOrdering int64, toOrdering int64, limit int,
+) ([]*Journal, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	tenant := tenantOf(ctx)
+	journals := make([]*Journal, 0)
+	for persistenceID, rows := range d.journals {
+		if persistenceID.tenantID != tenant {
+			continue
+		}
+
+		for _, journal := range rows {
+			if journal.Ordering > fromOrdering && journal.Ordering <= toOrdering && !journal.Deleted &&
+				hasTag(journal, tag) {
+				row := *journal
+				journals = append(journals, &row)
+			}
+		}
+	}
+
+	sort.Slice(journals, func(i, j int) bool {
+		return journals[i].Ordering < journals[j].Ordering
+	})
+	if limit >= 0 && len(journals) > limit {
+		journals = journals[:limit]
+	}
+	return journals, nil
+}
+
+// GetJournalOrderings fetches the orderings and timestamps of up to limit journal rows of every tenant which ordering
+// is greater than fromOrdering, in ordering order
+func (d *InMemoryDialect) GetJournalOrderings(
+	_ context.Context, fromOrdering int64, limit int,
+) ([]*JournalOrdering, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	orderings := make([]*JournalOrdering, 0)
+	for _, rows := range d.journals {
+		for _, journal := range rows {
+			if journal.Ordering > fromOrdering {
+				orderings = append(orderings, &JournalOrdering{Ordering: journal.Ordering, Timestamp: journal.Timestamp})
+			}
+		}
+	}
+
+	sort.Slice(orderings, func(i, j int) bool {
+		return orderings[i].Ordering < orderings[j].Ordering
+	})
+	if limit >= 0 && len(orderings) > limit {
+		orderings = orderings[:limit]
+	}
+	return orderings, nil
+}
+
+// ListPersistenceIDs lists up to limit persistenceIDs that have journal rows, in ascending order. Only the
+// persistenceIDs starting with the given prefix and sorting after afterID are listed
+func (d *InMemoryDialect) ListPersistenceIDs(
+	ctx context.Context, prefix string, afterID string, limit int,
+) ([]string, error) {
+	persistenceIDs := make([]string, 0)
+	for _, persistenceID := range d.persistenceIDs(tenantOf(ctx)) {
+		if len(persistenceIDs) == limit {
+			break
+		}
+
+		if persistenceID > afterID && strings.HasPrefix(persistenceID, prefix) {
+			persistenceIDs = append(persistenceIDs, persistenceID)
+		}
+	}
+	return persistenceIDs, nil
+}
+
+// ListNewPersistenceIDs fetches the metadata of up to limit first journal rows of the persistenceIDs, i.e. the rows of
+// their lowest sequence number, which ordering is greater than fromOrdering, in ordering order. Only the
+// persistenceIDs starting with the given prefix are listed
+func (d *InMemoryDialect) ListNewPersistenceIDs(
+	ctx context.Context, prefix string, fromOrdering int64, limit int,
+) ([]*JournalMetadata, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	tenant := tenantOf(ctx)
+	metadata := make([]*JournalMetadata, 0)
+	for persistenceID, rows := range d.journals {
+		if persistenceID.tenantID != tenant || !strings.HasPrefix(persistenceID.name, prefix) {
+			continue
+		}
+
+		// the rows are sorted by sequence number
+		if len(rows) == 0 || rows[0].Ordering <= fromOrdering {
+			continue
+		}
+
+		metadata = append(metadata, &JournalMetadata{
+			Ordering:       rows[0].Ordering,
+			PersistenceID:  rows[0].PersistenceID,
+			SequenceNumber: rows[0].SequenceNumber,
+			Timestamp:      rows[0].Timestamp,
+		})
+	}
+
+	sort.Slice(metadata, func(i, j int) bool {
+		return metadata[i].Ordering < metadata[j].Ordering
+	})
+	if limit >= 0 && len(metadata) > limit {
+		metadata = metadata[:limit]
+	}
+	return metadata, nil
+}
+
+// DeleteSnapshots removes all the snapshots which sequence numbers are less than or equal to
+// the given sequence number
+func (d *InMemoryDialect) DeleteSnapshots(ctx context.Context, persistenceID string, toSequenceNumber int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.deleteSnapshots(tenantKey{tenantOf(ctx), persistenceID}, toSequenceNumber)
+	return nil
+}
+
+// ListSnapshots lists the snapshots of a given persistenceID ordered by sequence number
+func (d *InMemoryDialect) ListSnapshots(ctx context.Context, persistenceID string) ([]*SnapshotMetadata, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	rows := d.snapshots[tenantKey{tenantOf(ctx), persistenceID}]
+	snapshots := make([]*SnapshotMetadata, 0, len(rows))
+	for _, snapshot := range rows {
+		snapshots = append(snapshots, &SnapshotMetadata{
+			PersistenceID:  snapshot.PersistenceID,
+			SequenceNumber: snapshot.SequenceNumber,
+			Timestamp:      snapshot.Timestamp,
+		})
+	}
+	return snapshots, nil
+}
+
+// ListSnapshotPersistenceIDs lists the sorted persistenceIDs that have at least one snapshot
+func (d *InMemoryDialect) ListSnapshotPersistenceIDs(ctx context.Context) ([]string, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	tenant := tenantOf(ctx)
+	persistenceIDs := make([]string, 0, len(d.snapshots))
+	for persistenceID, snapshots := range d.snapshots {
+		if persistenceID.tenantID == tenant && len(snapshots) > 0 {
+			persistenceIDs = append(persistenceIDs, persistenceID.name)
+		}
+	}
+	sort.Strings(persistenceIDs)
+	return persistenceIDs, nil
+}
+
+// ListSnapshotTenants lists the sorted tenants that have at least one snapshot, the default tenant being the empty
+// one. It is not scoped to the tenant of its context
+func (d *InMemoryDialect) ListSnapshotTenants(context.Context) ([]string, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	unique := make(map[string]bool)
+	for persistenceID, snapshots := range d.snapshots {
+		if len(snapshots) > 0 {
+			unique[persistenceID.tenantID] = true
+		}
+	}
+
+	tenants := make([]string, 0, len(unique))
+	for tenant := range unique {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+	return tenants, nil
+}
+
+// PruneSnapshots removes the snapshots of a given persistenceID at the given sequence numbers.
+// It returns the number of snapshots removed
+func (d *InMemoryDialect) PruneSnapshots(
+	ctx context.Context, persistenceID string, sequenceNumbers []int,
+) (int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	prune := make(map[int]bool, len(sequenceNumbers))
+	for _, sequenceNumber := range sequenceNumbers {
+		prune[sequenceNumber] = true
+	}
+
+	key := tenantKey{tenantOf(ctx), persistenceID}
+	var pruned int64
+	snapshots := make([]*Snapshot, 0, len(d.snapshots[key]))
+	for _, snapshot := range d.snapshots[key] {
+		if prune[snapshot.SequenceNumber] {
+			pruned++
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	d.snapshots[key] = snapshots
+	return pruned, nil
+}
+
+// DeleteJournals removes some events from the journal. All events which sequence numbers are less than
+// the given sequence number will be either soft deleted or hard-deleted
+func (d *InMemoryDialect) DeleteJournals(
+	ctx context.Context, persistenceID string, toSequenceNumber int, logical bool,
+) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.deleteJournals(tenantKey{tenantOf(ctx), persistenceID}, toSequenceNumber, logical)
+	return nil
+}
+
+// ClaimWriter makes the given writer the owner of the persistenceID.
+// From then on, journal entries of the persistenceID written by any other writer are rejected
+func (d *InMemoryDialect) ClaimWriter(ctx context.Context, persistenceID string, writerID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.writers[tenantKey{tenantOf(ctx), persistenceID}] = writerID
+	return nil
+}
+
+// GetProjectionOffset fetches the offset a given projection has reached. It returns 0 when the projection has not
+// saved any offset yet
+func (d *InMemoryDialect) GetProjectionOffset(ctx context.Context, projectionName string) (int64, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.offsets[tenantKey{tenantOf(ctx), projectionName}], nil
+}
+
+// SaveProjectionOffset saves the offset a given projection has reached.
+// There is no database transaction to run fn in, hence ErrTransactionsUnsupported is returned when fn is set
+func (d *InMemoryDialect) SaveProjectionOffset(
+	ctx context.Context, projectionName string, offset int64, fn func(tx *sql.Tx) error,
+) error {
+	if fn != nil {
+		return ErrTransactionsUnsupported
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.offsets[tenantKey{tenantOf(ctx), projectionName}] = offset
+	return nil
+}
+
+// ClaimOutboxEntries claims up to limit pending outbox entries due at the given time, in Unix milliseconds, by
+// leasing them until leaseUntil. The entries are returned as they were before being leased
+func (d *InMemoryDialect) ClaimOutboxEntries(
+	ctx context.Context, now int64, leaseUntil int64, limit int,
+) ([]*OutboxEntry, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tenant := tenantOf(ctx)
+	entries := make([]*OutboxEntry, 0)
+	for _, entry := range d.outbox {
+		if len(entries) == limit {
+			break
+		}
+
+		if entry.Journal.TenantID == tenant && entry.Status == OutboxPending && entry.NextAttemptAt <= now {
+			claimed := *entry
+			entries = append(entries, &claimed)
+			entry.NextAttemptAt = leaseUntil
+		}
+	}
+	return entries, nil
+}
+
+// UpdateOutboxEntries saves the delivery states of the given outbox entries
+func (d *InMemoryDialect) UpdateOutboxEntries(ctx context.Context, entries []*OutboxEntry) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, entry := range entries {
+		updated := *entry
+		d.outbox[entry.ID-1] = &updated
+	}
+	return nil
+}
+
+// GetOutboxEntries fetches up to limit outbox entries in a given delivery state, oldest first
+func (d *InMemoryDialect) GetOutboxEntries(
+	ctx context.Context, status OutboxStatus, limit int,
+) ([]*OutboxEntry, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	tenant := tenantOf(ctx)
+	entries := make([]*OutboxEntry, 0)
+	for _, entry := range d.outbox {
+		if len(entries) == limit {
+			break
+		}
+
+		if entry.Journal.TenantID == tenant && entry.Status == status {
+			found := *entry
+			entries = append(entries, &found)
+		}
+	}
+	return entries, nil
+}
+
+// ListOutboxTenants lists the sorted tenants that have at least one outbox entry pending, the default tenant being
+// the empty one. It is not scoped to the tenant of its context
+func (d *InMemoryDialect) ListOutboxTenants(context.Context) ([]string, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	unique := make(map[string]bool)
+	for _, entry := range d.outbox {
+		if entry.Status == OutboxPending {
+			unique[entry.Journal.TenantID] = true
+		}
+	}
+
+	tenants := make([]string, 0, len(unique))
+	for tenant := range unique {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+	return tenants, nil
+}
+
+// Subscribe registers for the notifications of new journal rows
+func (d *InMemoryDialect) Subscribe() (<-chan struct{}, func()) {
+	return d.notifications.Subscribe()
+}
+
+// Listening tells whether the notifications of new journal rows are delivered, which they always are
+func (d *InMemoryDialect) Listening() bool {
+	return d.notifications.Listening()
+}
+
+// Journals returns a copy of all the journal rows of a given persistenceID, including the logically deleted ones.
+// The rows of every tenant are returned, ordered by tenant and then by sequence number
+func (d *InMemoryDialect) Journals(persistenceID string) []*Journal {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	journals := make([]*Journal, 0)
+	for _, tenant := range d.tenants() {
+		for _, journal := range d.journals[tenantKey{tenant, persistenceID}] {
+			row := *journal
+			journals = append(journals, &row)
+		}
+	}
+	return journals
+}
+
+// Snapshots returns a copy of all the snapshots of a given persistenceID. The snapshots of every tenant are returned,
+// ordered by tenant and then by sequence number
+func (d *InMemoryDialect) Snapshots(persistenceID string) []*Snapshot {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	snapshots := make([]*Snapshot, 0)
+	for _, tenant := range d.tenants() {
+		for _, snapshot := range d.snapshots[tenantKey{tenant, persistenceID}] {
+			row := *snapshot
+			snapshots = append(snapshots, &row)
+		}
+	}
+	return snapshots
+}
+
+// PersistenceIDs returns the sorted list of persistenceIDs that have journal rows, whatever their tenant
+func (d *InMemoryDialect) PersistenceIDs() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	unique := make(map[string]bool)
+	for persistenceID, journals := range d.journals {
+		if len(journals) > 0 {
+			unique[persistenceID.name] = true
+		}
+	}
+
+	persistenceIDs := make([]string, 0, len(unique))
+	for persistenceID := range unique {
+		persistenceIDs = append(persistenceIDs, persistenceID)
+	}
+	sort.Strings(persistenceIDs)
+	return persistenceIDs
+}
+
+// tenants returns the sorted list of tenants that have journal rows or snapshots. It must be called with the lock held
+func (d *InMemoryDialect) tenants() []string {
+	unique := make(map[string]bool)
+	for persistenceID := range d.journals {
+		unique[persistenceID.tenantID] = true
+	}
+	for persistenceID := range d.snapshots {
+		unique[persistenceID.tenantID] = true
+	}
+
+	tenants := make([]string, 0, len(unique))
+	for tenant := range unique {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+	return tenants
+}
+
+// Reset removes all the journal rows and snapshots
+func (d *InMemoryDialect) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.journals = make(map[tenantKey][]*Journal)
+	d.snapshots = make(map[tenantKey][]*Snapshot)
+	d.writers = make(map[tenantKey]string)
+	d.offsets = make(map[tenantKey]int64)
+	d.outbox = nil
+	d.ordering = 0
+}
+
+// persistenceIDs returns the sorted list of persistenceIDs of a tenant that have journal rows
+func (d *InMemoryDialect) persistenceIDs(tenant string) []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	persistenceIDs := make([]string, 0, len(d.journals))
+	for persistenceID, journals := range d.journals {
+		if persistenceID.tenantID == tenant && len(journals) > 0 {
+			persistenceIDs = append(persistenceIDs, persistenceID.name)
+		}
+	}
+	sort.Strings(persistenceIDs)
+	return persistenceIDs
+}
+
+// readJournals returns a copy of the non-deleted journal rows within a range of sequence numbers.
+// A negative limit means no limit. It must be called with the lock held
+func (d *InMemoryDialect) readJournals(
+	persistenceID tenantKey, fromSequenceNumber int, toSequenceNumber int, limit int,
+) []*Journal {
+	journals := d.journals[persistenceID]
+	index := sort.Search(len(journals), func(i int) bool {
+		return journals[i].SequenceNumber >= fromSequenceNumber
+	})
+
+	result := make([]*Journal, 0)
+	for _, journal := range journals[index:] {
+		if journal.SequenceNumber > toSequenceNumber || len(result) == limit {
+			break
+		}
+
+		if journal.Deleted {
+			continue
+		}
+
+		row := *journal
+		result = append(result, &row)
+	}
+	return result
+}
+
+// deleteSnapshots removes the snapshots up to a given sequence number. It must be called with the lock held
+func (d *InMemoryDialect) deleteSnapshots(persistenceID tenantKey, toSequenceNumber int) {
+	snapshots := d.snapshots[persistenceID]
+	index := sort.Search(len(snapshots), func(i int) bool {
+		return snapshots[i].SequenceNumber > toSequenceNumber
+	})
+	d.snapshots[persistenceID] = snapshots[index:]
+}
+
+// deleteJournals soft deletes or hard-deletes the journal rows up to a given sequence number.
+// It must be called with the lock held
+func (d *InMemoryDialect) deleteJournals(persistenceID tenantKey, toSequenceNumber int, logical bool) {
+	journals := d.journals[persistenceID]
+	index := sort.Search(len(journals), func(i int) bool {
+		return journals[i].SequenceNumber > toSequenceNumber
+	})
+
+	if !logical {
+		d.journals[persistenceID] = journals[index:]
+		return
+	}
+
+	for _, journal := range journals[:index] {
+		journal.Deleted = true
+	}
+}
+
+// hasTag tells whether a journal row has been tagged with the given tag
+func hasTag(journal *Journal, tag string) bool {
+	for _, t := range journal.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// findJournal returns the position of a given sequence number in the journal of a persistenceID and whether it
+// has been found. When not found the position is where the sequence number would be inserted.
+// It must be called with the lock held
+func (d *InMemoryDialect) findJournal(persistenceID tenantKey, sequenceNumber int) (int, bool) {
+	journals := d.journals[persistenceID]
+	index := sort.Search(len(journals), func(i int) bool {
+		return journals[i].SequenceNumber >= sequenceNumber
+	})
+	return index, index < len(journals) && journals[index].SequenceNumber == sequenceNumber
+}
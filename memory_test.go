@@ -0,0 +1,318 @@
+package persistencesql
+
+import (
+	"context"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AsynkronIT/protoactor-go/actor"
+	"github.com/AsynkronIT/protoactor-go/persistence"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	pb "github.com/tochemey/protoactor-persistence-sql/gen"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestInMemoryDialect(t *testing.T) {
+	ctx := context.TODO()
+	persistenceID := uuid.New().String()
+
+	// get instance of assert
+	assertions := assert.New(t)
+	memoryDialect := NewInMemoryDialect()
+	assertions.NoError(memoryDialect.Connect(ctx))
+	assertions.NoError(memoryDialect.CreateSchemasIfNotExist(ctx))
+
+	// a persistence ID without snapshot has no latest snapshot
+	latest, err := memoryDialect.GetLatestSnapshot(ctx, persistenceID)
+	assertions.NoError(err)
+	assertions.Nil(latest)
+
+	// insert some data into the snapshot store in an arbitrary order
+	for _, sequenceNumber := range []int{2, 3, 1} {
+		snapshot, err := NewSnapshot(persistenceID, &pb.Account{
+			AccountNumber: persistenceID,
+			ActualBalance: float32(sequenceNumber * 100),
+		}, sequenceNumber, "some-actor-pid")
+		assertions.NoError(err)
+		assertions.NoError(memoryDialect.PersistSnapshot(ctx, snapshot))
+	}
+
+	// the latest snapshot is the one with the highest sequence number
+	latest, err = memoryDialect.GetLatestSnapshot(ctx, persistenceID)
+	assertions.NoError(err)
+	assertions.Equal(3, latest.SequenceNumber)
+	message, err := latest.message(defaultSerializer)
+	assertions.NoError(err)
+	assertions.True(proto.Equal(&pb.Account{AccountNumber: persistenceID, ActualBalance: 300}, message))
+
+	// insert events into the journal store
+	for i := 0; i < 10; i++ {
+		journal, err := NewJournal(persistenceID, &pb.AccountDebited{
+			AccountNumber: persistenceID,
+			Balance:       float32(i * 100),
+		}, i+1, "some-actor-pid")
+		assertions.NoError(err)
+		assertions.NoError(memoryDialect.PersistJournal(ctx, journal))
+	}
+
+	// persisting an already existing sequence number fails
+	journal, err := NewJournal(persistenceID, &pb.AccountDebited{}, 1, "some-actor-pid")
+	assertions.NoError(err)
+	assertions.Error(memoryDialect.PersistJournal(ctx, journal))
+	assertions.Len(memoryDialect.Journals(persistenceID), 10)
+
+	journals, err := memoryDialect.GetJournals(ctx, persistenceID, 2, 6)
+	assertions.NoError(err)
+	assertions.Len(journals, 5)
+	for i, journal := range journals {
+		assertions.Equal(i+2, journal.SequenceNumber)
+		assertions.Equal(int64(i+2), journal.Ordering)
+	}
+
+	// an empty range returns no events
+	journals, err = memoryDialect.GetJournals(ctx, persistenceID, 6, 2)
+	assertions.NoError(err)
+	assertions.Empty(journals)
+
+	// let us stream the events page by page
+	sequenceNumbers := make([]int, 0)
+	err = memoryDialect.StreamJournals(ctx, persistenceID, 2, 6, 2, func(journal *Journal) error {
+		sequenceNumbers = append(sequenceNumbers, journal.SequenceNumber)
+		return nil
+	})
+	assertions.NoError(err)
+	assertions.Equal([]int{2, 3, 4, 5, 6}, sequenceNumbers)
+
+	// streaming stops when the context is canceled
+	cancelCtx, cancel := context.WithCancel(ctx)
+	err = memoryDialect.StreamJournals(cancelCtx, persistenceID, 1, math.MaxInt32, 2, func(journal *Journal) error {
+		cancel()
+		return nil
+	})
+	assertions.ErrorIs(err, context.Canceled)
+
+	// logically delete some events from the journal
+	assertions.NoError(memoryDialect.DeleteJournals(ctx, persistenceID, 2, true))
+	journals, err = memoryDialect.GetJournals(ctx, persistenceID, 1, math.MaxInt32)
+	assertions.NoError(err)
+	assertions.Len(journals, 8)
+	assertions.Len(memoryDialect.Journals(persistenceID), 10)
+	assertions.True(memoryDialect.Journals(persistenceID)[0].Deleted)
+
+	// physically delete some events from the journal
+	assertions.NoError(memoryDialect.DeleteJournals(ctx, persistenceID, 4, false))
+	journals, err = memoryDialect.GetJournals(ctx, persistenceID, 1, math.MaxInt32)
+	assertions.NoError(err)
+	assertions.Len(journals, 6)
+	assertions.Len(memoryDialect.Journals(persistenceID), 6)
+
+	// delete some snapshots
+	assertions.NoError(memoryDialect.DeleteSnapshots(ctx, persistenceID, 2))
+	assertions.Len(memoryDialect.Snapshots(persistenceID), 1)
+
+	assertions.Equal([]string{persistenceID}, memoryDialect.PersistenceIDs())
+	memoryDialect.Reset()
+	assertions.Empty(memoryDialect.PersistenceIDs())
+	assertions.NoError(memoryDialect.Close())
+}
+
+func TestInMemoryDialectConcurrentWriters(t *testing.T) {
+	ctx := context.TODO()
+	persistenceID := uuid.New().String()
+	numWriters := 10
+	numEvents := 50
+
+	// get instance of assert
+	assertions := assert.New(t)
+	memoryDialect := NewInMemoryDialect()
+
+	// every writer attempts to write the same sequence numbers
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	written := 0
+	for i := 0; i < numWriters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for sequenceNumber := 1; sequenceNumber <= numEvents; sequenceNumber++ {
+				journal, err := NewJournal(persistenceID, &pb.AccountDebited{}, sequenceNumber, uuid.New().String())
+				assertions.NoError(err)
+				if memoryDialect.PersistJournal(ctx, journal) == nil {
+					mu.Lock()
+					written++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// only one write per sequence number succeeds
+	assertions.Equal(numEvents, written)
+	journals, err := memoryDialect.GetJournals(ctx, persistenceID, 1, math.MaxInt32)
+	assertions.NoError(err)
+	assertions.Len(journals, numEvents)
+	for i, journal := range journals {
+		assertions.Equal(i+1, journal.SequenceNumber)
+	}
+}
+
+func TestInMemoryDialectProvider(t *testing.T) {
+	ctx := context.TODO()
+	persistenceID := uuid.New().String()
+
+	// get instance of assert
+	assertions := assert.New(t)
+	memoryDialect := NewInMemoryDialect()
+	provider := NewSQLProvider(ctx, actor.NewActorSystem(), memoryDialect, WithSnapshotInterval(3))
+	state := provider.GetState()
+
+	// persist some events and a snapshot
+	for i := 1; i <= 5; i++ {
+		state.PersistEvent(persistenceID, i, &pb.AccountDebited{AccountNumber: persistenceID, Balance: float32(i)})
+	}
+	state.PersistSnapshot(persistenceID, 3, &pb.Account{AccountNumber: persistenceID, ActualBalance: 3})
+
+	assertions.Len(memoryDialect.Journals(persistenceID), 5)
+	assertions.Len(memoryDialect.Snapshots(persistenceID), 1)
+
+	// recover the snapshot and the subsequent events
+	snapshot, eventIndex, ok := state.GetSnapshot(persistenceID)
+	assertions.True(ok)
+	assertions.Equal(3, eventIndex)
+	assertions.True(proto.Equal(&pb.Account{AccountNumber: persistenceID, ActualBalance: 3}, snapshot.(proto.Message)))
+
+	replayed := make([]float32, 0)
+	state.GetEvents(persistenceID, eventIndex+1, 0, func(e interface{}) {
+		replayed = append(replayed, e.(*pb.AccountDebited).GetBalance())
+	})
+	assertions.Equal([]float32{4, 5}, replayed)
+
+	// a persistence ID without snapshot has nothing to recover from
+	_, _, ok = state.GetSnapshot(uuid.New().String())
+	assertions.False(ok)
+}
+
+// accountActor is a persistent actor keeping the balance of the last debit
+type accountActor struct {
+	persistence.Mixin
+	balance float32
+}
+
+// balanceQuery asks an accountActor for its balance
+type balanceQuery struct{}
+
+// Receive handles the messages of the actor
+func (a *accountActor) Receive(ctx actor.Context) {
+	switch msg := ctx.Message().(type) {
+	case *persistence.RequestSnapshot:
+		a.PersistSnapshot(&pb.Account{ActualBalance: a.balance})
+	case *pb.Account:
+		a.balance = msg.GetActualBalance()
+	case *pb.AccountDebited:
+		if !a.Recovering() {
+			a.PersistReceive(msg)
+		}
+		a.balance = msg.GetBalance()
+	case *balanceQuery:
+		ctx.Respond(a.balance)
+	}
+}
+
+func TestJournalTruncationOnSnapshotRecovery(t *testing.T) {
+	testCases := map[string]struct {
+		logical bool
+	}{
+		"logical deletion":  {logical: true},
+		"physical deletion": {logical: false},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(
+			name, func(t *testing.T) {
+				persistenceID := uuid.New().String()
+
+				// get instance of assert
+				assertions := assert.New(t)
+				memoryDialect := NewInMemoryDialect()
+				var mu sync.Mutex
+				failures := make([]error, 0)
+				opts := []OptFunc{
+					WithJournalTruncationOnSnapshot(), WithSnapshotInterval(3),
+					WithErrorHandler(func(err *PersistenceError) Directive {
+						mu.Lock()
+						defer mu.Unlock()
+						failures = append(failures, err)
+						return ResumeDirective
+					}),
+				}
+				if testCase.logical {
+					opts = append(opts, WithLogicalDeletion())
+				}
+				system := actor.NewActorSystem()
+				provider := NewSQLProvider(context.TODO(), system, memoryDialect, opts...)
+				props := actor.PropsFromProducer(func() actor.Actor { return &accountActor{} }).
+					WithReceiverMiddleware(persistence.Using(provider))
+
+				balance := func(pid *actor.PID) interface{} {
+					result, err := system.Root.RequestFuture(pid, &balanceQuery{}, time.Second).Result()
+					assertions.NoError(err)
+					return result
+				}
+
+				// the events 0 to 4 are persisted and snapshots are taken at the events 0 and 3
+				pid, err := system.Root.SpawnNamed(props, persistenceID)
+				assertions.NoError(err)
+				for i := 0; i < 5; i++ {
+					system.Root.Send(pid, &pb.AccountDebited{Balance: float32(i)})
+				}
+				assertions.EqualValues(4, balance(pid))
+				assertions.NoError(system.Root.StopFuture(pid).Wait())
+
+				snapshots := memoryDialect.Snapshots(persistenceID)
+				if assertions.Len(snapshots, 1) {
+					assertions.Equal(3, snapshots[0].SequenceNumber)
+				}
+
+				// the event the snapshot has been taken at is kept for the recovery
+				journals := memoryDialect.Journals(persistenceID)
+				if testCase.logical {
+					assertions.Len(journals, 5)
+					for _, journal := range journals {
+						assertions.Equal(journal.SequenceNumber < 3, journal.Deleted)
+					}
+				} else {
+					assertions.Equal([]int{3, 4}, journalSequenceNumbers(journals))
+				}
+
+				// the recovered actor carries on with the next sequence number
+				pid, err = system.Root.SpawnNamed(props, persistenceID)
+				assertions.NoError(err)
+				assertions.EqualValues(4, balance(pid))
+				system.Root.Send(pid, &pb.AccountDebited{Balance: 5})
+				assertions.EqualValues(5, balance(pid))
+				assertions.NoError(system.Root.StopFuture(pid).Wait())
+
+				journals = memoryDialect.Journals(persistenceID)
+				if assertions.NotEmpty(journals) {
+					assertions.Equal(5, journals[len(journals)-1].SequenceNumber)
+				}
+				mu.Lock()
+				assertions.Empty(failures)
+				mu.Unlock()
+			},
+		)
+	}
+}
+
+// journalSequenceNumbers returns the sequence numbers of the given journal rows
+func journalSequenceNumbers(journals []*Journal) []int {
+	sequenceNumbers := make([]int, 0, len(journals))
+	for _, journal := range journals {
+		sequenceNumbers = append(sequenceNumbers, journal.SequenceNumber)
+	}
+	return sequenceNumbers
+}
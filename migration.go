@@ -0,0 +1,335 @@
+package persistencesql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// MigrationMode states how the schema migrations are carried out when the provider starts
+type MigrationMode int
+
+const (
+	// AutoMigrate applies the pending migrations
+	AutoMigrate MigrationMode = iota
+	// DryRunMigrations logs the SQL of the pending migrations without applying them
+	DryRunMigrations
+	// VerifySchema applies no migration. Connecting fails with ErrOutdatedSchema when some migrations are pending,
+	// e.g. when the schema is managed by a separate deployment step
+	VerifySchema
+)
+
+const (
+	// schemaVersionTable is the default name of the table recording the applied migrations
+	schemaVersionTable = "schema_version"
+
+	createSchemaVersionTableStmt = "create-schema-version-table"
+	schemaVersionTableExistsStmt = "schema-version-table-exists"
+	schemaVersionsQueryStmt      = "schema-versions"
+	createSchemaVersionStmt      = "create-schema-version"
+	lockMigrationsStmt           = "lock-migrations"
+	unlockMigrationsStmt         = "unlock-migrations"
+
+	addJournalCodecStmt          = "add-journal-codec"
+	addSnapshotCodecStmt         = "add-snapshot-codec"
+	addJournalKeyIDStmt          = "add-journal-key-id"
+	addSnapshotKeyIDStmt         = "add-snapshot-key-id"
+	addJournalTenantStmt         = "add-journal-tenant"
+	createJournalTenantIndexStmt = "create-journal-tenant-index"
+	addSnapshotTenantStmt        = "add-snapshot-tenant"
+	addWriterTenantStmt          = "add-writer-tenant"
+	addProjectionTenantStmt      = "add-projection-offset-tenant"
+	addOutboxTenantStmt          = "add-outbox-tenant"
+	dropOutboxIndexStmt          = "drop-outbox-index"
+	createOutboxTenantIndexStmt  = "create-outbox-tenant-index"
+	addJournalSerializerStmt     = "add-journal-serializer"
+	addSnapshotSerializerStmt    = "add-snapshot-serializer"
+	addOutboxSerializerStmt      = "add-outbox-serializer"
+)
+
+// migration is a forward change of the schema, made of named statements of the driver SQL file. The statements a
+// driver does not declare are skipped. A released migration must never change: any change of the schema is a new
+// migration appended to the migrations
+type migration struct {
+	version     int
+	description string
+	statements  []string
+}
+
+// migrations are the migrations of the schema, in order
+var migrations = []migration{
+	{
+		// the tables are only created when they do not exist, hence the databases created before the schema was
+		// versioned are taken over as they are and brought up to date by the following migrations
+		version:     1,
+		description: "create the journal and snapshot tables",
+		statements:  []string{createJournalTableStmt, createSnapshotTableStmt},
+	},
+	{
+		version:     2,
+		description: "create the journal writer table",
+		statements:  []string{createWriterTableStmt},
+	},
+	{
+		version:     3,
+		description: "add the codec to the journal and snapshot tables",
+		statements:  []string{addJournalCodecStmt, addSnapshotCodecStmt},
+	},
+	{
+		version:     4,
+		description: "add the key ID to the journal and snapshot tables",
+		statements:  []string{addJournalKeyIDStmt, addSnapshotKeyIDStmt},
+	},
+	{
+		version:     5,
+		description: "create the event tag table",
+		statements:  []string{createTagTableStmt},
+	},
+	{
+		version:     6,
+		description: "create the projection offset table",
+		statements:  []string{createProjectionTableStmt},
+	},
+	{
+		version:     7,
+		description: "create the outbox table",
+		statements:  []string{createOutboxTableStmt, createOutboxIndexStmt},
+	},
+	{
+		version:     8,
+		description: "add the tenant to the journal, snapshot, writer, projection offset and outbox tables",
+		statements: []string{
+			addJournalTenantStmt, createJournalTenantIndexStmt, addSnapshotTenantStmt, addWriterTenantStmt,
+			addProjectionTenantStmt, addOutboxTenantStmt, dropOutboxIndexStmt, createOutboxTenantIndexStmt,
+		},
+	},
+	{
+		version:     9,
+		description: "add the serializer to the journal, snapshot and outbox tables",
+		statements:  []string{addJournalSerializerStmt, addSnapshotSerializerStmt, addOutboxSerializerStmt},
+	},
+}
+
+// Migration is a forward change of the schema, as applied to a given database
+type Migration struct {
+	// the version the schema is at once the migration is applied
+	Version int
+	// what the migration changes
+	Description string
+	// the SQL statements applying the migration
+	Statements []string
+}
+
+// Migrator is implemented by the dialects whose schema is versioned. The applied migrations are recorded in the
+// schema_version table
+type Migrator interface {
+	// PendingMigrations returns the migrations not applied yet, in the order they are to be applied
+	PendingMigrations(ctx context.Context) ([]*Migration, error)
+	// Migrate applies the pending migrations. Concurrent migrations of the same database are serialized
+	Migrate(ctx context.Context) error
+}
+
+// enforces that dialect implements the Migrator interface
+var _ Migrator = (*dialect)(nil)
+
+// PendingMigrations returns the migrations not applied yet, in the order they are to be applied
+func (d *dialect) PendingMigrations(ctx context.Context) ([]*Migration, error) {
+	return d.pendingMigrations(ctx, d.db)
+}
+
+// Migrate applies the pending migrations. Each migration is applied in its own transaction, which MySQL commits
+// statement by statement. On Postgres and MySQL an advisory lock serializes the concurrent migrations
+func (d *dialect) Migrate(ctx context.Context) error {
+	// the advisory lock is held by the connection
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	unlock, err := d.lockMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err = d.dotSQL.ExecContext(ctx, conn, createSchemaVersionTableStmt); err != nil {
+		return err
+	}
+
+	// the pending migrations are read once locked since another node may have applied them in the meantime
+	pending, err := d.pendingMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range pending {
+		if err = d.applyMigration(ctx, conn, migration); err != nil {
+			return fmt.Errorf("failed to apply the migration to version %d: %w", migration.Version, err)
+		}
+	}
+	return nil
+}
+
+// migrate carries the migrations out according to the migration mode
+func (d *dialect) migrate(ctx context.Context) error {
+	switch d.config.dbMigrationMode {
+	case VerifySchema:
+		// the schema has been verified when connecting
+		return nil
+	case DryRunMigrations:
+		pending, err := d.PendingMigrations(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range pending {
+			log.Printf(
+				"pending migration to version %d (%s):\n%s", migration.Version, migration.Description,
+				strings.Join(migration.Statements, "\n"),
+			)
+		}
+		return nil
+	default:
+		return d.Migrate(ctx)
+	}
+}
+
+// verifySchema makes sure that every migration has been applied
+func (d *dialect) verifySchema(ctx context.Context) error {
+	pending, err := d.PendingMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(pending) > 0 {
+		return fmt.Errorf(
+			"%w: %d pending migrations, from version %d to %d", ErrOutdatedSchema, len(pending), pending[0].Version,
+			pending[len(pending)-1].Version,
+		)
+	}
+	return nil
+}
+
+// pendingMigrations returns the migrations not recorded in the schema_version table
+func (d *dialect) pendingMigrations(ctx context.Context, conn queryer) ([]*Migration, error) {
+	applied, err := d.appliedVersions(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make([]*Migration, 0, len(migrations))
+	for _, migration := range migrations {
+		if applied[migration.version] {
+			continue
+		}
+
+		statements := make([]string, 0, len(migration.statements))
+		for _, name := range migration.statements {
+			// let us skip the statements the driver does not declare
+			if statement, err := d.dotSQL.Raw(name); err == nil {
+				statements = append(statements, statement)
+			}
+		}
+
+		pending = append(
+			pending, &Migration{
+				Version:     migration.version,
+				Description: migration.description,
+				Statements:  statements,
+			},
+		)
+	}
+	return pending, nil
+}
+
+// appliedVersions returns the versions recorded in the schema_version table, if any
+func (d *dialect) appliedVersions(ctx context.Context, conn queryer) (map[int]bool, error) {
+	row, err := d.dotSQL.QueryRowContext(ctx, conn, schemaVersionTableExistsStmt, d.names.table(schemaVersionTable))
+	if err != nil {
+		return nil, err
+	}
+
+	var tables int
+	if err = row.Scan(&tables); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]bool)
+	if tables == 0 {
+		return applied, nil
+	}
+
+	rows, err := d.dotSQL.QueryContext(ctx, conn, schemaVersionsQueryStmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int
+		if err = rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+// applyMigration runs the statements of a migration and records it in the schema_version table
+func (d *dialect) applyMigration(ctx context.Context, conn *sql.Conn, migration *Migration) (err error) {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	for _, statement := range migration.Statements {
+		if _, err = tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	if _, err = d.dotSQL.ExecContext(
+		ctx, tx, createSchemaVersionStmt, migration.Version, migration.Description, time.Now().Unix(),
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// lockMigrations takes the advisory lock serializing the migrations, when the driver supports it.
+// It returns the function releasing the lock
+func (d *dialect) lockMigrations(ctx context.Context, conn *sql.Conn) (func(), error) {
+	if _, err := d.dotSQL.Raw(lockMigrationsStmt); err != nil {
+		return func() {}, nil
+	}
+
+	// the lock is named after the schema_version table, which is specific to an event store
+	key := d.names.table(schemaVersionTable)
+	if _, err := d.dotSQL.ExecContext(ctx, conn, lockMigrationsStmt, key); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		// the lock must be released even when the context is done since the connection goes back to the pool
+		if _, err := d.dotSQL.ExecContext(context.Background(), conn, unlockMigrationsStmt, key); err != nil {
+			log.Printf("failed to release the migration lock: %v", err)
+		}
+	}, nil
+}
+
+// queryer runs queries either on the connection pool or on a given connection
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
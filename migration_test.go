@@ -0,0 +1,194 @@
+package persistencesql
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	pb "github.com/tochemey/protoactor-persistence-sql/gen"
+)
+
+// connectSQLite connects a SQLite dialect to the given database file
+func connectSQLite(t *testing.T, dbPath string, opts ...PoolOpt) (*dialect, error) {
+	sqliteDialect, err := NewSQLiteDialect(NewSQLiteConfig(dbPath, opts...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = sqliteDialect.Connect(context.TODO()); err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() { _ = sqliteDialect.Close() })
+	return sqliteDialect.(*dialect), nil
+}
+
+// unversionedSchema is the schema created by the versions of the library predating the schema migrations
+const unversionedSchema = `
+	CREATE TABLE journal
+	(
+	    ordering        INTEGER PRIMARY KEY AUTOINCREMENT,
+	    persistence_id  VARCHAR(255)          NOT NULL,
+	    sequence_number BIGINT                NOT NULL,
+	    timestamp       BIGINT                NOT NULL,
+	    payload         BLOB                  NOT NULL,
+	    manifest        VARCHAR(255)          NOT NULL,
+	    writer_id       VARCHAR(255)          NOT NULL,
+	    deleted         BOOLEAN DEFAULT FALSE NOT NULL,
+	    UNIQUE (persistence_id, sequence_number)
+	);
+
+	CREATE TABLE snapshot
+	(
+	    persistence_id  VARCHAR(255) NOT NULL,
+	    sequence_number BIGINT       NOT NULL,
+	    timestamp       BIGINT       NOT NULL,
+	    snapshot        BLOB         NOT NULL,
+	    manifest        VARCHAR(255) NOT NULL,
+	    writer_id       VARCHAR(255) NOT NULL,
+	    PRIMARY KEY (persistence_id, sequence_number)
+	);
+`
+
+func TestMigrations(t *testing.T) {
+	// get instance of assert
+	assertions := assert.New(t)
+	for i, migration := range migrations {
+		// versions follow each other
+		assertions.Equal(i+1, migration.version)
+		assertions.NotEmpty(migration.statements)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.TODO()
+
+	// get instance of assert
+	assertions := assert.New(t)
+	sqliteDialect, err := connectSQLite(t, filepath.Join(t.TempDir(), "journal.db"))
+	assertions.NoError(err)
+
+	// every migration is pending on a new database
+	pending, err := sqliteDialect.PendingMigrations(ctx)
+	assertions.NoError(err)
+	assertions.Len(pending, len(migrations))
+	assertions.Equal(1, pending[0].Version)
+	assertions.Contains(pending[0].Statements[0], "CREATE TABLE IF NOT EXISTS journal")
+
+	assertions.NoError(sqliteDialect.Migrate(ctx))
+	pending, err = sqliteDialect.PendingMigrations(ctx)
+	assertions.NoError(err)
+	assertions.Empty(pending)
+
+	var versions int
+	assertions.NoError(sqliteDialect.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM schema_version").Scan(&versions))
+	assertions.Equal(len(migrations), versions)
+
+	// migrating an up-to-date database does nothing
+	assertions.NoError(sqliteDialect.Migrate(ctx))
+}
+
+func TestMigrateUnversionedDatabase(t *testing.T) {
+	ctx := context.TODO()
+
+	// get instance of assert
+	assertions := assert.New(t)
+	sqliteDialect, err := connectSQLite(t, filepath.Join(t.TempDir(), "journal.db"))
+	assertions.NoError(err)
+
+	// the tables created before the schema was versioned are taken over and brought up to date
+	_, err = sqliteDialect.db.ExecContext(ctx, unversionedSchema)
+	assertions.NoError(err)
+	_, err = sqliteDialect.db.ExecContext(
+		ctx,
+		`INSERT INTO journal (persistence_id, sequence_number, timestamp, payload, manifest, writer_id)
+		VALUES ('account', 1, 0, x'', 'manifest', 'writer');
+		INSERT INTO snapshot (persistence_id, sequence_number, timestamp, snapshot, manifest, writer_id)
+		VALUES ('account', 1, 0, x'', 'manifest', 'writer');`,
+	)
+	assertions.NoError(err)
+
+	assertions.NoError(sqliteDialect.Migrate(ctx))
+	pending, err := sqliteDialect.PendingMigrations(ctx)
+	assertions.NoError(err)
+	assertions.Empty(pending)
+
+	// the existing rows belong to the default tenant and the orderings carry on
+	journals, err := sqliteDialect.GetJournals(ctx, "account", 1, 2)
+	assertions.NoError(err)
+	if !assertions.Len(journals, 1) {
+		return
+	}
+	assertions.Empty(journals[0].TenantID)
+
+	snapshot, err := sqliteDialect.GetLatestSnapshot(ctx, "account")
+	assertions.NoError(err)
+	if assertions.NotNil(snapshot) {
+		assertions.Equal(NoCompression, snapshot.Codec)
+		assertions.Empty(snapshot.KeyID)
+	}
+
+	// the tables added since are usable
+	journal, err := NewJournal("account", &pb.AccountDebited{}, 2, "writer")
+	assertions.NoError(err)
+	journal.Tags = []string{"tag"}
+	journal.Outbox = true
+	assertions.NoError(sqliteDialect.ClaimWriter(ctx, "account", "writer"))
+	assertions.NoError(sqliteDialect.PersistJournal(ctx, journal))
+	assertions.NoError(sqliteDialect.SaveProjectionOffset(ctx, "projection", 1, nil))
+	journals, err = sqliteDialect.GetJournals(ctx, "account", 1, 2)
+	assertions.NoError(err)
+	if assertions.Len(journals, 2) {
+		assertions.Greater(journals[1].Ordering, journals[0].Ordering)
+	}
+}
+
+func TestMigrationModes(t *testing.T) {
+	ctx := context.TODO()
+	testCases := map[string]struct {
+		mode    MigrationMode
+		pending int
+	}{
+		"auto":    {mode: AutoMigrate, pending: 0},
+		"dry run": {mode: DryRunMigrations, pending: len(migrations)},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(
+			name, func(t *testing.T) {
+				// get instance of assert
+				assertions := assert.New(t)
+				sqliteDialect, err := connectSQLite(
+					t, filepath.Join(t.TempDir(), "journal.db"), WithMigrationMode(testCase.mode),
+				)
+				assertions.NoError(err)
+
+				assertions.NoError(sqliteDialect.CreateSchemasIfNotExist(ctx))
+				pending, err := sqliteDialect.PendingMigrations(ctx)
+				assertions.NoError(err)
+				assertions.Len(pending, testCase.pending)
+			},
+		)
+	}
+}
+
+func TestVerifySchema(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "journal.db")
+
+	// get instance of assert
+	assertions := assert.New(t)
+
+	// connecting to an outdated database fails
+	_, err := connectSQLite(t, dbPath, WithMigrationMode(VerifySchema))
+	assertions.True(errors.Is(err, ErrOutdatedSchema))
+
+	migratingDialect, err := connectSQLite(t, dbPath)
+	assertions.NoError(err)
+	assertions.NoError(migratingDialect.Migrate(context.TODO()))
+
+	verifyingDialect, err := connectSQLite(t, dbPath, WithMigrationMode(VerifySchema))
+	assertions.NoError(err)
+	// no migration is applied
+	assertions.NoError(verifyingDialect.CreateSchemasIfNotExist(context.TODO()))
+}
@@ -2,13 +2,9 @@ package persistencesql
 
 import (
 	"context"
-	"math"
 	"testing"
 
-	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
-	pb "github.com/tochemey/protoactor-persistence-sql/gen"
-	"google.golang.org/protobuf/proto"
 )
 
 func TestMySQLConnection(t *testing.T) {
@@ -81,9 +77,6 @@ func TestMySQLConnection(t *testing.T) {
 
 func TestMySQLDialect(t *testing.T) {
 	ctx := context.TODO()
-	numEvents := 10
-	numSnapshots := 3
-	persistenceID := uuid.New().String()
 	config := NewDBConfig(
 		"test",
 		"test",
@@ -112,81 +105,7 @@ func TestMySQLDialect(t *testing.T) {
 	// check whether both tables have been created
 	err = tableExist(mysqlHandle, MYSQL, "testdb", "journal")
 	assertions.NoError(err)
-	assertions.Nil(err)
 	err = tableExist(mysqlHandle, MYSQL, "testdb", "snapshot")
 	assertions.NoError(err)
 	assertions.Nil(err)
-
-	// insert events into the journal store
-	for i := 0; i < numEvents; i++ {
-		persistenceID := uuid.New().String()
-		journal := NewJournal(persistenceID, &pb.AccountDebited{
-			AccountNumber: persistenceID,
-			Balance:       float32(i * 100),
-		}, i+1, "writer-1")
-
-		err = mySQLDialect.PersistJournal(ctx, journal)
-		assertions.NoError(err)
-		assertions.Nil(err)
-	}
-
-	// insert some data into the snapshot store
-	for i := 0; i < numSnapshots; i++ {
-		snapshot := NewSnapshot(persistenceID, &pb.Account{
-			AccountNumber: persistenceID,
-			ActualBalance: float32(i * 100),
-		}, i+1, "writer-2")
-
-		err = mySQLDialect.PersistSnapshot(ctx, snapshot)
-		assertions.NoError(err)
-		assertions.Nil(err)
-	}
-
-	// let us count the number of elements in the journal and snapshot
-	count := countJournal(mysqlHandle)
-	assertions.Equal(numEvents, count)
-	count = countSnapshot(mysqlHandle)
-	assertions.Equal(numSnapshots, count)
-
-	// let us fetch the latest snapshot for the given persistenceId
-	// and perform some assertions
-	latest, err := mySQLDialect.GetLatestSnapshot(ctx, persistenceID)
-	assertions.NoError(err)
-	assertions.Equal(latest.SequenceNumber, 3)
-	assertions.Equal(string(latest.SnapshotManifest), string(proto.MessageName(&pb.Account{})))
-	snapshot, ok := (latest.message()).(*pb.Account)
-	assertions.True(ok)
-	assertions.Equal(snapshot.ActualBalance, float32(200))
-
-	// let fetch some events from the journal store
-	for i := 0; i < numEvents; i++ {
-		journal := NewJournal(persistenceID, &pb.AccountDebited{
-			AccountNumber: persistenceID,
-			Balance:       float32(i * 100),
-		}, i+1, "some-actor-pid")
-
-		err = mySQLDialect.PersistJournal(ctx, journal)
-		assertions.NoError(err)
-		assertions.Nil(err)
-	}
-
-	journals, err := mySQLDialect.GetJournals(ctx, persistenceID, 2, 6)
-	assertions.NoError(err)
-	assertions.NotNil(journals)
-	assertions.Equal(len(journals), 5)
-
-	// delete some events from the journal
-	err = mySQLDialect.DeleteJournals(ctx, persistenceID, 2, true)
-	assertions.NoError(err)
-
-	// check the number of events remaining for the given persistence ID
-	journals, err = mySQLDialect.GetJournals(ctx, persistenceID, 1, math.MaxInt32)
-	assertions.NoError(err)
-	assertions.NotNil(journals)
-	assertions.Equal(len(journals), 8)
-
-	// delete some snapshots
-	err = mySQLDialect.DeleteSnapshots(ctx, persistenceID, 2)
-	assertions.NoError(err)
-
 }
@@ -0,0 +1,201 @@
+package persistencesql
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// maxIdentifierLength is the maximum length of a table or column name, which is the Postgres limit
+const maxIdentifierLength = 63
+
+// identifierPattern matches the table and column names accepted. Names are written unquoted into the SQL statements,
+// hence anything but letters, digits and underscores is rejected
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// reservedWords are the SQL reserved words, which cannot be used unquoted as table or column names. They are the
+// reserved keywords of Postgres along with the statement keywords reserved by MySQL and SQLite
+var reservedWords = map[string]bool{
+	"all": true, "analyse": true, "analyze": true, "and": true, "any": true, "array": true, "as": true, "asc": true,
+	"asymmetric": true, "authorization": true, "binary": true, "both": true, "case": true, "cast": true,
+	"check": true, "collate": true, "collation": true, "column": true, "concurrently": true, "constraint": true,
+	"create": true, "cross": true, "current_catalog": true, "current_date": true, "current_role": true,
+	"current_schema": true, "current_time": true, "current_timestamp": true, "current_user": true, "default": true,
+	"deferrable": true, "delete": true, "desc": true, "distinct": true, "do": true, "drop": true, "else": true,
+	"end": true, "except": true, "exists": true, "false": true, "fetch": true, "for": true, "foreign": true,
+	"freeze": true, "from": true, "full": true, "grant": true, "group": true, "having": true, "ilike": true,
+	"in": true, "index": true, "initially": true, "inner": true, "insert": true, "intersect": true, "into": true,
+	"is": true, "isnull": true, "join": true, "key": true, "lateral": true, "leading": true, "left": true,
+	"like": true, "limit": true, "localtime": true, "localtimestamp": true, "natural": true, "not": true,
+	"notnull": true, "null": true, "offset": true, "on": true, "only": true, "or": true, "order": true,
+	"outer": true, "overlaps": true, "placing": true, "primary": true, "references": true, "returning": true,
+	"right": true, "select": true, "session_user": true, "set": true, "similar": true, "some": true,
+	"symmetric": true, "system_user": true, "table": true, "tablesample": true, "then": true, "to": true,
+	"trailing": true, "true": true, "union": true, "unique": true, "update": true, "user": true, "using": true,
+	"values": true, "variadic": true, "verbose": true, "when": true, "where": true, "window": true, "with": true,
+}
+
+// TableNames names the tables of the event store. The names left empty keep their default.
+// Two event stores can share a database as long as their tables are named differently
+type TableNames struct {
+	Journal          string // defaults to journal
+	Snapshot         string // defaults to snapshot
+	Tag              string // defaults to event_tag
+	Writer           string // defaults to journal_writer
+	ProjectionOffset string // defaults to projection_offset
+	Outbox           string // defaults to outbox
+	SchemaVersion    string // defaults to schema_version
+}
+
+// JournalColumns names the columns of the journal table. The names left empty keep their default
+type JournalColumns struct {
+	Ordering       string // defaults to ordering
+	PersistenceID  string // defaults to persistence_id
+	SequenceNumber string // defaults to sequence_number
+	Timestamp      string // defaults to timestamp
+	Payload        string // defaults to payload
+	Manifest       string // defaults to manifest
+	WriterID       string // defaults to writer_id
+	Deleted        string // defaults to deleted
+	Codec          string // defaults to codec
+	KeyID          string // defaults to key_id
+	TenantID       string // defaults to tenant_id
+	Serializer     string // defaults to serializer
+}
+
+// SnapshotColumns names the columns of the snapshot table. The names left empty keep their default
+type SnapshotColumns struct {
+	PersistenceID  string // defaults to persistence_id
+	SequenceNumber string // defaults to sequence_number
+	Timestamp      string // defaults to timestamp
+	Snapshot       string // defaults to snapshot
+	Manifest       string // defaults to manifest
+	WriterID       string // defaults to writer_id
+	Codec          string // defaults to codec
+	KeyID          string // defaults to key_id
+	TenantID       string // defaults to tenant_id
+	Serializer     string // defaults to serializer
+}
+
+// names maps the default table and column names to the configured ones
+type names struct {
+	tables   map[string]string
+	journal  map[string]string
+	snapshot map[string]string
+}
+
+// newNames resolves and validates the table and column names of the given configuration
+func newNames(config *DBConfig) (*names, error) {
+	tables := config.dbTables
+	journal := config.dbJournalColumns
+	snapshot := config.dbSnapshotColumns
+
+	resolved := &names{}
+	var err error
+	if resolved.tables, err = resolveNames(
+		"table", "journal", tables.Journal, "snapshot", tables.Snapshot, "event_tag", tables.Tag,
+		"journal_writer", tables.Writer, "projection_offset", tables.ProjectionOffset, "outbox", tables.Outbox,
+		"schema_version", tables.SchemaVersion,
+	); err != nil {
+		return nil, err
+	}
+
+	if resolved.journal, err = resolveNames(
+		"journal column", "ordering", journal.Ordering, "persistence_id", journal.PersistenceID,
+		"sequence_number", journal.SequenceNumber, "timestamp", journal.Timestamp, "payload", journal.Payload,
+		"manifest", journal.Manifest, "writer_id", journal.WriterID, "deleted", journal.Deleted,
+		"codec", journal.Codec, "key_id", journal.KeyID, "tenant_id", journal.TenantID,
+		"serializer", journal.Serializer,
+	); err != nil {
+		return nil, err
+	}
+
+	if resolved.snapshot, err = resolveNames(
+		"snapshot column", "persistence_id", snapshot.PersistenceID, "sequence_number", snapshot.SequenceNumber,
+		"timestamp", snapshot.Timestamp, "snapshot", snapshot.Snapshot, "manifest", snapshot.Manifest,
+		"writer_id", snapshot.WriterID, "codec", snapshot.Codec, "key_id", snapshot.KeyID,
+		"tenant_id", snapshot.TenantID, "serializer", snapshot.Serializer,
+	); err != nil {
+		return nil, err
+	}
+
+	return resolved, nil
+}
+
+// resolveNames maps the given default names to the configured ones, given as pairs of default and configured name.
+// It fails when a configured name is not a valid identifier or when two names collide.
+// The names are lowercased: Postgres folds the unquoted identifiers to lowercase, hence the statements quoting them,
+// e.g. COPY, would not find the tables created with an uppercase name otherwise
+func resolveNames(kind string, pairs ...string) (map[string]string, error) {
+	resolved := make(map[string]string, len(pairs)/2)
+	taken := make(map[string]string, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		defaultName, name := pairs[i], pairs[i+1]
+		if name == "" {
+			name = defaultName
+		}
+
+		if len(name) > maxIdentifierLength || !identifierPattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid %s name %q", kind, name)
+		}
+
+		lowercased := strings.ToLower(name)
+		if reservedWords[lowercased] {
+			return nil, fmt.Errorf("invalid %s name %q: reserved word", kind, name)
+		}
+
+		if other, ok := taken[lowercased]; ok {
+			return nil, fmt.Errorf("the %s names of %s and %s collide: %q", kind, other, defaultName, name)
+		}
+
+		taken[lowercased] = defaultName
+		resolved[defaultName] = lowercased
+	}
+	return resolved, nil
+}
+
+// table returns the name of a table given its default name
+func (n *names) table(name string) string {
+	return n.tables[name]
+}
+
+// journalColumns returns the names of the journal columns given their default names
+func (n *names) journalColumns(columns []string) []string {
+	resolved := make([]string, 0, len(columns))
+	for _, column := range columns {
+		resolved = append(resolved, n.journal[column])
+	}
+	return resolved
+}
+
+// render resolves the table and column names referenced by the given SQL statements, e.g. {{table "journal"}} or
+// {{journal "persistence_id"}}
+func (n *names) render(statements string) (string, error) {
+	lookup := func(kind string, names map[string]string) func(name string) (string, error) {
+		return func(name string) (string, error) {
+			resolved, ok := names[name]
+			if !ok {
+				return "", fmt.Errorf("unknown %s %q", kind, name)
+			}
+			return resolved, nil
+		}
+	}
+
+	tmpl, err := template.New("sql").Funcs(
+		template.FuncMap{
+			"table":    lookup("table", n.tables),
+			"journal":  lookup("journal column", n.journal),
+			"snapshot": lookup("snapshot column", n.snapshot),
+		},
+	).Parse(statements)
+	if err != nil {
+		return "", err
+	}
+
+	var rendered strings.Builder
+	if err = tmpl.Execute(&rendered, nil); err != nil {
+		return "", err
+	}
+	return rendered.String(), nil
+}
@@ -0,0 +1,103 @@
+package persistencesql
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewNames(t *testing.T) {
+	testCases := map[string]struct {
+		config   *DBConfig
+		expected string
+		err      string
+	}{
+		"defaults": {
+			config:   &DBConfig{},
+			expected: "journal",
+		},
+		"custom names": {
+			config:   &DBConfig{dbTables: TableNames{Journal: "billing_journal"}},
+			expected: "billing_journal",
+		},
+		"mixed case": {
+			config:   &DBConfig{dbTables: TableNames{Journal: "BillingJournal"}},
+			expected: "billingjournal",
+		},
+		"reserved word": {
+			config: &DBConfig{dbJournalColumns: JournalColumns{Ordering: "Order"}},
+			err:    `invalid journal column name "Order": reserved word`,
+		},
+		"injection": {
+			config: &DBConfig{dbTables: TableNames{Journal: "journal; DROP TABLE snapshot"}},
+			err:    `invalid table name "journal; DROP TABLE snapshot"`,
+		},
+		"quoted name": {
+			config: &DBConfig{dbJournalColumns: JournalColumns{Payload: `"payload"`}},
+			err:    `invalid journal column name "\"payload\""`,
+		},
+		"leading digit": {
+			config: &DBConfig{dbSnapshotColumns: SnapshotColumns{Snapshot: "1state"}},
+			err:    `invalid snapshot column name "1state"`,
+		},
+		"too long": {
+			config: &DBConfig{dbTables: TableNames{Outbox: strings.Repeat("o", maxIdentifierLength+1)}},
+			err:    "invalid table name",
+		},
+		"collision": {
+			config: &DBConfig{dbTables: TableNames{Snapshot: "JOURNAL"}},
+			err:    `the table names of journal and snapshot collide: "JOURNAL"`,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(
+			name, func(t *testing.T) {
+				// get instance of assert
+				assertions := assert.New(t)
+
+				names, err := newNames(testCase.config)
+				if testCase.err != "" {
+					if assertions.Error(err) {
+						assertions.Contains(err.Error(), testCase.err)
+					}
+					return
+				}
+
+				assertions.NoError(err)
+				assertions.Equal(testCase.expected, names.table(journalTable))
+			},
+		)
+	}
+}
+
+func TestRenderNames(t *testing.T) {
+	// get instance of assert
+	assertions := assert.New(t)
+	names, err := newNames(
+		&DBConfig{
+			dbTables:         TableNames{Journal: "billing_journal"},
+			dbJournalColumns: JournalColumns{PersistenceID: "stream_id"},
+		},
+	)
+	assertions.NoError(err)
+
+	for _, driver := range []Driver{POSTGRES, MYSQL, SQLITE} {
+		statements, err := names.render(driver.SQLFile())
+		assertions.NoError(err, driver)
+		assertions.NotContains(statements, "{{", driver)
+		assertions.Contains(statements, "CREATE TABLE IF NOT EXISTS billing_journal", driver)
+		assertions.Contains(statements, "DELETE FROM billing_journal", driver)
+	}
+
+	rendered, err := names.render(`SELECT {{journal "persistence_id"}} FROM {{table "journal"}}`)
+	assertions.NoError(err)
+	assertions.Equal("SELECT stream_id FROM billing_journal", rendered)
+
+	// a statement referencing an unknown name does not render
+	_, err = names.render(`SELECT * FROM {{table "unknown"}}`)
+	if assertions.Error(err) {
+		assertions.Contains(err.Error(), `unknown table "unknown"`)
+	}
+}
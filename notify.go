@@ -0,0 +1,194 @@
+package persistencesql
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// listenerPingInterval is the interval at which the listening connection is checked
+const listenerPingInterval = 30 * time.Second
+
+// Notifier is implemented by the dialects able to notify the live queries of new journal rows, sparing them the wait
+// for the next poll
+type Notifier interface {
+	// Subscribe registers for the notifications of new journal rows. The returned channel receives a value whenever
+	// new journal rows may be available. unsubscribe must be called once the notifications are no longer needed
+	Subscribe() (notifications <-chan struct{}, unsubscribe func())
+	// Listening tells whether the notifications are currently delivered. Live queries poll the journal when they are not
+	Listening() bool
+}
+
+// broadcaster fans the notifications out to the subscribers
+type broadcaster struct {
+	mu          sync.Mutex
+	subscribers map[chan struct{}]bool
+	listening   bool
+}
+
+// newBroadcaster creates an instance of broadcaster
+func newBroadcaster(listening bool) *broadcaster {
+	return &broadcaster{
+		subscribers: make(map[chan struct{}]bool),
+		listening:   listening,
+	}
+}
+
+// Subscribe registers for the notifications
+func (b *broadcaster) Subscribe() (<-chan struct{}, func()) {
+	// a pending notification is enough to wake the subscriber up, hence the buffer of one
+	notifications := make(chan struct{}, 1)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers[notifications] = true
+
+	return notifications, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers, notifications)
+	}
+}
+
+// Listening tells whether the notifications are currently delivered
+func (b *broadcaster) Listening() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.listening
+}
+
+// setListening records whether the notifications are delivered and wakes the subscribers up, since notifications
+// may have been missed in the meantime
+func (b *broadcaster) setListening(listening bool) {
+	b.mu.Lock()
+	b.listening = listening
+	b.mu.Unlock()
+
+	b.broadcast()
+}
+
+// broadcast notifies every subscriber without blocking
+func (b *broadcaster) broadcast() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for notifications := range b.subscribers {
+		select {
+		case notifications <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// notificationListener is the listening connection of a journalListener, implemented by pq.Listener
+type notificationListener interface {
+	Listen(channel string) error
+	Ping() error
+	NotificationChannel() <-chan *pq.Notification
+	Close() error
+}
+
+// journalListener listens to the new journal rows notified by Postgres and broadcasts them.
+// The listening connection is re-established whenever it drops
+type journalListener struct {
+	*broadcaster
+	listener notificationListener
+	// the channel the new journal rows are notified on, named after the journal table
+	channel string
+	// signals that the listening connection has been re-established
+	reconnected chan struct{}
+	// closed when the listener is closed
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// newJournalListener creates an instance of journalListener and starts listening in the background
+func newJournalListener(connStr, channel string) *journalListener {
+	journalListener := &journalListener{
+		broadcaster: newBroadcaster(false),
+		channel:     channel,
+		reconnected: make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+	journalListener.listener = pq.NewListener(connStr, 100*time.Millisecond, 10*time.Second, journalListener.handleEvent)
+
+	go journalListener.run()
+	return journalListener
+}
+
+// handleEvent handles the state changes of the listening connection
+func (l *journalListener) handleEvent(event pq.ListenerEventType, err error) {
+	switch event {
+	case pq.ListenerEventReconnected:
+		// the run loop resumes the notifications
+		select {
+		case l.reconnected <- struct{}{}:
+		default:
+		}
+	case pq.ListenerEventDisconnected, pq.ListenerEventConnectionAttemptFailed:
+		log.Printf("journal listener connection lost, falling back to polling: %v", err)
+		l.setListening(false)
+	}
+}
+
+// run listens to the channel and broadcasts the notifications until the listener is closed
+func (l *journalListener) run() {
+	listened := l.listen()
+
+	ticker := time.NewTicker(listenerPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case _, ok := <-l.listener.NotificationChannel():
+			if !ok {
+				return
+			}
+			l.broadcast()
+		case <-l.reconnected:
+			// the listening connection listens again to the channel it was listening to before it dropped, hence
+			// listening is only retried when it has failed so far
+			if listened {
+				l.setListening(true)
+			} else {
+				listened = l.listen()
+			}
+		case <-ticker.C:
+			// let us make sure a dead connection is detected. The listening connection reports it when it is
+			if l.Listening() {
+				if err := l.listener.Ping(); err != nil {
+					log.Printf("journal listener ping failed: %v", err)
+				}
+			}
+		}
+	}
+}
+
+// listen listens to the channel. It tells whether the notifications are delivered, otherwise listening is retried
+// once the listening connection is re-established
+func (l *journalListener) listen() bool {
+	// Listen blocks until the server has acknowledged it
+	err := l.listener.Listen(l.channel)
+	if err != nil && err != pq.ErrChannelAlreadyOpen {
+		select {
+		case <-l.done:
+		default:
+			log.Printf("failed to listen to the journal notifications, falling back to polling: %v", err)
+		}
+		return false
+	}
+
+	l.setListening(true)
+	return true
+}
+
+// Close stops listening
+func (l *journalListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	l.setListening(false)
+	return l.listener.Close()
+}
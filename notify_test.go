@@ -0,0 +1,199 @@
+package persistencesql
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AsynkronIT/protoactor-go/actor"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	pb "github.com/tochemey/protoactor-persistence-sql/gen"
+)
+
+// silentDialect is an in-memory dialect whose notifications have stopped
+type silentDialect struct {
+	*InMemoryDialect
+}
+
+func (d *silentDialect) Subscribe() (<-chan struct{}, func()) {
+	return make(chan struct{}), func() {}
+}
+
+func (d *silentDialect) Listening() bool {
+	return false
+}
+
+// collectWithin reads the given number of events from the stream, giving up after the timeout
+func collectWithin(stream *EventStream, count int, timeout time.Duration) []*EventEnvelope {
+	collected := make(chan []*EventEnvelope, 1)
+	go func() {
+		collected <- collect(stream, count)
+	}()
+
+	select {
+	case envelopes := <-collected:
+		return envelopes
+	case <-time.After(timeout):
+		return nil
+	}
+}
+
+func TestBroadcaster(t *testing.T) {
+	// get instance of assert
+	assertions := assert.New(t)
+	broadcaster := newBroadcaster(false)
+	notifications, unsubscribe := broadcaster.Subscribe()
+
+	// several notifications pending wake the subscriber up once
+	broadcaster.broadcast()
+	broadcaster.broadcast()
+	assertions.Len(notifications, 1)
+	<-notifications
+
+	// the subscribers are woken up when the notifications resume
+	broadcaster.setListening(true)
+	assertions.True(broadcaster.Listening())
+	assertions.Len(notifications, 1)
+	<-notifications
+
+	// unsubscribed subscribers are no longer notified
+	unsubscribe()
+	broadcaster.broadcast()
+	assertions.Empty(notifications)
+}
+
+// fakeListener is a listening connection failing to listen a given number of times
+type fakeListener struct {
+	mu            sync.Mutex
+	failures      int
+	listens       int
+	notifications chan *pq.Notification
+}
+
+func (l *fakeListener) Listen(string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.listens++
+	if l.listens <= l.failures {
+		return errors.New("listen failed")
+	}
+	return nil
+}
+
+func (l *fakeListener) Ping() error {
+	return nil
+}
+
+func (l *fakeListener) NotificationChannel() <-chan *pq.Notification {
+	return l.notifications
+}
+
+func (l *fakeListener) Close() error {
+	close(l.notifications)
+	return nil
+}
+
+// listenCount returns the number of times the channel has been listened to
+func (l *fakeListener) listenCount() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.listens
+}
+
+func TestJournalListener(t *testing.T) {
+	// get instance of assert
+	assertions := assert.New(t)
+	listener := &fakeListener{failures: 1, notifications: make(chan *pq.Notification)}
+	journalListener := &journalListener{
+		broadcaster: newBroadcaster(false),
+		listener:    listener,
+		channel:     "journal",
+		reconnected: make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+	notifications, unsubscribe := journalListener.Subscribe()
+	defer unsubscribe()
+	go journalListener.run()
+
+	// the live queries poll the journal until listening succeeds
+	assertions.Eventually(func() bool { return listener.listenCount() == 1 }, time.Second, time.Millisecond)
+	assertions.False(journalListener.Listening())
+
+	// listening is retried once the connection is re-established
+	journalListener.handleEvent(pq.ListenerEventReconnected, nil)
+	assertions.Eventually(journalListener.Listening, time.Second, time.Millisecond)
+	assertions.Equal(2, listener.listenCount())
+
+	// the notifications are broadcast
+	<-notifications
+	listener.notifications <- &pq.Notification{Channel: "journal"}
+	assertions.Eventually(func() bool { return len(notifications) == 1 }, time.Second, time.Millisecond)
+
+	// the channel is listened to again by the connection itself once listening has succeeded
+	journalListener.handleEvent(pq.ListenerEventDisconnected, errors.New("connection lost"))
+	assertions.False(journalListener.Listening())
+	journalListener.handleEvent(pq.ListenerEventReconnected, nil)
+	assertions.Eventually(journalListener.Listening, time.Second, time.Millisecond)
+	assertions.Equal(2, listener.listenCount())
+
+	assertions.NoError(journalListener.Close())
+	assertions.False(journalListener.Listening())
+}
+
+func TestNotifiedLiveQueries(t *testing.T) {
+	persistenceID := uuid.New().String()
+
+	// get instance of assert
+	assertions := assert.New(t)
+	provider := NewSQLProvider(context.TODO(), actor.NewActorSystem(), NewInMemoryDialect())
+	state := provider.GetState()
+	// the live queries would not poll the journal in time
+	readJournal := provider.ReadJournal(WithPollInterval(time.Minute), WithNotifiedPollInterval(time.Minute))
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	streams := map[string]*EventStream{
+		"by persistence ID": readJournal.EventsByPersistenceID(ctx, persistenceID, 1, 10),
+		"all events":        readJournal.AllEvents(ctx, 0),
+	}
+	persistenceIDs := readJournal.PersistenceIDs(ctx, "")
+
+	// let us wait for the queries to catch up before persisting
+	time.Sleep(50 * time.Millisecond)
+	state.PersistEvent(persistenceID, 1, &pb.AccountDebited{AccountNumber: persistenceID, Balance: 1})
+
+	for name, stream := range streams {
+		assertions.Equal([]float32{1}, balances(collectWithin(stream, 1, 5*time.Second)), name)
+	}
+
+	select {
+	case id := <-persistenceIDs.PersistenceIDs():
+		assertions.Equal(persistenceID, id)
+	case <-time.After(5 * time.Second):
+		assertions.Fail("the persistence ID has not been notified")
+	}
+}
+
+func TestNotificationsFallback(t *testing.T) {
+	persistenceID := uuid.New().String()
+
+	// get instance of assert
+	assertions := assert.New(t)
+	provider := NewSQLProvider(context.TODO(), actor.NewActorSystem(), &silentDialect{NewInMemoryDialect()})
+	state := provider.GetState()
+	readJournal := provider.ReadJournal(WithPollInterval(5*time.Millisecond), WithNotifiedPollInterval(time.Minute))
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	stream := readJournal.AllEvents(ctx, 0)
+
+	// the live query polls the journal while the notifications are not delivered
+	time.Sleep(50 * time.Millisecond)
+	state.PersistEvent(persistenceID, 1, &pb.AccountDebited{AccountNumber: persistenceID, Balance: 1})
+	assertions.Equal([]float32{1}, balances(collectWithin(stream, 1, 5*time.Second)))
+}
@@ -0,0 +1,286 @@
+package persistencesql
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+)
+
+// OutboxStatus is the delivery state of an outbox entry
+type OutboxStatus string
+
+const (
+	// OutboxPending states that the event is waiting to be published
+	OutboxPending OutboxStatus = "pending"
+	// OutboxDelivered states that the event has been published
+	OutboxDelivered OutboxStatus = "delivered"
+	// OutboxDeadLetter states that the event could not be published within the maximum number of attempts.
+	// It is no longer retried
+	OutboxDeadLetter OutboxStatus = "dead_letter"
+)
+
+const (
+	// maxLastErrorLength is the maximum length of the error recorded along with an outbox entry
+	maxLastErrorLength = 1024
+	// defaultOutboxBatchSize is the default number of outbox entries processed at once
+	defaultOutboxBatchSize = 100
+	// defaultMaxAttempts is the default number of attempts made to publish an event before dead-lettering it
+	defaultMaxAttempts = 10
+	// defaultMinBackoff is the default delay before retrying to publish an event the first time
+	defaultMinBackoff = time.Second
+	// defaultMaxBackoff is the default maximum delay before retrying to publish an event
+	defaultMaxBackoff = 5 * time.Minute
+	// defaultOutboxLease is the default time a relay has to publish the outbox entries it has claimed
+	defaultOutboxLease = time.Minute
+)
+
+// OutboxEntry is an event waiting to be published through the outbox, or which has been
+type OutboxEntry struct {
+	// the unique id of the outbox row
+	ID int64
+	// the copy of the journal row of the event
+	Journal *Journal
+	// the delivery state of the event
+	Status OutboxStatus
+	// the number of attempts made to publish the event
+	Attempts int
+	// the time before which the event is not published again, in milliseconds since midnight, January 1, 1970 UTC.
+	NextAttemptAt int64
+	// the error returned by the last failed attempt
+	LastError string
+}
+
+// Publisher publishes the events relayed from the outbox, e.g. to a message broker.
+// Events are published at least once: an event is published again when the relay stops, or its lease expires, before
+// recording its delivery, hence consumers must be idempotent. Events are published in order, except for the retried ones.
+// The context an event is published with is scoped to the tenant of the event, which is also set on its envelope
+type Publisher interface {
+	Publish(ctx context.Context, envelope *EventEnvelope) error
+}
+
+// OutboxRelay hands the events written to the outbox over to a Publisher. The events which fail to be published are
+// retried with an exponential backoff and dead-lettered once the maximum number of attempts is reached.
+// A relay relays the events of the tenant its context is scoped to or, when it is not scoped to any, the events of
+// every tenant when the tenants are resolved from the persistence IDs and the events of the tenant of the provider
+// context otherwise
+type OutboxRelay struct {
+	*SQLProvider
+
+	publisher Publisher
+	// the interval at which the outbox is polled once it has been drained
+	pollInterval time.Duration
+	// the number of entries processed at once
+	batchSize int
+	// the time the relay has to publish the entries it has claimed before another relay may claim them
+	lease time.Duration
+	// the number of attempts made to publish an event before dead-lettering it
+	maxAttempts int
+	// the bounds of the delay between two attempts
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// OutboxRelayOptFunc is a function that sets some options on the OutboxRelay
+type OutboxRelayOptFunc func(relay *OutboxRelay)
+
+// WithRelayPollInterval sets the interval at which the outbox is polled once it has been drained
+func WithRelayPollInterval(interval time.Duration) OutboxRelayOptFunc {
+	return func(relay *OutboxRelay) {
+		relay.pollInterval = interval
+	}
+}
+
+// WithRelayBatchSize sets the number of outbox entries processed at once. The entries are leased to the relay while
+// they are being published
+func WithRelayBatchSize(batchSize int) OutboxRelayOptFunc {
+	return func(relay *OutboxRelay) {
+		if batchSize < 1 {
+			batchSize = 1
+		}
+		relay.batchSize = batchSize
+	}
+}
+
+// WithRelayLease sets the time a relay has to publish a batch of outbox entries. The entries of a batch which has not
+// been published within the lease, e.g. because the relay has crashed, are claimed again by the next relay and hence
+// published again
+func WithRelayLease(lease time.Duration) OutboxRelayOptFunc {
+	return func(relay *OutboxRelay) {
+		relay.lease = lease
+	}
+}
+
+// WithMaxAttempts sets the number of attempts made to publish an event before dead-lettering it
+func WithMaxAttempts(attempts int) OutboxRelayOptFunc {
+	return func(relay *OutboxRelay) {
+		if attempts < 1 {
+			attempts = 1
+		}
+		relay.maxAttempts = attempts
+	}
+}
+
+// WithRetryBackoff sets the bounds of the delay between two attempts to publish an event. The delay starts at min
+// and doubles at every failed attempt, up to max
+func WithRetryBackoff(min, max time.Duration) OutboxRelayOptFunc {
+	return func(relay *OutboxRelay) {
+		relay.minBackoff = min
+		relay.maxBackoff = max
+	}
+}
+
+// OutboxRelay creates an OutboxRelay publishing the events written to the outbox with the given publisher.
+// The events are decoded the same way they are during recovery
+func (p *SQLProvider) OutboxRelay(publisher Publisher, opts ...OutboxRelayOptFunc) *OutboxRelay {
+	relay := &OutboxRelay{
+		SQLProvider:  p,
+		publisher:    publisher,
+		pollInterval: defaultPollInterval,
+		batchSize:    defaultOutboxBatchSize,
+		lease:        defaultOutboxLease,
+		maxAttempts:  defaultMaxAttempts,
+		minBackoff:   defaultMinBackoff,
+		maxBackoff:   defaultMaxBackoff,
+	}
+
+	for _, opt := range opts {
+		opt(relay)
+	}
+	return relay
+}
+
+// Run relays the events written to the outbox until the context is done, in which case it returns the context error.
+// Failing to process the outbox is logged and retried at the next poll interval
+func (r *OutboxRelay) Run(ctx context.Context) error {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		tenants, err := r.relayedTenants(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("failed to list the outbox tenants: %v", err)
+			}
+			timer.Reset(r.pollInterval)
+			continue
+		}
+
+		full := false
+		for _, tenant := range tenants {
+			processed, err := r.relay(WithTenant(ctx, tenant))
+			if err != nil && ctx.Err() == nil {
+				log.Printf("failed to relay the outbox of tenant: %s: %v", tenant, err)
+			}
+			full = full || (err == nil && processed == r.batchSize)
+		}
+
+		// let us keep on draining the outbox when a batch was full
+		if full {
+			timer.Reset(0)
+			continue
+		}
+		timer.Reset(r.pollInterval)
+	}
+}
+
+// relayedTenants returns the tenants the relay publishes the events of: the tenant of its context, the tenants that
+// have pending outbox entries when the tenants are resolved from the persistence IDs, the tenant of the provider
+// context otherwise
+func (r *OutboxRelay) relayedTenants(ctx context.Context) ([]string, error) {
+	if tenant, ok := TenantFromContext(ctx); ok {
+		return []string{tenant}, nil
+	}
+
+	if tenant, ok := TenantFromContext(r.ctx); ok || r.tenantResolver == nil {
+		return []string{tenant}, nil
+	}
+	return r.dialect.ListOutboxTenants(ctx)
+}
+
+// relay processes a batch of due outbox entries of the tenant ctx is scoped to. The entries are claimed and their
+// delivery states saved in two short transactions, none of them being open while the events are published.
+// It returns the number of entries processed
+func (r *OutboxRelay) relay(ctx context.Context) (int, error) {
+	now := time.Now()
+	entries, err := r.dialect.ClaimOutboxEntries(
+		ctx, now.UnixNano()/int64(time.Millisecond), now.Add(r.lease).UnixNano()/int64(time.Millisecond), r.batchSize,
+	)
+	if err != nil || len(entries) == 0 {
+		return 0, err
+	}
+
+	for _, entry := range entries {
+		// let us leave the remaining entries, which are saved unchanged, to the next relay
+		if ctx.Err() != nil {
+			break
+		}
+		r.publish(ctx, entry)
+	}
+
+	// the delivery states are saved even though ctx is done, lest the published events be published again
+	if err = r.dialect.UpdateOutboxEntries(WithTenant(context.Background(), tenantOf(ctx)), entries); err != nil {
+		return 0, err
+	}
+	return len(entries), ctx.Err()
+}
+
+// publish publishes the event of an outbox entry and updates its delivery state
+func (r *OutboxRelay) publish(ctx context.Context, entry *OutboxEntry) {
+	entry.Attempts++
+
+	err := func() error {
+		prepared, err := r.prepareJournal(ctx, entry.Journal)
+		if err != nil {
+			return err
+		}
+
+		event, err := r.eventDecoder(prepared)
+		if err != nil {
+			return err
+		}
+		return r.publisher.Publish(ctx, newEventEnvelope(entry.Journal, event))
+	}()
+
+	if err == nil {
+		entry.Status = OutboxDelivered
+		entry.LastError = ""
+		return
+	}
+
+	entry.LastError = err.Error()
+	if len(entry.LastError) > maxLastErrorLength {
+		entry.LastError = strings.ToValidUTF8(entry.LastError[:maxLastErrorLength], "")
+	}
+
+	if entry.Attempts >= r.maxAttempts {
+		entry.Status = OutboxDeadLetter
+		return
+	}
+	entry.NextAttemptAt = time.Now().Add(r.backoff(entry.Attempts)).UnixNano() / int64(time.Millisecond)
+}
+
+// backoff returns the delay before the next attempt given the number of attempts made so far
+func (r *OutboxRelay) backoff(attempts int) time.Duration {
+	delay := r.minBackoff
+	for i := 1; i < attempts && delay < r.maxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > r.maxBackoff {
+		delay = r.maxBackoff
+	}
+	return delay
+}
+
+// OutboxEntries fetches up to limit outbox entries in a given delivery state, oldest first.
+// It is meant to inspect the dead-lettered events
+func (r *OutboxRelay) OutboxEntries(ctx context.Context, status OutboxStatus, limit int) ([]*OutboxEntry, error) {
+	return r.dialect.GetOutboxEntries(ctx, status, limit)
+}
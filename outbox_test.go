@@ -0,0 +1,295 @@
+package persistencesql
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AsynkronIT/protoactor-go/actor"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	pb "github.com/tochemey/protoactor-persistence-sql/gen"
+)
+
+// recordingPublisher is a Publisher recording the events it publishes along with the tenant of their context.
+// It fails while err is set
+type recordingPublisher struct {
+	mu        sync.Mutex
+	envelopes []*EventEnvelope
+	tenants   []string
+	err       error
+}
+
+// Publish records an event
+func (p *recordingPublisher) Publish(ctx context.Context, envelope *EventEnvelope) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err != nil {
+		return p.err
+	}
+	p.envelopes = append(p.envelopes, envelope)
+	p.tenants = append(p.tenants, tenantOf(ctx))
+	return nil
+}
+
+// published returns the balances of the events published so far
+func (p *recordingPublisher) published() []float32 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return balances(p.envelopes)
+}
+
+// publisherFunc is a Publisher publishing the events with a function
+type publisherFunc func(ctx context.Context, envelope *EventEnvelope) error
+
+// Publish publishes an event
+func (f publisherFunc) Publish(ctx context.Context, envelope *EventEnvelope) error {
+	return f(ctx, envelope)
+}
+
+func TestOutboxRelay(t *testing.T) {
+	ctx := context.TODO()
+	persistenceID := uuid.New().String()
+
+	// get instance of assert
+	assertions := assert.New(t)
+	memoryDialect := NewInMemoryDialect()
+	provider := NewSQLProvider(ctx, actor.NewActorSystem(), memoryDialect, WithOutbox(), WithCompression(GzipCodec, 0))
+	state := provider.GetState()
+	for i := 1; i <= 3; i++ {
+		state.PersistEvent(persistenceID, i, &pb.AccountDebited{Balance: float32(i)})
+	}
+
+	publisher := &recordingPublisher{}
+	relay := provider.OutboxRelay(publisher, WithRelayBatchSize(2))
+
+	// the events are published in order, batch by batch
+	processed, err := relay.relay(ctx)
+	assertions.NoError(err)
+	assertions.Equal(2, processed)
+	processed, err = relay.relay(ctx)
+	assertions.NoError(err)
+	assertions.Equal(1, processed)
+	assertions.Equal([]float32{1, 2, 3}, publisher.published())
+	assertions.Equal(persistenceID, publisher.envelopes[0].PersistenceID)
+	assertions.Equal(1, publisher.envelopes[0].SequenceNumber)
+
+	// the published events are not published again
+	processed, err = relay.relay(ctx)
+	assertions.NoError(err)
+	assertions.Zero(processed)
+
+	delivered, err := relay.OutboxEntries(ctx, OutboxDelivered, 10)
+	assertions.NoError(err)
+	assertions.Len(delivered, 3)
+
+	// the events persisted without the outbox are not published
+	NewSQLProvider(ctx, actor.NewActorSystem(), memoryDialect).GetState().
+		PersistEvent(persistenceID, 4, &pb.AccountDebited{Balance: 4})
+	processed, err = relay.relay(ctx)
+	assertions.NoError(err)
+	assertions.Zero(processed)
+}
+
+func TestOutboxRelayRetries(t *testing.T) {
+	ctx := context.TODO()
+	persistenceID := uuid.New().String()
+
+	// get instance of assert
+	assertions := assert.New(t)
+	memoryDialect := NewInMemoryDialect()
+	provider := NewSQLProvider(ctx, actor.NewActorSystem(), memoryDialect, WithOutbox())
+	provider.GetState().PersistEvent(persistenceID, 1, &pb.AccountDebited{Balance: 1})
+	publisher := &recordingPublisher{err: errors.New("broker unavailable")}
+
+	// a failed event is not retried before its backoff elapses
+	relay := provider.OutboxRelay(publisher, WithRetryBackoff(time.Hour, time.Hour))
+	processed, err := relay.relay(ctx)
+	assertions.NoError(err)
+	assertions.Equal(1, processed)
+	processed, err = relay.relay(ctx)
+	assertions.NoError(err)
+	assertions.Zero(processed)
+
+	pending, err := relay.OutboxEntries(ctx, OutboxPending, 10)
+	assertions.NoError(err)
+	if assertions.Len(pending, 1) {
+		assertions.Equal(1, pending[0].Attempts)
+		assertions.Equal("broker unavailable", pending[0].LastError)
+		assertions.Greater(pending[0].NextAttemptAt, time.Now().UnixNano()/int64(time.Millisecond))
+	}
+
+	// a failed event is dead-lettered once the maximum number of attempts is reached
+	provider.GetState().PersistEvent(persistenceID, 2, &pb.AccountDebited{Balance: 2})
+	relay = provider.OutboxRelay(publisher, WithRetryBackoff(0, 0), WithMaxAttempts(2))
+	for i := 0; i < 3; i++ {
+		_, err = relay.relay(ctx)
+		assertions.NoError(err)
+	}
+
+	dead, err := relay.OutboxEntries(ctx, OutboxDeadLetter, 10)
+	assertions.NoError(err)
+	if assertions.Len(dead, 1) {
+		assertions.Equal(2, dead[0].Journal.SequenceNumber)
+		assertions.Equal(2, dead[0].Attempts)
+	}
+
+	// a retried event is published once the publisher recovers
+	publisher.err = nil
+	relay = provider.OutboxRelay(publisher)
+	_, err = relay.relay(ctx)
+	assertions.NoError(err)
+	assertions.Empty(publisher.published())
+
+	memoryDialect.mu.Lock()
+	memoryDialect.outbox[0].NextAttemptAt = 0
+	memoryDialect.mu.Unlock()
+	_, err = relay.relay(ctx)
+	assertions.NoError(err)
+	assertions.Equal([]float32{1}, publisher.published())
+}
+
+func TestOutboxRelayBackoff(t *testing.T) {
+	relay := NewSQLProvider(context.TODO(), actor.NewActorSystem(), NewInMemoryDialect()).
+		OutboxRelay(&recordingPublisher{}, WithRetryBackoff(time.Second, 10*time.Second))
+
+	testCases := map[string]struct {
+		attempts int
+		expected time.Duration
+	}{
+		"first attempt":  {attempts: 1, expected: time.Second},
+		"second attempt": {attempts: 2, expected: 2 * time.Second},
+		"fourth attempt": {attempts: 4, expected: 8 * time.Second},
+		"capped":         {attempts: 5, expected: 10 * time.Second},
+		"long after":     {attempts: 100, expected: 10 * time.Second},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(
+			name, func(t *testing.T) {
+				assert.Equal(t, testCase.expected, relay.backoff(testCase.attempts))
+			},
+		)
+	}
+}
+
+func TestOutboxRelayRun(t *testing.T) {
+	persistenceID := uuid.New().String()
+
+	// get instance of assert
+	assertions := assert.New(t)
+	provider := NewSQLProvider(context.TODO(), actor.NewActorSystem(), NewInMemoryDialect(), WithOutbox())
+	state := provider.GetState()
+	publisher := &recordingPublisher{}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	done := make(chan error, 1)
+	go func() {
+		done <- provider.OutboxRelay(publisher, WithRelayPollInterval(5*time.Millisecond)).Run(ctx)
+	}()
+
+	for i := 1; i <= 3; i++ {
+		state.PersistEvent(persistenceID, i, &pb.AccountDebited{Balance: float32(i)})
+	}
+	assertions.Eventually(func() bool {
+		return len(publisher.published()) == 3
+	}, time.Second, 5*time.Millisecond)
+	assertions.Equal([]float32{1, 2, 3}, publisher.published())
+
+	cancel()
+	assertions.ErrorIs(<-done, context.Canceled)
+}
+
+func TestOutboxRelayLease(t *testing.T) {
+	ctx := context.TODO()
+	persistenceID := uuid.New().String()
+
+	// get instance of assert
+	assertions := assert.New(t)
+	sqliteDialect, err := NewSQLiteDialect(NewSQLiteConfig(filepath.Join(t.TempDir(), "journal.db")))
+	assertions.NoError(err)
+	provider := NewSQLProvider(ctx, actor.NewActorSystem(), sqliteDialect, WithOutbox())
+	defer sqliteDialect.Close()
+	state := provider.GetState()
+	for i := 1; i <= 3; i++ {
+		state.PersistEvent(persistenceID, i, &pb.AccountDebited{Balance: float32(i)})
+	}
+
+	// the events are published outside of any transaction while they are leased to the relay, hence another relay
+	// can process the outbox meanwhile but does not claim them
+	otherPublisher := &recordingPublisher{}
+	otherRelay := provider.OutboxRelay(otherPublisher)
+	relayCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	envelopes := make([]*EventEnvelope, 0)
+	relay := provider.OutboxRelay(publisherFunc(func(ctx context.Context, envelope *EventEnvelope) error {
+		processed, err := otherRelay.relay(ctx)
+		assertions.NoError(err)
+		assertions.Zero(processed)
+
+		// the relay stops once the second event is published
+		envelopes = append(envelopes, envelope)
+		if len(envelopes) == 2 {
+			cancel()
+		}
+		return nil
+	}))
+
+	processed, err := relay.relay(relayCtx)
+	assertions.ErrorIs(err, context.Canceled)
+	assertions.Equal(3, processed)
+	assertions.Equal([]float32{1, 2}, balances(envelopes))
+
+	// the published events are delivered and the remaining one is released to the next relay
+	delivered, err := relay.OutboxEntries(ctx, OutboxDelivered, 10)
+	assertions.NoError(err)
+	assertions.Len(delivered, 2)
+	processed, err = otherRelay.relay(ctx)
+	assertions.NoError(err)
+	assertions.Equal(1, processed)
+	assertions.Equal([]float32{3}, otherPublisher.published())
+}
+
+func TestOutboxRelayTenants(t *testing.T) {
+	// get instance of assert
+	assertions := assert.New(t)
+	provider := NewSQLProvider(
+		context.TODO(), actor.NewActorSystem(), NewInMemoryDialect(), WithOutbox(),
+		WithTenantResolver(PrefixTenantResolver("/")),
+	)
+	state := provider.GetState()
+	state.PersistEvent("acme/account", 1, &pb.AccountDebited{Balance: 1})
+	state.PersistEvent("globex/account", 1, &pb.AccountDebited{Balance: 2})
+	state.PersistEvent("account", 1, &pb.AccountDebited{Balance: 3})
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	publisher := &recordingPublisher{}
+	relay := provider.OutboxRelay(publisher, WithRelayPollInterval(5*time.Millisecond))
+	go func() {
+		_ = relay.Run(ctx)
+	}()
+
+	// the events of every tenant are published within their tenant
+	assertions.Eventually(func() bool {
+		return len(publisher.published()) == 3
+	}, time.Second, 5*time.Millisecond)
+
+	publisher.mu.Lock()
+	defer publisher.mu.Unlock()
+	tenants := make(map[string]string)
+	for i, envelope := range publisher.envelopes {
+		assertions.Equal(envelope.TenantID, publisher.tenants[i])
+		tenants[envelope.PersistenceID] = envelope.TenantID
+	}
+	assertions.Equal(map[string]string{"acme/account": "acme", "globex/account": "globex", "account": ""}, tenants)
+
+	// a relay scoped to a tenant only relays the events of that tenant
+	relayed, err := relay.relayedTenants(WithTenant(ctx, "acme"))
+	assertions.NoError(err)
+	assertions.Equal([]string{"acme"}, relayed)
+}
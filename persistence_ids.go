@@ -0,0 +1,148 @@
+package persistencesql
+
+import (
+	"context"
+	"time"
+)
+
+// PersistenceIDStream is the stream of persistence IDs delivered by a ReadJournal query
+type PersistenceIDStream struct {
+	persistenceIDs chan string
+	err            error
+}
+
+// PersistenceIDs returns the channel the persistence IDs are delivered over. The channel is closed when the stream
+// completes
+func (s *PersistenceIDStream) PersistenceIDs() <-chan string {
+	return s.persistenceIDs
+}
+
+// Err returns the error that made the stream complete. It returns the context error when the context of the query
+// is done. It must only be called once the persistence IDs channel has been closed
+func (s *PersistenceIDStream) Err() error {
+	return s.err
+}
+
+// CurrentPersistenceIDs lists up to limit persistence IDs starting with the given prefix, in ascending order.
+// Listing starts right after afterID: callers page through the persistence IDs by passing back the last one listed.
+// An empty prefix matches every persistence ID and an empty afterID starts from the first one
+func (r *ReadJournal) CurrentPersistenceIDs(
+	ctx context.Context, prefix string, afterID string, limit int,
+) ([]string, error) {
+	return r.dialect.ListPersistenceIDs(ctx, prefix, afterID, limit)
+}
+
+// PersistenceIDs delivers every persistence ID starting with the given prefix and keeps on delivering the new ones
+// as they appear until the context is done. Every persistence ID is delivered once.
+// The persistence IDs stored at the time of the query are listed first. The new ones are then followed by the ordering
+// of their first journal row, hence every poll only reads the persistence IDs created in the meantime
+func (r *ReadJournal) PersistenceIDs(ctx context.Context, prefix string) *PersistenceIDStream {
+	stream := &PersistenceIDStream{persistenceIDs: make(chan string)}
+	go func() {
+		defer close(stream.persistenceIDs)
+
+		notifications, unsubscribe := r.subscribe()
+		defer unsubscribe()
+
+		listed := make(map[string]bool)
+		afterID := ""
+		for {
+			persistenceIDs, err := r.dialect.ListPersistenceIDs(ctx, prefix, afterID, r.replayPageSize)
+			if err != nil {
+				stream.err = err
+				return
+			}
+
+			for _, persistenceID := range persistenceIDs {
+				listed[persistenceID] = true
+				if stream.err = stream.send(ctx, persistenceID); stream.err != nil {
+					return
+				}
+			}
+
+			// a partial page means every persistence ID has been listed
+			if len(persistenceIDs) < r.replayPageSize {
+				break
+			}
+			afterID = persistenceIDs[len(persistenceIDs)-1]
+		}
+
+		tail := &newPersistenceIDs{listed: listed, delivered: make(map[string]int64)}
+		for {
+			if stream.err = r.followNewPersistenceIDs(ctx, prefix, tail, stream); stream.err != nil {
+				return
+			}
+
+			if stream.err = r.wait(ctx, notifications); stream.err != nil {
+				return
+			}
+		}
+	}()
+
+	return stream
+}
+
+// send delivers a persistence ID unless the context is done
+func (s *PersistenceIDStream) send(ctx context.Context, persistenceID string) error {
+	select {
+	case s.persistenceIDs <- persistenceID:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// newPersistenceIDs tracks the first journal rows a persistence IDs query follows
+type newPersistenceIDs struct {
+	// the persistence IDs listed when the query started
+	listed map[string]bool
+	// the ordering up to which the first journal rows have all been read. The rows stored within the gap timeout can
+	// still be preceded by rows committed late, hence they are read again until they are settled
+	settled int64
+	// the persistence IDs delivered past the settled ordering, along with the ordering of their first journal row
+	delivered map[string]int64
+}
+
+// followNewPersistenceIDs delivers the persistence IDs whose first journal row has been stored since the last call
+func (r *ReadJournal) followNewPersistenceIDs(
+	ctx context.Context, prefix string, tail *newPersistenceIDs, stream *PersistenceIDStream,
+) error {
+	from := tail.settled
+	for {
+		journals, err := r.dialect.ListNewPersistenceIDs(ctx, prefix, from, r.replayPageSize)
+		if err != nil {
+			return err
+		}
+
+		for _, journal := range journals {
+			// no transaction stays in-flight longer than the gap timeout, hence no row can be committed before a row
+			// stored longer than that ago anymore
+			if time.Since(time.Unix(journal.Timestamp, 0)) >= r.gapTimeout && journal.Ordering > tail.settled {
+				tail.settled = journal.Ordering
+			}
+
+			if _, ok := tail.delivered[journal.PersistenceID]; ok || tail.listed[journal.PersistenceID] {
+				continue
+			}
+
+			tail.delivered[journal.PersistenceID] = journal.Ordering
+			if err = stream.send(ctx, journal.PersistenceID); err != nil {
+				return err
+			}
+		}
+
+		// a partial page means every new persistence ID has been read
+		if len(journals) < r.replayPageSize {
+			break
+		}
+		from = journals[len(journals)-1].Ordering
+	}
+
+	// the settled rows are not read again
+	for persistenceID, ordering := range tail.delivered {
+		if ordering <= tail.settled {
+			delete(tail.delivered, persistenceID)
+		}
+	}
+	return nil
+}
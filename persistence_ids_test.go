@@ -0,0 +1,112 @@
+package persistencesql
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AsynkronIT/protoactor-go/actor"
+	"github.com/stretchr/testify/assert"
+	pb "github.com/tochemey/protoactor-persistence-sql/gen"
+)
+
+func TestCurrentPersistenceIDs(t *testing.T) {
+	ctx := context.TODO()
+	memoryDialect := NewInMemoryDialect()
+	provider := NewSQLProvider(ctx, actor.NewActorSystem(), memoryDialect)
+	state := provider.GetState()
+	for _, persistenceID := range []string{"account-2", "user-1", "account-1", "account-3"} {
+		state.PersistEvent(persistenceID, 1, &pb.AccountDebited{AccountNumber: persistenceID})
+	}
+	readJournal := provider.ReadJournal()
+
+	testCases := map[string]struct {
+		prefix   string
+		afterID  string
+		limit    int
+		expected []string
+	}{
+		"all":          {limit: 10, expected: []string{"account-1", "account-2", "account-3", "user-1"}},
+		"first page":   {limit: 2, expected: []string{"account-1", "account-2"}},
+		"next page":    {afterID: "account-2", limit: 2, expected: []string{"account-3", "user-1"}},
+		"prefix":       {prefix: "account-", limit: 10, expected: []string{"account-1", "account-2", "account-3"}},
+		"prefix page":  {prefix: "account-", afterID: "account-1", limit: 1, expected: []string{"account-2"}},
+		"no match":     {prefix: "order-", limit: 10, expected: []string{}},
+		"after latest": {afterID: "user-1", limit: 10, expected: []string{}},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(
+			name, func(t *testing.T) {
+				// get instance of assert
+				assertions := assert.New(t)
+
+				persistenceIDs, err := readJournal.CurrentPersistenceIDs(
+					ctx, testCase.prefix, testCase.afterID, testCase.limit,
+				)
+				assertions.NoError(err)
+				assertions.Equal(testCase.expected, persistenceIDs)
+			},
+		)
+	}
+}
+
+// listingDialect counts the listings of the persistence IDs
+type listingDialect struct {
+	*InMemoryDialect
+	listings int32
+}
+
+// ListPersistenceIDs lists the persistence IDs
+func (d *listingDialect) ListPersistenceIDs(
+	ctx context.Context, prefix string, afterID string, limit int,
+) ([]string, error) {
+	atomic.AddInt32(&d.listings, 1)
+	return d.InMemoryDialect.ListPersistenceIDs(ctx, prefix, afterID, limit)
+}
+
+func TestPersistenceIDs(t *testing.T) {
+	// get instance of assert
+	assertions := assert.New(t)
+	dialect := &listingDialect{InMemoryDialect: NewInMemoryDialect()}
+	provider := NewSQLProvider(context.TODO(), actor.NewActorSystem(), dialect, WithReplayPageSize(2))
+	state := provider.GetState()
+	for _, persistenceID := range []string{"account-2", "user-1", "account-1", "account-3"} {
+		state.PersistEvent(persistenceID, 1, &pb.AccountDebited{AccountNumber: persistenceID})
+	}
+	readJournal := provider.ReadJournal(WithPollInterval(5 * time.Millisecond))
+
+	// receive reads the given number of persistence IDs from the stream
+	receive := func(stream *PersistenceIDStream, count int) []string {
+		persistenceIDs := make([]string, 0)
+		for persistenceID := range stream.PersistenceIDs() {
+			persistenceIDs = append(persistenceIDs, persistenceID)
+			if len(persistenceIDs) == count {
+				break
+			}
+		}
+		return persistenceIDs
+	}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	stream := readJournal.PersistenceIDs(ctx, "account-")
+	assertions.Equal([]string{"account-1", "account-2", "account-3"}, receive(stream, 3))
+
+	// only the new persistence IDs matching the prefix are delivered, whatever their position
+	state.PersistEvent("account-1", 2, &pb.AccountDebited{AccountNumber: "account-1"})
+	state.PersistEvent("user-2", 1, &pb.AccountDebited{AccountNumber: "user-2"})
+	state.PersistEvent("account-0", 1, &pb.AccountDebited{AccountNumber: "account-0"})
+	assertions.Equal([]string{"account-0"}, receive(stream, 1))
+	// the persistent actors start at sequence number 0
+	state.PersistEvent("account-4", 0, &pb.AccountDebited{AccountNumber: "account-4"})
+	assertions.Equal([]string{"account-4"}, receive(stream, 1))
+
+	// the new persistence IDs are followed without listing all of them again
+	assertions.EqualValues(2, atomic.LoadInt32(&dialect.listings))
+
+	// the stream completes when the context is canceled
+	cancel()
+	assertions.Empty(receive(stream, -1))
+	assertions.ErrorIs(stream.Err(), context.Canceled)
+}
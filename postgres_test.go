@@ -2,13 +2,12 @@ package persistencesql
 
 import (
 	"context"
-	"math"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	pb "github.com/tochemey/protoactor-persistence-sql/gen"
-	"google.golang.org/protobuf/proto"
 )
 
 func TestPostgresConnection(t *testing.T) {
@@ -80,9 +79,6 @@ func TestPostgresConnection(t *testing.T) {
 
 func TestPostgresSQLDialect(t *testing.T) {
 	ctx := context.TODO()
-	numEvents := 10
-	numSnapshots := 3
-	persistenceID := uuid.New().String()
 
 	// set the database config
 	config := NewDBConfig(
@@ -113,81 +109,47 @@ func TestPostgresSQLDialect(t *testing.T) {
 	// check whether both tables have been created
 	err = tableExist(postgresHandle, POSTGRES, "public", "journal")
 	assertions.NoError(err)
-	assertions.Nil(err)
 	err = tableExist(postgresHandle, POSTGRES, "public", "snapshot")
 	assertions.NoError(err)
 	assertions.Nil(err)
+}
 
-	// insert events into the journal store
-	for i := 0; i < numEvents; i++ {
-		persistenceID := uuid.New().String()
-		journal := NewJournal(persistenceID, &pb.AccountDebited{
-			AccountNumber: persistenceID,
-			Balance:       float32(i * 100),
-		}, i+1, "some-actor-pid")
-
-		err = postgresDialect.PersistJournal(ctx, journal)
-		assertions.NoError(err)
-		assertions.Nil(err)
-	}
-
-	// insert some data into the snapshot store
-	for i := 0; i < numSnapshots; i++ {
-		snapshot := NewSnapshot(persistenceID, &pb.Account{
-			AccountNumber: persistenceID,
-			ActualBalance: float32(i * 100),
-		}, i+1, "some-actor-pid")
-
-		err = postgresDialect.PersistSnapshot(ctx, snapshot)
-		assertions.NoError(err)
-		assertions.Nil(err)
-	}
-
-	// let us count the number of elements in the journal and snapshot
-	count := countJournal(postgresHandle)
-	assertions.Equal(numEvents, count)
-	count = countSnapshot(postgresHandle)
-	assertions.Equal(numSnapshots, count)
+func TestPostgresNotificationsMixedCaseTable(t *testing.T) {
+	ctx := context.TODO()
+	persistenceID := uuid.New().String()
 
-	// let us fetch the latest snapshot for the given persistenceId
-	// and perform some assertions
-	latest, err := postgresDialect.GetLatestSnapshot(ctx, persistenceID)
+	// get instance of assert
+	assertions := assert.New(t)
+	config := PostgresTestConfig()
+	WithNotifications()(config)
+	WithTableNames(TableNames{
+		Journal: "NotifiedJournal", Snapshot: "NotifiedSnapshot", Tag: "NotifiedTag", Writer: "NotifiedWriter",
+		ProjectionOffset: "NotifiedProjection", Outbox: "NotifiedOutbox", SchemaVersion: "NotifiedSchemaVersion",
+	})(config)
+	postgresDialect, err := NewPostgresDialect(config)
 	assertions.NoError(err)
-	assertions.Equal(latest.SequenceNumber, 3)
-	assertions.Equal(string(latest.SnapshotManifest), string(proto.MessageName(&pb.Account{})))
-	snapshot, ok := (latest.message()).(*pb.Account)
-	assertions.True(ok)
-	assertions.Equal(snapshot.ActualBalance, float32(200))
-
-	// let fetch some events from the journal store
-	for i := 0; i < numEvents; i++ {
-		journal := NewJournal(persistenceID, &pb.AccountDebited{
-			AccountNumber: persistenceID,
-			Balance:       float32(i * 100),
-		}, i+1, "some-actor-pid")
-
-		err = postgresDialect.PersistJournal(ctx, journal)
-		assertions.NoError(err)
-		assertions.Nil(err)
+	assertions.NoError(postgresDialect.Connect(ctx))
+	defer func() {
+		_ = postgresDialect.Close()
+	}()
+	assertions.NoError(postgresDialect.CreateSchemasIfNotExist(ctx))
+
+	notifier := postgresDialect.(Notifier)
+	notifications, unsubscribe := notifier.Subscribe()
+	defer unsubscribe()
+	assertions.Eventually(notifier.Listening, 5*time.Second, 10*time.Millisecond)
+	// the subscribers are woken up once listening has succeeded
+	for len(notifications) > 0 {
+		<-notifications
 	}
 
-	journals, err := postgresDialect.GetJournals(ctx, persistenceID, 2, 6)
-	assertions.NoError(err)
-	assertions.NotNil(journals)
-	assertions.Equal(len(journals), 5)
-
-	// delete some events from the journal
-	err = postgresDialect.DeleteJournals(ctx, persistenceID, 2, true)
-	assertions.NoError(err)
-
-	// check the number of events remaining for the given persistence ID
-	journals, err = postgresDialect.GetJournals(ctx, persistenceID, 1, math.MaxInt32)
-	assertions.NoError(err)
-	assertions.NotNil(journals)
-	assertions.Equal(len(journals), 8)
-
-	// delete some snapshots
-	err = postgresDialect.DeleteSnapshots(ctx, persistenceID, 2)
+	// the new journal rows are notified on the channel named after the journal table, whatever its case
+	journal, err := NewJournal(persistenceID, &pb.AccountDebited{AccountNumber: persistenceID}, 1, "writer")
 	assertions.NoError(err)
-
+	assertions.NoError(postgresDialect.PersistJournal(ctx, journal))
+	select {
+	case <-notifications:
+	case <-time.After(5 * time.Second):
+		assertions.Fail("the new journal row has not been notified")
+	}
 }
@@ -0,0 +1,162 @@
+package persistencesql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Projection handles the events consumed by a ProjectionRunner to build a read model.
+// Events are delivered at least once: the events handled since the last saved offset are delivered again when the
+// runner is restarted, hence Handle must be idempotent
+type Projection interface {
+	Handle(ctx context.Context, envelope *EventEnvelope) error
+}
+
+// TransactionalProjection handles the events consumed by a ProjectionRunner within the transaction the offset of the
+// event is saved in. Events are delivered exactly once as long as the read model is updated using the given
+// transaction, which requires the read model to live in the same database as the journal
+type TransactionalProjection interface {
+	Handle(ctx context.Context, tx *sql.Tx, envelope *EventEnvelope) error
+}
+
+// ProjectionRunner feeds a projection with the events of the journal, either all of them or the ones of a given tag,
+// and keeps track of the offset the projection has reached in the projection_offset table. A stopped runner resumes
+// from the offset it has reached
+type ProjectionRunner struct {
+	readJournal *ReadJournal
+	// the unique name of the projection, under which its offset is saved
+	name string
+	// handles the events at least once
+	handler Projection
+	// handles the events exactly once
+	transactionalHandler TransactionalProjection
+	// the tag of the events to consume. All the events are consumed when not set
+	tag string
+	// the number of events handled between two offset saves in at-least-once mode
+	saveInterval int
+}
+
+// ProjectionOptFunc is a function that sets some options on the ProjectionRunner
+type ProjectionOptFunc func(runner *ProjectionRunner)
+
+// WithProjectionTag makes the projection consume the events of the given tag only
+func WithProjectionTag(tag string) ProjectionOptFunc {
+	return func(runner *ProjectionRunner) {
+		runner.tag = tag
+	}
+}
+
+// WithOffsetSaveInterval saves the offset of an at-least-once projection every given number of events rather than
+// after every event. It trades fewer writes for more events delivered again on restart. It has no effect on
+// exactly-once projections, which save the offset of every event along with the changes made to the read model
+func WithOffsetSaveInterval(events int) ProjectionOptFunc {
+	return func(runner *ProjectionRunner) {
+		if events < 1 {
+			events = 1
+		}
+		runner.saveInterval = events
+	}
+}
+
+// NewProjectionRunner creates a ProjectionRunner delivering the events at least once to the given projection
+func NewProjectionRunner(
+	readJournal *ReadJournal, name string, projection Projection, opts ...ProjectionOptFunc,
+) *ProjectionRunner {
+	runner := newProjectionRunner(readJournal, name, opts...)
+	runner.handler = projection
+	return runner
+}
+
+// NewTransactionalProjectionRunner creates a ProjectionRunner delivering the events exactly once to the given
+// projection. The dialect must support database transactions: running the ProjectionRunner against the
+// InMemoryDialect fails with ErrTransactionsUnsupported
+func NewTransactionalProjectionRunner(
+	readJournal *ReadJournal, name string, projection TransactionalProjection, opts ...ProjectionOptFunc,
+) *ProjectionRunner {
+	runner := newProjectionRunner(readJournal, name, opts...)
+	runner.transactionalHandler = projection
+	return runner
+}
+
+// newProjectionRunner creates an instance of ProjectionRunner
+func newProjectionRunner(readJournal *ReadJournal, name string, opts ...ProjectionOptFunc) *ProjectionRunner {
+	runner := &ProjectionRunner{
+		readJournal:  readJournal,
+		name:         name,
+		saveInterval: 1,
+	}
+
+	for _, opt := range opts {
+		opt(runner)
+	}
+	return runner
+}
+
+// Offset returns the offset the projection has reached, i.e. the ordering of the last event it has handled
+func (r *ProjectionRunner) Offset(ctx context.Context) (int64, error) {
+	return r.readJournal.dialect.GetProjectionOffset(ctx, r.name)
+}
+
+// Reset moves the projection to the given offset: the events stored after it are delivered on the next run.
+// Offset 0 rebuilds the read model from the beginning. It must not be called while the runner is running
+func (r *ProjectionRunner) Reset(ctx context.Context, offset int64) error {
+	return r.readJournal.dialect.SaveProjectionOffset(ctx, r.name, offset, nil)
+}
+
+// Run feeds the projection with the events stored after the offset it has reached, then with the new ones as they
+// are stored. It blocks until the context is done, in which case it returns the context error, or until the
+// projection fails to handle an event, in which case the offset is left right before that event
+func (r *ProjectionRunner) Run(ctx context.Context) error {
+	offset, err := r.Offset(ctx)
+	if err != nil {
+		return err
+	}
+
+	// the stream is stopped as soon as the projection fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var stream *EventStream
+	if r.tag != "" {
+		stream = r.readJournal.EventsByTag(ctx, r.tag, offset)
+	} else {
+		stream = r.readJournal.AllEvents(ctx, offset)
+	}
+
+	handled := 0
+	for envelope := range stream.Events() {
+		if err = r.handle(ctx, envelope, &handled); err != nil {
+			cancel()
+			// let us wait for the stream to complete
+			for range stream.Events() {
+			}
+			return fmt.Errorf("projection %s failed at offset %d: %w", r.name, envelope.Ordering, err)
+		}
+	}
+
+	return stream.Err()
+}
+
+// handle hands an event over to the projection and saves its offset when due
+func (r *ProjectionRunner) handle(ctx context.Context, envelope *EventEnvelope, handled *int) error {
+	if r.transactionalHandler != nil {
+		return r.readJournal.dialect.SaveProjectionOffset(
+			ctx, r.name, envelope.Ordering, func(tx *sql.Tx) error {
+				return r.transactionalHandler.Handle(ctx, tx, envelope)
+			},
+		)
+	}
+
+	if err := r.handler.Handle(ctx, envelope); err != nil {
+		return err
+	}
+
+	*handled++
+	if *handled < r.saveInterval {
+		return nil
+	}
+
+	*handled = 0
+	return r.readJournal.dialect.SaveProjectionOffset(ctx, r.name, envelope.Ordering, nil)
+}
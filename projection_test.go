@@ -0,0 +1,268 @@
+package persistencesql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AsynkronIT/protoactor-go/actor"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	pb "github.com/tochemey/protoactor-persistence-sql/gen"
+	"google.golang.org/protobuf/proto"
+)
+
+// projectionFunc is a Projection handling the events with a function
+type projectionFunc func(ctx context.Context, envelope *EventEnvelope) error
+
+// Handle handles an event
+func (f projectionFunc) Handle(ctx context.Context, envelope *EventEnvelope) error {
+	return f(ctx, envelope)
+}
+
+// transactionalProjectionFunc is a TransactionalProjection handling the events with a function
+type transactionalProjectionFunc func(ctx context.Context, tx *sql.Tx, envelope *EventEnvelope) error
+
+// Handle handles an event
+func (f transactionalProjectionFunc) Handle(ctx context.Context, tx *sql.Tx, envelope *EventEnvelope) error {
+	return f(ctx, tx, envelope)
+}
+
+// runProjection runs a projection until the given number of events has been handled. It returns the balances of the
+// events handled and the error returned by the runner
+func runProjection(runner *ProjectionRunner, handled <-chan float32, count int) ([]float32, error) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	done := make(chan error, 1)
+	go func() {
+		done <- runner.Run(ctx)
+	}()
+
+	balances := make([]float32, 0)
+	for len(balances) < count {
+		select {
+		case balance := <-handled:
+			balances = append(balances, balance)
+		case err := <-done:
+			cancel()
+			// the events handled before the runner stopped may still be buffered
+			for len(handled) > 0 {
+				balances = append(balances, <-handled)
+			}
+			return balances, err
+		}
+	}
+
+	cancel()
+	return balances, <-done
+}
+
+func TestProjectionRunner(t *testing.T) {
+	persistenceID := uuid.New().String()
+
+	// get instance of assert
+	assertions := assert.New(t)
+	memoryDialect := NewInMemoryDialect()
+	provider := NewSQLProvider(context.TODO(), actor.NewActorSystem(), memoryDialect)
+	state := provider.GetState()
+	readJournal := provider.ReadJournal(WithPollInterval(5 * time.Millisecond))
+	for i := 1; i <= 3; i++ {
+		state.PersistEvent(persistenceID, i, &pb.AccountDebited{Balance: float32(i)})
+	}
+
+	handled := make(chan float32)
+	failAt := float32(0)
+	runner := NewProjectionRunner(
+		readJournal, "balances", projectionFunc(func(ctx context.Context, envelope *EventEnvelope) error {
+			balance := envelope.Event.(*pb.AccountDebited).GetBalance()
+			if balance == failAt {
+				return errors.New("failure")
+			}
+
+			select {
+			case handled <- balance:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}),
+	)
+
+	// the projection consumes the stored events and stops when the context is canceled
+	balances, err := runProjection(runner, handled, 3)
+	assertions.Equal([]float32{1, 2, 3}, balances)
+	assertions.ErrorIs(err, context.Canceled)
+	offset, err := runner.Offset(context.TODO())
+	assertions.NoError(err)
+	assertions.EqualValues(3, offset)
+
+	// a failing projection stops right before the failing event
+	state.PersistEvent(persistenceID, 4, &pb.AccountDebited{Balance: 4})
+	state.PersistEvent(persistenceID, 5, &pb.AccountDebited{Balance: 5})
+	failAt = 5
+	balances, err = runProjection(runner, handled, 2)
+	assertions.Equal([]float32{4}, balances)
+	assertions.Error(err)
+	assertions.NotErrorIs(err, context.Canceled)
+	offset, err = runner.Offset(context.TODO())
+	assertions.NoError(err)
+	assertions.EqualValues(4, offset)
+
+	// the restarted projection resumes with the failed event
+	failAt = 0
+	balances, err = runProjection(runner, handled, 1)
+	assertions.Equal([]float32{5}, balances)
+	assertions.ErrorIs(err, context.Canceled)
+
+	// the projection is rebuilt from a given offset once reset
+	assertions.NoError(runner.Reset(context.TODO(), 2))
+	balances, _ = runProjection(runner, handled, 3)
+	assertions.Equal([]float32{3, 4, 5}, balances)
+}
+
+func TestProjectionRunnerOffsetSaveInterval(t *testing.T) {
+	persistenceID := uuid.New().String()
+
+	// get instance of assert
+	assertions := assert.New(t)
+	memoryDialect := NewInMemoryDialect()
+	provider := NewSQLProvider(context.TODO(), actor.NewActorSystem(), memoryDialect)
+	state := provider.GetState()
+	for i := 1; i <= 5; i++ {
+		state.PersistEvent(persistenceID, i, &pb.AccountDebited{Balance: float32(i)})
+	}
+
+	handled := make(chan float32, 5)
+	runner := NewProjectionRunner(
+		provider.ReadJournal(WithPollInterval(5*time.Millisecond)), "balances",
+		projectionFunc(func(ctx context.Context, envelope *EventEnvelope) error {
+			handled <- envelope.Event.(*pb.AccountDebited).GetBalance()
+			return nil
+		}),
+		WithOffsetSaveInterval(2),
+	)
+
+	balances, _ := runProjection(runner, handled, 5)
+	assertions.Equal([]float32{1, 2, 3, 4, 5}, balances)
+
+	// the offset of the last event has not been saved yet
+	offset, err := runner.Offset(context.TODO())
+	assertions.NoError(err)
+	assertions.EqualValues(4, offset)
+}
+
+func TestProjectionRunnerShreddedEvent(t *testing.T) {
+	ctx := context.TODO()
+	persistenceID := uuid.New().String()
+	shreddedID := uuid.New().String()
+
+	// get instance of assert
+	assertions := assert.New(t)
+	memoryDialect := NewInMemoryDialect()
+	provider := NewSQLProvider(ctx, actor.NewActorSystem(), memoryDialect, WithEncryption(NewInMemoryKeyProvider()))
+	state := provider.GetState()
+	state.PersistEvent(persistenceID, 1, &pb.AccountDebited{Balance: 1})
+	state.PersistEvent(shreddedID, 1, &pb.AccountDebited{Balance: 2})
+	state.PersistEvent(persistenceID, 2, &pb.AccountDebited{Balance: 3})
+	assertions.NoError(provider.CryptoShred(ctx, shreddedID))
+
+	handled := make(chan float32, 2)
+	runner := NewProjectionRunner(
+		provider.ReadJournal(WithPollInterval(5*time.Millisecond)), "balances",
+		projectionFunc(func(ctx context.Context, envelope *EventEnvelope) error {
+			handled <- envelope.Event.(*pb.AccountDebited).GetBalance()
+			return nil
+		}),
+	)
+
+	// the projection moves past the shredded event
+	balances, err := runProjection(runner, handled, 2)
+	assertions.Equal([]float32{1, 3}, balances)
+	assertions.ErrorIs(err, context.Canceled)
+	offset, err := runner.Offset(ctx)
+	assertions.NoError(err)
+	assertions.EqualValues(3, offset)
+}
+
+func TestTransactionalProjectionRunner(t *testing.T) {
+	ctx := context.TODO()
+	persistenceID := uuid.New().String()
+	otherPersistenceID := uuid.New().String()
+
+	// get instance of assert
+	assertions := assert.New(t)
+	sqliteDialect, err := NewSQLiteDialect(NewSQLiteConfig(filepath.Join(t.TempDir(), "journal.db")))
+	assertions.NoError(err)
+	tagger := func(persistenceID string, _ proto.Message) []string {
+		return []string{persistenceID}
+	}
+	provider := NewSQLProvider(ctx, actor.NewActorSystem(), sqliteDialect, WithTagger(tagger))
+	defer sqliteDialect.Close()
+	state := provider.GetState()
+	for i := 1; i <= 3; i++ {
+		state.PersistEvent(persistenceID, i, &pb.AccountDebited{Balance: float32(i)})
+		state.PersistEvent(otherPersistenceID, i, &pb.AccountDebited{Balance: float32(10 * i)})
+	}
+
+	// the read model lives in the same database as the journal
+	db := sqliteDialect.(*dialect).db
+	_, err = db.Exec("CREATE TABLE balances (balance REAL NOT NULL)")
+	assertions.NoError(err)
+
+	handled := make(chan float32, 3)
+	runner := NewTransactionalProjectionRunner(
+		provider.ReadJournal(WithPollInterval(5*time.Millisecond)), "other-balances",
+		transactionalProjectionFunc(func(ctx context.Context, tx *sql.Tx, envelope *EventEnvelope) error {
+			balance := envelope.Event.(*pb.AccountDebited).GetBalance()
+			if _, err := tx.ExecContext(ctx, "INSERT INTO balances (balance) VALUES (?)", balance); err != nil {
+				return err
+			}
+
+			if balance == 30 {
+				return errors.New("failure")
+			}
+
+			handled <- balance
+			return nil
+		}),
+		WithProjectionTag(otherPersistenceID),
+	)
+
+	// only the tagged events are consumed and neither the offset nor the changes of the failed event are saved
+	balances, err := runProjection(runner, handled, 3)
+	assertions.Equal([]float32{10, 20}, balances)
+	assertions.Error(err)
+
+	offset, err := runner.Offset(ctx)
+	assertions.NoError(err)
+	assertions.EqualValues(4, offset)
+
+	var count int
+	assertions.NoError(db.QueryRow("SELECT COUNT(*) FROM balances").Scan(&count))
+	assertions.Equal(2, count)
+}
+
+func TestTransactionalProjectionRunnerInMemory(t *testing.T) {
+	persistenceID := uuid.New().String()
+
+	// get instance of assert
+	assertions := assert.New(t)
+	provider := NewSQLProvider(context.TODO(), actor.NewActorSystem(), NewInMemoryDialect())
+	provider.GetState().PersistEvent(persistenceID, 1, &pb.AccountDebited{Balance: 1})
+
+	// the in-memory dialect has no database transaction to run the projection in
+	handled := make(chan float32, 1)
+	runner := NewTransactionalProjectionRunner(
+		provider.ReadJournal(WithPollInterval(5*time.Millisecond)), "balances",
+		transactionalProjectionFunc(func(ctx context.Context, tx *sql.Tx, envelope *EventEnvelope) error {
+			handled <- envelope.Event.(*pb.AccountDebited).GetBalance()
+			return nil
+		}),
+	)
+
+	balances, err := runProjection(runner, handled, 1)
+	assertions.Empty(balances)
+	assertions.ErrorIs(err, ErrTransactionsUnsupported)
+}
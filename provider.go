@@ -2,18 +2,25 @@ package persistencesql
 
 import (
 	"context"
+	"errors"
 	"log"
+	"time"
 
 	"github.com/AsynkronIT/protoactor-go/actor"
 	"github.com/AsynkronIT/protoactor-go/persistence"
+	"github.com/google/uuid"
 )
 
 type OptFunc = func(provider *SQLProvider)
 
+// defaultReplayPageSize is the default number of events fetched at once during recovery
+const defaultReplayPageSize = 500
+
 // SQLProvider defines a generic persistence provider.
 // The type of provider is determined by the type of SQLDialect defined
 type SQLProvider struct {
 	writer  *actor.PID
+	root    *actor.RootContext
 	dialect SQLDialect
 
 	ctx context.Context
@@ -24,6 +31,56 @@ type SQLProvider struct {
 
 	// Set the snapshot interval
 	snapshotInterval int
+
+	// decides how to proceed when a persistence operation fails
+	errorHandler ErrorHandler
+
+	// turns journal rows into events during recovery
+	eventDecoder EventDecoder
+	// encodes the events and the snapshots
+	serializer Serializer
+	// decodes the rows written by the former serializers
+	formerSerializers []Serializer
+	// decode the events and the snapshots, by serializer ID
+	serializers serializers
+	// upcasts the events and the snapshots written with an old schema
+	upcasters *UpcasterRegistry
+	// the codec the payloads are compressed with
+	compression Codec
+	// the minimum size in bytes of the payloads to compress
+	compressionThreshold int
+	// hands over the keys the payloads are encrypted with. Payloads are not encrypted when not set
+	keyProvider KeyProvider
+	// tags the events before they are persisted
+	tagger Tagger
+	// states whether the events are written to the outbox along with the journal
+	outbox bool
+	// states whether replayed events are wrapped into an EventEnvelope
+	eventEnvelope bool
+	// the number of events fetched at once during recovery
+	replayPageSize int
+	// states whether the journal entries and the older snapshots are deleted along with every new snapshot
+	truncateOnSnapshot bool
+	// selects the snapshots to prune
+	retentionPolicy RetentionPolicy
+	// the interval at which the retention policy is enforced in the background.
+	// When zero, the retention policy is enforced after every snapshot
+	sweepInterval time.Duration
+	// reports the number of pruned snapshots
+	pruneHook PruneHook
+	// states whether every persistent actor incarnation claims the ownership of its persistence ID
+	writerFencing bool
+	// resolves the tenant of the persistence IDs. The provider context tenant is used when not set
+	tenantResolver TenantResolver
+
+	// states whether events are queued to the writer instead of being written right away
+	asyncWrites bool
+	// the maximum number of events group-committed by the writer
+	maxBatchSize int
+	// the maximum time an event waits for its batch to fill up
+	maxBatchDelay time.Duration
+	// bounds the number of events queued to the writer. Persisting blocks when it is full
+	pendingWrites chan struct{}
 }
 
 // NewSQLProvider creates a new instance of the SQLProvider
@@ -41,30 +98,96 @@ func NewSQLProvider(
 		log.Fatalf("error creating schemas: %v", err)
 	}
 
-	pid := actorSystem.Root.Spawn(actor.PropsFromFunc(newWriter()))
-
 	// create a new instance of SQLProvider
-	provider := new(SQLProvider)
+	provider := &SQLProvider{
+		errorHandler:   EscalateOnError,
+		serializer:     defaultSerializer,
+		replayPageSize: defaultReplayPageSize,
+		maxBatchSize:   1,
+	}
 
 	// call option functions on instance to set options on it
 	for _, opt := range opts {
 		opt(provider)
 	}
 
+	// the writes of the persistent actors need not be checked against the claims without fencing
+	if !provider.writerFencing {
+		ctx = withoutWriterFencing(ctx)
+	}
+
+	// the events are decoded using the serializer they have been encoded with unless a custom decoder is set
+	provider.serializers = newSerializers(append(provider.formerSerializers, provider.serializer)...)
+	if provider.eventDecoder == nil {
+		provider.eventDecoder = serializerDecoder(provider.serializers)
+	}
+
+	pid := actorSystem.Root.Spawn(
+		actor.PropsFromProducer(newWriter(ctx, dialect, provider.maxBatchSize, provider.maxBatchDelay)),
+	)
+
 	// set the provider
 	provider.writer = pid
+	provider.root = actorSystem.Root
 	provider.dialect = dialect
 	provider.ctx = ctx
 
+	if provider.retentionPolicy != nil && provider.sweepInterval > 0 {
+		go provider.sweepSnapshots()
+	}
+
 	// create a new instance of the SqlProvider and returns it
 	return provider
 }
 
 // GetState returns an instance of the ProviderState
 func (p *SQLProvider) GetState() persistence.ProviderState {
-	return &SQLProviderState{
+	state := &SQLProviderState{
 		SQLProvider: p,
+		writerID:    p.writer.Id,
+	}
+
+	// with writer fencing every incarnation of a persistent actor needs its own writer ID
+	if p.writerFencing {
+		state.writerID = uuid.New().String()
+	}
+	return state
+}
+
+// prepareJournal prepares a journal entry read from the database for decoding: the payload is decrypted,
+// decompressed and then upcasted
+func (p *SQLProvider) prepareJournal(ctx context.Context, journal *Journal) (*Journal, error) {
+	journal, err := decryptJournal(ctx, p.keyProvider, journal)
+	if err != nil {
+		return nil, err
+	}
+
+	if journal, err = decompressJournal(journal); err != nil {
+		return nil, err
+	}
+
+	if p.upcasters != nil {
+		return p.upcasters.upcastJournal(journal)
+	}
+	return journal, nil
+}
+
+// prepareSnapshot prepares a snapshot read from the database for decoding: the payload is decrypted,
+// decompressed and then upcasted
+func (p *SQLProvider) prepareSnapshot(ctx context.Context, snapshot *Snapshot) (*Snapshot, error) {
+	snapshot, err := decryptSnapshot(ctx, p.keyProvider, snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	if snapshot, err = decompressSnapshot(snapshot); err != nil {
+		return nil, err
 	}
+
+	if p.upcasters != nil {
+		return p.upcasters.upcastSnapshot(snapshot)
+	}
+	return snapshot, nil
 }
 
 // WithLogicalDeletion enables logical deletion
@@ -80,3 +203,189 @@ func WithSnapshotInterval(interval int) OptFunc {
 		provider.snapshotInterval = interval
 	}
 }
+
+// WithErrorHandler sets the strategy applied when a persistence operation fails.
+// By default, failures are escalated to the owning actor.
+func WithErrorHandler(handler ErrorHandler) OptFunc {
+	return func(provider *SQLProvider) {
+		provider.errorHandler = handler
+	}
+}
+
+// WithEventDecoder sets the decoder used to turn journal rows into events during recovery.
+// By default, the event type is resolved from the journal manifest
+func WithEventDecoder(decoder EventDecoder) OptFunc {
+	return func(provider *SQLProvider) {
+		provider.eventDecoder = decoder
+	}
+}
+
+// WithEventEnvelope wraps every replayed event into an EventEnvelope carrying the journal metadata
+func WithEventEnvelope() OptFunc {
+	return func(provider *SQLProvider) {
+		provider.eventEnvelope = true
+	}
+}
+
+// WithReplayPageSize sets the number of events fetched at once from the journal during recovery
+func WithReplayPageSize(pageSize int) OptFunc {
+	return func(provider *SQLProvider) {
+		provider.replayPageSize = pageSize
+	}
+}
+
+// WithWriterFencing makes every persistent actor claim the ownership of its persistence ID when it recovers.
+// Journal entries written afterwards by a previous incarnation, for instance still running on another node
+// during cluster rebalancing, are rejected with ErrStaleWriter
+func WithWriterFencing() OptFunc {
+	return func(provider *SQLProvider) {
+		provider.writerFencing = true
+	}
+}
+
+// unfencedContextKey marks the contexts of the providers that do not fence the writers
+type unfencedContextKey struct{}
+
+// withoutWriterFencing returns a copy of ctx telling the SQL dialects that the persistence IDs are not claimed,
+// hence their claims need not be locked when writing
+func withoutWriterFencing(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unfencedContextKey{}, true)
+}
+
+// writerFenced tells whether the claims of the persistence IDs must be checked when writing with ctx
+func writerFenced(ctx context.Context) bool {
+	unfenced, _ := ctx.Value(unfencedContextKey{}).(bool)
+	return !unfenced
+}
+
+// WithAsyncWrites queues the events to the writer instead of writing them right away.
+// The writer group-commits the queued events of all the persistent actors in batches of at most maxBatchSize
+// events, waiting at most maxBatchDelay for a batch to fill up. At most maxPendingWrites events can be queued,
+// persisting an event blocks until the queue has some room.
+// Failures are handed over to the error handler as usual, outside the writer, and retried events are queued again.
+// Escalated failures are raised on the next call made by the persistent actor. Pending events are always written
+// before recovering, deleting or snapshotting.
+func WithAsyncWrites(maxBatchSize int, maxBatchDelay time.Duration, maxPendingWrites int) OptFunc {
+	if maxBatchSize < 1 {
+		maxBatchSize = 1
+	}
+	if maxPendingWrites < 1 {
+		maxPendingWrites = 1
+	}
+
+	return func(provider *SQLProvider) {
+		provider.asyncWrites = true
+		provider.maxBatchSize = maxBatchSize
+		provider.maxBatchDelay = maxBatchDelay
+		provider.pendingWrites = make(chan struct{}, maxPendingWrites)
+	}
+}
+
+// WithJournalTruncationOnSnapshot deletes, along with every new snapshot and in the same transaction, the journal
+// entries before the snapshot as well as the older snapshots. The entry at the sequence number of the snapshot is
+// kept since the recovery replays it. The journal entries are logically deleted when WithLogicalDeletion is set.
+func WithJournalTruncationOnSnapshot() OptFunc {
+	return func(provider *SQLProvider) {
+		provider.truncateOnSnapshot = true
+	}
+}
+
+// WithSnapshotRetention sets the retention policy enforced on the snapshots of every persistence ID.
+// By default the policy is enforced right after a snapshot has been persisted. The latest snapshot of a
+// persistence ID is never pruned.
+func WithSnapshotRetention(policy RetentionPolicy) OptFunc {
+	return func(provider *SQLProvider) {
+		provider.retentionPolicy = policy
+	}
+}
+
+// WithSnapshotSweeper enforces the retention policy on every persistence ID in the background at the given
+// interval instead of after every snapshot. The sweeper stops when the provider context is done.
+// It sweeps the tenant of the provider context or, when the tenants are resolved WithTenantResolver, every tenant.
+func WithSnapshotSweeper(interval time.Duration) OptFunc {
+	return func(provider *SQLProvider) {
+		provider.sweepInterval = interval
+	}
+}
+
+// WithSnapshotPruneHook sets the hook the number of pruned snapshots is reported to
+func WithSnapshotPruneHook(hook PruneHook) OptFunc {
+	return func(provider *SQLProvider) {
+		provider.pruneHook = hook
+	}
+}
+
+// WithSerializer sets the serializer used to encode the events and the snapshots.
+// The default serializer uses the protobuf binary wire format. The serializer ID is recorded along with every row,
+// hence the setting can be changed at any time: the rows written beforehand by the built-in serializers remain
+// readable, see WithFormerSerializers for the custom ones
+func WithSerializer(serializer Serializer) OptFunc {
+	return func(provider *SQLProvider) {
+		provider.serializer = serializer
+	}
+}
+
+// WithFormerSerializers sets the custom serializers the rows written beforehand have been encoded with, so that they
+// remain readable once another serializer is set. The serializer set WithSerializer takes precedence over the former
+// serializers of the same ID
+func WithFormerSerializers(serializers ...Serializer) OptFunc {
+	return func(provider *SQLProvider) {
+		provider.formerSerializers = append(provider.formerSerializers, serializers...)
+	}
+}
+
+// WithUpcasters sets the registry of upcasters applied to the events and the snapshots read during recovery,
+// before they are decoded
+func WithUpcasters(registry *UpcasterRegistry) OptFunc {
+	return func(provider *SQLProvider) {
+		provider.upcasters = registry
+	}
+}
+
+// WithCompression compresses the events and the snapshots which payloads are at least threshold bytes long
+// using the given codec. The codec is recorded along with every row, hence the setting can be changed at any time:
+// the rows written beforehand remain readable
+func WithCompression(codec Codec, threshold int) OptFunc {
+	return func(provider *SQLProvider) {
+		provider.compression = codec
+		provider.compressionThreshold = threshold
+	}
+}
+
+// WithEncryption encrypts the events and the snapshots with AES-GCM using envelope encryption: every payload is
+// encrypted with its own data key, which is in turn encrypted with the current key of the persistence ID handed
+// over by the key provider. The key ID is recorded along with every row, hence keys can be rotated at any time.
+// Every payload is bound to its row: it cannot be decrypted once moved to another persistence ID, sequence number,
+// manifest or from an event to a snapshot.
+func WithEncryption(keyProvider KeyProvider) OptFunc {
+	return func(provider *SQLProvider) {
+		provider.keyProvider = keyProvider
+	}
+}
+
+// WithTagger tags the events before they are persisted using the given tagger.
+// The tags are stored along with the events and the tagged events can be queried using ReadJournal.EventsByTag
+func WithTagger(tagger Tagger) OptFunc {
+	return func(provider *SQLProvider) {
+		provider.tagger = tagger
+	}
+}
+
+// WithOutbox writes every event to the outbox in the transaction it is persisted in. The events are then published
+// by an OutboxRelay, hence no event is lost when the process dies between persisting and publishing it
+func WithOutbox() OptFunc {
+	return func(provider *SQLProvider) {
+		provider.outbox = true
+	}
+}
+
+// CryptoShred destroys the keys of a given persistence ID. Its encrypted events and snapshots can no longer be
+// decrypted afterwards, even though the rows are left in the database. The read journal queries and the projection
+// runners skip the shredded events. The keys are destroyed within the tenant ctx is scoped to or, when it is not
+// scoped to any, within the tenant the persistence ID is resolved to
+func (p *SQLProvider) CryptoShred(ctx context.Context, persistenceID string) error {
+	if p.keyProvider == nil {
+		return errors.New("encryption is not enabled")
+	}
+	return p.keyProvider.DestroyKeys(p.tenantContext(ctx, persistenceID), persistenceID)
+}
@@ -1,28 +1,69 @@
 package persistencesql
 
 import (
-	"log"
 	"sync"
 
 	"github.com/golang/protobuf/proto"
+	protov2 "google.golang.org/protobuf/proto"
 )
 
+// maxSequenceNumber is the upper bound used when replaying the whole journal
+const maxSequenceNumber = int(^uint(0) >> 1)
+
 // SQLProviderState is an implementation of the proto-actor ProviderState interface
 type SQLProviderState struct {
 	*SQLProvider
 	wg sync.WaitGroup
+
+	// the writer ID recorded along with the journal entries and snapshots
+	writerID string
+
+	// the failure of an asynchronous write escalated by the error handler.
+	// It is raised on the next call made by the persistent actor
+	mu      sync.Mutex
+	failure *PersistenceError
 }
 
 // GetSnapshot fetches the latest snapshot of a given persistenceID represented by the actorName
 // actorName is the persistenceID
 func (s *SQLProviderState) GetSnapshot(actorName string) (snapshot interface{}, eventIndex int, ok bool) {
-	record, err := s.dialect.GetLatestSnapshot(s.ctx, actorName)
-	if err != nil {
-		log.Fatalf("error fetching snapshot: %v", err)
+	s.await()
+
+	ctx := s.tenantContext(s.ctx, actorName)
+
+	// the snapshot is fetched when the persistent actor recovers. That is when it claims its persistence ID
+	if s.writerFencing {
+		s.handle(ClaimWriterOperation, actorName, 0, func() error {
+			return s.dialect.ClaimWriter(ctx, actorName, s.writerID)
+		})
+	}
+
+	var record *Snapshot
+	if !s.handle(GetSnapshotOperation, actorName, 0, func() (err error) {
+		record, err = s.dialect.GetLatestSnapshot(ctx, actorName)
+		return err
+	}) || record == nil {
+		return nil, 0, false
+	}
+
+	// every attempt prepares the snapshot as read from the database
+	if !s.handle(GetSnapshotOperation, actorName, record.SequenceNumber, func() error {
+		prepared, err := s.prepareSnapshot(ctx, record)
+		if err != nil {
+			return err
+		}
+
+		serializer, err := s.serializers.of(prepared.Serializer)
+		if err != nil {
+			return err
+		}
+		snapshot, err = prepared.message(serializer)
+		return err
+	}) {
 		return nil, 0, false
 	}
 
-	return record.message(), record.SequenceNumber, true
+	return snapshot, record.SequenceNumber, true
 }
 
 // PersistSnapshot saves the snapshot of a given persistenceID.
@@ -30,13 +71,29 @@ func (s *SQLProviderState) GetSnapshot(actorName string) (snapshot interface{},
 // snapshotIndex is the sequenceNumber of the snapshot data
 // snapshot is the payload to persist
 func (s *SQLProviderState) PersistSnapshot(actorName string, snapshotIndex int, snapshot proto.Message) {
-	// let us convert the v1 proto to a v2 proto message
+	// the snapshot must not be written before the events it covers
+	s.await()
 
-	newSnapshot := NewSnapshot(actorName, proto.MessageV2(snapshot), snapshotIndex, s.writer.Id)
-	if err := s.dialect.PersistSnapshot(s.ctx, newSnapshot); err != nil {
-		log.Fatalf(
-			"error: %v persisting snapshot: %s for persistenceID: %s", err, newSnapshot.SnapshotManifest, actorName,
-		)
+	ctx := s.tenantContext(s.ctx, actorName)
+	if !s.handle(PersistSnapshotOperation, actorName, snapshotIndex, func() error {
+		newSnapshot, err := s.newSnapshot(actorName, snapshotIndex, snapshot)
+		if err != nil {
+			return err
+		}
+
+		if s.truncateOnSnapshot {
+			return s.dialect.PersistSnapshotAndTruncate(ctx, newSnapshot, s.logicalDeletion)
+		}
+		return s.dialect.PersistSnapshot(ctx, newSnapshot)
+	}) {
+		return
+	}
+
+	// the retention policy is enforced here unless the sweeper takes care of it
+	if s.retentionPolicy != nil && s.sweepInterval <= 0 {
+		s.handle(PruneSnapshotsOperation, actorName, snapshotIndex, func() error {
+			return s.pruneSnapshots(ctx, actorName)
+		})
 	}
 }
 
@@ -44,26 +101,58 @@ func (s *SQLProviderState) PersistSnapshot(actorName string, snapshotIndex int,
 // actorName is the persistenceID
 // inclusiveToIndex is the sequenceNumber
 func (s *SQLProviderState) DeleteSnapshots(actorName string, inclusiveToIndex int) {
-	if err := s.dialect.DeleteSnapshots(s.ctx, actorName, inclusiveToIndex); err != nil {
-		log.Fatalf("error deleting snapshots: %v for persistenceID: %s", err, actorName)
-	}
+	s.await()
+
+	ctx := s.tenantContext(s.ctx, actorName)
+	s.handle(DeleteSnapshotsOperation, actorName, inclusiveToIndex, func() error {
+		return s.dialect.DeleteSnapshots(ctx, actorName, inclusiveToIndex)
+	})
 }
 
 // GetEvents list events from the journal store within a range of sequenceNumber for a given persistence ID
+// and hands the decoded events over to the callback
 // actorName is the persistenceID
 // eventIndexStart is the from sequenceNumber
-// eventIndexEnd is the to sequenceNumber
+// eventIndexEnd is the to sequenceNumber. 0 means up to the latest event
 func (s *SQLProviderState) GetEvents(
 	actorName string, eventIndexStart int, eventIndexEnd int, callback func(e interface{}),
 ) {
-	events, err := s.dialect.GetJournals(s.ctx, actorName, eventIndexStart, eventIndexEnd)
-	if err != nil {
-		log.Fatalf("error fetching events: %v", err)
-	}
+	s.await()
 
-	for _, e := range events {
-		callback(e)
+	ctx := s.tenantContext(s.ctx, actorName)
+	if eventIndexEnd == 0 {
+		eventIndexEnd = maxSequenceNumber
 	}
+
+	// events are streamed page by page. On retry the replay carries on after the last delivered event
+	next := eventIndexStart
+	s.handle(GetEventsOperation, actorName, eventIndexStart, func() error {
+		return s.dialect.StreamJournals(
+			ctx, actorName, next, eventIndexEnd, s.replayPageSize, func(journal *Journal) error {
+				next = journal.SequenceNumber + 1
+
+				// every attempt prepares the journal entry as read from the database
+				var prepared *Journal
+				var event protov2.Message
+				if !s.handle(GetEventsOperation, actorName, journal.SequenceNumber, func() (err error) {
+					if prepared, err = s.prepareJournal(ctx, journal); err != nil {
+						return err
+					}
+					event, err = s.eventDecoder(prepared)
+					return err
+				}) {
+					return nil
+				}
+
+				if s.eventEnvelope {
+					callback(newEventEnvelope(prepared, event))
+					return nil
+				}
+				callback(event)
+				return nil
+			},
+		)
+	})
 }
 
 // PersistEvent persists an event for a given persistence ID
@@ -71,28 +160,256 @@ func (s *SQLProviderState) GetEvents(
 // eventIndex is the event to persist sequenceNumber
 // event is the event payload
 func (s *SQLProviderState) PersistEvent(actorName string, eventIndex int, event proto.Message) {
-	journal := NewJournal(actorName, proto.MessageV2(event), eventIndex, s.writer.Id)
-	if err := s.dialect.PersistJournal(s.ctx, journal); err != nil {
-		log.Fatalf("error: %v persisting event: %s for persistenceID: %s", err, journal.EventManifest, actorName)
+	if s.asyncWrites {
+		s.persistEventAsync(actorName, eventIndex, event)
+		return
 	}
+
+	ctx := s.tenantContext(s.ctx, actorName)
+	s.handle(PersistEventOperation, actorName, eventIndex, func() error {
+		journal, err := s.newJournal(actorName, eventIndex, event)
+		if err != nil {
+			return err
+		}
+		return s.dialect.PersistJournal(ctx, journal)
+	})
+}
+
+// PersistEvents persists several events for a given persistence ID atomically.
+// Either all the events are persisted or none of them
+// actorName is the persistenceID
+// eventIndex is the sequenceNumber of the first event. The following events get consecutive sequenceNumbers
+// events are the event payloads
+func (s *SQLProviderState) PersistEvents(actorName string, eventIndex int, events ...proto.Message) {
+	// the batch is written right away, after the queued events
+	s.await()
+
+	ctx := s.tenantContext(s.ctx, actorName)
+	s.handle(PersistEventOperation, actorName, eventIndex, func() error {
+		journals := make([]*Journal, 0, len(events))
+		for i, event := range events {
+			journal, err := s.newJournal(actorName, eventIndex+i, event)
+			if err != nil {
+				return err
+			}
+			journals = append(journals, journal)
+		}
+		return s.dialect.PersistJournals(ctx, journals)
+	})
 }
 
 // DeleteEvents deletes events from journal to a given index
 // actorName is the persistenceID
 // inclusiveToIndex is the sequence Number
 func (s *SQLProviderState) DeleteEvents(actorName string, inclusiveToIndex int) {
-	if err := s.dialect.DeleteJournals(s.ctx, actorName, inclusiveToIndex, s.logicalDeletion); err != nil {
-		log.Fatalf("error deleting events: %v for persistenceID: %s", err, actorName)
-	}
+	// the queued events must be written before they can be deleted
+	s.await()
+
+	ctx := s.tenantContext(s.ctx, actorName)
+	s.handle(DeleteEventsOperation, actorName, inclusiveToIndex, func() error {
+		return s.dialect.DeleteJournals(ctx, actorName, inclusiveToIndex, s.logicalDeletion)
+	})
 }
 
 // Restart executes task to run before the provider state is up
 func (s *SQLProviderState) Restart() {
 	// let us wait for any pending  writes to complete
+	s.flush()
 	s.wg.Wait()
+
+	// the persistent actor is restarting, any escalated failure has been dealt with
+	s.mu.Lock()
+	s.failure = nil
+	s.mu.Unlock()
 }
 
 // GetSnapshotInterval return the snapshot interval
 func (s *SQLProviderState) GetSnapshotInterval() int {
 	return s.snapshotInterval
 }
+
+// handle runs the given operation and hands any failure over to the provider error handler.
+// It returns true when the operation eventually succeeds and false when the handler decides to resume.
+// When the handler escalates, it panics with the PersistenceError so that the actor supervisor takes over.
+func (s *SQLProviderState) handle(operation Operation, persistenceID string, sequenceNumber int, fn func() error) bool {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return true
+		}
+
+		persistenceErr := &PersistenceError{
+			Operation:      operation,
+			PersistenceID:  persistenceID,
+			SequenceNumber: sequenceNumber,
+			Attempt:        attempt,
+			Cause:          err,
+		}
+
+		switch s.errorHandler(persistenceErr) {
+		case RetryDirective:
+			continue
+		case ResumeDirective:
+			return false
+		default:
+			panic(persistenceErr)
+		}
+	}
+}
+
+// persistEventAsync queues an event to the writer. It blocks when too many events are already queued
+func (s *SQLProviderState) persistEventAsync(actorName string, eventIndex int, event proto.Message) {
+	s.raise()
+
+	var journal *Journal
+	if !s.handle(PersistEventOperation, actorName, eventIndex, func() (err error) {
+		journal, err = s.newJournal(actorName, eventIndex, event)
+		return err
+	}) {
+		return
+	}
+
+	// back-pressure the persistent actor when the queue is full
+	select {
+	case s.pendingWrites <- struct{}{}:
+	default:
+		// let us ask the writer to make some room instead of waiting for the batch delay
+		s.flush()
+		s.pendingWrites <- struct{}{}
+	}
+	s.wg.Add(1)
+	s.enqueue(journal, 1)
+}
+
+// enqueue queues a journal entry to the writer. The outcome of the write is handled off the writer so that
+// handling a failure, e.g. waiting before a retry, does not hold up the writes of the other persistent actors
+func (s *SQLProviderState) enqueue(journal *Journal, attempt int) {
+	s.root.Send(s.writer, &write{
+		journal: journal,
+		done: func(err error) {
+			go s.complete(journal, attempt, err)
+		},
+		retry: attempt > 1,
+	})
+}
+
+// complete is called once a queued event has been written or has failed to be written.
+// Failures are handed over to the error handler. Retried events are queued again and escalated failures are kept
+// to be raised on the next call made by the persistent actor since the writer is not the owner of the event.
+// The writer holds the following events of the persistence ID back until the failed event is retried or given up
+// on: they are written once it is resumed and failed with the same error once it is escalated
+func (s *SQLProviderState) complete(journal *Journal, attempt int, err error) {
+	if err != nil {
+		persistenceErr := &PersistenceError{
+			Operation:      PersistEventOperation,
+			PersistenceID:  journal.PersistenceID,
+			SequenceNumber: journal.SequenceNumber,
+			Attempt:        attempt,
+			Cause:          err,
+		}
+
+		switch s.errorHandler(persistenceErr) {
+		case RetryDirective:
+			// the retried event is written right away along with the events held back behind it
+			s.enqueue(journal, attempt+1)
+			s.flush()
+			return
+		case ResumeDirective:
+			s.root.Send(s.writer, &release{journal: journal})
+		default:
+			s.mu.Lock()
+			if s.failure == nil {
+				s.failure = persistenceErr
+			}
+			s.mu.Unlock()
+			s.root.Send(s.writer, &release{journal: journal, err: err})
+		}
+	}
+
+	<-s.pendingWrites
+	s.wg.Done()
+}
+
+// flush asks the writer to write the queued events right away
+func (s *SQLProviderState) flush() {
+	if s.asyncWrites {
+		s.root.Send(s.writer, &flush{})
+	}
+}
+
+// await waits for the queued events of the persistent actor to be written and raises any escalated failure
+func (s *SQLProviderState) await() {
+	if !s.asyncWrites {
+		return
+	}
+
+	s.flush()
+	s.wg.Wait()
+	s.raise()
+}
+
+// raise panics with the failure escalated by an asynchronous write, if any,
+// so that the persistent actor supervisor takes over
+func (s *SQLProviderState) raise() {
+	s.mu.Lock()
+	failure := s.failure
+	s.failure = nil
+	s.mu.Unlock()
+
+	if failure != nil {
+		panic(failure)
+	}
+}
+
+// newJournal creates the journal entry of an event: the event is tagged, serialized, compressed and then encrypted
+func (s *SQLProviderState) newJournal(actorName string, eventIndex int, event proto.Message) (*Journal, error) {
+	ctx := s.tenantContext(s.ctx, actorName)
+
+	// let us convert the v1 proto to a v2 proto message
+	journal, err := newJournal(s.serializer, actorName, proto.MessageV2(event), eventIndex, s.writerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.tagger != nil {
+		journal.Tags = s.tagger(actorName, proto.MessageV2(event))
+	}
+	journal.Outbox = s.outbox
+	// the writer writes the queued entries to the tenant they have been created for
+	journal.TenantID = tenantOf(ctx)
+
+	if err = compressJournal(journal, s.compression, s.compressionThreshold); err != nil {
+		return nil, err
+	}
+
+	if s.keyProvider != nil {
+		if err = encryptJournal(ctx, s.keyProvider, journal); err != nil {
+			return nil, err
+		}
+	}
+	return journal, nil
+}
+
+// newSnapshot creates the snapshot entry of a snapshot: the snapshot is serialized, compressed and then encrypted
+func (s *SQLProviderState) newSnapshot(actorName string, snapshotIndex int, snapshot proto.Message) (*Snapshot, error) {
+	ctx := s.tenantContext(s.ctx, actorName)
+
+	// let us convert the v1 proto to a v2 proto message
+	record, err := newSnapshot(s.serializer, actorName, proto.MessageV2(snapshot), snapshotIndex, s.writerID)
+	if err != nil {
+		return nil, err
+	}
+
+	record.TenantID = tenantOf(ctx)
+
+	if err = compressSnapshot(record, s.compression, s.compressionThreshold); err != nil {
+		return nil, err
+	}
+
+	if s.keyProvider != nil {
+		if err = encryptSnapshot(ctx, s.keyProvider, record); err != nil {
+			return nil, err
+		}
+	}
+	return record, nil
+}
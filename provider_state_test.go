@@ -0,0 +1,386 @@
+package persistencesql
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AsynkronIT/protoactor-go/actor"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	pb "github.com/tochemey/protoactor-persistence-sql/gen"
+	"google.golang.org/protobuf/proto"
+)
+
+// journalDialect is a SQLDialect serving a fixed set of journals
+type journalDialect struct {
+	SQLDialect
+	journals []*Journal
+	// the sequence number at which streaming fails once
+	failAt int
+}
+
+func (d *journalDialect) StreamJournals(
+	_ context.Context, persistenceID string, fromSequenceNumber int, toSequenceNumber int, _ int,
+	fn func(journal *Journal) error,
+) error {
+	for _, journal := range d.journals {
+		if journal.PersistenceID != persistenceID ||
+			journal.SequenceNumber < fromSequenceNumber || journal.SequenceNumber > toSequenceNumber {
+			continue
+		}
+
+		if journal.SequenceNumber == d.failAt {
+			d.failAt = 0
+			return errors.New("connection reset")
+		}
+
+		if err := fn(journal); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestGetEvents(t *testing.T) {
+	persistenceID := "some-persistence-id"
+	events := make([]*pb.AccountDebited, 0)
+	journals := make([]*Journal, 0)
+	for i := 0; i < 3; i++ {
+		event := &pb.AccountDebited{
+			AccountNumber: persistenceID,
+			Balance:       float32(i * 100),
+		}
+		journal, err := NewJournal(persistenceID, event, i+1, "some-writer-id")
+		assert.NoError(t, err)
+		journal.Ordering = int64(i + 10)
+
+		events = append(events, event)
+		journals = append(journals, journal)
+	}
+
+	testCases := map[string]struct {
+		opts   []OptFunc
+		failAt int
+	}{
+		// asserting that the decoded events are replayed
+		"events": {},
+		// asserting that the events are replayed wrapped into envelopes
+		"envelopes": {
+			opts: []OptFunc{WithEventEnvelope()},
+		},
+		// asserting that a custom decoder is used
+		"custom decoder": {
+			opts: []OptFunc{WithEventDecoder(func(journal *Journal) (proto.Message, error) {
+				event := new(pb.AccountDebited)
+				err := proto.Unmarshal(journal.Payload, event)
+				return event, err
+			})},
+		},
+		// asserting that the replay carries on after the last delivered event on retry
+		"retry": {
+			opts:   []OptFunc{WithErrorHandler(RetryOnError(2, 0, EscalateOnError))},
+			failAt: 3,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(
+			name, func(t *testing.T) {
+				// get instance of assert
+				assertions := assert.New(t)
+
+				provider := &SQLProvider{
+					writer:       actor.NewPID("localhost", "writer"),
+					dialect:      &journalDialect{journals: journals, failAt: testCase.failAt},
+					ctx:          context.TODO(),
+					errorHandler: EscalateOnError,
+					eventDecoder: serializerDecoder(newSerializers()),
+				}
+				for _, opt := range testCase.opts {
+					opt(provider)
+				}
+
+				replayed := make([]interface{}, 0)
+				provider.GetState().GetEvents(persistenceID, 2, 0, func(e interface{}) {
+					replayed = append(replayed, e)
+				})
+
+				assertions.Len(replayed, 2)
+				for i, e := range replayed {
+					if provider.eventEnvelope {
+						envelope, ok := e.(*EventEnvelope)
+						assertions.True(ok)
+						assertions.True(proto.Equal(events[i+1], envelope.Event))
+						assertions.Equal(persistenceID, envelope.PersistenceID)
+						assertions.Equal(i+2, envelope.SequenceNumber)
+						assertions.Equal(journals[i+1].Timestamp, envelope.Timestamp)
+						assertions.Equal("some-writer-id", envelope.WriterID)
+						assertions.Equal(int64(i+11), envelope.Ordering)
+						continue
+					}
+
+					event, ok := e.(*pb.AccountDebited)
+					assertions.True(ok)
+					assertions.True(proto.Equal(events[i+1], event))
+				}
+			},
+		)
+	}
+}
+
+func TestRetriedPreparation(t *testing.T) {
+	ctx := context.TODO()
+	persistenceID := uuid.New().String()
+
+	// get instance of assert
+	assertions := assert.New(t)
+	memoryDialect := NewInMemoryDialect()
+
+	// the payloads cannot be decompressed
+	journal, err := NewJournal(persistenceID, &pb.AccountDebited{Balance: 1}, 1, "writer")
+	assertions.NoError(err)
+	journal.Codec = GzipCodec
+	assertions.NoError(memoryDialect.PersistJournal(ctx, journal))
+	snapshot, err := NewSnapshot(persistenceID, &pb.Account{ActualBalance: 1}, 1, "writer")
+	assertions.NoError(err)
+	snapshot.Codec = GzipCodec
+	assertions.NoError(memoryDialect.PersistSnapshot(ctx, snapshot))
+
+	attempts := 0
+	state := NewSQLProvider(
+		ctx, actor.NewActorSystem(), memoryDialect,
+		WithErrorHandler(RetryOnError(3, 0, func(err *PersistenceError) Directive {
+			attempts = err.Attempt
+			return ResumeDirective
+		})),
+	).GetState()
+
+	// every attempt starts over from the row read
+	assertions.NotPanics(func() {
+		state.GetEvents(persistenceID, 1, 0, func(interface{}) {
+			assertions.Fail("the event cannot be decoded")
+		})
+	})
+	assertions.Equal(3, attempts)
+
+	attempts = 0
+	assertions.NotPanics(func() {
+		_, _, ok := state.GetSnapshot(persistenceID)
+		assertions.False(ok)
+	})
+	assertions.Equal(3, attempts)
+}
+
+// batchDialect is an InMemoryDialect counting the batches written
+type batchDialect struct {
+	*InMemoryDialect
+	batches int32
+}
+
+func (d *batchDialect) PersistJournals(ctx context.Context, journals []*Journal) error {
+	atomic.AddInt32(&d.batches, 1)
+	return d.InMemoryDialect.PersistJournals(ctx, journals)
+}
+
+// failingDialect is an InMemoryDialect failing to write the journal entries of a persistenceID a number of times
+type failingDialect struct {
+	*InMemoryDialect
+	persistenceID string
+	failures      int32
+}
+
+func (d *failingDialect) PersistJournal(ctx context.Context, journal *Journal) error {
+	if journal.PersistenceID == d.persistenceID && atomic.AddInt32(&d.failures, -1) >= 0 {
+		return errors.New("connection reset")
+	}
+	return d.InMemoryDialect.PersistJournal(ctx, journal)
+}
+
+func (d *failingDialect) PersistJournals(ctx context.Context, journals []*Journal) error {
+	for _, journal := range journals {
+		if journal.PersistenceID == d.persistenceID {
+			return errors.New("connection reset")
+		}
+	}
+	return d.InMemoryDialect.PersistJournals(ctx, journals)
+}
+
+func TestAsyncWrites(t *testing.T) {
+	persistenceID := "some-persistence-id"
+	event := &pb.AccountDebited{AccountNumber: persistenceID, Balance: 100}
+
+	t.Run("group commit", func(t *testing.T) {
+		assertions := assert.New(t)
+		dialect := &batchDialect{InMemoryDialect: NewInMemoryDialect()}
+		provider := NewSQLProvider(context.TODO(), actor.NewActorSystem(), dialect, WithAsyncWrites(5, time.Hour, 10))
+		state := provider.GetState()
+
+		for i := 1; i <= 10; i++ {
+			state.PersistEvent(persistenceID, i, event)
+		}
+
+		// replaying waits for the queued events to be written
+		replayed := 0
+		state.GetEvents(persistenceID, 1, 0, func(interface{}) { replayed++ })
+		assertions.Equal(10, replayed)
+		assertions.EqualValues(2, atomic.LoadInt32(&dialect.batches))
+	})
+
+	t.Run("batch delay", func(t *testing.T) {
+		assertions := assert.New(t)
+		dialect := NewInMemoryDialect()
+		provider := NewSQLProvider(
+			context.TODO(), actor.NewActorSystem(), dialect, WithAsyncWrites(100, 10*time.Millisecond, 10),
+		)
+		state := provider.GetState()
+
+		for i := 1; i <= 3; i++ {
+			state.PersistEvent(persistenceID, i, event)
+		}
+
+		// the batch is written once the delay is over even though it is not full
+		assertions.Eventually(func() bool {
+			return len(dialect.Journals(persistenceID)) == 3
+		}, time.Second, 5*time.Millisecond)
+	})
+
+	t.Run("restart", func(t *testing.T) {
+		assertions := assert.New(t)
+		dialect := NewInMemoryDialect()
+		provider := NewSQLProvider(context.TODO(), actor.NewActorSystem(), dialect, WithAsyncWrites(100, time.Hour, 1))
+		state := provider.GetState()
+
+		// the queue only holds one event, persisting waits for the room to be made
+		for i := 1; i <= 3; i++ {
+			state.PersistEvent(persistenceID, i, event)
+		}
+
+		state.Restart()
+		assertions.Len(dialect.Journals(persistenceID), 3)
+	})
+
+	t.Run("stale deadline", func(t *testing.T) {
+		assertions := assert.New(t)
+		dialect := NewInMemoryDialect()
+		provider := NewSQLProvider(
+			context.TODO(), actor.NewActorSystem(), dialect, WithAsyncWrites(2, 200*time.Millisecond, 10),
+		)
+		state := provider.GetState()
+
+		// the first batch is written once full, before its deadline
+		state.PersistEvent(persistenceID, 1, event)
+		state.PersistEvent(persistenceID, 2, event)
+		time.Sleep(100 * time.Millisecond)
+		state.PersistEvent(persistenceID, 3, event)
+
+		// the deadline of the first batch does not cut the delay of the next one short
+		time.Sleep(150 * time.Millisecond)
+		assertions.Len(dialect.Journals(persistenceID), 2)
+		assertions.Eventually(func() bool {
+			return len(dialect.Journals(persistenceID)) == 3
+		}, time.Second, 5*time.Millisecond)
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		assertions := assert.New(t)
+		failingPersistenceID := "failing-persistence-id"
+		dialect := &failingDialect{
+			InMemoryDialect: NewInMemoryDialect(),
+			persistenceID:   failingPersistenceID,
+			failures:        2,
+		}
+		provider := NewSQLProvider(
+			context.TODO(), actor.NewActorSystem(), dialect, WithAsyncWrites(100, 10*time.Millisecond, 10),
+			WithErrorHandler(RetryOnError(3, 200*time.Millisecond, EscalateOnError)),
+		)
+		failingState := provider.GetState()
+		failingState.PersistEvent(failingPersistenceID, 1, event)
+		time.Sleep(50 * time.Millisecond)
+
+		// waiting for the retry does not hold up the events of the other persistent actors
+		provider.GetState().PersistEvent(persistenceID, 1, event)
+		assertions.Eventually(func() bool {
+			return len(dialect.Journals(persistenceID)) == 1
+		}, 100*time.Millisecond, 5*time.Millisecond)
+		assertions.Empty(dialect.Journals(failingPersistenceID))
+
+		// the failed event is eventually written
+		replayed := 0
+		failingState.GetEvents(failingPersistenceID, 1, 0, func(interface{}) { replayed++ })
+		assertions.Equal(1, replayed)
+	})
+
+	t.Run("retry in order", func(t *testing.T) {
+		assertions := assert.New(t)
+		dialect := &failingDialect{InMemoryDialect: NewInMemoryDialect(), persistenceID: persistenceID, failures: 1}
+		provider := NewSQLProvider(
+			context.TODO(), actor.NewActorSystem(), dialect, WithAsyncWrites(100, 10*time.Millisecond, 10),
+			WithErrorHandler(RetryOnError(3, 20*time.Millisecond, EscalateOnError)),
+		)
+		state := provider.GetState()
+		for i := 1; i <= 3; i++ {
+			state.PersistEvent(persistenceID, i, event)
+		}
+
+		// the events following the failed one are held back until it is written
+		replayed := 0
+		state.GetEvents(persistenceID, 1, 0, func(interface{}) { replayed++ })
+		assertions.Equal(3, replayed)
+		journals := dialect.Journals(persistenceID)
+		if assertions.Len(journals, 3) {
+			assertions.Less(journals[0].Ordering, journals[1].Ordering)
+			assertions.Less(journals[1].Ordering, journals[2].Ordering)
+		}
+	})
+
+	t.Run("escalation in order", func(t *testing.T) {
+		assertions := assert.New(t)
+		dialect := &failingDialect{InMemoryDialect: NewInMemoryDialect(), persistenceID: persistenceID, failures: 1}
+		provider := NewSQLProvider(
+			context.TODO(), actor.NewActorSystem(), dialect, WithAsyncWrites(100, 10*time.Millisecond, 10),
+		)
+		state := provider.GetState()
+		state.PersistEvent(persistenceID, 1, event)
+		state.PersistEvent(persistenceID, 2, event)
+
+		// the events following the escalated one fail with the same error
+		assertions.Panics(func() { state.GetEvents(persistenceID, 1, 0, func(interface{}) {}) })
+		assertions.Empty(dialect.Journals(persistenceID))
+	})
+
+	t.Run("escalation", func(t *testing.T) {
+		assertions := assert.New(t)
+		dialect := NewInMemoryDialect()
+		provider := NewSQLProvider(context.TODO(), actor.NewActorSystem(), dialect, WithAsyncWrites(100, time.Hour, 10))
+		state := provider.GetState()
+
+		state.PersistEvent(persistenceID, 1, event)
+		state.PersistEvent(persistenceID, 1, event)
+
+		// the failure is raised on the next call once the queued events have been written
+		assertions.PanicsWithError(
+			(&PersistenceError{
+				Operation:      PersistEventOperation,
+				PersistenceID:  persistenceID,
+				SequenceNumber: 1,
+				Attempt:        1,
+				Cause: concurrentModificationError(
+					persistenceID, 1, duplicateKeyError(persistenceID, 1),
+				),
+			}).Error(),
+			func() { state.GetEvents(persistenceID, 1, 0, func(interface{}) {}) },
+		)
+
+		// the first event has been written
+		assertions.Len(dialect.Journals(persistenceID), 1)
+
+		// the failure is dealt with once the persistent actor restarts
+		state.Restart()
+		assertions.NotPanics(func() { state.PersistEvent(persistenceID, 2, event) })
+		state.Restart()
+		assertions.Len(dialect.Journals(persistenceID), 2)
+	})
+}
@@ -0,0 +1,406 @@
+package persistencesql
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	// defaultPollInterval is the interval at which live queries look for new journal rows
+	defaultPollInterval = time.Second
+	// defaultGapTimeout is how long the queries by ordering wait for a gap in the ordering to be filled
+	defaultGapTimeout = 10 * time.Second
+	// defaultNotifiedPollInterval is the interval at which live queries look for new journal rows while they are
+	// notified of them
+	defaultNotifiedPollInterval = 30 * time.Second
+)
+
+// ReadJournal queries the journal on the read side, e.g. to build projections. The events are decoded the same way
+// they are during recovery and delivered wrapped into envelopes.
+//
+// Queries come in two variants. The current queries deliver the events stored at the time of the query and
+// complete. The live queries keep on looking for new events until their context is done.
+//
+// Queries only deliver the events of the tenant their context is scoped to using WithTenant, or of the default tenant.
+// The queries by persistence ID fall back to the tenant resolver of the provider when their context is not scoped
+type ReadJournal struct {
+	*SQLProvider
+
+	// the interval at which live queries look for new journal rows
+	pollInterval time.Duration
+	// how long the queries by ordering wait for a gap in the ordering to be filled
+	gapTimeout time.Duration
+	// the interval at which live queries look for new journal rows while they are notified of them
+	notifiedPollInterval time.Duration
+}
+
+// ReadJournalOptFunc is a function that sets some options on the ReadJournal
+type ReadJournalOptFunc func(readJournal *ReadJournal)
+
+// WithPollInterval sets the interval at which live queries look for new journal rows
+func WithPollInterval(interval time.Duration) ReadJournalOptFunc {
+	return func(readJournal *ReadJournal) {
+		readJournal.pollInterval = interval
+	}
+}
+
+// WithNotifiedPollInterval sets the interval at which live queries look for new journal rows while the dialect notifies
+// them of the new rows, as a safety net. Live queries fall back to the poll interval whenever the notifications stop
+func WithNotifiedPollInterval(interval time.Duration) ReadJournalOptFunc {
+	return func(readJournal *ReadJournal) {
+		readJournal.notifiedPollInterval = interval
+	}
+}
+
+// WithGapTimeout sets how long the queries by ordering wait for a gap in the ordering to be filled before skipping it.
+// On Postgres the ordering is allocated when a row is inserted but the row only becomes visible once its transaction
+// commits, hence rows can become visible out of order. A gap is either a row about to be committed or a row that
+// never will be, e.g. because its transaction rolled back or the row has been hard-deleted
+func WithGapTimeout(timeout time.Duration) ReadJournalOptFunc {
+	return func(readJournal *ReadJournal) {
+		readJournal.gapTimeout = timeout
+	}
+}
+
+// ReadJournal creates a ReadJournal sharing the provider datastore and decoding settings
+func (p *SQLProvider) ReadJournal(opts ...ReadJournalOptFunc) *ReadJournal {
+	readJournal := &ReadJournal{
+		SQLProvider:          p,
+		pollInterval:         defaultPollInterval,
+		gapTimeout:           defaultGapTimeout,
+		notifiedPollInterval: defaultNotifiedPollInterval,
+	}
+
+	for _, opt := range opts {
+		opt(readJournal)
+	}
+	return readJournal
+}
+
+// EventStream is the stream of events delivered by a ReadJournal query
+type EventStream struct {
+	events chan *EventEnvelope
+	err    error
+}
+
+// newEventStream creates an instance of EventStream
+func newEventStream() *EventStream {
+	return &EventStream{events: make(chan *EventEnvelope)}
+}
+
+// Events returns the channel the events are delivered over. The channel is closed when the stream completes
+func (s *EventStream) Events() <-chan *EventEnvelope {
+	return s.events
+}
+
+// Err returns the error that made the stream complete, if any. It returns the context error when the context of the
+// query is done. It must only be called once the events channel has been closed
+func (s *EventStream) Err() error {
+	return s.err
+}
+
+// send delivers an event unless the context is done
+func (s *EventStream) send(ctx context.Context, envelope *EventEnvelope) error {
+	select {
+	case s.events <- envelope:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// CurrentEventsByPersistenceID delivers the events of a given persistence ID stored at the time of the query within
+// a range of sequence numbers. toSequenceNumber 0 means up to the latest event
+func (r *ReadJournal) CurrentEventsByPersistenceID(
+	ctx context.Context, persistenceID string, fromSequenceNumber int, toSequenceNumber int,
+) *EventStream {
+	return r.eventsByPersistenceID(ctx, persistenceID, fromSequenceNumber, toSequenceNumber, false)
+}
+
+// EventsByPersistenceID delivers the events of a given persistence ID within a range of sequence numbers and keeps on
+// delivering the new ones as they are stored. The stream completes once toSequenceNumber has been delivered or when
+// the context is done. toSequenceNumber 0 means no upper bound
+func (r *ReadJournal) EventsByPersistenceID(
+	ctx context.Context, persistenceID string, fromSequenceNumber int, toSequenceNumber int,
+) *EventStream {
+	return r.eventsByPersistenceID(ctx, persistenceID, fromSequenceNumber, toSequenceNumber, true)
+}
+
+// eventsByPersistenceID runs the events by persistence ID queries
+func (r *ReadJournal) eventsByPersistenceID(
+	ctx context.Context, persistenceID string, fromSequenceNumber int, toSequenceNumber int, live bool,
+) *EventStream {
+	if toSequenceNumber == 0 {
+		toSequenceNumber = maxSequenceNumber
+	}
+
+	// the events are read from the tenant of the persistence ID unless the query is scoped to a tenant
+	ctx = r.tenantContext(ctx, persistenceID)
+
+	stream := newEventStream()
+	go func() {
+		defer close(stream.events)
+
+		notifications, unsubscribe := r.subscribe()
+		defer unsubscribe()
+
+		next := fromSequenceNumber
+		for {
+			err := r.dialect.StreamJournals(
+				ctx, persistenceID, next, toSequenceNumber, r.replayPageSize, func(journal *Journal) error {
+					envelope, err := r.envelope(ctx, journal)
+					if err != nil {
+						return err
+					}
+
+					if envelope != nil {
+						if err = stream.send(ctx, envelope); err != nil {
+							return err
+						}
+					}
+					next = journal.SequenceNumber + 1
+					return nil
+				},
+			)
+			if err != nil {
+				stream.err = err
+				return
+			}
+
+			if !live || next > toSequenceNumber {
+				return
+			}
+
+			if stream.err = r.wait(ctx, notifications); stream.err != nil {
+				return
+			}
+		}
+	}()
+
+	return stream
+}
+
+// CurrentAllEvents delivers the events of all the persistenceIDs stored at the time of the query, in the order they
+// have been stored, starting right after the given offset. The offset of an event is the Ordering of its envelope:
+// callers can store it and pass it back to resume the stream where they left off. Offset 0 starts from the beginning
+func (r *ReadJournal) CurrentAllEvents(ctx context.Context, fromOffset int64) *EventStream {
+	return r.allEvents(ctx, fromOffset, false)
+}
+
+// AllEvents delivers the events of all the persistenceIDs in the order they have been stored, starting right after
+// the given offset, and keeps on delivering the new ones as they are stored until the context is done.
+// The offset of an event is the Ordering of its envelope. Offset 0 starts from the beginning
+func (r *ReadJournal) AllEvents(ctx context.Context, fromOffset int64) *EventStream {
+	return r.allEvents(ctx, fromOffset, true)
+}
+
+// allEvents runs the all events queries
+func (r *ReadJournal) allEvents(ctx context.Context, offset int64, live bool) *EventStream {
+	return r.eventsByOrdering(
+		ctx, offset, live, func(offset int64, horizon int64) ([]*Journal, error) {
+			return r.dialect.GetJournalsByOrdering(ctx, offset, horizon, r.replayPageSize)
+		},
+	)
+}
+
+// orderingGap tracks the gap a query by ordering is waiting on
+type orderingGap struct {
+	// the ordering after which the gap starts
+	after int64
+	// when the gap has been noticed
+	since time.Time
+}
+
+// settled tells whether the gap between the given offset and the next journal row read, stored at the given
+// timestamp, can be skipped. A gap is settled once it has been waited on for the timeout, or right away when the row
+// following it has been stored longer than the timeout ago, since no transaction stays in-flight for that long
+func (g *orderingGap) settled(offset int64, timestamp int64, timeout time.Duration) bool {
+	now := time.Now()
+	if g.since.IsZero() || g.after != offset {
+		g.after, g.since = offset, now
+	}
+
+	return now.Sub(g.since) >= timeout || now.Sub(time.Unix(timestamp, 0)) >= timeout
+}
+
+// CurrentEventsByTag delivers the events tagged with the given tag stored at the time of the query, in the order
+// they have been stored, starting right after the given offset. The offset of an event is the Ordering of its
+// envelope. Offset 0 starts from the beginning
+func (r *ReadJournal) CurrentEventsByTag(ctx context.Context, tag string, fromOffset int64) *EventStream {
+	return r.eventsByTag(ctx, tag, fromOffset, false)
+}
+
+// EventsByTag delivers the events tagged with the given tag in the order they have been stored, starting right after
+// the given offset, and keeps on delivering the new ones as they are stored until the context is done.
+// The offset of an event is the Ordering of its envelope. Offset 0 starts from the beginning
+func (r *ReadJournal) EventsByTag(ctx context.Context, tag string, fromOffset int64) *EventStream {
+	return r.eventsByTag(ctx, tag, fromOffset, true)
+}
+
+// eventsByTag runs the events by tag queries
+func (r *ReadJournal) eventsByTag(ctx context.Context, tag string, offset int64, live bool) *EventStream {
+	return r.eventsByOrdering(
+		ctx, offset, live, func(offset int64, horizon int64) ([]*Journal, error) {
+			return r.dialect.GetJournalsByTag(ctx, tag, offset, horizon, r.replayPageSize)
+		},
+	)
+}
+
+// eventsByOrdering runs the queries by ordering, reading the events with the given function one page at a time.
+// Events are delivered strictly in ordering order. The orderings of the events read are not contiguous, since they are
+// shared by the tags and the tenants, hence gaps cannot be told apart from the events of the others. The query rather
+// reads the events up to a horizon, which is moved forward over the orderings of the whole journal and held back at
+// the gaps until they are filled or considered settled, so that a row committed late is never skipped over
+func (r *ReadJournal) eventsByOrdering(
+	ctx context.Context, offset int64, live bool, read func(offset int64, horizon int64) ([]*Journal, error),
+) *EventStream {
+	stream := newEventStream()
+	go func() {
+		defer close(stream.events)
+
+		notifications, unsubscribe := r.subscribe()
+		defer unsubscribe()
+
+		gap := &orderingGap{}
+		horizon := offset
+		for {
+			next, blocked, caughtUp, err := r.advanceHorizon(ctx, horizon, gap)
+			if err != nil {
+				stream.err = err
+				return
+			}
+			horizon = next
+
+			for offset < horizon {
+				journals, err := read(offset, horizon)
+				if err != nil {
+					stream.err = err
+					return
+				}
+
+				for _, journal := range journals {
+					if !journal.Deleted {
+						envelope, err := r.envelope(ctx, journal)
+						if err != nil {
+							stream.err = err
+							return
+						}
+
+						if envelope != nil {
+							if stream.err = stream.send(ctx, envelope); stream.err != nil {
+								return
+							}
+						}
+					}
+					offset = journal.Ordering
+				}
+
+				// a partial page means every event up to the horizon has been delivered
+				if len(journals) < r.replayPageSize {
+					offset = horizon
+				}
+			}
+
+			if caughtUp && !live {
+				return
+			}
+
+			switch {
+			case blocked:
+				// let us give the gap some time to be filled
+				stream.err = r.poll(ctx)
+			case caughtUp:
+				stream.err = r.wait(ctx, notifications)
+			}
+
+			if stream.err != nil {
+				return
+			}
+		}
+	}()
+
+	return stream
+}
+
+// advanceHorizon moves the horizon forward over the orderings of the next page of journal rows of every tenant,
+// stopping at the first unsettled gap. It returns the new horizon, whether it has been stopped by a gap and whether
+// it has caught up with the journal
+func (r *ReadJournal) advanceHorizon(
+	ctx context.Context, horizon int64, gap *orderingGap,
+) (int64, bool, bool, error) {
+	orderings, err := r.dialect.GetJournalOrderings(ctx, horizon, r.replayPageSize)
+	if err != nil {
+		return horizon, false, false, err
+	}
+
+	for _, ordering := range orderings {
+		if ordering.Ordering > horizon+1 && !gap.settled(horizon, ordering.Timestamp, r.gapTimeout) {
+			return horizon, true, false, nil
+		}
+		horizon = ordering.Ordering
+	}
+	return horizon, false, len(orderings) < r.replayPageSize, nil
+}
+
+// subscribe registers for the notifications of new journal rows when the dialect supports them. It must be called
+// before reading the journal so that no new row goes unnoticed
+func (r *ReadJournal) subscribe() (<-chan struct{}, func()) {
+	if notifier, ok := r.dialect.(Notifier); ok {
+		return notifier.Subscribe()
+	}
+	return nil, func() {}
+}
+
+// wait waits for new journal rows, either until notified of them or until the poll interval elapses. The notified
+// poll interval applies while the dialect delivers the notifications. It returns the context error when the context
+// is done in the meantime
+func (r *ReadJournal) wait(ctx context.Context, notifications <-chan struct{}) error {
+	interval := r.pollInterval
+	if notifier, ok := r.dialect.(Notifier); ok && notifications != nil && notifier.Listening() {
+		interval = r.notifiedPollInterval
+	}
+
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-notifications:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// poll waits for the poll interval to elapse. It returns the context error when the context is done in the meantime
+func (r *ReadJournal) poll(ctx context.Context) error {
+	timer := time.NewTimer(r.pollInterval)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// envelope decodes a journal row into an envelope. It returns no envelope when the row has been crypto-shredded:
+// the row can no longer be decrypted, hence the queries skip it rather than stopping at it for good
+func (r *ReadJournal) envelope(ctx context.Context, journal *Journal) (*EventEnvelope, error) {
+	prepared, err := r.prepareJournal(ctx, journal)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	event, err := r.eventDecoder(prepared)
+	if err != nil {
+		return nil, err
+	}
+	return newEventEnvelope(journal, event), nil
+}
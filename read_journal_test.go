@@ -0,0 +1,442 @@
+package persistencesql
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AsynkronIT/protoactor-go/actor"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	pb "github.com/tochemey/protoactor-persistence-sql/gen"
+	"google.golang.org/protobuf/proto"
+)
+
+// collect reads the given number of events from the stream. A negative count reads until the stream completes
+func collect(stream *EventStream, count int) []*EventEnvelope {
+	envelopes := make([]*EventEnvelope, 0)
+	for envelope := range stream.Events() {
+		envelopes = append(envelopes, envelope)
+		if len(envelopes) == count {
+			break
+		}
+	}
+	return envelopes
+}
+
+// balances returns the balances of the account debited events wrapped into the given envelopes
+func balances(envelopes []*EventEnvelope) []float32 {
+	result := make([]float32, 0, len(envelopes))
+	for _, envelope := range envelopes {
+		result = append(result, envelope.Event.(*pb.AccountDebited).GetBalance())
+	}
+	return result
+}
+
+func TestCurrentEventsByPersistenceID(t *testing.T) {
+	ctx := context.TODO()
+	persistenceID := uuid.New().String()
+
+	memoryDialect := NewInMemoryDialect()
+	provider := NewSQLProvider(ctx, actor.NewActorSystem(), memoryDialect, WithReplayPageSize(2))
+	state := provider.GetState()
+	for i := 1; i <= 5; i++ {
+		state.PersistEvent(persistenceID, i, &pb.AccountDebited{AccountNumber: persistenceID, Balance: float32(i)})
+	}
+	readJournal := provider.ReadJournal()
+
+	testCases := map[string]struct {
+		from     int
+		to       int
+		expected []float32
+	}{
+		"range":        {from: 2, to: 4, expected: []float32{2, 3, 4}},
+		"up to latest": {from: 3, to: 0, expected: []float32{3, 4, 5}},
+		"empty range":  {from: 6, to: 0, expected: []float32{}},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(
+			name, func(t *testing.T) {
+				// get instance of assert
+				assertions := assert.New(t)
+
+				stream := readJournal.CurrentEventsByPersistenceID(ctx, persistenceID, testCase.from, testCase.to)
+				envelopes := collect(stream, -1)
+				assertions.NoError(stream.Err())
+				assertions.Equal(testCase.expected, balances(envelopes))
+				for _, envelope := range envelopes {
+					assertions.Equal(persistenceID, envelope.PersistenceID)
+					assertions.Equal(int(envelope.Event.(*pb.AccountDebited).GetBalance()), envelope.SequenceNumber)
+				}
+			},
+		)
+	}
+}
+
+func TestEventsByPersistenceID(t *testing.T) {
+	persistenceID := uuid.New().String()
+
+	// get instance of assert
+	assertions := assert.New(t)
+	memoryDialect := NewInMemoryDialect()
+	provider := NewSQLProvider(context.TODO(), actor.NewActorSystem(), memoryDialect)
+	state := provider.GetState()
+	readJournal := provider.ReadJournal(WithPollInterval(5 * time.Millisecond))
+
+	state.PersistEvent(persistenceID, 1, &pb.AccountDebited{AccountNumber: persistenceID, Balance: 1})
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	stream := readJournal.EventsByPersistenceID(ctx, persistenceID, 1, 0)
+	assertions.Equal([]float32{1}, balances(collect(stream, 1)))
+
+	// the events stored afterwards are delivered as well
+	for i := 2; i <= 3; i++ {
+		state.PersistEvent(persistenceID, i, &pb.AccountDebited{AccountNumber: persistenceID, Balance: float32(i)})
+	}
+	assertions.Equal([]float32{2, 3}, balances(collect(stream, 2)))
+
+	// the stream completes when the context is canceled
+	cancel()
+	assertions.Empty(collect(stream, -1))
+	assertions.ErrorIs(stream.Err(), context.Canceled)
+
+	// a bounded live stream completes once the last event has been delivered
+	stream = readJournal.EventsByPersistenceID(context.TODO(), persistenceID, 2, 4)
+	go state.PersistEvent(persistenceID, 4, &pb.AccountDebited{AccountNumber: persistenceID, Balance: 4})
+	assertions.Equal([]float32{2, 3, 4}, balances(collect(stream, -1)))
+	assertions.NoError(stream.Err())
+}
+
+func TestEventsByPersistenceIDDecodingFailure(t *testing.T) {
+	ctx := context.TODO()
+	persistenceID := uuid.New().String()
+
+	// get instance of assert
+	assertions := assert.New(t)
+	memoryDialect := NewInMemoryDialect()
+	assertions.NoError(memoryDialect.PersistJournal(ctx, &Journal{
+		PersistenceID:  persistenceID,
+		SequenceNumber: 1,
+		EventManifest:  "persistence.Unknown",
+	}))
+
+	provider := NewSQLProvider(ctx, actor.NewActorSystem(), memoryDialect)
+	stream := provider.ReadJournal().CurrentEventsByPersistenceID(ctx, persistenceID, 1, 0)
+	assertions.Empty(collect(stream, -1))
+	assertions.Error(stream.Err())
+}
+
+func TestShreddedEvents(t *testing.T) {
+	ctx := context.TODO()
+	persistenceID := uuid.New().String()
+	shreddedID := uuid.New().String()
+
+	// get instance of assert
+	assertions := assert.New(t)
+	memoryDialect := NewInMemoryDialect()
+	tagger := func(string, proto.Message) []string {
+		return []string{"account"}
+	}
+	provider := NewSQLProvider(
+		ctx, actor.NewActorSystem(), memoryDialect, WithEncryption(NewInMemoryKeyProvider()), WithTagger(tagger),
+	)
+	state := provider.GetState()
+
+	// an event is shredded in the middle of the journal
+	state.PersistEvent(persistenceID, 1, &pb.AccountDebited{Balance: 1})
+	state.PersistEvent(shreddedID, 1, &pb.AccountDebited{Balance: 2})
+	state.PersistEvent(persistenceID, 2, &pb.AccountDebited{Balance: 3})
+	assertions.NoError(provider.CryptoShred(ctx, shreddedID))
+
+	// the queries skip it and carry on
+	readJournal := provider.ReadJournal()
+	stream := readJournal.CurrentAllEvents(ctx, 0)
+	assertions.Equal([]float32{1, 3}, balances(collect(stream, -1)))
+	assertions.NoError(stream.Err())
+
+	stream = readJournal.CurrentEventsByTag(ctx, "account", 0)
+	assertions.Equal([]float32{1, 3}, balances(collect(stream, -1)))
+	assertions.NoError(stream.Err())
+
+	stream = readJournal.CurrentEventsByPersistenceID(ctx, shreddedID, 1, 0)
+	assertions.Empty(collect(stream, -1))
+	assertions.NoError(stream.Err())
+
+	// the live queries keep on delivering the new events
+	liveCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream = readJournal.AllEvents(liveCtx, 0)
+	assertions.Equal([]float32{1, 3}, balances(collect(stream, 2)))
+	state.PersistEvent(persistenceID, 3, &pb.AccountDebited{Balance: 4})
+	assertions.Equal([]float32{4}, balances(collect(stream, 1)))
+}
+
+// gapDialect hides a journal row from the queries by ordering, as if its transaction had not committed yet
+type gapDialect struct {
+	*InMemoryDialect
+	mu     sync.Mutex
+	hidden int64
+}
+
+// GetJournalsByOrdering fetches the journal rows by ordering, the hidden one excepted
+func (d *gapDialect) GetJournalsByOrdering(
+	ctx context.Context, fromOrdering int64, toOrdering int64, limit int,
+) ([]*Journal, error) {
+	journals, err := d.InMemoryDialect.GetJournalsByOrdering(ctx, fromOrdering, toOrdering, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	visible := make([]*Journal, 0, len(journals))
+	for _, journal := range journals {
+		if journal.Ordering != d.hidden {
+			visible = append(visible, journal)
+		}
+	}
+	return visible, nil
+}
+
+// GetJournalOrderings fetches the orderings of the journal rows, the hidden one excepted
+func (d *gapDialect) GetJournalOrderings(ctx context.Context, fromOrdering int64, limit int) ([]*JournalOrdering, error) {
+	orderings, err := d.InMemoryDialect.GetJournalOrderings(ctx, fromOrdering, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	visible := make([]*JournalOrdering, 0, len(orderings))
+	for _, ordering := range orderings {
+		if ordering.Ordering != d.hidden {
+			visible = append(visible, ordering)
+		}
+	}
+	return visible, nil
+}
+
+// reveal makes the hidden journal row visible
+func (d *gapDialect) reveal() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hidden = 0
+}
+
+func TestCurrentAllEvents(t *testing.T) {
+	ctx := context.TODO()
+	persistenceID := uuid.New().String()
+	otherPersistenceID := uuid.New().String()
+
+	memoryDialect := NewInMemoryDialect()
+	provider := NewSQLProvider(
+		ctx, actor.NewActorSystem(), memoryDialect, WithReplayPageSize(2), WithLogicalDeletion(),
+	)
+	state := provider.GetState()
+	for i := 1; i <= 3; i++ {
+		state.PersistEvent(persistenceID, i, &pb.AccountDebited{Balance: float32(2*i - 1)})
+		state.PersistEvent(otherPersistenceID, i, &pb.AccountDebited{Balance: float32(2 * i)})
+	}
+	state.DeleteEvents(persistenceID, 1)
+	readJournal := provider.ReadJournal()
+
+	testCases := map[string]struct {
+		offset   int64
+		expected []float32
+	}{
+		"from the beginning": {offset: 0, expected: []float32{2, 3, 4, 5, 6}},
+		"resumed":            {offset: 4, expected: []float32{5, 6}},
+		"caught up":          {offset: 6, expected: []float32{}},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(
+			name, func(t *testing.T) {
+				// get instance of assert
+				assertions := assert.New(t)
+
+				stream := readJournal.CurrentAllEvents(ctx, testCase.offset)
+				envelopes := collect(stream, -1)
+				assertions.NoError(stream.Err())
+				assertions.Equal(testCase.expected, balances(envelopes))
+				for _, envelope := range envelopes {
+					// the offset of an event is its ordering
+					assertions.Equal(int64(envelope.Event.(*pb.AccountDebited).GetBalance()), envelope.Ordering)
+				}
+			},
+		)
+	}
+}
+
+func TestAllEvents(t *testing.T) {
+	persistenceID := uuid.New().String()
+	otherPersistenceID := uuid.New().String()
+
+	// get instance of assert
+	assertions := assert.New(t)
+	memoryDialect := NewInMemoryDialect()
+	provider := NewSQLProvider(context.TODO(), actor.NewActorSystem(), memoryDialect)
+	state := provider.GetState()
+	readJournal := provider.ReadJournal(WithPollInterval(5 * time.Millisecond))
+
+	state.PersistEvent(persistenceID, 1, &pb.AccountDebited{AccountNumber: persistenceID, Balance: 1})
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	stream := readJournal.AllEvents(ctx, 0)
+	assertions.Equal([]float32{1}, balances(collect(stream, 1)))
+
+	// the events stored afterwards are delivered as well, whatever their persistenceID
+	state.PersistEvent(otherPersistenceID, 1, &pb.AccountDebited{AccountNumber: otherPersistenceID, Balance: 2})
+	state.PersistEvent(persistenceID, 2, &pb.AccountDebited{AccountNumber: persistenceID, Balance: 3})
+	envelopes := collect(stream, 2)
+	assertions.Equal([]float32{2, 3}, balances(envelopes))
+
+	// the stream completes when the context is canceled
+	cancel()
+	assertions.Empty(collect(stream, -1))
+	assertions.ErrorIs(stream.Err(), context.Canceled)
+
+	// the stream resumes from a stored offset
+	ctx, cancel = context.WithCancel(context.TODO())
+	defer cancel()
+	stream = readJournal.AllEvents(ctx, envelopes[0].Ordering)
+	state.PersistEvent(otherPersistenceID, 2, &pb.AccountDebited{AccountNumber: otherPersistenceID, Balance: 4})
+	assertions.Equal([]float32{3, 4}, balances(collect(stream, 2)))
+}
+
+func TestAllEventsGapDetection(t *testing.T) {
+	persistenceID := uuid.New().String()
+	otherPersistenceID := uuid.New().String()
+
+	// persist writes the events of both persistenceIDs interleaved, hence they get the orderings 1, 2, 3
+	persist := func(dialect SQLDialect, timestamp int64) {
+		rows := []struct {
+			persistenceID  string
+			sequenceNumber int
+		}{{persistenceID, 1}, {otherPersistenceID, 1}, {persistenceID, 2}}
+
+		for i, row := range rows {
+			event := &pb.AccountDebited{Balance: float32(i + 1)}
+			journal, err := NewJournal(row.persistenceID, event, row.sequenceNumber, "writer")
+			assert.NoError(t, err)
+			if timestamp != 0 {
+				journal.Timestamp = timestamp
+			}
+			assert.NoError(t, dialect.PersistJournal(context.TODO(), journal))
+		}
+	}
+
+	t.Run(
+		"gap filled", func(t *testing.T) {
+			// get instance of assert
+			assertions := assert.New(t)
+			dialect := &gapDialect{InMemoryDialect: NewInMemoryDialect(), hidden: 2}
+			persist(dialect, 0)
+
+			ctx, cancel := context.WithCancel(context.TODO())
+			defer cancel()
+			provider := NewSQLProvider(ctx, actor.NewActorSystem(), dialect)
+			stream := provider.ReadJournal(WithPollInterval(5*time.Millisecond), WithGapTimeout(time.Minute)).
+				AllEvents(ctx, 0)
+			assertions.Equal([]float32{1}, balances(collect(stream, 1)))
+
+			// the row following the gap is held back until the gap is filled
+			select {
+			case envelope := <-stream.Events():
+				assertions.Failf("unexpected event", "ordering: %d", envelope.Ordering)
+			case <-time.After(50 * time.Millisecond):
+			}
+
+			dialect.reveal()
+			assertions.Equal([]float32{2, 3}, balances(collect(stream, 2)))
+		},
+	)
+
+	t.Run(
+		"gap timed out", func(t *testing.T) {
+			// get instance of assert
+			assertions := assert.New(t)
+			dialect := &gapDialect{InMemoryDialect: NewInMemoryDialect(), hidden: 2}
+			persist(dialect, 0)
+
+			provider := NewSQLProvider(context.TODO(), actor.NewActorSystem(), dialect)
+			stream := provider.ReadJournal(WithPollInterval(5*time.Millisecond), WithGapTimeout(20*time.Millisecond)).
+				CurrentAllEvents(context.TODO(), 0)
+			assertions.Equal([]float32{1, 3}, balances(collect(stream, -1)))
+			assertions.NoError(stream.Err())
+		},
+	)
+
+	t.Run(
+		"old gap", func(t *testing.T) {
+			// get instance of assert
+			assertions := assert.New(t)
+			dialect := &gapDialect{InMemoryDialect: NewInMemoryDialect(), hidden: 2}
+			persist(dialect, time.Now().Add(-time.Hour).Unix())
+
+			// the rows following the gap have been stored long ago, hence it is skipped right away
+			provider := NewSQLProvider(context.TODO(), actor.NewActorSystem(), dialect)
+			stream := provider.ReadJournal(WithPollInterval(time.Minute), WithGapTimeout(time.Minute)).
+				CurrentAllEvents(context.TODO(), 0)
+			assertions.Equal([]float32{1, 3}, balances(collect(stream, -1)))
+			assertions.NoError(stream.Err())
+		},
+	)
+}
+
+func TestEventsByTag(t *testing.T) {
+	persistenceID := uuid.New().String()
+	otherPersistenceID := uuid.New().String()
+
+	// get instance of assert
+	assertions := assert.New(t)
+	memoryDialect := NewInMemoryDialect()
+	tagger := func(persistenceID string, event proto.Message) []string {
+		tags := ManifestTagger(persistenceID, event)
+		if event.(*pb.AccountDebited).GetBalance() >= 3 {
+			tags = append(tags, "large")
+		}
+		return tags
+	}
+	provider := NewSQLProvider(context.TODO(), actor.NewActorSystem(), memoryDialect, WithTagger(tagger))
+	state := provider.GetState()
+	readJournal := provider.ReadJournal(WithPollInterval(5 * time.Millisecond))
+
+	state.PersistEvent(persistenceID, 1, &pb.AccountDebited{Balance: 1})
+	state.PersistEvent(otherPersistenceID, 1, &pb.AccountDebited{Balance: 3})
+	state.PersistEvent(persistenceID, 2, &pb.AccountDebited{Balance: 2})
+	state.PersistEvent(persistenceID, 3, &pb.AccountDebited{Balance: 4})
+	state.PersistSnapshot(persistenceID, 3, &pb.Account{AccountNumber: persistenceID})
+
+	// the current query delivers the tagged events stored so far
+	stream := readJournal.CurrentEventsByTag(context.TODO(), "persistence.AccountDebited", 0)
+	assertions.Equal([]float32{1, 3, 2, 4}, balances(collect(stream, -1)))
+	assertions.NoError(stream.Err())
+
+	stream = readJournal.CurrentEventsByTag(context.TODO(), "large", 0)
+	envelopes := collect(stream, -1)
+	assertions.Equal([]float32{3, 4}, balances(envelopes))
+	assertions.Equal([]string{otherPersistenceID, persistenceID}, []string{
+		envelopes[0].PersistenceID, envelopes[1].PersistenceID,
+	})
+
+	// the live query resumes from a stored offset and delivers the tagged events stored afterwards
+	ctx, cancel := context.WithCancel(context.TODO())
+	stream = readJournal.EventsByTag(ctx, "large", envelopes[0].Ordering)
+	assertions.Equal([]float32{4}, balances(collect(stream, 1)))
+
+	state.PersistEvent(otherPersistenceID, 2, &pb.AccountDebited{Balance: 1})
+	state.PersistEvent(otherPersistenceID, 3, &pb.AccountDebited{Balance: 5})
+	assertions.Equal([]float32{5}, balances(collect(stream, 1)))
+
+	cancel()
+	assertions.Empty(collect(stream, -1))
+	assertions.ErrorIs(stream.Err(), context.Canceled)
+
+	// unknown tags deliver nothing
+	stream = readJournal.CurrentEventsByTag(context.TODO(), "unknown", 0)
+	assertions.Empty(collect(stream, -1))
+	assertions.NoError(stream.Err())
+}
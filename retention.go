@@ -0,0 +1,149 @@
+package persistencesql
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// RetentionPolicy selects, among the snapshots of a persistence ID ordered by sequence number, the sequence numbers
+// of the snapshots to prune. now is the time at which the policy is enforced.
+// Whatever the policy selects, the latest snapshot of a persistence ID is never pruned since it is the one the
+// persistent actor recovers from.
+type RetentionPolicy = func(snapshots []*SnapshotMetadata, now time.Time) []int
+
+// PruneHook is called with the number of snapshots pruned for a persistence ID
+type PruneHook = func(persistenceID string, pruned int64)
+
+// KeepLast keeps the latest n snapshots of every persistence ID
+func KeepLast(n int) RetentionPolicy {
+	return func(snapshots []*SnapshotMetadata, _ time.Time) []int {
+		prune := make([]int, 0)
+		for i := 0; i < len(snapshots)-n; i++ {
+			prune = append(prune, snapshots[i].SequenceNumber)
+		}
+		return prune
+	}
+}
+
+// KeepYoungerThan keeps the snapshots younger than the given age
+func KeepYoungerThan(age time.Duration) RetentionPolicy {
+	return func(snapshots []*SnapshotMetadata, now time.Time) []int {
+		prune := make([]int, 0)
+		for _, snapshot := range snapshots {
+			if now.Sub(time.Unix(snapshot.Timestamp, 0)) > age {
+				prune = append(prune, snapshot.SequenceNumber)
+			}
+		}
+		return prune
+	}
+}
+
+// KeepOnePerBucket splits the time into buckets of the given width and keeps the latest snapshot of every bucket.
+// Buckets are aligned on the Unix epoch, e.g. a width of 24 hours keeps one snapshot per UTC day
+func KeepOnePerBucket(width time.Duration) RetentionPolicy {
+	return func(snapshots []*SnapshotMetadata, _ time.Time) []int {
+		seconds := int64(width / time.Second)
+		if seconds <= 0 {
+			return nil
+		}
+
+		// snapshots are ordered by sequence number, the latest snapshot of a bucket is the last one seen
+		latest := make(map[int64]int)
+		for _, snapshot := range snapshots {
+			latest[snapshot.Timestamp/seconds] = snapshot.SequenceNumber
+		}
+
+		prune := make([]int, 0)
+		for _, snapshot := range snapshots {
+			if latest[snapshot.Timestamp/seconds] != snapshot.SequenceNumber {
+				prune = append(prune, snapshot.SequenceNumber)
+			}
+		}
+		return prune
+	}
+}
+
+// pruneSnapshots enforces the retention policy on the snapshots of a given persistence ID and reports the number
+// of pruned snapshots to the prune hook
+func (p *SQLProvider) pruneSnapshots(ctx context.Context, persistenceID string) error {
+	snapshots, err := p.dialect.ListSnapshots(ctx, persistenceID)
+	if err != nil {
+		return err
+	}
+
+	// the latest snapshot is always kept
+	if len(snapshots) < 2 {
+		return nil
+	}
+	latest := snapshots[len(snapshots)-1].SequenceNumber
+
+	sequenceNumbers := make([]int, 0)
+	for _, sequenceNumber := range p.retentionPolicy(snapshots, time.Now()) {
+		if sequenceNumber != latest {
+			sequenceNumbers = append(sequenceNumbers, sequenceNumber)
+		}
+	}
+
+	if len(sequenceNumbers) == 0 {
+		return nil
+	}
+
+	pruned, err := p.dialect.PruneSnapshots(ctx, persistenceID, sequenceNumbers)
+	if err != nil {
+		return err
+	}
+
+	if pruned > 0 && p.pruneHook != nil {
+		p.pruneHook(persistenceID, pruned)
+	}
+	return nil
+}
+
+// sweepSnapshots enforces the retention policy on every persistence ID at the sweep interval until the provider
+// context is done
+func (p *SQLProvider) sweepSnapshots() {
+	ticker := time.NewTicker(p.sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+			tenants, err := p.sweptTenants()
+			if err != nil {
+				log.Printf("error listing the snapshot tenants: %v", err)
+				continue
+			}
+
+			for _, tenant := range tenants {
+				p.sweepTenantSnapshots(WithTenant(p.ctx, tenant))
+			}
+		}
+	}
+}
+
+// sweptTenants returns the tenants the provider writes the snapshots to: the tenant of its context, the tenants
+// that have snapshots when the tenants are resolved from the persistence IDs, the default tenant otherwise
+func (p *SQLProvider) sweptTenants() ([]string, error) {
+	if tenant, ok := TenantFromContext(p.ctx); ok || p.tenantResolver == nil {
+		return []string{tenant}, nil
+	}
+	return p.dialect.ListSnapshotTenants(p.ctx)
+}
+
+// sweepTenantSnapshots enforces the retention policy on every persistence ID of the tenant ctx is scoped to
+func (p *SQLProvider) sweepTenantSnapshots(ctx context.Context) {
+	persistenceIDs, err := p.dialect.ListSnapshotPersistenceIDs(ctx)
+	if err != nil {
+		log.Printf("error listing the snapshot persistence IDs of tenant: %s: %v", tenantOf(ctx), err)
+		return
+	}
+
+	for _, persistenceID := range persistenceIDs {
+		if err := p.pruneSnapshots(ctx, persistenceID); err != nil {
+			log.Printf("error pruning the snapshots of persistenceID: %s: %v", persistenceID, err)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package persistencesql
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AsynkronIT/protoactor-go/actor"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	pb "github.com/tochemey/protoactor-persistence-sql/gen"
+)
+
+func TestRetentionPolicy(t *testing.T) {
+	now := time.Date(2021, 6, 10, 12, 0, 0, 0, time.UTC)
+	hour := int64(time.Hour / time.Second)
+
+	// one snapshot every half an hour over the last three hours
+	snapshots := make([]*SnapshotMetadata, 0)
+	for i := 0; i < 6; i++ {
+		snapshots = append(snapshots, &SnapshotMetadata{
+			PersistenceID:  "some-persistence-id",
+			SequenceNumber: (i + 1) * 10,
+			Timestamp:      now.Unix() - 3*hour + int64(i)*hour/2,
+		})
+	}
+
+	testCases := map[string]struct {
+		policy   RetentionPolicy
+		expected []int
+	}{
+		"keep last": {
+			policy:   KeepLast(2),
+			expected: []int{10, 20, 30, 40},
+		},
+		"keep last more than available": {
+			policy:   KeepLast(10),
+			expected: []int{},
+		},
+		"keep younger than": {
+			policy:   KeepYoungerThan(90 * time.Minute),
+			expected: []int{10, 20, 30},
+		},
+		"keep one per bucket": {
+			policy:   KeepOnePerBucket(time.Hour),
+			expected: []int{10, 30, 50},
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(
+			name, func(t *testing.T) {
+				// get instance of assert
+				assertions := assert.New(t)
+				assertions.Equal(testCase.expected, testCase.policy(snapshots, now))
+			},
+		)
+	}
+}
+
+func TestSnapshotRetention(t *testing.T) {
+	persistenceID := uuid.New().String()
+
+	// get instance of assert
+	assertions := assert.New(t)
+	memoryDialect := NewInMemoryDialect()
+
+	var mu sync.Mutex
+	pruned := make(map[string]int64)
+	provider := NewSQLProvider(
+		context.TODO(), actor.NewActorSystem(), memoryDialect,
+		WithSnapshotRetention(KeepLast(2)),
+		WithSnapshotPruneHook(func(persistenceID string, count int64) {
+			mu.Lock()
+			defer mu.Unlock()
+			pruned[persistenceID] += count
+		}),
+	)
+	state := provider.GetState()
+
+	for i := 1; i <= 5; i++ {
+		state.PersistSnapshot(persistenceID, i, &pb.Account{AccountNumber: persistenceID, ActualBalance: float32(i)})
+	}
+
+	// the policy is enforced after every snapshot
+	snapshots := memoryDialect.Snapshots(persistenceID)
+	if assertions.Len(snapshots, 2) {
+		assertions.Equal(4, snapshots[0].SequenceNumber)
+		assertions.Equal(5, snapshots[1].SequenceNumber)
+	}
+	assertions.EqualValues(3, pruned[persistenceID])
+
+	// the latest snapshot is kept whatever the policy
+	provider = NewSQLProvider(
+		context.TODO(), actor.NewActorSystem(), memoryDialect, WithSnapshotRetention(KeepLast(0)),
+	)
+	provider.GetState().PersistSnapshot(persistenceID, 6, &pb.Account{AccountNumber: persistenceID})
+	snapshots = memoryDialect.Snapshots(persistenceID)
+	if assertions.Len(snapshots, 1) {
+		assertions.Equal(6, snapshots[0].SequenceNumber)
+	}
+}
+
+func TestSnapshotSweeper(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	persistenceIDs := []string{uuid.New().String(), uuid.New().String()}
+
+	// get instance of assert
+	assertions := assert.New(t)
+	memoryDialect := NewInMemoryDialect()
+
+	var mu sync.Mutex
+	pruned := int64(0)
+	provider := NewSQLProvider(
+		ctx, actor.NewActorSystem(), memoryDialect,
+		WithSnapshotRetention(KeepLast(1)),
+		WithSnapshotSweeper(10*time.Millisecond),
+		WithSnapshotPruneHook(func(_ string, count int64) {
+			mu.Lock()
+			defer mu.Unlock()
+			pruned += count
+		}),
+	)
+	state := provider.GetState()
+
+	for _, persistenceID := range persistenceIDs {
+		for i := 1; i <= 3; i++ {
+			state.PersistSnapshot(persistenceID, i, &pb.Account{AccountNumber: persistenceID})
+		}
+	}
+
+	// the sweeper prunes the snapshots of every persistence ID in the background
+	assertions.Eventually(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return pruned == 4
+	}, time.Second, 5*time.Millisecond)
+
+	for _, persistenceID := range persistenceIDs {
+		snapshots := memoryDialect.Snapshots(persistenceID)
+		if assertions.Len(snapshots, 1) {
+			assertions.Equal(3, snapshots[0].SequenceNumber)
+		}
+	}
+}
+
+func TestSnapshotSweeperTenants(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	// get instance of assert
+	assertions := assert.New(t)
+	memoryDialect := NewInMemoryDialect()
+	state := NewSQLProvider(
+		ctx, actor.NewActorSystem(), memoryDialect, WithTenantResolver(PrefixTenantResolver("/")),
+	).GetState()
+	for _, persistenceID := range []string{"acme/account", "globex/account"} {
+		for i := 1; i <= 3; i++ {
+			state.PersistSnapshot(persistenceID, i, &pb.Account{AccountNumber: persistenceID})
+		}
+	}
+
+	// sweep runs a sweeper until the snapshots of the given persistence ID have been pruned
+	sweep := func(ctx context.Context, persistenceID string, opts ...OptFunc) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		opts = append(opts, WithSnapshotRetention(KeepLast(1)), WithSnapshotSweeper(10*time.Millisecond))
+		NewSQLProvider(ctx, actor.NewActorSystem(), memoryDialect, opts...)
+		assertions.Eventually(func() bool {
+			return len(memoryDialect.Snapshots(persistenceID)) == 1
+		}, time.Second, 5*time.Millisecond)
+	}
+
+	// a sweeper scoped to a tenant only prunes the snapshots of that tenant
+	sweep(WithTenant(ctx, "acme"), "acme/account")
+	assertions.Len(memoryDialect.Snapshots("globex/account"), 3)
+
+	// a sweeper resolving the tenants prunes the snapshots of every tenant
+	sweep(ctx, "globex/account", WithTenantResolver(PrefixTenantResolver("/")))
+	for _, persistenceID := range []string{"acme/account", "globex/account"} {
+		snapshots := memoryDialect.Snapshots(persistenceID)
+		if assertions.Len(snapshots, 1) {
+			assertions.Equal(3, snapshots[0].SequenceNumber)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package persistencesql
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+const (
+	// ProtoSerializerID identifies the serializers using the protobuf binary wire format
+	ProtoSerializerID = "proto"
+	// ProtoJSONSerializerID identifies the serializers using the protobuf JSON format
+	ProtoJSONSerializerID = "protojson"
+)
+
+// Serializer turns the events and the snapshots into the payloads stored in the database and back
+type Serializer interface {
+	// ID identifies the format of the payloads. It is recorded along with every row so that the rows are decoded by
+	// a serializer of the format they have been encoded with, whatever the serializer currently set
+	ID() string
+	// Serialize encodes a message into a payload along with the manifest required to decode it
+	Serialize(message proto.Message) ([]byte, Manifest, error)
+	// Deserialize decodes a payload given its manifest
+	Deserialize(payload []byte, manifest Manifest) (proto.Message, error)
+}
+
+// defaultSerializer is the serializer used when none is set on the provider
+var defaultSerializer = NewProtoSerializer()
+
+// protoSerializer encodes messages using the protobuf binary wire format
+type protoSerializer struct {
+	resolver protoregistry.MessageTypeResolver
+}
+
+// enforces that protoSerializer implements the Serializer interface
+var _ Serializer = (*protoSerializer)(nil)
+
+// NewProtoSerializer creates a Serializer using the protobuf binary wire format. Message types are resolved
+// from the global registry
+func NewProtoSerializer() Serializer {
+	return &protoSerializer{resolver: protoregistry.GlobalTypes}
+}
+
+// NewRegistrySerializer creates a Serializer using the protobuf binary wire format. Message types are resolved
+// from the given registry instead of the global one, e.g. a private protoregistry.Types
+func NewRegistrySerializer(resolver protoregistry.MessageTypeResolver) Serializer {
+	return &protoSerializer{resolver: resolver}
+}
+
+// ID identifies the format of the payloads
+func (s *protoSerializer) ID() string {
+	return ProtoSerializerID
+}
+
+// Serialize encodes a message into a payload along with the manifest required to decode it
+func (s *protoSerializer) Serialize(message proto.Message) ([]byte, Manifest, error) {
+	bytes, err := proto.Marshal(message)
+	if err != nil {
+		return nil, "", err
+	}
+	return bytes, Manifest(proto.MessageName(message)), nil
+}
+
+// Deserialize decodes a payload given its manifest
+func (s *protoSerializer) Deserialize(payload []byte, manifest Manifest) (proto.Message, error) {
+	message, err := newMessage(s.resolver, manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = proto.Unmarshal(payload, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// protoJSONSerializer encodes messages using the protobuf JSON format
+type protoJSONSerializer struct {
+	resolver interface {
+		protoregistry.MessageTypeResolver
+		protoregistry.ExtensionTypeResolver
+	}
+}
+
+// enforces that protoJSONSerializer implements the Serializer interface
+var _ Serializer = (*protoJSONSerializer)(nil)
+
+// NewProtoJSONSerializer creates a Serializer using the protobuf JSON format, which makes the payloads human-readable.
+// Message types are resolved from the global registry
+func NewProtoJSONSerializer() Serializer {
+	return &protoJSONSerializer{resolver: protoregistry.GlobalTypes}
+}
+
+// ID identifies the format of the payloads
+func (s *protoJSONSerializer) ID() string {
+	return ProtoJSONSerializerID
+}
+
+// Serialize encodes a message into a payload along with the manifest required to decode it
+func (s *protoJSONSerializer) Serialize(message proto.Message) ([]byte, Manifest, error) {
+	bytes, err := (protojson.MarshalOptions{Resolver: s.resolver}).Marshal(message)
+	if err != nil {
+		return nil, "", err
+	}
+	return bytes, Manifest(proto.MessageName(message)), nil
+}
+
+// Deserialize decodes a payload given its manifest
+func (s *protoJSONSerializer) Deserialize(payload []byte, manifest Manifest) (proto.Message, error) {
+	message, err := newMessage(s.resolver, manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = (protojson.UnmarshalOptions{Resolver: s.resolver}).Unmarshal(payload, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// newMessage creates an empty message of the type named by the manifest
+func newMessage(resolver protoregistry.MessageTypeResolver, manifest Manifest) (proto.Message, error) {
+	mt, err := resolver.FindMessageByName(protoreflect.FullName(manifest))
+	if err != nil {
+		return nil, err
+	}
+	return mt.New().Interface(), nil
+}
+
+// serializers are the serializers the rows are decoded with, by ID
+type serializers map[string]Serializer
+
+// newSerializers creates the serializers decoding the rows written by the built-in serializers and the given ones.
+// The given serializers take precedence over the built-in ones of the same ID, the last one winning
+func newSerializers(given ...Serializer) serializers {
+	known := serializers{
+		ProtoSerializerID:     defaultSerializer,
+		ProtoJSONSerializerID: NewProtoJSONSerializer(),
+	}
+	for _, serializer := range given {
+		known[serializer.ID()] = serializer
+	}
+	return known
+}
+
+// of returns the serializer of a given ID. The rows written before the serializer was recorded use the protobuf
+// binary wire format
+func (s serializers) of(id string) (Serializer, error) {
+	if id == "" {
+		id = ProtoSerializerID
+	}
+
+	serializer, ok := s[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown serializer: %s", id)
+	}
+	return serializer, nil
+}
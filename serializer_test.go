@@ -0,0 +1,199 @@
+package persistencesql
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/AsynkronIT/protoactor-go/actor"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	pb "github.com/tochemey/protoactor-persistence-sql/gen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestSerializer(t *testing.T) {
+	// a private registry only knowing about the account events
+	registry := new(protoregistry.Types)
+	assert.NoError(t, registry.RegisterMessage((&pb.AccountDebited{}).ProtoReflect().Type()))
+
+	event := &pb.AccountDebited{AccountNumber: "1234555", Balance: 2000}
+
+	testCases := map[string]struct {
+		serializer Serializer
+		json       bool
+	}{
+		"protobuf": {
+			serializer: NewProtoSerializer(),
+		},
+		"protobuf json": {
+			serializer: NewProtoJSONSerializer(),
+			json:       true,
+		},
+		"private registry": {
+			serializer: NewRegistrySerializer(registry),
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(
+			name, func(t *testing.T) {
+				// get instance of assert
+				assertions := assert.New(t)
+
+				payload, manifest, err := testCase.serializer.Serialize(event)
+				assertions.NoError(err)
+				assertions.Equal(Manifest(proto.MessageName(event)), manifest)
+				assertions.Equal(testCase.json, json.Valid(payload))
+
+				message, err := testCase.serializer.Deserialize(payload, manifest)
+				assertions.NoError(err)
+				assertions.True(proto.Equal(event, message))
+
+				// unknown manifests cannot be decoded
+				message, err = testCase.serializer.Deserialize(payload, "persistence.Unknown")
+				assertions.Error(err)
+				assertions.Nil(message)
+			},
+		)
+	}
+}
+
+func TestRegistrySerializerUnregisteredType(t *testing.T) {
+	// get instance of assert
+	assertions := assert.New(t)
+
+	// the global registry knows about the wrappers, the private one does not
+	payload, manifest, err := NewProtoSerializer().Serialize(wrapperspb.String("some-value"))
+	assertions.NoError(err)
+
+	message, err := NewRegistrySerializer(new(protoregistry.Types)).Deserialize(payload, manifest)
+	assertions.ErrorIs(err, protoregistry.NotFound)
+	assertions.Nil(message)
+}
+
+func TestProviderSerializer(t *testing.T) {
+	persistenceID := uuid.New().String()
+
+	// get instance of assert
+	assertions := assert.New(t)
+	memoryDialect := NewInMemoryDialect()
+	provider := NewSQLProvider(
+		context.TODO(), actor.NewActorSystem(), memoryDialect, WithSerializer(NewProtoJSONSerializer()),
+	)
+	state := provider.GetState()
+
+	event := &pb.AccountDebited{AccountNumber: persistenceID, Balance: 100}
+	snapshot := &pb.Account{AccountNumber: persistenceID, ActualBalance: 100}
+	state.PersistEvent(persistenceID, 1, event)
+	state.PersistSnapshot(persistenceID, 1, snapshot)
+
+	// the payloads are stored as JSON
+	journals := memoryDialect.Journals(persistenceID)
+	if assertions.Len(journals, 1) {
+		assertions.True(json.Valid(journals[0].Payload))
+	}
+	snapshots := memoryDialect.Snapshots(persistenceID)
+	if assertions.Len(snapshots, 1) {
+		assertions.True(json.Valid(snapshots[0].Snapshot))
+	}
+
+	// and decoded back on recovery
+	recovered, _, ok := state.GetSnapshot(persistenceID)
+	assertions.True(ok)
+	assertions.True(proto.Equal(snapshot, recovered.(proto.Message)))
+
+	replayed := make([]interface{}, 0)
+	state.GetEvents(persistenceID, 1, 0, func(e interface{}) {
+		replayed = append(replayed, e)
+	})
+	if assertions.Len(replayed, 1) {
+		assertions.True(proto.Equal(event, replayed[0].(proto.Message)))
+	}
+}
+
+// customSerializer is a custom serializer using the protobuf JSON format under its own ID
+type customSerializer struct {
+	Serializer
+}
+
+// ID identifies the format of the payloads
+func (s customSerializer) ID() string {
+	return "custom"
+}
+
+func TestProviderSerializerChange(t *testing.T) {
+	testCases := map[string]struct {
+		written Serializer
+		opts    []OptFunc
+		fail    bool
+	}{
+		"built-in serializer": {
+			written: NewProtoJSONSerializer(),
+		},
+		"former serializer": {
+			written: customSerializer{NewProtoJSONSerializer()},
+			opts:    []OptFunc{WithFormerSerializers(customSerializer{NewProtoJSONSerializer()})},
+		},
+		"unknown serializer": {
+			written: customSerializer{NewProtoJSONSerializer()},
+			fail:    true,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(
+			name, func(t *testing.T) {
+				persistenceID := uuid.New().String()
+
+				// get instance of assert
+				assertions := assert.New(t)
+				memoryDialect := NewInMemoryDialect()
+				event := &pb.AccountDebited{AccountNumber: persistenceID, Balance: 100}
+				snapshot := &pb.Account{AccountNumber: persistenceID, ActualBalance: 100}
+
+				// the history is written with a serializer
+				state := NewSQLProvider(
+					context.TODO(), actor.NewActorSystem(), memoryDialect, WithSerializer(testCase.written),
+				).GetState()
+				state.PersistEvent(persistenceID, 1, event)
+				state.PersistSnapshot(persistenceID, 1, snapshot)
+				journals := memoryDialect.Journals(persistenceID)
+				if assertions.Len(journals, 1) {
+					assertions.Equal(testCase.written.ID(), journals[0].Serializer)
+				}
+
+				// and read once the default serializer is set
+				var failures int
+				opts := append(testCase.opts, WithErrorHandler(func(err *PersistenceError) Directive {
+					failures++
+					return ResumeDirective
+				}))
+				state = NewSQLProvider(context.TODO(), actor.NewActorSystem(), memoryDialect, opts...).GetState()
+				state.PersistEvent(persistenceID, 2, event)
+
+				recovered, _, ok := state.GetSnapshot(persistenceID)
+				replayed := make([]interface{}, 0)
+				state.GetEvents(persistenceID, 1, 0, func(e interface{}) {
+					replayed = append(replayed, e)
+				})
+
+				if testCase.fail {
+					assertions.False(ok)
+					assertions.NotZero(failures)
+					return
+				}
+
+				assertions.Zero(failures)
+				assertions.True(ok)
+				assertions.True(proto.Equal(snapshot, recovered.(proto.Message)))
+				if assertions.Len(replayed, 2) {
+					assertions.True(proto.Equal(event, replayed[0].(proto.Message)))
+					assertions.True(proto.Equal(event, replayed[1].(proto.Message)))
+				}
+			},
+		)
+	}
+}
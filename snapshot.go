@@ -1,12 +1,9 @@
 package persistencesql
 
 import (
-	"log"
 	"time"
 
 	"google.golang.org/protobuf/proto"
-	"google.golang.org/protobuf/reflect/protoreflect"
-	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
 // Snapshot defines the snapshot row
@@ -23,14 +20,39 @@ type Snapshot struct {
 	SnapshotManifest Manifest
 	// Unique identifier of the writing persistent actor.
 	WriterID string
+	// The codec the snapshot has been compressed with. Empty when the snapshot is not compressed
+	Codec Codec
+	// The ID of the key the payload has been encrypted with. Empty when the payload is not encrypted
+	KeyID string
+	// The tenant the snapshot belongs to. Empty for the default tenant
+	TenantID string
+	// The ID of the serializer the snapshot has been encoded with. Empty for the rows written before the serializer was
+	// recorded, which use the protobuf binary wire format
+	Serializer string
 }
 
-// NewSnapshot creates a new instance of Snapshot
-func NewSnapshot(persistenceID string, message proto.Message, sequenceNumber int, writerID string) *Snapshot {
-	manifest := proto.MessageName(message)
-	bytes, err := proto.Marshal(message)
+// SnapshotMetadata describes a snapshot row without its payload
+type SnapshotMetadata struct {
+	// Persistent ID that journals a persistent message.
+	PersistenceID string
+	// This persistent message's sequence number
+	SequenceNumber int
+	// The `timestamp` is the time the snapshot was stored, in seconds since midnight, January 1, 1970 UTC.
+	Timestamp int64
+}
+
+// NewSnapshot creates a new instance of Snapshot. The snapshot is encoded using the protobuf binary wire format
+func NewSnapshot(persistenceID string, message proto.Message, sequenceNumber int, writerID string) (*Snapshot, error) {
+	return newSnapshot(defaultSerializer, persistenceID, message, sequenceNumber, writerID)
+}
+
+// newSnapshot creates a new instance of Snapshot encoding the snapshot with the given serializer
+func newSnapshot(
+	serializer Serializer, persistenceID string, message proto.Message, sequenceNumber int, writerID string,
+) (*Snapshot, error) {
+	bytes, manifest, err := serializer.Serialize(message)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	return &Snapshot{
@@ -38,21 +60,13 @@ func NewSnapshot(persistenceID string, message proto.Message, sequenceNumber int
 		SequenceNumber:   sequenceNumber,
 		Timestamp:        time.Now().UTC().Unix(),
 		Snapshot:         bytes,
-		SnapshotManifest: Manifest(manifest),
+		SnapshotManifest: manifest,
 		WriterID:         writerID,
-	}
+		Serializer:       serializer.ID(),
+	}, nil
 }
 
-func (snapshot *Snapshot) message() proto.Message {
-	mt, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(snapshot.SnapshotManifest))
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	pm := mt.New().Interface()
-	err = proto.Unmarshal(snapshot.Snapshot, pm)
-	if err != nil {
-		log.Fatal(err)
-	}
-	return pm
+// message decodes the snapshot using the given serializer
+func (snapshot *Snapshot) message(serializer Serializer) (proto.Message, error) {
+	return serializer.Deserialize(snapshot.Snapshot, snapshot.SnapshotManifest)
 }
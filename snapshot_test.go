@@ -18,8 +18,26 @@ func TestSnapshot(t *testing.T) {
 		ActualBalance: 2000,
 	}
 
-	snapshot := NewSnapshot("some-persistence-id", state, 1, "some-writer-id")
+	snapshot, err := NewSnapshot("some-persistence-id", state, 1, "some-writer-id")
+	assertions.NoError(err)
 
 	assertions.Equal(snapshot.SnapshotManifest, Manifest(proto.MessageName(state)))
-	assertions.True(proto.Equal(snapshot.message(), state))
+	message, err := snapshot.message(defaultSerializer)
+	assertions.NoError(err)
+	assertions.True(proto.Equal(message, state))
+}
+
+func TestSnapshotUnknownManifest(t *testing.T) {
+	// get instance of assert
+	assertions := assert.New(t)
+
+	snapshot := &Snapshot{
+		PersistenceID:    "some-persistence-id",
+		SequenceNumber:   1,
+		SnapshotManifest: "persistence.Unknown",
+	}
+
+	message, err := snapshot.message(defaultSerializer)
+	assertions.Error(err)
+	assertions.Nil(message)
 }
@@ -0,0 +1,26 @@
+package persistencesql
+
+import (
+	"context"
+
+	"github.com/AsynkronIT/protoactor-go/actor"
+)
+
+// NewSQLiteProvider creates an instance SQLite base SQLProvider
+func NewSQLiteProvider(ctx context.Context, actorSystem *actor.ActorSystem, dbConfig *DBConfig, opts ...OptFunc) (*SQLProvider, error) {
+	dialect, err := NewSQLiteDialect(dbConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSQLProvider(ctx, actorSystem, dialect, opts...), nil
+}
+
+// NewSQLiteDialect creates a new instance of SQLDialect
+func NewSQLiteDialect(dbConfig *DBConfig) (SQLDialect, error) {
+	dialect, err := NewDialect(dbConfig, SQLITE)
+	if err != nil {
+		return nil, err
+	}
+	return dialect, nil
+}
@@ -0,0 +1,158 @@
+package persistencesql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	pb "github.com/tochemey/protoactor-persistence-sql/gen"
+)
+
+func TestSQLiteConnection(t *testing.T) {
+	ctx := context.TODO()
+	testCases := map[string]struct {
+		config      *DBConfig
+		expectError bool
+	}{
+		"file database": {
+			config:      NewSQLiteConfig(filepath.Join(t.TempDir(), "journal.db")),
+			expectError: false,
+		},
+		"in-memory database": {
+			config:      NewSQLiteConfig(":memory:"),
+			expectError: false,
+		},
+		"directory does not exist": {
+			config:      NewSQLiteConfig(filepath.Join(t.TempDir(), "unknown", "journal.db")),
+			expectError: true,
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(
+			testName, func(t *testing.T) {
+				// get instance of assert
+				assertions := assert.New(t)
+
+				// create the dialect instance
+				dialect, err := NewSQLiteDialect(testCase.config)
+				assertions.NoError(err)
+				assertions.NotNil(dialect)
+
+				err = dialect.Connect(ctx)
+				switch testCase.expectError {
+				case false:
+					assertions.NoError(err)
+					assertions.NoError(dialect.Close())
+				default:
+					assertions.Error(err)
+				}
+			},
+		)
+	}
+}
+
+func TestSQLiteDialect(t *testing.T) {
+	ctx := context.TODO()
+	dbPath := filepath.Join(t.TempDir(), "journal.db")
+
+	// get instance of assert
+	assertions := assert.New(t)
+	// create the sqliteDialect instance
+	sqliteDialect, err := NewSQLiteDialect(NewSQLiteConfig(dbPath))
+	assertions.NoError(err)
+	assertions.NotNil(sqliteDialect)
+
+	// connect to the database
+	err = sqliteDialect.Connect(ctx)
+	assertions.NoError(err)
+	defer sqliteDialect.Close()
+
+	// create the journal and snapshot table successfully
+	err = sqliteDialect.CreateSchemasIfNotExist(ctx)
+	assertions.NoError(err)
+
+	// open a handle on the database file to inspect it
+	sqliteHandle, err := sql.Open(SQLITE.String(), dbPath)
+	assertions.NoError(err)
+	defer sqliteHandle.Close()
+
+	// check whether both tables have been created
+	err = tableExist(sqliteHandle, SQLITE, "", "journal")
+	assertions.NoError(err)
+	err = tableExist(sqliteHandle, SQLITE, "", "snapshot")
+	assertions.NoError(err)
+}
+
+func TestSQLiteInMemoryDialect(t *testing.T) {
+	ctx := context.TODO()
+	persistenceID := uuid.New().String()
+
+	// get instance of assert
+	assertions := assert.New(t)
+	// create the sqliteDialect instance
+	sqliteDialect, err := NewSQLiteDialect(NewSQLiteConfig(":memory:"))
+	assertions.NoError(err)
+
+	// connect to the database and create the tables
+	assertions.NoError(sqliteDialect.Connect(ctx))
+	defer sqliteDialect.Close()
+	assertions.NoError(sqliteDialect.CreateSchemasIfNotExist(ctx))
+
+	// insert events into the journal store
+	for i := 0; i < 5; i++ {
+		journal, err := NewJournal(persistenceID, &pb.AccountDebited{
+			AccountNumber: persistenceID,
+			Balance:       float32(i * 100),
+		}, i+1, "some-actor-pid")
+		assertions.NoError(err)
+		assertions.NoError(sqliteDialect.PersistJournal(ctx, journal))
+	}
+
+	journals, err := sqliteDialect.GetJournals(ctx, persistenceID, 1, math.MaxInt32)
+	assertions.NoError(err)
+	assertions.Len(journals, 5)
+	for i, journal := range journals {
+		assertions.Equal(i+1, journal.SequenceNumber)
+		assertions.False(journal.Deleted)
+	}
+}
+
+func TestSQLiteWriterFencing(t *testing.T) {
+	ctx := context.TODO()
+	persistenceID := uuid.New().String()
+
+	// get instance of assert
+	assertions := assert.New(t)
+	// create the sqliteDialect instance
+	sqliteDialect, err := NewSQLiteDialect(NewSQLiteConfig(filepath.Join(t.TempDir(), "journal.db")))
+	assertions.NoError(err)
+
+	// connect to the database and create the tables
+	assertions.NoError(sqliteDialect.Connect(ctx))
+	defer sqliteDialect.Close()
+	assertions.NoError(sqliteDialect.CreateSchemasIfNotExist(ctx))
+	assertions.NoError(sqliteDialect.ClaimWriter(ctx, persistenceID, "writer-1"))
+
+	// the claim of another writer fences both the single and the batch writes
+	journal, err := NewJournal(persistenceID, &pb.AccountDebited{}, 1, "writer-2")
+	assertions.NoError(err)
+	assertions.True(errors.Is(sqliteDialect.PersistJournal(ctx, journal), ErrStaleWriter))
+	assertions.True(errors.Is(sqliteDialect.PersistJournals(ctx, []*Journal{journal}), ErrStaleWriter))
+
+	// the claims are not checked when the writers are not fenced
+	unfenced := withoutWriterFencing(ctx)
+	assertions.NoError(sqliteDialect.PersistJournal(unfenced, journal))
+	journal, err = NewJournal(persistenceID, &pb.AccountDebited{}, 2, "writer-2")
+	assertions.NoError(err)
+	assertions.NoError(sqliteDialect.PersistJournals(unfenced, []*Journal{journal}))
+
+	journals, err := sqliteDialect.GetJournals(ctx, persistenceID, 1, math.MaxInt32)
+	assertions.NoError(err)
+	assertions.Len(journals, 2)
+}
@@ -0,0 +1,98 @@
+package persistencesql_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	persistencesql "github.com/tochemey/protoactor-persistence-sql"
+	"github.com/tochemey/protoactor-persistence-sql/dialecttest"
+)
+
+func TestSQLDialectSuite(t *testing.T) {
+	testCases := map[string]dialecttest.Factory{
+		"postgres": func(t *testing.T) persistencesql.SQLDialect {
+			dialect, err := persistencesql.NewPostgresDialect(persistencesql.PostgresTestConfig())
+			if err != nil {
+				t.Fatal(err)
+			}
+			return dialect
+		},
+		"postgres with mixed-case names": func(t *testing.T) persistencesql.SQLDialect {
+			config := persistencesql.PostgresTestConfig()
+			persistencesql.WithNotifications()(config)
+			persistencesql.WithTableNames(
+				persistencesql.TableNames{
+					Journal: "EsJournal", Snapshot: "EsSnapshot", Tag: "EsTag", Writer: "EsWriter",
+					ProjectionOffset: "EsProjection", Outbox: "EsOutbox", SchemaVersion: "EsSchemaVersion",
+				},
+			)(config)
+			persistencesql.WithJournalColumns(
+				persistencesql.JournalColumns{PersistenceID: "StreamID", SequenceNumber: "StreamVersion"},
+			)(config)
+			dialect, err := persistencesql.NewPostgresDialect(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return dialect
+		},
+		"mysql": func(t *testing.T) persistencesql.SQLDialect {
+			dialect, err := persistencesql.NewMySQLDialect(persistencesql.MySQLTestConfig())
+			if err != nil {
+				t.Fatal(err)
+			}
+			return dialect
+		},
+		"sqlite": func(t *testing.T) persistencesql.SQLDialect {
+			dialect, err := persistencesql.NewSQLiteDialect(
+				persistencesql.NewSQLiteConfig(filepath.Join(t.TempDir(), "journal.db")),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return dialect
+		},
+		"sqlite with custom names": func(t *testing.T) persistencesql.SQLDialect {
+			dialect, err := persistencesql.NewSQLiteDialect(
+				persistencesql.NewSQLiteConfig(
+					filepath.Join(t.TempDir(), "journal.db"),
+					persistencesql.WithTableNames(
+						persistencesql.TableNames{
+							Journal: "ES_Journal", Snapshot: "es_snapshot", Tag: "es_tag", Writer: "es_writer",
+							ProjectionOffset: "es_projection", Outbox: "es_outbox", SchemaVersion: "es_schema_version",
+						},
+					),
+					persistencesql.WithJournalColumns(
+						persistencesql.JournalColumns{
+							Ordering: "global_offset", PersistenceID: "stream_id", SequenceNumber: "stream_version",
+							Timestamp: "created_at", Payload: "event_data", Manifest: "event_type", WriterID: "writer",
+							Deleted: "is_deleted", Codec: "encoding", KeyID: "encryption_key", TenantID: "tenant",
+							Serializer: "format",
+						},
+					),
+					persistencesql.WithSnapshotColumns(
+						persistencesql.SnapshotColumns{
+							PersistenceID: "stream_id", SequenceNumber: "stream_version", Timestamp: "created_at",
+							Snapshot: "state", Manifest: "state_type", WriterID: "writer", Codec: "encoding",
+							KeyID: "encryption_key", TenantID: "tenant", Serializer: "format",
+						},
+					),
+				),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return dialect
+		},
+		"in-memory": func(t *testing.T) persistencesql.SQLDialect {
+			return persistencesql.NewInMemoryDialect()
+		},
+	}
+
+	for name, factory := range testCases {
+		t.Run(
+			name, func(t *testing.T) {
+				dialecttest.RunSQLDialectSuite(t, factory)
+			},
+		)
+	}
+}
@@ -0,0 +1,16 @@
+package persistencesql
+
+import (
+	"google.golang.org/protobuf/proto"
+)
+
+// Tagger returns the tags of an event about to be persisted. Tags group the events of a category across the
+// persistence IDs, e.g. all the events of a given type or all the events of a given tenant. It returns no tags
+// when the event does not belong to any category
+type Tagger = func(persistenceID string, event proto.Message) []string
+
+// ManifestTagger is a Tagger tagging every event with the full name of its proto message,
+// e.g. all the AccountDebited events can then be queried by the "persistence.AccountDebited" tag
+func ManifestTagger(_ string, event proto.Message) []string {
+	return []string{string(event.ProtoReflect().Descriptor().FullName())}
+}
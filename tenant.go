@@ -0,0 +1,61 @@
+package persistencesql
+
+import (
+	"context"
+	"strings"
+)
+
+// tenantContextKey is the key of the tenant carried by a context
+type tenantContextKey struct{}
+
+// TenantResolver returns the tenant a persistence ID belongs to. It returns an empty tenant for the persistence IDs
+// of the default tenant
+type TenantResolver = func(persistenceID string) string
+
+// WithTenant returns a copy of ctx scoped to the given tenant.
+// Every operation of a SQLDialect only reads and writes the rows of the tenant its context is scoped to, or the rows
+// of the default tenant when the context is not scoped to any. The empty tenant is the default tenant
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenantID)
+}
+
+// TenantFromContext returns the tenant ctx is scoped to, if any
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenantID, ok := ctx.Value(tenantContextKey{}).(string)
+	return tenantID, ok
+}
+
+// PrefixTenantResolver resolves the tenant of a persistence ID from its prefix, up to the first separator,
+// e.g. acme for acme/account-42 with / as separator. The persistence IDs without separator belong to the default
+// tenant
+func PrefixTenantResolver(separator string) TenantResolver {
+	return func(persistenceID string) string {
+		if index := strings.Index(persistenceID, separator); index > 0 {
+			return persistenceID[:index]
+		}
+		return ""
+	}
+}
+
+// WithTenantResolver resolves the tenant of every persistence ID using the given resolver. The events and snapshots
+// of a persistence ID are then only written to and read from its tenant.
+// A tenant set on the provider context with WithTenant takes precedence over the resolver
+func WithTenantResolver(resolver TenantResolver) OptFunc {
+	return func(provider *SQLProvider) {
+		provider.tenantResolver = resolver
+	}
+}
+
+// tenantOf returns the tenant ctx is scoped to, the default tenant when it is not scoped to any
+func tenantOf(ctx context.Context) string {
+	tenantID, _ := TenantFromContext(ctx)
+	return tenantID
+}
+
+// tenantContext scopes ctx to the tenant of the given persistence ID, unless it is already scoped to a tenant
+func (p *SQLProvider) tenantContext(ctx context.Context, persistenceID string) context.Context {
+	if _, ok := TenantFromContext(ctx); ok || p.tenantResolver == nil {
+		return ctx
+	}
+	return WithTenant(ctx, p.tenantResolver(persistenceID))
+}
@@ -0,0 +1,153 @@
+package persistencesql
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/AsynkronIT/protoactor-go/actor"
+	"github.com/stretchr/testify/assert"
+	pb "github.com/tochemey/protoactor-persistence-sql/gen"
+)
+
+func TestPrefixTenantResolver(t *testing.T) {
+	resolver := PrefixTenantResolver("/")
+
+	testCases := map[string]struct {
+		persistenceID string
+		expected      string
+	}{
+		"prefixed":          {persistenceID: "acme/account-42", expected: "acme"},
+		"nested":            {persistenceID: "acme/eu/account-42", expected: "acme"},
+		"without separator": {persistenceID: "account-42", expected: ""},
+		"empty prefix":      {persistenceID: "/account-42", expected: ""},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(
+			name, func(t *testing.T) {
+				assert.Equal(t, testCase.expected, resolver(testCase.persistenceID))
+			},
+		)
+	}
+}
+
+func TestProviderTenants(t *testing.T) {
+	ctx := context.TODO()
+	acme := WithTenant(ctx, "acme")
+
+	// get instance of assert
+	assertions := assert.New(t)
+	memoryDialect := NewInMemoryDialect()
+	provider := NewSQLProvider(
+		ctx, actor.NewActorSystem(), memoryDialect,
+		WithTenantResolver(PrefixTenantResolver("/")), WithAsyncWrites(10, time.Hour, 10),
+	)
+	state := provider.GetState()
+
+	// the queued events of several tenants are written to their own tenant
+	for i := 1; i <= 2; i++ {
+		state.PersistEvent("acme/account", i, &pb.AccountDebited{Balance: float32(i)})
+		state.PersistEvent("globex/account", i, &pb.AccountDebited{Balance: float32(10 * i)})
+	}
+	state.PersistEvent("account", 1, &pb.AccountDebited{Balance: 100})
+	state.PersistSnapshot("acme/account", 2, &pb.Account{AccountNumber: "acme"})
+
+	replayed := make([]float32, 0)
+	state.GetEvents("acme/account", 1, 0, func(e interface{}) {
+		replayed = append(replayed, e.(*pb.AccountDebited).GetBalance())
+	})
+	assertions.Equal([]float32{1, 2}, replayed)
+	_, _, ok := state.GetSnapshot("acme/account")
+	assertions.True(ok)
+
+	journals, err := memoryDialect.GetJournals(acme, "acme/account", 1, 2)
+	assertions.NoError(err)
+	if assertions.Len(journals, 2) {
+		assertions.Equal("acme", journals[0].TenantID)
+	}
+
+	// the helpers of the in-memory dialect cover every tenant
+	journals = memoryDialect.Journals("acme/account")
+	if assertions.Len(journals, 2) {
+		assertions.Equal("acme", journals[1].TenantID)
+	}
+	snapshots := memoryDialect.Snapshots("acme/account")
+	if assertions.Len(snapshots, 1) {
+		assertions.Equal("acme", snapshots[0].TenantID)
+	}
+	assertions.Equal([]string{"account", "acme/account", "globex/account"}, memoryDialect.PersistenceIDs())
+
+	// the queries are scoped to the tenant of their context or of the persistence ID
+	readJournal := provider.ReadJournal()
+	envelopes := collect(readJournal.CurrentAllEvents(acme, 0), -1)
+	assertions.Equal([]float32{1, 2}, balances(envelopes))
+	envelopes = collect(readJournal.CurrentEventsByPersistenceID(ctx, "globex/account", 1, 2), -1)
+	assertions.Equal([]float32{10, 20}, balances(envelopes))
+
+	// the rows of the other tenants are not taken for gaps
+	envelopes = collect(
+		provider.ReadJournal(WithGapTimeout(time.Minute)).CurrentAllEvents(WithTenant(ctx, "globex"), 0), -1,
+	)
+	assertions.Equal([]float32{10, 20}, balances(envelopes))
+	journals, err = memoryDialect.GetJournalsByOrdering(WithTenant(ctx, "globex"), 0, math.MaxInt64, 10)
+	assertions.NoError(err)
+	for _, journal := range journals {
+		assertions.Equal("globex/account", journal.PersistenceID)
+	}
+
+	// a tenant set on the provider context takes precedence over the resolver
+	globex := NewSQLProvider(
+		WithTenant(ctx, "globex"), actor.NewActorSystem(), memoryDialect,
+		WithTenantResolver(PrefixTenantResolver("/")),
+	)
+	globex.GetState().PersistEvent("acme/other", 1, &pb.AccountDebited{Balance: 1})
+	journals, err = memoryDialect.GetJournals(WithTenant(ctx, "globex"), "acme/other", 1, 1)
+	assertions.NoError(err)
+	assertions.Len(journals, 1)
+
+	// the rows of a persistence ID shared by several tenants are ordered by tenant
+	NewSQLProvider(ctx, actor.NewActorSystem(), memoryDialect, WithTenantResolver(PrefixTenantResolver("/"))).
+		GetState().PersistEvent("acme/other", 1, &pb.AccountDebited{Balance: 2})
+	journals = memoryDialect.Journals("acme/other")
+	if assertions.Len(journals, 2) {
+		assertions.Equal("acme", journals[0].TenantID)
+		assertions.Equal("globex", journals[1].TenantID)
+	}
+}
+
+func TestTenantsLiveQueries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	acme := WithTenant(ctx, "acme")
+	globex := WithTenant(ctx, "globex")
+
+	// get instance of assert
+	assertions := assert.New(t)
+	provider := NewSQLProvider(
+		ctx, actor.NewActorSystem(), NewInMemoryDialect(), WithTenantResolver(PrefixTenantResolver("/")),
+		WithTagger(ManifestTagger),
+	)
+	state := provider.GetState()
+	readJournal := provider.ReadJournal(WithPollInterval(5*time.Millisecond), WithGapTimeout(time.Minute))
+
+	streams := map[string]*EventStream{
+		"acme":          readJournal.AllEvents(acme, 0),
+		"globex":        readJournal.AllEvents(globex, 0),
+		"acme by tag":   readJournal.EventsByTag(acme, "persistence.AccountDebited", 0),
+		"globex by tag": readJournal.EventsByTag(globex, "persistence.AccountDebited", 0),
+	}
+
+	// the interleaved events of the other tenant do not hold the live queries back until the gap timeout
+	for i := 1; i <= 3; i++ {
+		state.PersistEvent("acme/account", i, &pb.AccountDebited{Balance: float32(i)})
+		state.PersistEvent("globex/account", i, &pb.AccountDebited{Balance: float32(10 * i)})
+	}
+	expected := map[string][]float32{
+		"acme": {1, 2, 3}, "globex": {10, 20, 30}, "acme by tag": {1, 2, 3}, "globex by tag": {10, 20, 30},
+	}
+	for name, stream := range streams {
+		assertions.Equal(expected[name], balances(collectWithin(stream, 3, time.Second)), name)
+	}
+}
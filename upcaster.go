@@ -0,0 +1,115 @@
+package persistencesql
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Upcaster turns a payload written with an old schema into a payload of a newer schema.
+// It returns the new payload along with the manifest describing it. When that manifest has an upcaster
+// registered as well, the upcasts are chained until the current schema is reached.
+type Upcaster = func(payload []byte) ([]byte, Manifest, error)
+
+// VersionedManifest returns the manifest of a given version of a schema, e.g. persistence.AccountDebited@2.
+// Version 0 is the unversioned manifest. Versioned manifests let a Serializer record the schema version of the
+// payloads it writes so that upcasters can be registered per version.
+func VersionedManifest(manifest Manifest, version int) Manifest {
+	if version == 0 {
+		return manifest
+	}
+	return Manifest(fmt.Sprintf("%s@%d", manifest, version))
+}
+
+// UpcasterRegistry holds the upcasters and the manifest aliases applied to the events and the snapshots
+// read from the database before they are decoded
+type UpcasterRegistry struct {
+	mu        sync.RWMutex
+	upcasters map[Manifest]Upcaster
+	aliases   map[Manifest]Manifest
+}
+
+// NewUpcasterRegistry creates a new instance of UpcasterRegistry
+func NewUpcasterRegistry() *UpcasterRegistry {
+	return &UpcasterRegistry{
+		upcasters: make(map[Manifest]Upcaster),
+		aliases:   make(map[Manifest]Manifest),
+	}
+}
+
+// Register registers the upcaster of a given version of a manifest. Version 0 registers the upcaster of the
+// unversioned manifest
+func (r *UpcasterRegistry) Register(manifest Manifest, version int, upcaster Upcaster) *UpcasterRegistry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.upcasters[VersionedManifest(manifest, version)] = upcaster
+	return r
+}
+
+// Alias makes the payloads written with the from manifest decodable with the to manifest as is.
+// It is meant for renamed messages which wire format has not changed
+func (r *UpcasterRegistry) Alias(from Manifest, to Manifest) *UpcasterRegistry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.aliases[from] = to
+	return r
+}
+
+// Upcast runs the chain of upcasters and aliases registered for a given manifest.
+// Payloads of a manifest without upcaster or alias are returned as is
+func (r *UpcasterRegistry) Upcast(payload []byte, manifest Manifest) ([]byte, Manifest, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// let us guard against misconfigured registries looping forever
+	seen := make(map[Manifest]bool)
+	for {
+		if seen[manifest] {
+			return nil, "", fmt.Errorf("upcasting cycle detected at manifest: %s", manifest)
+		}
+		seen[manifest] = true
+
+		if alias, ok := r.aliases[manifest]; ok {
+			manifest = alias
+			continue
+		}
+
+		upcaster, ok := r.upcasters[manifest]
+		if !ok {
+			return payload, manifest, nil
+		}
+
+		from := manifest
+		var err error
+		if payload, manifest, err = upcaster(payload); err != nil {
+			return nil, "", fmt.Errorf("error upcasting manifest: %s: %w", from, err)
+		}
+	}
+}
+
+// upcastJournal returns a copy of the journal entry carrying the upcasted payload
+func (r *UpcasterRegistry) upcastJournal(journal *Journal) (*Journal, error) {
+	payload, manifest, err := r.Upcast(journal.Payload, journal.EventManifest)
+	if err != nil {
+		return nil, err
+	}
+
+	upcasted := *journal
+	upcasted.Payload = payload
+	upcasted.EventManifest = manifest
+	return &upcasted, nil
+}
+
+// upcastSnapshot returns a copy of the snapshot carrying the upcasted payload
+func (r *UpcasterRegistry) upcastSnapshot(snapshot *Snapshot) (*Snapshot, error) {
+	payload, manifest, err := r.Upcast(snapshot.Snapshot, snapshot.SnapshotManifest)
+	if err != nil {
+		return nil, err
+	}
+
+	upcasted := *snapshot
+	upcasted.Snapshot = payload
+	upcasted.SnapshotManifest = manifest
+	return &upcasted, nil
+}
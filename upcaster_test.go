@@ -0,0 +1,184 @@
+package persistencesql
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/AsynkronIT/protoactor-go/actor"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	pb "github.com/tochemey/protoactor-persistence-sql/gen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// legacy manifests of the test messages
+const (
+	legacyBalanceManifest  Manifest = "legacy.Balance"
+	legacyDebitedManifest  Manifest = "persistence.AccountWithdrawn"
+	legacyAccountManifest  Manifest = "legacy.Account"
+	currentDebitedManifest Manifest = "persistence.AccountDebited"
+)
+
+// legacyUpcasters upcasts the legacy balances into account debited events:
+// legacy.Balance@1 holds the balance as a float, legacy.Balance@2 holds the balance as a double
+func legacyUpcasters() *UpcasterRegistry {
+	return NewUpcasterRegistry().
+		Register(legacyBalanceManifest, 1, func(payload []byte) ([]byte, Manifest, error) {
+			balance := new(wrapperspb.FloatValue)
+			if err := proto.Unmarshal(payload, balance); err != nil {
+				return nil, "", err
+			}
+
+			bytes, err := proto.Marshal(wrapperspb.Double(float64(balance.GetValue())))
+			return bytes, VersionedManifest(legacyBalanceManifest, 2), err
+		}).
+		Register(legacyBalanceManifest, 2, func(payload []byte) ([]byte, Manifest, error) {
+			balance := new(wrapperspb.DoubleValue)
+			if err := proto.Unmarshal(payload, balance); err != nil {
+				return nil, "", err
+			}
+
+			bytes, err := proto.Marshal(&pb.AccountDebited{
+				AccountNumber: "legacy",
+				Balance:       float32(balance.GetValue()),
+			})
+			return bytes, currentDebitedManifest, err
+		}).
+		Alias(legacyDebitedManifest, currentDebitedManifest).
+		Alias(legacyAccountManifest, "persistence.Account")
+}
+
+func TestUpcasterRegistry(t *testing.T) {
+	debited := &pb.AccountDebited{AccountNumber: "1234555", Balance: 2000}
+	debitedBytes, err := proto.Marshal(debited)
+	assert.NoError(t, err)
+	floatBytes, err := proto.Marshal(wrapperspb.Float(300))
+	assert.NoError(t, err)
+
+	testCases := map[string]struct {
+		registry *UpcasterRegistry
+		payload  []byte
+		manifest Manifest
+		expected proto.Message
+		err      bool
+	}{
+		"current manifest": {
+			registry: legacyUpcasters(),
+			payload:  debitedBytes,
+			manifest: currentDebitedManifest,
+			expected: debited,
+		},
+		"alias": {
+			registry: legacyUpcasters(),
+			payload:  debitedBytes,
+			manifest: legacyDebitedManifest,
+			expected: debited,
+		},
+		"chained upcasts": {
+			registry: legacyUpcasters(),
+			payload:  floatBytes,
+			manifest: VersionedManifest(legacyBalanceManifest, 1),
+			expected: &pb.AccountDebited{AccountNumber: "legacy", Balance: 300},
+		},
+		"upcaster failure": {
+			registry: NewUpcasterRegistry().Register(legacyBalanceManifest, 0, func([]byte) ([]byte, Manifest, error) {
+				return nil, "", errors.New("corrupted payload")
+			}),
+			payload:  floatBytes,
+			manifest: legacyBalanceManifest,
+			err:      true,
+		},
+		"cycle": {
+			registry: NewUpcasterRegistry().
+				Alias(legacyDebitedManifest, currentDebitedManifest).
+				Alias(currentDebitedManifest, legacyDebitedManifest),
+			payload:  debitedBytes,
+			manifest: legacyDebitedManifest,
+			err:      true,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(
+			name, func(t *testing.T) {
+				// get instance of assert
+				assertions := assert.New(t)
+
+				payload, manifest, err := testCase.registry.Upcast(testCase.payload, testCase.manifest)
+				if testCase.err {
+					assertions.Error(err)
+					return
+				}
+				assertions.NoError(err)
+
+				message, err := defaultSerializer.Deserialize(payload, manifest)
+				assertions.NoError(err)
+				assertions.True(proto.Equal(testCase.expected, message))
+			},
+		)
+	}
+}
+
+func TestProviderUpcasters(t *testing.T) {
+	ctx := context.TODO()
+	persistenceID := uuid.New().String()
+
+	// get instance of assert
+	assertions := assert.New(t)
+	memoryDialect := NewInMemoryDialect()
+
+	// the journal holds events written with several generations of the schema
+	floatBytes, err := proto.Marshal(wrapperspb.Float(100))
+	assertions.NoError(err)
+	debitedBytes, err := proto.Marshal(&pb.AccountDebited{AccountNumber: persistenceID, Balance: 200})
+	assertions.NoError(err)
+	accountBytes, err := proto.Marshal(&pb.Account{AccountNumber: persistenceID, ActualBalance: 50})
+	assertions.NoError(err)
+
+	assertions.NoError(memoryDialect.PersistJournals(ctx, []*Journal{
+		{
+			PersistenceID:  persistenceID,
+			SequenceNumber: 1,
+			Payload:        floatBytes,
+			EventManifest:  VersionedManifest(legacyBalanceManifest, 1),
+		},
+		{
+			PersistenceID:  persistenceID,
+			SequenceNumber: 2,
+			Payload:        debitedBytes,
+			EventManifest:  legacyDebitedManifest,
+		},
+	}))
+	assertions.NoError(memoryDialect.PersistSnapshot(ctx, &Snapshot{
+		PersistenceID:    persistenceID,
+		SequenceNumber:   1,
+		Snapshot:         accountBytes,
+		SnapshotManifest: legacyAccountManifest,
+	}))
+
+	provider := NewSQLProvider(ctx, actor.NewActorSystem(), memoryDialect, WithUpcasters(legacyUpcasters()))
+	state := provider.GetState()
+
+	snapshot, _, ok := state.GetSnapshot(persistenceID)
+	assertions.True(ok)
+	assertions.True(proto.Equal(&pb.Account{AccountNumber: persistenceID, ActualBalance: 50}, snapshot.(proto.Message)))
+
+	replayed := make([]interface{}, 0)
+	state.GetEvents(persistenceID, 1, 0, func(e interface{}) {
+		replayed = append(replayed, e)
+	})
+	if assertions.Len(replayed, 2) {
+		assertions.True(proto.Equal(&pb.AccountDebited{AccountNumber: "legacy", Balance: 100}, replayed[0].(proto.Message)))
+		assertions.True(proto.Equal(&pb.AccountDebited{AccountNumber: persistenceID, Balance: 200}, replayed[1].(proto.Message)))
+	}
+
+	// without upcasters the legacy events cannot be decoded
+	provider = NewSQLProvider(ctx, actor.NewActorSystem(), memoryDialect, WithErrorHandler(LogAndContinue))
+	replayed = replayed[:0]
+	provider.GetState().GetEvents(persistenceID, 1, 0, func(e interface{}) {
+		replayed = append(replayed, e)
+	})
+	assertions.Empty(replayed)
+}
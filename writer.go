@@ -1,18 +1,190 @@
 package persistencesql
 
 import (
+	"context"
+	"errors"
+	"time"
+
 	"github.com/AsynkronIT/protoactor-go/actor"
+	"github.com/AsynkronIT/protoactor-go/scheduler"
 )
 
+// errWriterStopped fails the journal entries still held back when the writer stops
+var errWriterStopped = errors.New("writer stopped")
+
+// write is a journal entry queued to the writer
 type write struct {
-	fun func()
+	journal *Journal
+	// called once the journal entry has been written or has failed to be written
+	done func(err error)
+	// states whether the journal entry has already failed to be written
+	retry bool
+}
+
+// release is sent once a journal entry that failed to be written is given up on. The entries of its persistence ID
+// held back in the meantime are then written, or failed with the given error when set
+type release struct {
+	journal *Journal
+	err     error
+}
+
+// flush asks the writer to write the pending journal entries right away
+type flush struct{}
+
+// deadline is the end of the time the entries of a batch wait for the batch to fill up
+type deadline struct {
+	// the batch the deadline has been set for. The batches flushed in the meantime are not affected
+	batch int
+}
+
+// writer group-commits the journal entries queued in async mode
+type writer struct {
+	ctx     context.Context
+	dialect SQLDialect
+
+	// the maximum number of journal entries written in one go
+	maxBatchSize int
+	// the maximum time a journal entry waits for the batch to fill up
+	maxBatchDelay time.Duration
+
+	pending []*write
+	// the entries held back behind a journal entry that failed to be written, by persistence ID
+	held map[tenantKey][]*write
+	// the number of batches flushed so far
+	batch     int
+	scheduler *scheduler.TimerScheduler
+}
+
+func newWriter(ctx context.Context, dialect SQLDialect, maxBatchSize int, maxBatchDelay time.Duration) actor.Producer {
+	return func() actor.Actor {
+		return &writer{
+			ctx:           ctx,
+			dialect:       dialect,
+			maxBatchSize:  maxBatchSize,
+			maxBatchDelay: maxBatchDelay,
+			held:          make(map[tenantKey][]*write),
+		}
+	}
+}
+
+// Receive handles the messages sent to the writer
+func (w *writer) Receive(context actor.Context) {
+	switch msg := context.Message().(type) {
+	case *actor.Started:
+		w.scheduler = scheduler.NewTimerScheduler(context)
+	case *write:
+		if !w.queue(msg) {
+			return
+		}
+
+		switch {
+		case len(w.pending) >= w.maxBatchSize:
+			w.flush()
+		case len(w.pending) == 1:
+			// the first entry of a batch sets the deadline of the batch
+			w.scheduler.SendOnce(w.maxBatchDelay, context.Self(), &deadline{batch: w.batch})
+		}
+	case *deadline:
+		// the batch the deadline has been set for may have been flushed once full
+		if msg.batch == w.batch {
+			w.flush()
+		}
+	case *release:
+		key := tenantKey{msg.journal.TenantID, msg.journal.PersistenceID}
+		held := w.held[key]
+		delete(w.held, key)
+		if msg.err != nil {
+			for _, write := range held {
+				write.done(msg.err)
+			}
+			return
+		}
+		w.pending = append(w.pending, held...)
+		w.flush()
+	case *flush:
+		w.flush()
+	case *actor.Stopping:
+		w.flush()
+		for key, held := range w.held {
+			for _, write := range held {
+				write.done(errWriterStopped)
+			}
+			delete(w.held, key)
+		}
+	}
+}
+
+// queue adds a journal entry to the pending entries, unless it is held back behind an entry of its persistence ID
+// that failed to be written. A retried entry is queued along with the entries held back behind it, in order.
+// It returns whether the pending entries have grown
+func (w *writer) queue(write *write) bool {
+	key := tenantKey{write.journal.TenantID, write.journal.PersistenceID}
+	held, blocked := w.held[key]
+	switch {
+	case write.retry:
+		delete(w.held, key)
+		w.pending = append(append(w.pending, write), held...)
+	case blocked:
+		w.held[key] = append(held, write)
+		return false
+	default:
+		w.pending = append(w.pending, write)
+	}
+	return true
 }
 
-func newWriter() func(actor.Context) {
-	return func(context actor.Context) {
-		switch msg := context.Message().(type) {
-		case *write:
-			go msg.fun()
+// flush writes the pending journal entries of every tenant in a single transaction.
+// When a batch fails, its entries are written one by one so that a single faulty entry does not fail
+// the entries of the other persistent actors. The entries following a faulty entry of the same persistence ID are
+// held back until the faulty entry is written or given up on, so that the journal keeps the order of the events
+func (w *writer) flush() {
+	if len(w.pending) == 0 {
+		return
+	}
+
+	// the entries of a tenant can only be written in the context of that tenant
+	batches := make(map[string][]*write)
+	tenants := make([]string, 0)
+	for _, write := range w.pending {
+		tenant := write.journal.TenantID
+		if _, ok := batches[tenant]; !ok {
+			tenants = append(tenants, tenant)
+		}
+		batches[tenant] = append(batches[tenant], write)
+	}
+	w.pending = nil
+	w.batch++
+
+	for _, tenant := range tenants {
+		w.writeBatch(WithTenant(w.ctx, tenant), batches[tenant])
+	}
+}
+
+// writeBatch writes a batch of journal entries of the same tenant
+func (w *writer) writeBatch(ctx context.Context, batch []*write) {
+	journals := make([]*Journal, 0, len(batch))
+	for _, write := range batch {
+		journals = append(journals, write.journal)
+	}
+
+	if err := w.dialect.PersistJournals(ctx, journals); err == nil {
+		for _, write := range batch {
+			write.done(nil)
+		}
+		return
+	}
+
+	for _, write := range batch {
+		key := tenantKey{write.journal.TenantID, write.journal.PersistenceID}
+		if held, blocked := w.held[key]; blocked {
+			w.held[key] = append(held, write)
+			continue
+		}
+
+		err := w.dialect.PersistJournal(ctx, write.journal)
+		if err != nil {
+			w.held[key] = nil
 		}
+		write.done(err)
 	}
 }